@@ -0,0 +1,369 @@
+package biz
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/banbox/banbot/btime"
+	"github.com/banbox/banbot/config"
+	"github.com/banbox/banbot/core"
+	"github.com/banbox/banbot/strat"
+	"github.com/banbox/banexg/errs"
+	"github.com/banbox/banexg/log"
+	ta "github.com/banbox/banta"
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	InferTrend = "Trend"
+	InferTrade = "Trade"
+)
+
+// number of bar timestamps kept in the result cache 结果缓存保留的bar时间戳数量
+const inferCacheBars = 8
+
+/*
+FnJobArrMap
+Build the request of a job for AInfer, return nil to skip the job
+为任务构建AInfer请求，返回nil跳过此任务
+*/
+type FnJobArrMap = func(job *strat.StratJob) *ArrMap
+
+/*
+InferClient
+Client of the AInfer model server for strategies. Results are cached by bar timestamp, calls are skipped for
+retry_secs after the server is unavailable, and Fallback is used instead when set.
+策略使用的AInfer模型服务客户端。结果按bar时间戳缓存，服务不可用后retry_secs秒内跳过调用，设置Fallback时使用其结果代替。
+*/
+type InferClient struct {
+	Fallback  func(method string, req *ArrMap) *ArrMap
+	client    AInferClient
+	conn      *grpc.ClientConn
+	timeout   time.Duration
+	retryMS   int64
+	failUntil int64
+	cache     map[int64]map[string]*ArrMap // barMS: method_codes: result
+	lock      sync.Mutex
+}
+
+var (
+	inferCli  *InferClient
+	inferLock sync.Mutex
+)
+
+/*
+GetInfer
+Return the AInfer client of `ainfer` in config, nil if addr is empty
+返回配置中`ainfer`的AInfer客户端，addr为空时返回nil
+*/
+func GetInfer() (*InferClient, *errs.Error) {
+	inferLock.Lock()
+	defer inferLock.Unlock()
+	if inferCli != nil {
+		return inferCli, nil
+	}
+	if config.AInfer == nil || config.AInfer.Addr == "" {
+		return nil, nil
+	}
+	cli, err := NewInferClient(config.AInfer)
+	if err != nil {
+		return nil, err
+	}
+	inferCli = cli
+	return cli, nil
+}
+
+func NewInferClient(cfg *config.AInferConfig) (*InferClient, *errs.Error) {
+	maxMsgSize := cfg.MaxMsgMB * 1024 * 1024
+	creds := grpc.WithTransportCredentials(insecure.NewCredentials())
+	conn, err_ := grpc.NewClient(cfg.Addr, creds, grpc.WithDefaultCallOptions(
+		grpc.MaxCallSendMsgSize(maxMsgSize),
+		grpc.MaxCallRecvMsgSize(maxMsgSize),
+	))
+	if err_ != nil {
+		return nil, errs.New(core.ErrNetConnect, err_)
+	}
+	return &InferClient{
+		client:  NewAInferClient(conn),
+		conn:    conn,
+		timeout: time.Duration(cfg.TimeoutMS) * time.Millisecond,
+		retryMS: int64(cfg.RetrySecs) * 1000,
+		cache:   make(map[int64]map[string]*ArrMap),
+	}, nil
+}
+
+func (c *InferClient) Close() {
+	if c.conn != nil {
+		_ = c.conn.Close()
+	}
+}
+
+/*
+Call
+Call method(Trend/Trade) of AInfer with req for the bar at barMS, return cached result for the same bar and codes.
+When the server is unavailable, return the result of Fallback if set, otherwise an error.
+为barMS的bar调用AInfer的method(Trend/Trade)，相同bar和codes时返回缓存结果。
+服务不可用时，如设置了Fallback则返回其结果，否则返回错误。
+*/
+func (c *InferClient) Call(method string, barMS int64, req *ArrMap) (*ArrMap, *errs.Error) {
+	key := inferKey(method, req.Codes)
+	c.lock.Lock()
+	if res, ok := c.cache[barMS][key]; ok {
+		c.lock.Unlock()
+		return res, nil
+	}
+	failUntil := c.failUntil
+	c.lock.Unlock()
+	if btime.UTCStamp() < failUntil {
+		return c.fallback(method, req, errs.NewMsg(core.ErrNetConnect, "ainfer unavailable, skip call"))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	var res *ArrMap
+	var err_ error
+	switch method {
+	case InferTrend:
+		res, err_ = c.client.Trend(ctx, req)
+	case InferTrade:
+		res, err_ = c.client.Trade(ctx, req)
+	default:
+		return nil, errs.NewMsg(errs.CodeParamInvalid, "unknown ainfer method: %s", method)
+	}
+	if err_ != nil {
+		code := status.Code(err_)
+		if code == codes.Unavailable || code == codes.DeadlineExceeded || code == codes.ResourceExhausted {
+			c.lock.Lock()
+			c.failUntil = btime.UTCStamp() + c.retryMS
+			c.lock.Unlock()
+			log.Warn("ainfer unavailable, use fallback", zap.String("method", method),
+				zap.Int64("retryMS", c.retryMS), zap.Error(err_))
+			return c.fallback(method, req, errs.New(core.ErrNetConnect, err_))
+		}
+		return nil, errs.New(core.ErrNetReadFail, err_)
+	}
+	c.setCache(barMS, key, res)
+	return res, nil
+}
+
+/*
+CallJobs
+Call AInfer once for all jobs at the current bar (e.g. in OnBatchJobs), return results by pair.
+Requests of jobs are built by `build` and merged by MergeArrMaps, results are split by SplitArrMap.
+Jobs should share the same bar time, pairs already cached for the bar are not requested again.
+在当前bar为所有任务调用一次AInfer（如在OnBatchJobs中），按品种返回结果。
+任务的请求由build构建，通过MergeArrMaps合并，结果通过SplitArrMap拆分。
+任务应处于相同bar时间，此bar已缓存的品种不会重复请求。
+*/
+func (c *InferClient) CallJobs(method string, jobs []*strat.StratJob, build FnJobArrMap) (map[string]*ArrMap, *errs.Error) {
+	result := make(map[string]*ArrMap)
+	if len(jobs) == 0 {
+		return result, nil
+	}
+	barMS := jobs[0].Env.TimeStop
+	misses := make([]*strat.StratJob, 0, len(jobs))
+	c.lock.Lock()
+	cache := c.cache[barMS]
+	for _, job := range jobs {
+		pair := job.Symbol.Symbol
+		if res, ok := cache[inferKey(method, []string{pair})]; ok {
+			result[pair] = res
+		} else {
+			misses = append(misses, job)
+		}
+	}
+	c.lock.Unlock()
+	items := make([]*ArrMap, 0, len(misses))
+	for _, job := range misses {
+		pair := job.Symbol.Symbol
+		req := build(job)
+		if req == nil {
+			continue
+		}
+		req.Codes = []string{pair}
+		items = append(items, req)
+	}
+	if len(items) == 0 {
+		return result, nil
+	}
+	req, err := MergeArrMaps(items)
+	if err != nil {
+		return result, err
+	}
+	rsp, err := c.Call(method, barMS, req)
+	if err != nil || rsp == nil {
+		return result, err
+	}
+	rspMap, err := SplitArrMap(rsp)
+	if err != nil {
+		return result, err
+	}
+	for pair, res := range rspMap {
+		result[pair] = res
+		c.setCache(barMS, inferKey(method, []string{pair}), res)
+	}
+	return result, nil
+}
+
+/*
+CallJob
+Call AInfer for a single job at its current bar, used in OnBar
+在任务的当前bar为单个任务调用AInfer，用于OnBar
+*/
+func (c *InferClient) CallJob(method string, job *strat.StratJob, build FnJobArrMap) (*ArrMap, *errs.Error) {
+	res, err := c.CallJobs(method, []*strat.StratJob{job}, build)
+	if err != nil {
+		return nil, err
+	}
+	return res[job.Symbol.Symbol], nil
+}
+
+func (c *InferClient) fallback(method string, req *ArrMap, err *errs.Error) (*ArrMap, *errs.Error) {
+	if c.Fallback != nil {
+		return c.Fallback(method, req), nil
+	}
+	return nil, err
+}
+
+func (c *InferClient) setCache(barMS int64, key string, res *ArrMap) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	items, ok := c.cache[barMS]
+	if !ok {
+		items = make(map[string]*ArrMap)
+		c.cache[barMS] = items
+		if len(c.cache) > inferCacheBars {
+			times := make([]int64, 0, len(c.cache))
+			for t := range c.cache {
+				times = append(times, t)
+			}
+			slices.Sort(times)
+			for _, t := range times[:len(times)-inferCacheBars] {
+				delete(c.cache, t)
+			}
+		}
+	}
+	items[key] = res
+}
+
+func inferKey(method string, codes []string) string {
+	return method + "_" + strings.Join(codes, ",")
+}
+
+func NewNumArr(data []float64, shape ...int) *NumArr {
+	res := &NumArr{Data: data, Shape: make([]int32, len(shape))}
+	for i, v := range shape {
+		res.Shape[i] = int32(v)
+	}
+	return res
+}
+
+/*
+BarEnvArr
+Encode the latest win bars of e to NumArr with shape [win, len(cols)], oldest first.
+Use OHLCV when cols is empty. Return nil if there are not enough bars.
+将e最近win个bar编码为形状[win, len(cols)]的NumArr，旧的在前。cols为空时使用OHLCV。bar数量不足时返回nil
+*/
+func BarEnvArr(e *ta.BarEnv, win int, cols ...*ta.Series) *NumArr {
+	if len(cols) == 0 {
+		cols = []*ta.Series{e.Open, e.High, e.Low, e.Close, e.Volume}
+	}
+	data := make([]float64, win*len(cols))
+	for j, col := range cols {
+		if len(col.Data) < win {
+			return nil
+		}
+		vals := col.Data[len(col.Data)-win:]
+		for i, v := range vals {
+			data[i*len(cols)+j] = v
+		}
+	}
+	return NewNumArr(data, win, len(cols))
+}
+
+/*
+OhlcvArrMap
+Return a FnJobArrMap encoding the latest win OHLCV bars of the job as `bar`
+返回一个FnJobArrMap，将任务最近win个OHLCV bar编码为`bar`
+*/
+func OhlcvArrMap(win int) FnJobArrMap {
+	return func(job *strat.StratJob) *ArrMap {
+		arr := BarEnvArr(job.Env, win)
+		if arr == nil {
+			return nil
+		}
+		return &ArrMap{
+			Codes: []string{job.Symbol.Symbol},
+			Mats:  map[string]*NumArr{"bar": arr},
+		}
+	}
+}
+
+/*
+MergeArrMaps
+Merge requests of single code into one, each mat is stacked along a new first axis in the order of codes
+将单个code的多个请求合并为一个，每个矩阵按codes顺序沿新的第一维堆叠
+*/
+func MergeArrMaps(items []*ArrMap) (*ArrMap, *errs.Error) {
+	res := &ArrMap{Codes: make([]string, 0, len(items)), Mats: make(map[string]*NumArr)}
+	for i, it := range items {
+		if len(it.Codes) != 1 {
+			return nil, errs.NewMsg(errs.CodeParamInvalid, "merge ArrMap need 1 code, got %v", it.Codes)
+		}
+		if i > 0 && len(it.Mats) != len(res.Mats) {
+			return nil, errs.NewMsg(errs.CodeParamInvalid, "mats of %s mismatch", it.Codes[0])
+		}
+		res.Codes = append(res.Codes, it.Codes[0])
+		for key, arr := range it.Mats {
+			mat, ok := res.Mats[key]
+			if !ok {
+				if i > 0 {
+					return nil, errs.NewMsg(errs.CodeParamInvalid, "mat %s missing before %s", key, it.Codes[0])
+				}
+				mat = &NumArr{
+					Data:  make([]float64, 0, len(arr.Data)*len(items)),
+					Shape: append([]int32{int32(len(items))}, arr.Shape...),
+				}
+				res.Mats[key] = mat
+			} else if !slices.Equal(mat.Shape[1:], arr.Shape) {
+				return nil, errs.NewMsg(errs.CodeParamInvalid, "shape of %s.%s mismatch: %v, %v",
+					it.Codes[0], key, arr.Shape, mat.Shape[1:])
+			}
+			mat.Data = append(mat.Data, arr.Data...)
+		}
+	}
+	return res, nil
+}
+
+/*
+SplitArrMap
+Split the result by codes, the first axis of each mat should be the number of codes
+按codes拆分结果，每个矩阵的第一维应为codes的数量
+*/
+func SplitArrMap(res *ArrMap) (map[string]*ArrMap, *errs.Error) {
+	num := len(res.Codes)
+	items := make(map[string]*ArrMap, num)
+	for _, code := range res.Codes {
+		items[code] = &ArrMap{Codes: []string{code}, Mats: make(map[string]*NumArr, len(res.Mats))}
+	}
+	for key, mat := range res.Mats {
+		if len(mat.Shape) == 0 || int(mat.Shape[0]) != num || len(mat.Data)%num != 0 {
+			return nil, errs.NewMsg(errs.CodeParamInvalid, "mat %s shape %v invalid for %d codes",
+				key, mat.Shape, num)
+		}
+		size := len(mat.Data) / num
+		for i, code := range res.Codes {
+			items[code].Mats[key] = &NumArr{
+				Data:  mat.Data[i*size : (i+1)*size],
+				Shape: mat.Shape[1:],
+			}
+		}
+	}
+	return items, nil
+}
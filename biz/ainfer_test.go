@@ -0,0 +1,122 @@
+package biz
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/banbox/banbot/config"
+	"github.com/banbox/banbot/orm"
+	"github.com/banbox/banbot/strat"
+	ta "github.com/banbox/banta"
+	"google.golang.org/grpc"
+)
+
+type fakeInfer struct {
+	UnimplementedAInferServer
+	calls atomic.Int32
+}
+
+// Trend return the last close of each code 返回每个code的最后收盘价
+func (s *fakeInfer) Trend(_ context.Context, req *ArrMap) (*ArrMap, error) {
+	s.calls.Add(1)
+	bar := req.Mats["bar"]
+	num := len(req.Codes)
+	size := len(bar.Data) / num
+	data := make([]float64, num)
+	for i := range data {
+		data[i] = bar.Data[(i+1)*size-2]
+	}
+	return &ArrMap{Codes: req.Codes, Mats: map[string]*NumArr{"out": NewNumArr(data, num, 1)}}, nil
+}
+
+func TestInferClient(t *testing.T) {
+	lis, err_ := net.Listen("tcp", "127.0.0.1:0")
+	if err_ != nil {
+		t.Fatal(err_)
+	}
+	srv := grpc.NewServer()
+	fake := &fakeInfer{}
+	RegisterAInferServer(srv, fake)
+	go func() {
+		_ = srv.Serve(lis)
+	}()
+	cfg := &config.AInferConfig{Addr: lis.Addr().String()}
+	cfg.Validate()
+	cli, err := NewInferClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cli.Close()
+
+	var jobs []*strat.StratJob
+	for i, pair := range []string{"BTC/USDT", "ETH/USDT"} {
+		env := &ta.BarEnv{TimeFrame: "1m", TFMSecs: 60000, MaxCache: 100}
+		for j := 0; j < 5; j++ {
+			price := float64((i+1)*100 + j)
+			_ = env.OnBar(int64(j)*60000, price, price+1, price-1, price, 10, 0)
+		}
+		jobs = append(jobs, &strat.StratJob{Env: env, Symbol: &orm.ExSymbol{Symbol: pair}})
+	}
+	if arr := BarEnvArr(jobs[0].Env, 10); arr != nil {
+		t.Fatalf("expect nil for short window, got %v", arr.Shape)
+	}
+	build := OhlcvArrMap(3)
+	res, err := cli.CallJobs(InferTrend, jobs, build)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fake.calls.Load() != 1 {
+		t.Fatalf("expect 1 batched call, got %d", fake.calls.Load())
+	}
+	if v := res["BTC/USDT"].Mats["out"].Data[0]; v != 104 {
+		t.Fatalf("BTC result expect 104, got %v", v)
+	}
+	if v := res["ETH/USDT"].Mats["out"].Data[0]; v != 204 {
+		t.Fatalf("ETH result expect 204, got %v", v)
+	}
+	// single job at the same bar hits the cache
+	one, err := cli.CallJob(InferTrend, jobs[1], build)
+	if err != nil || one.Mats["out"].Data[0] != 204 || fake.calls.Load() != 1 {
+		t.Fatalf("expect cached result, calls: %d, err: %v", fake.calls.Load(), err)
+	}
+
+	// server down: fallback is used, and calls are skipped in retry period
+	srv.Stop()
+	cli.Fallback = func(method string, req *ArrMap) *ArrMap {
+		return &ArrMap{Codes: req.Codes}
+	}
+	_ = jobs[0].Env.OnBar(5*60000, 105, 106, 104, 105, 10, 0)
+	one, err = cli.CallJob(InferTrend, jobs[0], build)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if one == nil || len(one.Mats) > 0 {
+		t.Fatalf("expect fallback result without mats, got %v", one)
+	}
+	if cli.failUntil == 0 {
+		t.Fatal("server should be marked unavailable")
+	}
+	cli.Fallback = nil
+	_, err = cli.Call(InferTrend, 1, &ArrMap{Codes: []string{"BTC/USDT"}})
+	if err == nil {
+		t.Fatal("expect error without fallback")
+	}
+}
+
+func TestSplitArrMap(t *testing.T) {
+	_, err := SplitArrMap(&ArrMap{Codes: []string{"a", "b"}, Mats: map[string]*NumArr{
+		"out": NewNumArr([]float64{1, 2, 3}, 3),
+	}})
+	if err == nil {
+		t.Fatal("expect shape mismatch error")
+	}
+	_, err = MergeArrMaps([]*ArrMap{
+		{Codes: []string{"a"}, Mats: map[string]*NumArr{"x": NewNumArr([]float64{1, 2}, 2)}},
+		{Codes: []string{"b"}, Mats: map[string]*NumArr{"x": NewNumArr([]float64{1}, 1)}},
+	})
+	if err == nil {
+		t.Fatal("expect merge shape error")
+	}
+}
@@ -24,7 +24,6 @@ import (
 	"github.com/banbox/banexg"
 	"github.com/banbox/banexg/errs"
 	"github.com/banbox/banexg/log"
-	utils2 "github.com/banbox/banexg/utils"
 	ta "github.com/banbox/banta"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -197,7 +196,7 @@ func AddBatchJob(account, tf string, job *strat.StratJob, isInfo bool) {
 	if !ok {
 		tasks = &strat.BatchMap{
 			Map:     make(map[string]*strat.BatchTask),
-			TFMSecs: int64(utils2.TFToSecs(tf) * 1000),
+			TFMSecs: int64(core.TFToSecs(tf) * 1000),
 		}
 		strat.BatchTasks[key] = tasks
 	}
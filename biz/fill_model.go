@@ -0,0 +1,494 @@
+package biz
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/banbox/banbot/config"
+	"github.com/banbox/banbot/core"
+	"github.com/banbox/banbot/orm"
+	"github.com/banbox/banbot/orm/ormo"
+	"github.com/banbox/banexg"
+	"github.com/banbox/banexg/log"
+	"github.com/banbox/banexg/utils"
+	"go.uber.org/zap"
+)
+
+/*
+IFillModel
+Simulate how orders are filled inside a bar, used by LocalOrderMgr for backtesting and paper trading.
+Selected by `fill_model.name` in config.
+模拟订单在一个bar内如何成交，LocalOrderMgr在回测和模拟交易时使用。通过配置中的`fill_model.name`选择
+*/
+type IFillModel interface {
+	// Market Fill a market order which reaches the exchange at rate(0~1) of the bar
+	// 市价单在bar的rate(0~1)位置到达交易所时的成交
+	Market(od *ormo.InOutOrder, exOrder *ormo.ExOrder, bar *orm.InfoKline, rate float64) *FillRes
+	// Limit Fill a pending limit order, return nil if the price is not reached in this bar
+	// 限价挂单的成交，本bar未达到价格时返回nil
+	Limit(od *ormo.InOutOrder, exOrder *ormo.ExOrder, bar *orm.InfoKline) *FillRes
+	// Trigger Fill the hit stop loss or take profit after afterRate of the bar, return nil if not filled
+	// 已触发的止损或止盈在bar的afterRate之后的成交，未成交时返回nil
+	Trigger(od *ormo.InOutOrder, state *ormo.TriggerState, isStopLoss bool, bar *orm.InfoKline,
+		afterRate float64) *FillRes
+}
+
+type FillRes struct {
+	Price   float64 // fill price 成交价格
+	Rate    float64 // position of the fill in bar, 0~1 成交在bar中的位置
+	Amount  float64 // max amount can be filled, 0 for unlimited 最大可成交数量，0表示不限
+	IsLimit bool    // Trigger only, whether filled as a limit order 仅Trigger，是否以限价单成交
+}
+
+func NewFillModel(cfg *config.FillModelConfig) IFillModel {
+	if cfg == nil {
+		return &CandleFill{}
+	}
+	switch cfg.Name {
+	case core.FillWorst:
+		return &WorstFill{}
+	case core.FillVolume:
+		return &VolumeFill{VolRate: cfg.VolRate}
+	case core.FillQueue:
+		return &QueueFill{VolumeFill: VolumeFill{VolRate: cfg.VolRate}}
+	case core.FillLowerTF:
+		return &LowerTFFill{SubTF: cfg.SubTF}
+	default:
+		return &CandleFill{}
+	}
+}
+
+/*
+CandleFill
+Estimate the price path inside the candle: a rising bar goes open-low-high-close, a falling bar goes open-high-low-close.
+按蜡烛内价格路径估算：阳线按开盘-最低-最高-收盘，阴线按开盘-最高-最低-收盘
+*/
+type CandleFill struct{}
+
+func (m *CandleFill) Market(_ *ormo.InOutOrder, _ *ormo.ExOrder, bar *orm.InfoKline, rate float64) *FillRes {
+	return &FillRes{Price: simMarketPrice(&bar.Kline, rate), Rate: rate}
+}
+
+func (m *CandleFill) Limit(_ *ormo.InOutOrder, exOrder *ormo.ExOrder, bar *orm.InfoKline) *FillRes {
+	price := exOrder.Price
+	isBuy := exOrder.Side == banexg.OdSideBuy
+	if isBuy {
+		if price < bar.Low {
+			return nil
+		} else if price > bar.Open {
+			// 买价高于市价，以市价成交
+			// If the purchase price is higher than the market price, the transaction will be completed at the market price.
+			price = bar.Open
+		}
+	} else {
+		if price > bar.High {
+			return nil
+		} else if price < bar.Open {
+			// If the selling price is lower than the market price, the transaction will be done at the market price.
+			// 卖价低于市价，以市价成交
+			price = bar.Open
+		}
+	}
+	return &FillRes{Price: price, Rate: simMarketRate(&bar.Kline, exOrder.Price, isBuy, false, 0)}
+}
+
+func (m *CandleFill) Trigger(od *ormo.InOutOrder, state *ormo.TriggerState, _ bool, bar *orm.InfoKline,
+	afterRate float64) *FillRes {
+	tfSecs := float64(core.TFToSecs(od.Timeframe))
+	fillPrice := getExcPrice(od, &bar.Kline, state.Price, state.Limit, afterRate, tfSecs)
+	if fillPrice < 0 {
+		return nil
+	}
+	// The time when the simulation is triggered
+	// 模拟触发时的时间
+	var rate = config.BTNetCost / tfSecs
+	if fillPrice > 0 {
+		rate += simMarketRate(&bar.Kline, fillPrice, od.Short, true, afterRate)
+		return &FillRes{Price: fillPrice, Rate: rate, IsLimit: true}
+	}
+	// Trigger time + network delay
+	// 触发时间+网络延迟
+	rate += simMarketRate(&bar.Kline, state.Price, od.Short, true, afterRate)
+	// Stop loss at market price and sell immediately
+	// 市价止损，立刻卖出
+	return &FillRes{Price: simMarketPrice(&bar.Kline, rate), Rate: rate}
+}
+
+/*
+WorstFill
+Pessimistic model: market orders fill at the worst price of the bar, limit orders fill only when the price trades
+through the limit. Timing is the same as CandleFill.
+悲观模型：市价单以bar内最差价格成交，限价单仅在价格穿过限价时成交。成交时间和CandleFill相同
+*/
+type WorstFill struct {
+	CandleFill
+}
+
+func (m *WorstFill) Market(od *ormo.InOutOrder, exOrder *ormo.ExOrder, bar *orm.InfoKline, rate float64) *FillRes {
+	price := bar.Low
+	if exOrder.Side == banexg.OdSideBuy {
+		price = bar.High
+	}
+	return &FillRes{Price: price, Rate: rate}
+}
+
+func (m *WorstFill) Limit(od *ormo.InOutOrder, exOrder *ormo.ExOrder, bar *orm.InfoKline) *FillRes {
+	res := m.CandleFill.Limit(od, exOrder, bar)
+	if res == nil {
+		return nil
+	}
+	price := exOrder.Price
+	if exOrder.Side == banexg.OdSideBuy {
+		if price >= bar.Open {
+			// Marketable, fill at the worst price not exceeding the limit
+			// 可立即成交，以不超过限价的最差价格成交
+			res.Price = min(price, bar.High)
+		} else if price <= bar.Low {
+			// Only touched the limit, may still be in the queue
+			// 仅触及限价，可能仍在排队
+			return nil
+		} else {
+			res.Price = price
+		}
+	} else {
+		if price <= bar.Open {
+			res.Price = max(price, bar.Low)
+		} else if price >= bar.High {
+			return nil
+		} else {
+			res.Price = price
+		}
+	}
+	return res
+}
+
+func (m *WorstFill) Trigger(od *ormo.InOutOrder, state *ormo.TriggerState, _ bool, bar *orm.InfoKline,
+	afterRate float64) *FillRes {
+	tfSecs := float64(core.TFToSecs(od.Timeframe))
+	rate := config.BTNetCost/tfSecs + simMarketRate(&bar.Kline, state.Price, od.Short, true, afterRate)
+	if state.Limit > 0 {
+		// Short orders exit by buying, long orders exit by selling
+		// 空单买入平仓，多单卖出平仓
+		if od.Short {
+			if state.Limit < state.Price && state.Limit <= bar.Low {
+				return nil
+			}
+			return &FillRes{Price: min(state.Limit, bar.High), Rate: rate, IsLimit: true}
+		}
+		if state.Limit > state.Price && state.Limit >= bar.High {
+			return nil
+		}
+		return &FillRes{Price: max(state.Limit, bar.Low), Rate: rate, IsLimit: true}
+	}
+	price := bar.Low
+	if od.Short {
+		price = bar.High
+	}
+	return &FillRes{Price: price, Rate: rate}
+}
+
+/*
+VolumeFill
+Prices are the same as CandleFill, but the amount filled in one bar is capped at VolRate of the bar volume, the rest
+keeps pending for later bars. Stop loss and take profit for part of the order are not capped.
+价格和CandleFill相同，但单个bar的成交数量不超过bar成交量的VolRate，剩余部分继续挂单等待后续bar。部分平仓的止损止盈不受限制
+*/
+type VolumeFill struct {
+	CandleFill
+	VolRate float64
+}
+
+func (m *VolumeFill) Market(od *ormo.InOutOrder, exOrder *ormo.ExOrder, bar *orm.InfoKline, rate float64) *FillRes {
+	res := m.CandleFill.Market(od, exOrder, bar, rate)
+	res.Amount = m.maxAmount(bar)
+	return res
+}
+
+func (m *VolumeFill) Limit(od *ormo.InOutOrder, exOrder *ormo.ExOrder, bar *orm.InfoKline) *FillRes {
+	res := m.CandleFill.Limit(od, exOrder, bar)
+	if res != nil {
+		res.Amount = m.maxAmount(bar)
+	}
+	return res
+}
+
+func (m *VolumeFill) Trigger(od *ormo.InOutOrder, state *ormo.TriggerState, isStopLoss bool, bar *orm.InfoKline,
+	afterRate float64) *FillRes {
+	res := m.CandleFill.Trigger(od, state, isStopLoss, bar, afterRate)
+	if res != nil && (state.Rate <= 0 || state.Rate > 0.99) {
+		res.Amount = m.maxAmount(bar)
+	}
+	return res
+}
+
+func (m *VolumeFill) maxAmount(bar *orm.InfoKline) float64 {
+	if bar.Volume <= 0 {
+		// No volume data, don't limit
+		// 无成交量数据，不限制
+		return 0
+	}
+	return bar.Volume * m.VolRate
+}
+
+/*
+QueueFill
+Queue position / volume share model. A resting limit order only gets VolRate of the volume traded at or through its
+price after it reaches the exchange, so it fills progressively over several bars. The volume is assumed to be uniform
+along the candle path. Marketable orders and triggers are capped like VolumeFill.
+排队位置/成交量份额模型。限价挂单到达交易所后，只能获得在其价格或穿过其价格的成交量的VolRate，因此会在多个bar中逐步成交。
+假设成交量沿蜡烛路径均匀分布。可立即成交的订单和触发单按VolumeFill限制
+*/
+type QueueFill struct {
+	VolumeFill
+}
+
+func (m *QueueFill) Limit(od *ormo.InOutOrder, exOrder *ormo.ExOrder, bar *orm.InfoKline) *FillRes {
+	res := m.CandleFill.Limit(od, exOrder, bar)
+	if res == nil {
+		return nil
+	}
+	price := exOrder.Price
+	isBuy := exOrder.Side == banexg.OdSideBuy
+	if bar.Volume <= 0 || isBuy && price >= bar.Open || !isBuy && price <= bar.Open {
+		// Marketable when arriving, take liquidity from the book
+		// 到达时可立即成交，从盘口吃单
+		res.Amount = m.maxAmount(bar)
+		return res
+	}
+	startRate := float64(0)
+	if exOrder.CreateAt > bar.Time {
+		startRate = min(1, float64(exOrder.CreateAt-bar.Time)/float64(barTFMSecs(bar)))
+	}
+	volRate := pathVolRate(&bar.Kline, price, isBuy, startRate)
+	if volRate <= 0 {
+		// Only touched the price, the queue ahead is not consumed
+		// 仅触及价格，前方队列未消耗完
+		return nil
+	}
+	res.Amount = bar.Volume * volRate * m.VolRate
+	return res
+}
+
+/*
+pathVolRate
+The rate of bar volume traded at or through price after startRate of the bar, assuming the volume is uniform along
+the candle path: open-low-high-close for rising bars, open-high-low-close for falling bars.
+bar在startRate之后，在price或穿过price的成交量占比。假设成交量沿蜡烛路径均匀分布：阳线开盘-最低-最高-收盘，阴线开盘-最高-最低-收盘
+*/
+func pathVolRate(bar *banexg.Kline, price float64, isBuy bool, startRate float64) float64 {
+	path := []float64{bar.Open, bar.Low, bar.High, bar.Close}
+	if bar.Open > bar.Close {
+		path = []float64{bar.Open, bar.High, bar.Low, bar.Close}
+	}
+	var total float64
+	for i := 1; i < len(path); i++ {
+		total += math.Abs(path[i] - path[i-1])
+	}
+	if total == 0 {
+		if isBuy && bar.Close < price || !isBuy && bar.Close > price {
+			return 1 - startRate
+		}
+		return 0
+	}
+	skip := startRate * total
+	var hitLen float64
+	for i := 1; i < len(path); i++ {
+		a, b := path[i-1], path[i]
+		segLen := math.Abs(b - a)
+		if segLen == 0 {
+			continue
+		}
+		if skip >= segLen {
+			skip -= segLen
+			continue
+		} else if skip > 0 {
+			a += (b - a) * skip / segLen
+			skip = 0
+		}
+		lo, hi := min(a, b), max(a, b)
+		if isBuy {
+			hitLen += max(0, min(price, hi)-lo)
+		} else {
+			hitLen += max(0, hi-max(price, lo))
+		}
+	}
+	return hitLen / total
+}
+
+/*
+LowerTFFill
+Walk the sub bars of SubTF inside the bar to find when and at what price orders are filled. Each sub bar is
+estimated like CandleFill. Fallback to CandleFill when sub bars are unavailable.
+遍历bar内SubTF周期的子K线，确定订单的成交时间和价格。每个子K线按CandleFill估算。无法获取子K线时回退到CandleFill
+*/
+type LowerTFFill struct {
+	CandleFill
+	SubTF     string
+	cacheKey  string
+	cacheBars []*banexg.Kline
+}
+
+func (m *LowerTFFill) Market(od *ormo.InOutOrder, exOrder *ormo.ExOrder, bar *orm.InfoKline, rate float64) *FillRes {
+	subs, subMSecs := m.subBars(od, bar)
+	if len(subs) == 0 {
+		return m.CandleFill.Market(od, exOrder, bar, rate)
+	}
+	tfMSecs := barTFMSecs(bar)
+	fillMS := bar.Time + int64(rate*float64(tfMSecs))
+	price, fillMS := subMarketPrice(subs, subMSecs, fillMS)
+	return &FillRes{Price: price, Rate: float64(fillMS-bar.Time) / float64(tfMSecs)}
+}
+
+func (m *LowerTFFill) Limit(od *ormo.InOutOrder, exOrder *ormo.ExOrder, bar *orm.InfoKline) *FillRes {
+	subs, subMSecs := m.subBars(od, bar)
+	if len(subs) == 0 {
+		return m.CandleFill.Limit(od, exOrder, bar)
+	}
+	isBuy := exOrder.Side == banexg.OdSideBuy
+	// Skip sub bars closed before the order is created 跳过订单创建前已收盘的子K线
+	start := 0
+	for start < len(subs) && subs[start].Time+subMSecs <= exOrder.CreateAt {
+		start++
+	}
+	idx, price, subRate := subLimitFill(subs, start, exOrder.Price, isBuy)
+	if idx < 0 {
+		return nil
+	}
+	fillMS := max(exOrder.CreateAt, subs[idx].Time+int64(subRate*float64(subMSecs)))
+	return &FillRes{Price: price, Rate: float64(fillMS-bar.Time) / float64(barTFMSecs(bar))}
+}
+
+func (m *LowerTFFill) Trigger(od *ormo.InOutOrder, state *ormo.TriggerState, isStopLoss bool, bar *orm.InfoKline,
+	afterRate float64) *FillRes {
+	subs, subMSecs := m.subBars(od, bar)
+	if len(subs) == 0 {
+		return m.CandleFill.Trigger(od, state, isStopLoss, bar, afterRate)
+	}
+	tfMSecs := barTFMSecs(bar)
+	startMS := bar.Time + int64(afterRate*float64(tfMSecs))
+	// Long stop loss and short take profit are hit when price falls
+	// 多单止损和空单止盈在价格下跌时触发
+	falling := isStopLoss != od.Short
+	hitIdx, hitMS := -1, startMS
+	for i, sub := range subs {
+		if sub.Time+subMSecs <= startMS {
+			continue
+		}
+		subAfter := max(0, float64(startMS-sub.Time)/float64(subMSecs))
+		if hitIdx < 0 {
+			// The trigger may have been hit in earlier bars, use the start by default
+			// 可能在更早的bar已触发，默认使用开始位置
+			hitIdx = i
+		}
+		if falling && sub.Low <= state.Price || !falling && sub.High >= state.Price {
+			hitIdx = i
+			subRate := simMarketRate(sub, state.Price, od.Short, true, subAfter)
+			hitMS = max(startMS, sub.Time+int64(subRate*float64(subMSecs)))
+			break
+		}
+	}
+	if hitIdx < 0 {
+		return nil
+	}
+	// Trigger time + network delay
+	// 触发时间+网络延迟
+	sendMS := hitMS + int64(config.BTNetCost*1000)
+	limit := state.Limit
+	if limit > 0 && (od.Short && limit < state.Price || !od.Short && limit > state.Price) {
+		// Not marketable when triggered, wait for the price to reach the limit
+		// 触发时不可立即成交，等待价格到达限价
+		idx, price, subRate := subLimitFill(subs, hitIdx, limit, od.Short)
+		if idx < 0 {
+			return nil
+		}
+		fillMS := max(sendMS, subs[idx].Time+int64(subRate*float64(subMSecs)))
+		return &FillRes{Price: price, Rate: float64(fillMS-bar.Time) / float64(tfMSecs), IsLimit: true}
+	}
+	price, fillMS := subMarketPrice(subs, subMSecs, sendMS)
+	if limit > 0 {
+		if od.Short {
+			price = min(price, limit)
+		} else {
+			price = max(price, limit)
+		}
+	}
+	return &FillRes{Price: price, Rate: float64(fillMS-bar.Time) / float64(tfMSecs)}
+}
+
+/*
+subBars
+Get the sub bars inside the bar, the result of the last bar is cached.
+获取bar内的子K线，缓存最近一个bar的结果
+*/
+func (m *LowerTFFill) subBars(od *ormo.InOutOrder, bar *orm.InfoKline) ([]*banexg.Kline, int64) {
+	subMSecs := int64(utils.TFToSecs(m.SubTF)) * 1000
+	tfMSecs := barTFMSecs(bar)
+	if subMSecs <= 0 || subMSecs >= tfMSecs {
+		return nil, 0
+	}
+	key := fmt.Sprintf("%s_%s_%d", bar.Symbol, bar.TimeFrame, bar.Time)
+	if key == m.cacheKey {
+		return m.cacheBars, subMSecs
+	}
+	m.cacheKey = key
+	m.cacheBars = nil
+	exs := orm.GetSymbolByID(int32(od.Sid))
+	if exs == nil {
+		return nil, 0
+	}
+	_, klines, err := orm.GetOHLCV(exs, m.SubTF, bar.Time, bar.Time+tfMSecs, 0, false)
+	if err != nil {
+		log.Warn("load sub bars fail, fallback to candle", zap.String("key", key), zap.Error(err))
+		return nil, 0
+	}
+	m.cacheBars = bar.Adj.Apply(klines, core.AdjFront)
+	return m.cacheBars, subMSecs
+}
+
+func barTFMSecs(bar *orm.InfoKline) int64 {
+	return int64(core.TFToSecs(bar.TimeFrame)) * 1000
+}
+
+/*
+subMarketPrice
+Estimate the market price at timeMS with sub bars, return the price and the actual time.
+用子K线估算timeMS时的市场价格，返回价格和实际时间
+*/
+func subMarketPrice(subs []*banexg.Kline, subMSecs, timeMS int64) (float64, int64) {
+	for _, sub := range subs {
+		if sub.Time+subMSecs <= timeMS {
+			continue
+		}
+		if sub.Time >= timeMS {
+			// Missing sub bars, fill at the open of next sub bar
+			// 子K线缺失，以下一个子K线开盘价成交
+			return sub.Open, sub.Time
+		}
+		return simMarketPrice(sub, float64(timeMS-sub.Time)/float64(subMSecs)), timeMS
+	}
+	last := subs[len(subs)-1]
+	return last.Close, min(timeMS, last.Time+subMSecs)
+}
+
+/*
+subLimitFill
+Find the first sub bar from start which fills the limit order, return index, fill price and rate in the sub bar.
+Return -1 if not filled.
+从start开始查找第一个成交此限价单的子K线，返回索引、成交价格和在子K线中的位置。未成交返回-1
+*/
+func subLimitFill(subs []*banexg.Kline, start int, price float64, isBuy bool) (int, float64, float64) {
+	for i := start; i < len(subs); i++ {
+		sub := subs[i]
+		if isBuy && price < sub.Low || !isBuy && price > sub.High {
+			continue
+		}
+		fillPrice := price
+		if isBuy && price > sub.Open || !isBuy && price < sub.Open {
+			// Better than market price, fill at market price
+			// 优于市价，以市价成交
+			fillPrice = sub.Open
+		}
+		return i, fillPrice, simMarketRate(sub, price, isBuy, false, 0)
+	}
+	return -1, 0, 0
+}
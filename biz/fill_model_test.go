@@ -0,0 +1,122 @@
+package biz
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/banbox/banbot/orm"
+	"github.com/banbox/banbot/orm/ormo"
+	"github.com/banbox/banexg"
+)
+
+func makeFillBar(open, high, low, close, vol float64) *orm.InfoKline {
+	return &orm.InfoKline{PairTFKline: &banexg.PairTFKline{
+		Symbol:    "BTC/USDT",
+		TimeFrame: "1h",
+		Kline: banexg.Kline{Time: 1704067200000, Open: open, High: high, Low: low, Close: close,
+			Volume: vol},
+	}}
+}
+
+func TestFillModels(t *testing.T) {
+	od := &ormo.InOutOrder{IOrder: &ormo.IOrder{Symbol: "BTC/USDT", Timeframe: "1h"}}
+	buy := &ormo.ExOrder{Side: banexg.OdSideBuy, Price: 95}
+	bar := makeFillBar(100, 110, 90, 105, 20)
+	sl := &ormo.TriggerState{ExitTrigger: &ormo.ExitTrigger{Price: 92}}
+
+	candle := &CandleFill{}
+	res := candle.Limit(od, buy, bar)
+	if res == nil || res.Price != 95 || res.Rate <= 0 || res.Rate >= 1 {
+		t.Fatalf("candle limit: %+v", res)
+	}
+	if res = candle.Limit(od, &ormo.ExOrder{Side: banexg.OdSideBuy, Price: 89}, bar); res != nil {
+		t.Fatalf("candle limit below low should not fill: %+v", res)
+	}
+	res = candle.Trigger(od, sl, true, bar, 0)
+	if res == nil || res.IsLimit || res.Price > 100 || res.Price < 90 {
+		t.Fatalf("candle trigger: %+v", res)
+	}
+
+	worst := &WorstFill{}
+	if res = worst.Market(od, buy, bar, 0); res.Price != 110 {
+		t.Fatalf("worst market buy should fill at high: %+v", res)
+	}
+	if res = worst.Limit(od, &ormo.ExOrder{Side: banexg.OdSideBuy, Price: 90}, bar); res != nil {
+		t.Fatalf("worst limit touching low should not fill: %+v", res)
+	}
+	if res = worst.Trigger(od, sl, true, bar, 0); res == nil || res.Price != 90 {
+		t.Fatalf("worst stop loss should fill at low: %+v", res)
+	}
+
+	volume := &VolumeFill{VolRate: 0.1}
+	if res = volume.Limit(od, buy, bar); res == nil || res.Amount != 2 || res.Price != 95 {
+		t.Fatalf("volume limit: %+v", res)
+	}
+
+	// 15m sub bars of the 1h bar
+	subs := []*banexg.Kline{
+		{Time: bar.Time, Open: 100, High: 104, Low: 99, Close: 103},
+		{Time: bar.Time + 900000, Open: 103, High: 110, Low: 102, Close: 108},
+		{Time: bar.Time + 1800000, Open: 108, High: 108, Low: 90, Close: 94},
+		{Time: bar.Time + 2700000, Open: 94, High: 106, Low: 93, Close: 105},
+	}
+	idx, price, _ := subLimitFill(subs, 0, 95, true)
+	if idx != 2 || price != 95 {
+		t.Fatalf("sub limit fill at %v %v", idx, price)
+	}
+	idx, price, _ = subLimitFill(subs, 0, 109, false)
+	if idx != 1 || price != 109 {
+		t.Fatalf("sub limit sell fill at %v %v", idx, price)
+	}
+	price, fillMS := subMarketPrice(subs, 900000, bar.Time+1800000)
+	if price != 108 || fillMS != bar.Time+1800000 {
+		t.Fatalf("sub market price %v at %v", price, fillMS)
+	}
+	lower := &LowerTFFill{SubTF: "15m", cacheKey: fmt.Sprintf("%s_%s_%d", bar.Symbol, bar.TimeFrame, bar.Time),
+		cacheBars: subs}
+	res = lower.Limit(od, &ormo.ExOrder{Side: banexg.OdSideBuy, Price: 95, CreateAt: bar.Time}, bar)
+	if res == nil || res.Price != 95 || res.Rate >= 0.75 {
+		t.Fatalf("lower tf limit: %+v", res)
+	}
+	// the dip to 90 happened before the order was created 跌到90发生在订单创建之前
+	createAt := bar.Time + 3000000
+	res = lower.Limit(od, &ormo.ExOrder{Side: banexg.OdSideBuy, Price: 95, CreateAt: createAt}, bar)
+	if res == nil || res.Price != 94 || res.Rate < float64(createAt-bar.Time)/3600000 {
+		t.Fatalf("lower tf limit should fill after created: %+v", res)
+	}
+}
+
+func TestQueueFill(t *testing.T) {
+	// rising bar: 100 -> 90 -> 110 -> 105, path length 10+20+5=35
+	bar := makeFillBar(100, 110, 90, 105, 70)
+	rate := pathVolRate(&bar.Kline, 95, true, 0)
+	// 5 on the way down, 5 on the way up
+	if math.Abs(rate-10.0/35) > 1e-9 {
+		t.Fatalf("bad buy path vol rate: %v", rate)
+	}
+	if rate = pathVolRate(&bar.Kline, 90, true, 0); rate != 0 {
+		t.Fatalf("touch only should be 0, got %v", rate)
+	}
+	// skip the first 10 of path, only the way up counts
+	if rate = pathVolRate(&bar.Kline, 95, true, 10.0/35); math.Abs(rate-5.0/35) > 1e-9 {
+		t.Fatalf("bad path vol rate after start: %v", rate)
+	}
+	if rate = pathVolRate(&bar.Kline, 108, false, 0); math.Abs(rate-4.0/35) > 1e-9 {
+		t.Fatalf("bad sell path vol rate: %v", rate)
+	}
+
+	od := &ormo.InOutOrder{IOrder: &ormo.IOrder{Symbol: "BTC/USDT", Timeframe: "1h"}}
+	model := &QueueFill{VolumeFill: VolumeFill{VolRate: 0.1}}
+	res := model.Limit(od, &ormo.ExOrder{Side: banexg.OdSideBuy, Price: 95, CreateAt: bar.Time}, bar)
+	if res == nil || res.Price != 95 || math.Abs(res.Amount-2) > 1e-9 {
+		t.Fatalf("bad queue limit fill: %+v", res)
+	}
+	if res = model.Limit(od, &ormo.ExOrder{Side: banexg.OdSideBuy, Price: 90}, bar); res != nil {
+		t.Fatalf("queue limit touching low should not fill: %+v", res)
+	}
+	res = model.Limit(od, &ormo.ExOrder{Side: banexg.OdSideBuy, Price: 101}, bar)
+	if res == nil || res.Price != 100 || math.Abs(res.Amount-7) > 1e-9 {
+		t.Fatalf("marketable queue limit should be capped by bar volume: %+v", res)
+	}
+}
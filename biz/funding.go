@@ -0,0 +1,94 @@
+package biz
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/banbox/banbot/config"
+	"github.com/banbox/banbot/orm"
+	"github.com/banbox/banbot/orm/ormo"
+	"github.com/banbox/banexg/log"
+	"go.uber.org/zap"
+)
+
+var (
+	fundRates = make(map[string][]*orm.FundingRate)
+	fundLock  sync.Mutex
+)
+
+/*
+getFundingRates
+Return the funding rates of sid in the backtest time range, cached after the first load
+返回sid在回测时间范围内的资金费率，首次加载后缓存
+*/
+func getFundingRates(sid int32) []*orm.FundingRate {
+	startMS, endMS := config.TimeRange.StartMS, config.TimeRange.EndMS
+	cacheKey := fmt.Sprintf("%d_%d_%d", sid, startMS, endMS)
+	fundLock.Lock()
+	defer fundLock.Unlock()
+	if rates, ok := fundRates[cacheKey]; ok {
+		return rates
+	}
+	var rates []*orm.FundingRate
+	sess, conn, err := orm.Conn(nil)
+	if err != nil {
+		log.Warn("load funding rates fail, skip funding", zap.Error(err))
+	} else {
+		var err_ error
+		rates, err_ = sess.GetFundingRates(context.Background(), orm.GetFundingRatesParams{
+			Sid: sid, TimeMs: startMS, TimeMs_2: endMS,
+		})
+		conn.Release()
+		if err_ != nil {
+			log.Warn("load funding rates fail, skip funding", zap.Error(err_))
+		}
+	}
+	if len(rates) == 0 {
+		exs := orm.GetSymbolByID(sid)
+		if exs != nil {
+			log.Warn("no funding rates, run `kline funding` to download", zap.String("pair", exs.Symbol))
+		}
+	}
+	fundRates[cacheKey] = rates
+	return rates
+}
+
+/*
+settleFunding
+Settle funding fees for open contract orders at every funding timestamp in (last settled, bar.Time].
+Funding is charged on the position value at the bar open: longs pay shorts when the rate is positive.
+在(上次结算, bar.Time]内的每个资金费时间点，为未平仓的合约订单结算资金费。
+资金费按bar开盘时的仓位价值收取：费率为正时多头支付给空头。
+*/
+func (o *LocalOrderMgr) settleFunding(orders []*ormo.InOutOrder, bar *orm.InfoKline) {
+	lastMS, ok := o.fundSettled[bar.Symbol]
+	o.fundSettled[bar.Symbol] = bar.Time
+	if !ok || len(orders) == 0 {
+		return
+	}
+	exs, err := orm.GetExSymbolCur(bar.Symbol)
+	if err != nil {
+		return
+	}
+	rates := getFundingRates(exs.ID)
+	idx := sort.Search(len(rates), func(i int) bool {
+		return rates[i].TimeMs > lastMS
+	})
+	wallets := GetWallets(o.Account)
+	for ; idx < len(rates) && rates[idx].TimeMs <= bar.Time; idx++ {
+		fr := rates[idx]
+		for _, od := range orders {
+			if od.Status >= ormo.InOutStatusFullExit || od.Enter == nil || od.Enter.Filled == 0 ||
+				od.RealEnterMS() > fr.TimeMs {
+				continue
+			}
+			amount := od.Enter.Filled * bar.Open * fr.Rate
+			if !od.Short {
+				amount = -amount
+			}
+			wallets.SettleFunding(od, amount)
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package biz
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/banbox/banbot/btime"
+	"github.com/banbox/banbot/config"
+	"github.com/banbox/banbot/core"
+	"github.com/banbox/banbot/exg"
+	"github.com/banbox/banbot/orm"
+	"github.com/banbox/banbot/orm/ormo"
+	"github.com/banbox/banbot/strat"
+	"github.com/banbox/banexg"
+	"github.com/banbox/banexg/errs"
+	"github.com/banbox/banexg/log"
+	"go.uber.org/zap"
+)
+
+const indexBarNum = 500
+
+type indexBars struct {
+	exs    *orm.ExSymbol
+	stopMS int64
+	closes map[int64]float64
+}
+
+/*
+checkLiquidation
+Liquidate contract positions by tiered maintenance margin at the mark price, orders are all open orders of the
+settle coin `code`. Isolated positions are checked one by one with their own margin (including top-ups), while
+cross positions share the whole wallet and are liquidated together.
+按分层维持保证金在标记价格下强平合约仓位，orders是定价币code的所有未平仓订单。
+逐仓仓位使用各自保证金（含追加）逐个检查，全仓仓位共享整个钱包，一起强平。
+*/
+func (o *LocalOrderMgr) checkLiquidation(orders []*ormo.InOutOrder, code string, bar *orm.InfoKline) *errs.Error {
+	if config.GetMarginMode(o.Account) == banexg.MarginIsolated {
+		return o.checkIsolated(orders, bar)
+	}
+	wallets := GetWallets(o.Account)
+	equity := wallets.Get(code).Total(false)
+	var maintSum float64
+	marks := make([]float64, len(orders))
+	for i, od := range orders {
+		if od.Enter == nil || od.Enter.Filled == 0 {
+			continue
+		}
+		mark := core.GetPrice(od.Symbol)
+		if od.Symbol == bar.Symbol {
+			mark = o.markPrice(od, bar)
+		}
+		marks[i] = mark
+		equity += positionPnl(od, mark)
+		maintSum += maintMargin(od.Symbol, od.Enter.Filled*mark)
+	}
+	if maintSum == 0 || equity > maintSum {
+		return nil
+	}
+	log.Warn("cross margin liquidation", zap.String("acc", o.Account), zap.String("coin", code),
+		zap.Float64("equity", equity), zap.Float64("maint", maintSum),
+		zap.String("date", btime.ToDateStr(btime.TimeMS(), "")))
+	for i, od := range orders {
+		if marks[i] == 0 {
+			continue
+		}
+		fee := od.Enter.Filled * marks[i] * config.Liquidation.FeeRate
+		err := o.liquidate(od, marks[i], fee)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (o *LocalOrderMgr) checkIsolated(orders []*ormo.InOutOrder, bar *orm.InfoKline) *errs.Error {
+	for _, od := range orders {
+		if od.Symbol != bar.Symbol || od.Enter == nil || od.Enter.Filled == 0 {
+			continue
+		}
+		mark := o.markPrice(od, bar)
+		notional := od.Enter.Filled * mark
+		maint := maintMargin(od.Symbol, notional)
+		upnl := positionPnl(od, mark)
+		margin := o.topUpMargin(od, upnl, maint)
+		if margin+upnl > maint {
+			continue
+		}
+		price := isolatedLiqPrice(od, margin, maint/notional)
+		price = max(bar.Low, min(bar.High, price))
+		// The clearance fee takes at most the remaining margin
+		// 清算费最多扣除剩余保证金
+		fee := math.Max(0, math.Min(od.Enter.Filled*price*config.Liquidation.FeeRate, margin+positionPnl(od, price)))
+		log.Warn("isolated liquidation", zap.String("od", od.Key()), zap.Float64("price", price),
+			zap.Float64("margin", margin), zap.Float64("maint", maint))
+		err := o.liquidate(od, price, fee)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+topUpMargin
+Add margin to the isolated position from available when the loss reaches MarginAddRate of (margin - maintenance),
+restore the equity to the initial margin as far as the available allows. The added margin is moved into the frozen
+funds of the order, and released with them on exit or liquidation. Return the current margin.
+当亏损达到(保证金-维持保证金)的MarginAddRate时，从可用余额为逐仓仓位追加保证金，在余额允许范围内将权益恢复到初始保证金。
+追加的保证金转入订单的冻结资金，在平仓或强平时一起释放。返回当前保证金
+*/
+func (o *LocalOrderMgr) topUpMargin(od *ormo.InOutOrder, upnl, maint float64) float64 {
+	initMargin := od.EnterCost() / max(od.Leverage, 1)
+	added := od.GetInfoFloat64(ormo.OdInfoMarginAdd)
+	margin := initMargin + added
+	if config.MarginAddRate <= 0 || upnl >= 0 || -upnl < (margin-maint)*config.MarginAddRate {
+		return margin
+	}
+	_, quote, _, _ := core.SplitSymbol(od.Symbol)
+	wallet := GetWallets(o.Account).Get(quote)
+	wallet.lock.Lock()
+	// margin of open positions are recorded in frozens, the rest of available is free
+	// 未平仓仓位的保证金记录在frozens，available剩余部分可用
+	amount := math.Min(initMargin-(margin+upnl), wallet.Available)
+	if amount > 0 {
+		wallet.Available -= amount
+		wallet.Frozens[od.Key()] += amount
+	}
+	wallet.lock.Unlock()
+	if amount <= 0 {
+		return margin
+	}
+	od.SetInfo(ormo.OdInfoMarginAdd, added+amount)
+	log.Debug("isolated add margin", zap.String("od", od.Key()), zap.Float64("amount", amount),
+		zap.Float64("margin", margin+amount))
+	return margin + amount
+}
+
+/*
+liquidate
+Force close the order at price with tag liquidation, fee is the clearance fee added to the exit fee.
+以price强制平仓，退出标签为liquidation，fee为清算费，计入出场手续费
+*/
+func (o *LocalOrderMgr) liquidate(od *ormo.InOutOrder, price, fee float64) *errs.Error {
+	o.cancelEnterLeft(od)
+	exitMS := btime.TimeMS()
+	err := od.LocalExit(core.ExitTagLiquidation, price, "", banexg.OdTypeMarket)
+	if err != nil {
+		return err
+	}
+	od.ExitAt = exitMS
+	od.Exit.UpdateAt = exitMS
+	od.Exit.CreateAt = exitMS
+	od.Exit.Fee += fee
+	od.UpdateProfits(price)
+	od.DirtyMain = true
+	od.DirtyExit = true
+	_ = od.Save(nil)
+	wallets := GetWallets(o.Account)
+	wallets.ExitOd(od, od.Exit.Amount)
+	_ = o.finishOrder(od, nil)
+	wallets.ConfirmOdExit(od, price)
+	o.callBack(od, false)
+	strat.FireOdChange(o.Account, od, strat.OdChgExitFill)
+	return nil
+}
+
+/*
+markPrice
+Return the mark price of the order on the bar according to liquidation.mark_price
+根据liquidation.mark_price返回订单在此bar的标记价格
+*/
+func (o *LocalOrderMgr) markPrice(od *ormo.InOutOrder, bar *orm.InfoKline) float64 {
+	switch config.Liquidation.MarkPrice {
+	case core.MarkPriceExtreme:
+		if od.Short {
+			return bar.High
+		}
+		return bar.Low
+	case core.MarkPriceIndex:
+		if price := o.indexPrice(bar); price > 0 {
+			return price
+		}
+	}
+	return bar.Close
+}
+
+/*
+indexPrice
+Return the close of the spot pair at the bar as the index price, 0 if missing
+返回现货交易对在此bar的收盘价作为指数价格，缺失时返回0
+*/
+func (o *LocalOrderMgr) indexPrice(bar *orm.InfoKline) float64 {
+	cache, ok := o.indexBars[bar.Symbol]
+	if !ok {
+		cache = &indexBars{}
+		o.indexBars[bar.Symbol] = cache
+		base, quote, _, _ := core.SplitSymbol(bar.Symbol)
+		spot := fmt.Sprintf("%s/%s", base, quote)
+		cache.exs = orm.GetExSymbolMap(exg.Default.Info().ID, banexg.MarketSpot)[spot]
+		if cache.exs == nil {
+			log.Warn("spot pair for index price not found, use close", zap.String("pair", spot))
+		}
+	}
+	if cache.exs == nil {
+		return 0
+	}
+	if bar.Time >= cache.stopMS {
+		tfMSecs := barTFMSecs(bar)
+		cache.stopMS = bar.Time + tfMSecs*indexBarNum
+		cache.closes = make(map[int64]float64)
+		tf := bar.TimeFrame
+		if core.ParseAltBar(tf) != nil {
+			// non-time bars take the time of their last source bar
+			// 非时间K线使用其最后一个源K线的时间
+			tf = core.AltBarBaseTF
+		}
+		_, klines, err := orm.GetOHLCV(cache.exs, tf, bar.Time, cache.stopMS, 0, false)
+		if err != nil {
+			log.Warn("load index klines fail", zap.String("pair", cache.exs.Symbol), zap.Error(err))
+		}
+		for _, k := range klines {
+			cache.closes[k.Time] = k.Close
+		}
+	}
+	return cache.closes[bar.Time]
+}
+
+/*
+maintMargin
+Return the maintenance margin of the position value, use exchange leverage brackets first, then liquidation.tiers
+返回仓位价值的维持保证金，优先使用交易所杠杆分层，其次liquidation.tiers
+*/
+func maintMargin(symbol string, notional float64) float64 {
+	res, err := exg.Default.CalcMaintMargin(symbol, notional)
+	if err == nil && res > 0 {
+		return res
+	}
+	return tierMaintMargin(config.Liquidation.Tiers, notional)
+}
+
+func tierMaintMargin(tiers []*config.MaintMarginTier, notional float64) float64 {
+	var res float64
+	for _, t := range tiers {
+		if notional < t.Floor {
+			break
+		}
+		res = notional*t.Rate - t.Cum
+	}
+	return max(res, 0)
+}
+
+func positionPnl(od *ormo.InOutOrder, price float64) float64 {
+	pnl := od.Enter.Filled * (price - od.Enter.Average)
+	if od.Short {
+		return -pnl
+	}
+	return pnl
+}
+
+/*
+isolatedLiqPrice
+Solve the price where margin + pnl equals maintenance margin: margin + d*Q*(P-avg) = r*Q*P
+求解保证金+盈亏等于维持保证金的价格
+*/
+func isolatedLiqPrice(od *ormo.InOutOrder, margin, maintRate float64) float64 {
+	dirt := 1.0
+	if od.Short {
+		dirt = -1.0
+	}
+	qty := od.Enter.Filled
+	return (dirt*qty*od.Enter.Average - margin) / (qty * (dirt - maintRate))
+}
@@ -0,0 +1,64 @@
+package biz
+
+import (
+	"math"
+	"testing"
+
+	"github.com/banbox/banbot/config"
+	"github.com/banbox/banbot/orm/ormo"
+)
+
+func TestLiquidation(t *testing.T) {
+	tiers := []*config.MaintMarginTier{{Floor: 0, Rate: 0.004}, {Floor: 50000, Rate: 0.005, Cum: 50}}
+	if res := tierMaintMargin(tiers, 10000); math.Abs(res-40) > 1e-9 {
+		t.Fatalf("bad maint margin of first tier: %v", res)
+	}
+	if res := tierMaintMargin(tiers, 100000); math.Abs(res-450) > 1e-9 {
+		t.Fatalf("bad maint margin of second tier: %v", res)
+	}
+	// 10x long of 1 at 100, margin 10
+	od := &ormo.InOutOrder{IOrder: &ormo.IOrder{Symbol: "BTC/USDT:USDT"},
+		Enter: &ormo.ExOrder{Filled: 1, Average: 100}}
+	price := isolatedLiqPrice(od, 10, 0.005)
+	if math.Abs(10+positionPnl(od, price)-price*0.005) > 1e-9 || price >= 100 || price <= 90 {
+		t.Fatalf("bad long liquidation price: %v", price)
+	}
+	od.Short = true
+	price = isolatedLiqPrice(od, 10, 0.005)
+	if math.Abs(10+positionPnl(od, price)-price*0.005) > 1e-9 || price <= 100 || price >= 110 {
+		t.Fatalf("bad short liquidation price: %v", price)
+	}
+}
+
+func TestTopUpMargin(t *testing.T) {
+	oldRate := config.MarginAddRate
+	config.MarginAddRate = 0.66
+	defer func() {
+		config.MarginAddRate = oldRate
+	}()
+	// 10x long of 1 at 100, margin 10 frozen from the wallet
+	od := &ormo.InOutOrder{IOrder: &ormo.IOrder{Symbol: "BTC/USDT:USDT", Leverage: 10},
+		Enter: &ormo.ExOrder{Filled: 1, Average: 100}, Info: map[string]interface{}{}}
+	wallets := GetWallets(config.DefAcc)
+	wallets.SetWallets(map[string]float64{"USDT": 990})
+	wallet := wallets.Get("USDT")
+	wallet.Frozens[od.Key()] = 10
+	o := &LocalOrderMgr{OrderMgr: OrderMgr{Account: config.DefAcc}}
+	if margin := o.topUpMargin(od, -2, 0.5); margin != 10 || wallet.Available != 990 {
+		t.Fatalf("small loss should not add margin: %v, ava %v", margin, wallet.Available)
+	}
+	// restore the equity to the initial margin from available 从可用余额将权益恢复到初始保证金
+	margin := o.topUpMargin(od, -8, 0.5)
+	if margin != 18 || od.GetInfoFloat64(ormo.OdInfoMarginAdd) != 8 {
+		t.Fatalf("bad added margin: %v", margin)
+	}
+	if wallet.Available != 982 || wallet.Frozens[od.Key()] != 18 || wallet.Total(false) != 1000 {
+		t.Fatalf("added margin should move from available to frozen, ava %v frozen %v", wallet.Available,
+			wallet.Frozens[od.Key()])
+	}
+	// exit with the loss releases frozen margin as ConfirmOdExit does 平仓亏损时同ConfirmOdExit一样释放冻结保证金
+	wallets.Cancel(od.Key(), "USDT", -9, false)
+	if bal := wallet.Total(false); bal != 991 || len(wallet.Frozens) != 0 {
+		t.Fatalf("balance after exit should be 991, got %v, frozen %v", bal, wallet.Frozens)
+	}
+}
@@ -51,6 +51,7 @@ type FuncHandleIOrder = func(order *ormo.InOutOrder) *errs.Error
 type OrderMgr struct {
 	callBack    func(order *ormo.InOutOrder, isEnter bool)
 	afterEnter  FuncHandleIOrder
+	afterAdd    FuncHandleIOrder
 	afterExit   FuncHandleIOrder
 	Account     string
 	BarMS       int64
@@ -142,6 +143,18 @@ func (o *OrderMgr) allowOrderEnter(env *banta.BarEnv, enters []*strat.EnterReq)
 			return nil
 		}
 	}
+	// Adding position to entered orders is not limited by the number of orders
+	// 对已入场订单加仓不受订单数量限制
+	var adds []*strat.EnterReq
+	news := make([]*strat.EnterReq, 0, len(enters))
+	for _, req := range enters {
+		if req.AddTo > 0 {
+			adds = append(adds, req)
+		} else {
+			news = append(news, req)
+		}
+	}
+	enters = news
 	if o.BarMS < env.TimeStart {
 		o.BarMS = env.TimeStart
 		o.simulOpen = 0
@@ -160,7 +173,7 @@ func (o *OrderMgr) allowOrderEnter(env *banta.BarEnv, enters []*strat.EnterReq)
 	}
 	if len(enters) == 0 {
 		lock.Unlock()
-		return nil
+		return adds
 	}
 	// Check whether the maximum number of orders opened by the strategy is exceeded
 	// 检查是否超出策略最大开单数量
@@ -188,7 +201,7 @@ func (o *OrderMgr) allowOrderEnter(env *banta.BarEnv, enters []*strat.EnterReq)
 		res = append(res, req)
 	}
 	lock.Unlock()
-	return res
+	return append(res, adds...)
 }
 
 func checkOrderNum(enters []*strat.EnterReq, oldNum, maxNum int, tag string) []*strat.EnterReq {
@@ -231,7 +244,9 @@ func (o *OrderMgr) ProcessOrders(sess *ormo.Queries, env *banta.BarEnv, enters [
 			if err != nil {
 				return entOrders, extOrders, err
 			}
-			entOrders = append(entOrders, iorder)
+			if iorder != nil {
+				entOrders = append(entOrders, iorder)
+			}
 		}
 	}
 	if len(exits) > 0 {
@@ -322,6 +337,9 @@ func (o *OrderMgr) EnterOrder(sess *ormo.Queries, env *banta.BarEnv, req *strat.
 			return nil, nil
 		}
 	}
+	if req.AddTo > 0 {
+		return o.addOrderLeg(sess, env, req)
+	}
 	if req.Leverage == 0 {
 		req.Leverage = 1
 		if !isSpot {
@@ -378,11 +396,92 @@ func (o *OrderMgr) EnterOrder(sess *ormo.Queries, env *banta.BarEnv, req *strat.
 			req.StopBars = config.StopEnterBars
 		}
 		if req.StopBars > 0 {
-			stopAfter := btime.TimeMS() + int64(req.StopBars*utils.TFToSecs(od.Timeframe))*1000
+			stopAfter := btime.TimeMS() + int64(req.StopBars*core.TFToSecs(od.Timeframe))*1000
 			od.SetInfo(ormo.OdInfoStopAfter, stopAfter)
 		}
 	}
 	od.SetInfo(ormo.OdInfoLegalCost, req.LegalCost)
+	if req.StopLoss > 0 || req.TrailCallback > 0 || req.TrailOffset > 0 {
+		od.SetStopLoss(&ormo.ExitTrigger{
+			Price:    req.StopLoss,
+			Limit:    req.StopLossLimit,
+			Rate:     req.StopLossRate,
+			Tag:      req.StopLossTag,
+			Activate: req.TrailActivate,
+			Callback: req.TrailCallback,
+			Offset:   req.TrailOffset,
+			Step:     req.TrailStep,
+		})
+	}
+	if req.TakeProfit > 0 {
+		od.SetTakeProfit(&ormo.ExitTrigger{
+			Price: req.TakeProfit,
+			Limit: req.TakeProfitLimit,
+			Rate:  req.TakeProfitRate,
+			Tag:   req.TakeProfitTag,
+		})
+	}
+	err := od.Save(sess)
+	if err != nil {
+		return od, err
+	}
+	if o.afterEnter != nil {
+		err = o.afterEnter(od)
+	}
+	return od, err
+}
+
+/*
+addOrderLeg
+Add an entry leg to the fully entered order req.AddTo, the leg is merged into Enter after filled.
+Return nil order when the order can't be added.
+为已完全入场的订单req.AddTo添加入场腿，成交后合并到Enter。订单无法加仓时返回nil
+*/
+func (o *OrderMgr) addOrderLeg(sess *ormo.Queries, env *banta.BarEnv, req *strat.EnterReq) (*ormo.InOutOrder, *errs.Error) {
+	openOds, lock := ormo.GetOpenODs(o.Account)
+	lock.Lock()
+	od, _ := openOds[req.AddTo]
+	lock.Unlock()
+	fields := []zap.Field{zap.String("acc", o.Account), zap.Int64("id", req.AddTo)}
+	if od == nil || od.Symbol != env.Symbol || od.Short != req.Short {
+		log.Warn("add order leg skip: order not found or mismatch", fields...)
+		return nil, nil
+	}
+	if od.Status != ormo.InOutStatusFullEnter || od.ExitTag != "" || od.PendingLeg() != nil {
+		log.Warn("add order leg skip: order not fully entered or busy", fields...)
+		return nil, nil
+	}
+	price := core.GetPrice(od.Symbol)
+	if req.Limit > 0 {
+		price = req.Limit
+	}
+	amount := req.Amount
+	if amount == 0 && price > 0 {
+		amount = req.LegalCost / price
+	}
+	amount, err := exg.PrecAmount(exg.Default, od.Symbol, amount)
+	if err != nil {
+		return nil, err
+	}
+	if amount <= 0 {
+		log.Warn("add order leg skip: amount too small", fields...)
+		return nil, nil
+	}
+	odType := core.OrderTypeEnums[req.OrderType]
+	if odType == "" {
+		odType = config.OrderType
+	}
+	od.AddLeg(odType, req.Limit, amount)
+	if req.Limit > 0 {
+		stopBars := req.StopBars
+		if stopBars == 0 {
+			stopBars = config.StopEnterBars
+		}
+		if stopBars > 0 {
+			stopAfter := btime.TimeMS() + int64(stopBars*core.TFToSecs(od.Timeframe))*1000
+			od.SetInfo(ormo.OdInfoStopAfter, stopAfter)
+		}
+	}
 	if req.StopLoss > 0 {
 		od.SetStopLoss(&ormo.ExitTrigger{
 			Price: req.StopLoss,
@@ -399,12 +498,12 @@ func (o *OrderMgr) EnterOrder(sess *ormo.Queries, env *banta.BarEnv, req *strat.
 			Tag:   req.TakeProfitTag,
 		})
 	}
-	err := od.Save(sess)
+	err = od.Save(sess)
 	if err != nil {
 		return od, err
 	}
-	if o.afterEnter != nil {
-		err = o.afterEnter(od)
+	if o.afterAdd != nil {
+		err = o.afterAdd(od)
 	}
 	return od, err
 }
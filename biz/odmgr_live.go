@@ -94,6 +94,7 @@ func newLiveOrderMgr(account string, callBack func(od *ormo.InOutOrder, isEnter
 		unMatchTrades: map[string]*banexg.MyTrade{},
 	}
 	res.afterEnter = makeAfterEnter(res)
+	res.afterAdd = makeAfterAdd(res)
 	res.afterExit = makeAfterExit(res)
 	if core.ExgName == "binance" {
 		res.exitByMyOrder = bnbExitByMyOrder(res)
@@ -383,6 +384,27 @@ Restore order status
 恢复订单状态
 */
 func (o *LiveOrderMgr) restoreInOutOrder(od *ormo.InOutOrder, exgOdMap map[string]*banexg.Order) *errs.Error {
+	if leg := od.PendingLeg(); leg != nil {
+		if leg.OrderID == "" {
+			// The leg has not been submitted to the exchange, cancel adding position
+			// 加仓腿未提交到交易所，取消加仓
+			od.CancelLeg()
+		} else {
+			exOd, ok := exgOdMap[leg.OrderID]
+			if !ok {
+				var err *errs.Error
+				exOd, err = exg.Default.FetchOrder(od.Symbol, leg.OrderID, map[string]interface{}{
+					banexg.ParamAccount: o.Account,
+				})
+				if err != nil {
+					return err
+				}
+			}
+			if exOd != nil {
+				o.updateLegByExgRes(od, leg, exOd)
+			}
+		}
+	}
 	tryOd := od.Enter
 	if od.Exit != nil {
 		tryOd = od.Exit
@@ -890,6 +912,17 @@ func makeAfterEnter(o *LiveOrderMgr) FuncHandleIOrder {
 	}
 }
 
+func makeAfterAdd(o *LiveOrderMgr) FuncHandleIOrder {
+	return func(order *ormo.InOutOrder) *errs.Error {
+		log.Info("NEW Add Enter", zap.String("acc", o.Account), zap.String("key", order.Key()))
+		o.queue <- &OdQItem{
+			Order:  order,
+			Action: ormo.OdActionAddEnter,
+		}
+		return nil
+	}
+}
+
 func makeAfterExit(o *LiveOrderMgr) FuncHandleIOrder {
 	return func(order *ormo.InOutOrder) *errs.Error {
 		fields := []zap.Field{zap.String("acc", o.Account), zap.String("key", order.Key())}
@@ -941,6 +974,8 @@ func (o *LiveOrderMgr) handleOrderQueue(od *ormo.InOutOrder, action string) {
 	switch action {
 	case ormo.OdActionEnter:
 		err = o.execOrderEnter(od)
+	case ormo.OdActionAddEnter:
+		err = o.execOrderAdd(od)
 	case ormo.OdActionExit:
 		err = o.execOrderExit(od)
 	case ormo.OdActionStopLoss, ormo.OdActionTakeProfit:
@@ -968,6 +1003,10 @@ func (o *LiveOrderMgr) handleOrderQueue(od *ormo.InOutOrder, action string) {
 			logFields = append(logFields, zap.String("exitTag", od.ExitTag))
 			log.Info("Enter Order Closed", logFields...)
 		}
+	} else if action == ormo.OdActionAddEnter {
+		if leg := od.PendingLeg(); leg != nil && leg.OrderID != "" {
+			log.Info("Add Enter Submitted", logFields...)
+		}
 	} else if action == ormo.OdActionExit {
 		if od.Exit.OrderID != "" {
 			logFields = append(logFields, zap.Int64("state", od.Status))
@@ -1047,13 +1086,15 @@ func (o *LiveOrderMgr) handleMyTrade(trade *banexg.MyTrade) {
 			return
 		}
 	}
-	if strings.Contains(trade.Type, banexg.OdTypeStop) || strings.Contains(trade.Type, banexg.OdTypeTakeProfit) {
-		// Ignore stop loss and take profit orders
-		// 忽略止损止盈订单
-		return
-	}
 	lock := iod.Lock()
 	defer lock.Unlock()
+	if strings.Contains(trade.Type, banexg.OdTypeStop) || strings.Contains(trade.Type, banexg.OdTypeTakeProfit) {
+		if !isFullTrigger(iod, trade.Order) {
+			// Ignore stop loss and take profit orders, except those closing the whole order
+			// 忽略止损止盈订单，平掉整个订单的除外
+			return
+		}
+	}
 	err := o.updateByMyTrade(iod, trade)
 	if err != nil {
 		log.Error("updateByMyTrade fail", zap.String("key", iod.Key()),
@@ -1117,72 +1158,84 @@ func (o *LiveOrderMgr) TrialUnMatchesForever() {
 			if !core.Sleep(time.Second * 3) {
 				return
 			}
-			var pairTrades = make(map[string][]*banexg.MyTrade)
-			expireMS := btime.TimeMS() - 1000
-			data := make(map[string]*banexg.MyTrade)
-			o.lockUnMatches.Lock()
-			for key, trade := range o.unMatchTrades {
-				if trade.Timestamp >= expireMS {
-					continue
-				}
-				data[key] = trade
-				delete(o.unMatchTrades, key)
-			}
-			o.lockUnMatches.Unlock()
-			for _, trade := range data {
-				odKey := trade.Symbol + trade.Order
-				o.lockExgIdMap.Lock()
-				iod, ok := o.exgIdMap[odKey]
-				o.lockExgIdMap.Unlock()
-				if ok {
-					lock := iod.Lock()
-					err := o.updateByMyTrade(iod, trade)
-					lock.Unlock()
-					if err != nil {
-						log.Error("updateByMyTrade fail", zap.String("key", iod.Key()),
-							zap.String("trade", trade.ID), zap.Error(err))
-					}
-					continue
-				}
-				if getClientOrderId(trade.ClientID) == 0 {
-					// Record non-robot orders to check if a third party closes or places an order
-					// 记录非机器人订单，检查是否第三方平仓或下单
-					odTrades, _ := pairTrades[odKey]
-					pairTrades[odKey] = append(odTrades, trade)
-				}
-			}
-			unHandleNum := 0
-			allowTakeOver := config.TakeOverStrat != ""
-			// Traverse third-party orders to check whether they are closed or tracked
-			// 遍历第三方订单，检查是否平仓或跟踪
-			for _, trades := range pairTrades {
-				exOd, err := banexg.MergeMyTrades(trades)
-				if err != nil {
-					log.Error("MergeMyTrades fail", zap.Int("num", len(trades)), zap.Error(err))
-					continue
-				}
-				if o.exitByMyOrder(exOd) {
-					continue
-				} else if allowTakeOver && o.traceExgOrder(exOd) {
-					continue
-				}
-				unHandleNum += 1
-			}
-			if unHandleNum > 0 {
-				log.Warn(fmt.Sprintf("expired unmatch orders: %v", unHandleNum))
-			}
-			err := ormo.SaveDirtyODs(orm.DbTrades, o.Account)
+			o.trialUnMatches()
+		}
+	}()
+}
+
+/*
+trialUnMatches
+Apply unmatched trades older than 1s to tracked orders, or handle them as third-party orders
+将超过1秒的未匹配交易应用到跟踪的订单，或作为第三方订单处理
+*/
+func (o *LiveOrderMgr) trialUnMatches() {
+	var pairTrades = make(map[string][]*banexg.MyTrade)
+	expireMS := btime.TimeMS() - 1000
+	data := make(map[string]*banexg.MyTrade)
+	o.lockUnMatches.Lock()
+	for key, trade := range o.unMatchTrades {
+		if trade.Timestamp >= expireMS {
+			continue
+		}
+		data[key] = trade
+		delete(o.unMatchTrades, key)
+	}
+	o.lockUnMatches.Unlock()
+	for _, trade := range data {
+		odKey := trade.Symbol + trade.Order
+		o.lockExgIdMap.Lock()
+		iod, ok := o.exgIdMap[odKey]
+		o.lockExgIdMap.Unlock()
+		if ok {
+			lock := iod.Lock()
+			err := o.updateByMyTrade(iod, trade)
+			lock.Unlock()
 			if err != nil {
-				log.Error("SaveDirtyODs fail", zap.Error(err))
+				log.Error("updateByMyTrade fail", zap.String("key", iod.Key()),
+					zap.String("trade", trade.ID), zap.Error(err))
 			}
+			continue
 		}
-	}()
+		if getClientOrderId(trade.ClientID) == 0 {
+			// Record non-robot orders to check if a third party closes or places an order
+			// 记录非机器人订单，检查是否第三方平仓或下单
+			odTrades, _ := pairTrades[odKey]
+			pairTrades[odKey] = append(odTrades, trade)
+		}
+	}
+	unHandleNum := 0
+	allowTakeOver := config.TakeOverStrat != ""
+	// Traverse third-party orders to check whether they are closed or tracked
+	// 遍历第三方订单，检查是否平仓或跟踪
+	for _, trades := range pairTrades {
+		exOd, err := banexg.MergeMyTrades(trades)
+		if err != nil {
+			log.Error("MergeMyTrades fail", zap.Int("num", len(trades)), zap.Error(err))
+			continue
+		}
+		if o.exitByMyOrder(exOd) {
+			continue
+		} else if allowTakeOver && o.traceExgOrder(exOd) {
+			continue
+		}
+		unHandleNum += 1
+	}
+	if unHandleNum > 0 {
+		log.Warn(fmt.Sprintf("expired unmatch orders: %v", unHandleNum))
+	}
+	err := ormo.SaveDirtyODs(orm.DbTrades, o.Account)
+	if err != nil {
+		log.Error("SaveDirtyODs fail", zap.Error(err))
+	}
 }
 
 func (o *LiveOrderMgr) updateByMyTrade(od *ormo.InOutOrder, trade *banexg.MyTrade) *errs.Error {
 	if trade.State == banexg.OdStatusOpen {
 		return nil
 	}
+	if leg := od.GetLeg(trade.Order); leg != nil {
+		return o.updateLegByTrade(od, leg, trade)
+	}
 	sl := od.GetStopLoss()
 	tp := od.GetTakeProfit()
 	isSell := trade.Side == banexg.OdSideSell
@@ -1270,6 +1323,9 @@ func (o *LiveOrderMgr) updateByMyTrade(od *ormo.InOutOrder, trade *banexg.MyTrad
 	} else {
 		log.Error(fmt.Sprintf("unknown bnb order status: %s", state))
 	}
+	if (isStopLoss || isTakeProfit) && subOd.Filled > 0 {
+		o.cancelOcoTrigger(od, isStopLoss)
+	}
 	if od.Status == ormo.InOutStatusFullExit {
 		// May be triggered by stop loss or take profit, delete and set to completed
 		// 可能由止盈止损触发，删除置为已完成
@@ -1358,6 +1414,21 @@ func (o *LiveOrderMgr) execOrderEnter(od *ormo.InOutOrder) *errs.Error {
 }
 
 func (o *LiveOrderMgr) tryExitEnter(od *ormo.InOutOrder) *errs.Error {
+	if leg := od.PendingLeg(); leg != nil {
+		// Cancel the unfilled part of adding position before exit
+		// 退出前取消加仓的未成交部分
+		if leg.OrderID != "" {
+			order, err := exg.Default.CancelOrder(leg.OrderID, od.Symbol, map[string]interface{}{
+				banexg.ParamAccount: o.Account,
+			})
+			if err != nil {
+				log.Error("cancel leg fail", zap.String("key", od.Key()), zap.String("err", err.Short()))
+			} else {
+				o.updateLegByExgRes(od, leg, order)
+			}
+		}
+		o.cancelLeg(od)
+	}
 	if od.Enter.Status == ormo.OdStatusClosed {
 		return nil
 	}
@@ -1570,7 +1641,7 @@ func (o *LiveOrderMgr) updateOdByExgRes(od *ormo.InOutOrder, isEnter bool, res *
 				subOd.FeeType = res.Fee.Currency
 			}
 		}
-		if res.Status == "expired" || res.Status == "rejected" || res.Status == "closed" || res.Status == "canceled" {
+		if banexg.IsOrderDone(res.Status) {
 			subOd.Status = ormo.OdStatusClosed
 			if subOd.Filled > 0 && subOd.Average > 0 {
 				subOd.Price = subOd.Average
@@ -1608,6 +1679,199 @@ func (o *LiveOrderMgr) updateOdByExgRes(od *ormo.InOutOrder, isEnter bool, res *
 	return o.consumeUnMatches(od, subOd)
 }
 
+/*
+execOrderAdd
+Submit the pending leg of adding position to the exchange
+将待加仓的入场腿提交到交易所
+*/
+func (o *LiveOrderMgr) execOrderAdd(od *ormo.InOutOrder) *errs.Error {
+	leg := od.PendingLeg()
+	if leg == nil || leg.OrderID != "" {
+		return nil
+	}
+	if od.ExitTag != "" || od.Status >= ormo.InOutStatusFullExit {
+		// 订单已退出，不提交到交易所
+		od.CancelLeg()
+		return nil
+	}
+	exchange := exg.Default
+	var err *errs.Error
+	if leg.Price == 0 && leg.OrderType != banexg.OdTypeMarket {
+		buyPrice, sellPrice := o.getLimitPrice(od.Symbol, config.LimitVolSecs)
+		price := sellPrice
+		if leg.Side == banexg.OdSideBuy {
+			price = buyPrice
+		}
+		leg.Price, err = exg.PrecPrice(exchange, od.Symbol, price)
+		if err != nil {
+			od.CancelLeg()
+			return err
+		}
+	}
+	params := map[string]interface{}{
+		banexg.ParamAccount:       o.Account,
+		banexg.ParamClientOrderId: od.ClientId(true),
+	}
+	if core.IsContract {
+		params[banexg.ParamPositionSide] = "LONG"
+		if od.Short {
+			params[banexg.ParamPositionSide] = "SHORT"
+		}
+	}
+	res, err := exchange.CreateOrder(od.Symbol, leg.OrderType, leg.Side, leg.Amount, leg.Price, params)
+	if err != nil {
+		od.CancelLeg()
+		return err
+	}
+	o.updateLegByExgRes(od, leg, res)
+	if od.PendingLeg() == nil {
+		// Filled immediately, replace stop loss and take profit orders with new amount
+		// 立即成交，按新数量重新提交止损止盈单
+		o.editTriggerOd(od, ormo.OdActionStopLoss)
+		o.editTriggerOd(od, ormo.OdActionTakeProfit)
+	}
+	return nil
+}
+
+/*
+updateLegByExgRes
+Update the leg of adding position with order returned from exchange
+使用交易所返回的订单更新加仓入场腿
+*/
+func (o *LiveOrderMgr) updateLegByExgRes(od *ormo.InOutOrder, leg *ormo.ExOrder, res *banexg.Order) {
+	leg.OrderID = res.ID
+	od.DirtyInfo = true
+	o.lockExgIdMap.Lock()
+	o.exgIdMap[od.Symbol+leg.OrderID] = od
+	o.lockExgIdMap.Unlock()
+	if !o.hasNewTrades(res) || leg.UpdateAt > res.Timestamp {
+		return
+	}
+	leg.UpdateAt = res.Timestamp
+	if res.Filled > leg.Filled {
+		average := res.Average
+		if average == 0 {
+			average = res.Price
+		}
+		fee := leg.Fee
+		if res.Fee != nil && res.Fee.Cost > 0 {
+			fee = res.Fee.Cost
+		}
+		od.FillLeg(leg, res.Filled, average, fee)
+	}
+	if banexg.IsOrderDone(res.Status) {
+		o.cancelLeg(od)
+	}
+	strat.FireOdChange(o.Account, od, strat.OdChgEnterFill)
+}
+
+/*
+updateLegByTrade
+Update the leg of adding position with cumulative filled, average and fee in trade
+使用trade中累计的成交量、均价、手续费更新加仓入场腿
+*/
+func (o *LiveOrderMgr) updateLegByTrade(od *ormo.InOutOrder, leg *ormo.ExOrder, trade *banexg.MyTrade) *errs.Error {
+	if leg.Status == ormo.OdStatusClosed || trade.Timestamp < leg.UpdateAt {
+		return nil
+	}
+	leg.UpdateAt = trade.Timestamp
+	state := trade.State
+	if state == banexg.OdStatusFilled || state == banexg.OdStatusPartFilled {
+		fee := leg.Fee
+		if trade.Fee != nil {
+			fee = trade.Fee.Cost
+		}
+		od.FillLeg(leg, trade.Filled, trade.Average, fee)
+		if state == banexg.OdStatusFilled {
+			o.cancelLeg(od)
+		}
+	} else if banexg.IsOrderDone(state) {
+		o.cancelLeg(od)
+	} else {
+		log.Error(fmt.Sprintf("unknown bnb order status: %s", state))
+	}
+	strat.FireOdChange(o.Account, od, strat.OdChgEnterFill)
+	return nil
+}
+
+/*
+cancelLeg
+Close the pending leg of the order, and ignore the later trades of it
+关闭订单的待成交加仓腿，并忽略其后续交易
+*/
+func (o *LiveOrderMgr) cancelLeg(od *ormo.InOutOrder) {
+	leg := od.PendingLeg()
+	if leg == nil {
+		return
+	}
+	if leg.OrderID != "" {
+		o.lockDoneKeys.Lock()
+		o.doneKeys[od.Symbol+leg.OrderID] = true
+		o.lockDoneKeys.Unlock()
+	}
+	od.CancelLeg()
+}
+
+/*
+cancelExpiredLegs
+Cancel the left of limit legs reaching StopEnterBars on exchange, the filled part is kept. Return changed orders
+在交易所取消达到StopEnterBars的限价加仓腿的剩余部分，保留已成交部分。返回有变化的订单
+*/
+func (o *LiveOrderMgr) cancelExpiredLegs() []*ormo.InOutOrder {
+	openOds, lock := ormo.GetOpenODs(o.Account)
+	lock.Lock()
+	orders := utils.ValsOfMap(openOds)
+	lock.Unlock()
+	curMS := btime.TimeMS()
+	var res []*ormo.InOutOrder
+	for _, od := range orders {
+		leg := od.PendingLeg()
+		if leg == nil || leg.Price == 0 || od.ExitTag != "" {
+			continue
+		}
+		stopAfter := od.GetInfoInt64(ormo.OdInfoStopAfter)
+		if stopAfter == 0 || stopAfter > curMS {
+			continue
+		}
+		if o.cancelExpiredLeg(od) {
+			res = append(res, od)
+		}
+	}
+	return res
+}
+
+func (o *LiveOrderMgr) cancelExpiredLeg(od *ormo.InOutOrder) bool {
+	lock := od.Lock()
+	defer lock.Unlock()
+	leg := od.PendingLeg()
+	if leg == nil {
+		return false
+	}
+	if leg.OrderID != "" {
+		res, err := exg.Default.CancelOrder(leg.OrderID, od.Symbol, map[string]interface{}{
+			banexg.ParamAccount: o.Account,
+		})
+		if err != nil {
+			// keep the leg and retry next time, it may still be filled on exchange
+			// 保留加仓腿下次重试，其仍可能在交易所成交
+			log.Error("cancel expired leg fail", zap.String("key", od.Key()), zap.Error(err))
+			return false
+		}
+		o.updateLegByExgRes(od, leg, res)
+	}
+	if od.PendingLeg() != nil {
+		o.cancelLeg(od)
+		strat.FireOdChange(o.Account, od, strat.OdChgEnterFill)
+	}
+	if leg.Filled > 0 {
+		// replace stop loss and take profit orders with the filled amount
+		// 按已成交数量重新提交止损止盈单
+		o.editTriggerOd(od, ormo.OdActionStopLoss)
+		o.editTriggerOd(od, ormo.OdActionTakeProfit)
+	}
+	return true
+}
+
 func (o *LiveOrderMgr) hasNewTrades(res *banexg.Order) bool {
 	if core.IsContract {
 		// 期货市场未返回trades，直接认为需要更新
@@ -1892,6 +2156,7 @@ func verifyAccountTriggerOds(account string) {
 			Action: tag,
 		}
 	}
+	saves = append(saves, odMgr.cancelExpiredLegs()...)
 	if len(saves) > 0 {
 		saveIOrders(saves)
 	}
@@ -2015,7 +2280,10 @@ func (o *LiveOrderMgr) editTriggerOd(od *ormo.InOutOrder, prefix string) {
 	}
 	tg.SaveOld()
 	od.DirtyInfo = true
-	if tg.Price <= 0 {
+	// Trailing stop loss is submitted as a native order when supported, otherwise emulated by TrailStopLosses
+	// 跟踪止损在支持时提交为原生订单，否则由TrailStopLosses模拟
+	native := prefix == ormo.OdActionStopLoss && tg.IsTrailing() && isNativeTrailing()
+	if tg.Price <= 0 && !native {
 		// Stop loss/take profit is not set, or needs to be cancelled
 		// 未设置止损/止盈，或需要撤销
 		if tg.OrderId != "" {
@@ -2048,7 +2316,14 @@ func (o *LiveOrderMgr) editTriggerOd(od *ormo.InOutOrder, prefix string) {
 	}
 	// 这里不应设置ClosePosition仓位止盈止损，否则多策略或多个订单止盈止损会互相覆盖
 	// 双向持仓无需设置ReduceOnly
-	if prefix == ormo.OdActionStopLoss {
+	if native {
+		odType = banexg.OdTypeTrailingStopMarket
+		price = 0
+		params[banexg.ParamCallbackRate] = trailCallbackPct(od, tg)
+		if tg.Activate > 0 {
+			params["activationPrice"] = tg.Activate
+		}
+	} else if prefix == ormo.OdActionStopLoss {
 		params[banexg.ParamStopLossPrice] = tg.Price
 	} else if prefix == ormo.OdActionTakeProfit {
 		params[banexg.ParamTakeProfitPrice] = tg.Price
@@ -2108,6 +2383,124 @@ func (o *LiveOrderMgr) editTriggerOd(od *ormo.InOutOrder, prefix string) {
 	}
 }
 
+/*
+isNativeTrailing
+Whether the exchange accepts native trailing stop orders, others are emulated by TrailStopLosses
+交易所是否支持原生跟踪止损单，其他交易所由TrailStopLosses模拟
+*/
+func isNativeTrailing() bool {
+	return core.ExgName == "binance" && core.IsContract
+}
+
+/*
+trailCallbackPct
+Callback percent of the native trailing stop order, Offset is converted by the activation or latest price.
+原生跟踪止损单的回调百分比，Offset按激活价或最新价转换
+*/
+func trailCallbackPct(od *ormo.InOutOrder, tg *ormo.TriggerState) float64 {
+	rate := tg.Callback
+	if rate <= 0 {
+		refPrice := tg.Activate
+		if refPrice <= 0 {
+			refPrice = core.GetPrice(od.Symbol)
+		}
+		if refPrice <= 0 {
+			refPrice = od.Enter.Average
+		}
+		rate = tg.Offset / refPrice
+	}
+	// binance accepts [0.1, 10] with 1 decimal place  币安支持[0.1, 10]，保留1位小数
+	return min(10, max(0.1, math.Round(rate*1000)/10))
+}
+
+/*
+TrailStopLosses
+Move emulated trailing stop losses by the latest prices and replace the exchange stop orders, should be called
+periodically. Only for real trading on exchanges without native trailing orders.
+按最新价格移动模拟的跟踪止损，并替换交易所止损单，应被定期调用。仅用于不支持原生跟踪单的交易所实盘
+*/
+func TrailStopLosses() {
+	if isNativeTrailing() {
+		return
+	}
+	for account := range config.Accounts {
+		odMgr := GetLiveOdMgr(account)
+		if odMgr == nil {
+			continue
+		}
+		openOds, lock := ormo.GetOpenODs(account)
+		lock.Lock()
+		ods := make([]*ormo.InOutOrder, 0, len(openOds))
+		for _, od := range openOds {
+			ods = append(ods, od)
+		}
+		lock.Unlock()
+		for _, od := range ods {
+			if odMgr.trailStopLoss(od, core.GetPrice(od.Symbol)) {
+				odMgr.queue <- &OdQItem{Order: od, Action: ormo.OdActionStopLoss}
+			}
+		}
+	}
+}
+
+/*
+trailStopLoss
+Move the emulated trailing stop loss of the entered order by price, return whether the stop order should be replaced
+按价格移动已入场订单的模拟跟踪止损，返回是否需要替换止损单
+*/
+func (o *LiveOrderMgr) trailStopLoss(od *ormo.InOutOrder, price float64) bool {
+	if price <= 0 || isNativeTrailing() || od.Status != ormo.InOutStatusFullEnter || od.ExitTag != "" {
+		return false
+	}
+	lock := od.Lock()
+	defer lock.Unlock()
+	sl := od.GetStopLoss()
+	if sl == nil || sl.Hit || !sl.TrailTo(od.Short, price) {
+		return false
+	}
+	od.DirtyInfo = true
+	return true
+}
+
+/*
+cancelOcoTrigger
+Stop loss and take profit are one-cancels-other: once one of them is filled, cancel the other on exchange.
+止损和止盈为二选一：其中一个成交后，撤销交易所的另一个
+*/
+func (o *LiveOrderMgr) cancelOcoTrigger(od *ormo.InOutOrder, isStopLoss bool) {
+	tg := od.GetTakeProfit()
+	if !isStopLoss {
+		tg = od.GetStopLoss()
+	}
+	if tg == nil || tg.OrderId == "" {
+		return
+	}
+	_, err := exg.Default.CancelOrder(tg.OrderId, od.Symbol, map[string]interface{}{
+		banexg.ParamAccount: o.Account,
+	})
+	if err != nil {
+		// keep OrderId so cancelTriggerOds can retry when the order is closed 保留OrderId，以便订单平仓时cancelTriggerOds重试
+		log.Warn("cancel oco trigger fail", zap.String("key", od.Key()), zap.String("err", err.Short()))
+		return
+	}
+	tg.OrderId = ""
+	od.DirtyInfo = true
+}
+
+/*
+isFullTrigger
+Whether orderId is the stop loss or take profit order closing the whole position of od
+orderId是否为平掉od全部仓位的止损或止盈单
+*/
+func isFullTrigger(od *ormo.InOutOrder, orderId string) bool {
+	for _, tg := range []*ormo.TriggerState{od.GetStopLoss(), od.GetTakeProfit()} {
+		if tg != nil && tg.ExitTrigger != nil && tg.OrderId == orderId && (tg.Rate <= 0 || tg.Rate >= 1) {
+			return true
+		}
+	}
+	return false
+}
+
 /*
 cancelTriggerOds
 Cancel the associated order of the order. When the order is closed, the associated stop loss order and take profit order will not be automatically exited, and this method needs to be called to exit
@@ -2167,6 +2560,13 @@ func (o *LiveOrderMgr) finishOrder(od *ormo.InOutOrder, sess *ormo.Queries) *err
 		o.doneKeys[od.Symbol+od.Exit.OrderID] = true
 		o.lockDoneKeys.Unlock()
 	}
+	for _, leg := range od.GetLegs() {
+		if leg.OrderID != "" {
+			o.lockDoneKeys.Lock()
+			o.doneKeys[od.Symbol+leg.OrderID] = true
+			o.lockDoneKeys.Unlock()
+		}
+	}
 	log.Info("Finish Order", zap.String("acc", o.Account), zap.String("key", od.Key()),
 		zap.String("tag", od.ExitTag))
 	return o.OrderMgr.finishOrder(od, sess)
@@ -0,0 +1,493 @@
+package biz
+
+import (
+	"testing"
+
+	"github.com/banbox/banbot/btime"
+	"github.com/banbox/banbot/config"
+	"github.com/banbox/banbot/core"
+	"github.com/banbox/banbot/exg"
+	"github.com/banbox/banbot/orm"
+	"github.com/banbox/banbot/orm/ormo"
+	"github.com/banbox/banexg"
+	"github.com/banbox/banexg/bex"
+	"github.com/banbox/banexg/errs"
+)
+
+func setupMockExg(t *testing.T) *exg.MockExchange {
+	inner, err := bex.New("binance", map[string]interface{}{
+		banexg.OptMarketType: banexg.MarketLinear,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock := exg.NewMockExchange(inner)
+	oldExg, oldName, oldContract := exg.Default, core.ExgName, core.IsContract
+	exg.Default, core.ExgName, core.IsContract = mock, "binance", true
+	t.Cleanup(func() {
+		exg.Default, core.ExgName, core.IsContract = oldExg, oldName, oldContract
+	})
+	return mock
+}
+
+/*
+setupLiveTask
+Use a sqlite trades db in temp dir for the default account, restore ormo vars after test
+为默认账户在临时目录使用sqlite交易库，测试后恢复ormo变量
+*/
+func setupLiveTask(t *testing.T) {
+	oldLive, oldAccs, oldName := core.LiveMode, config.Accounts, config.Name
+	backup := ormo.BackupVars()
+	core.LiveMode = true
+	config.Accounts = map[string]*config.AccountConfig{config.DefAcc: {}}
+	config.Name = "live_test"
+	ormo.ResetVars()
+	t.Cleanup(func() {
+		core.LiveMode, config.Accounts, config.Name = oldLive, oldAccs, oldName
+		ormo.RestoreVars(backup)
+	})
+	if err := ormo.InitTask(false, t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newLiveOd(pair string, price, amount float64, enterAt int64) *ormo.InOutOrder {
+	taskId := ormo.GetTaskID(config.DefAcc)
+	return &ormo.InOutOrder{
+		IOrder: &ormo.IOrder{TaskID: taskId, Symbol: pair, Timeframe: "1m",
+			Strategy: "live_test", InitPrice: price, QuoteCost: price * amount, Leverage: 1, EnterAt: enterAt},
+		Enter: &ormo.ExOrder{TaskID: taskId, Symbol: pair, Enter: true, OrderType: banexg.OdTypeLimit, Side: banexg.OdSideBuy,
+			Price: price, Amount: amount, CreateAt: enterAt},
+		Info: map[string]interface{}{},
+	}
+}
+
+func liveOdParams(od *ormo.InOutOrder) map[string]interface{} {
+	return map[string]interface{}{
+		banexg.ParamAccount:       config.DefAcc,
+		banexg.ParamPositionSide:  "LONG",
+		banexg.ParamClientOrderId: od.ClientId(true),
+	}
+}
+
+func TestLiveEditTriggerOd(t *testing.T) {
+	mock := setupMockExg(t)
+	pair := "ETH/USDT:USDT"
+	mock.OnBar(pair, "1m", &banexg.Kline{Time: 1700000000000, Open: 100, High: 101, Low: 99, Close: 100, Volume: 10})
+	o := newLiveOrderMgr("user1", func(od *ormo.InOutOrder, isEnter bool) {})
+	od := &ormo.InOutOrder{
+		IOrder: &ormo.IOrder{ID: 1, Symbol: pair, Status: ormo.InOutStatusFullEnter},
+		Enter:  &ormo.ExOrder{Amount: 2, Filled: 2, Average: 100},
+		Info:   map[string]interface{}{},
+	}
+	getOpens := func() []*banexg.Order {
+		res, err := mock.FetchOpenOrders(pair, 0, 0, map[string]interface{}{banexg.ParamAccount: "user1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	od.SetStopLoss(&ormo.ExitTrigger{Price: 95})
+	o.editTriggerOd(od, ormo.OdActionStopLoss)
+	opens := getOpens()
+	if len(opens) != 1 || opens[0].StopLossPrice != 95 || opens[0].Amount != 2 || opens[0].Side != banexg.OdSideSell {
+		t.Fatalf("expect a stop loss order at 95, got %v", opens)
+	}
+	firstId := od.GetStopLoss().OrderId
+	// unchanged trigger is not submitted again
+	o.editTriggerOd(od, ormo.OdActionStopLoss)
+	if len(getOpens()) != 1 || od.GetStopLoss().OrderId != firstId {
+		t.Fatal("unchanged stop loss should not be replaced")
+	}
+	// moving the stop loss replaces the old order
+	od.SetStopLoss(&ormo.ExitTrigger{Price: 97, Rate: 0.5})
+	o.editTriggerOd(od, ormo.OdActionStopLoss)
+	opens = getOpens()
+	if len(opens) != 1 || opens[0].ID == firstId || opens[0].StopLossPrice != 97 || opens[0].Amount != 1 {
+		t.Fatalf("expect the stop loss moved to 97 for half amount, got %v", opens)
+	}
+	// a failed update keeps the old order
+	moveId := opens[0].ID
+	mock.InjectErr("CreateOrder", errs.NewMsg(errs.CodeNetFail, "timeout"))
+	od.SetStopLoss(&ormo.ExitTrigger{Price: 98})
+	o.editTriggerOd(od, ormo.OdActionStopLoss)
+	opens = getOpens()
+	if len(opens) != 1 || opens[0].ID != moveId {
+		t.Fatalf("old stop loss should be kept when update fails, got %v", opens)
+	}
+	od.SetStopLoss(&ormo.ExitTrigger{Price: 0})
+	o.editTriggerOd(od, ormo.OdActionStopLoss)
+	if len(getOpens()) != 0 {
+		t.Fatal("removed stop loss should be canceled on exchange")
+	}
+}
+
+func TestLiveTrailingTriggerOd(t *testing.T) {
+	mock := setupMockExg(t)
+	pair := "ETH/USDT:USDT"
+	mock.OnBar(pair, "1m", &banexg.Kline{Time: 1700000000000, Open: 100, High: 110, Low: 99, Close: 110, Volume: 10})
+	o := newLiveOrderMgr("user1", func(od *ormo.InOutOrder, isEnter bool) {})
+	getOpens := func() []*banexg.Order {
+		res, err := mock.FetchOpenOrders(pair, 0, 0, map[string]interface{}{banexg.ParamAccount: "user1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	newOd := func(id int64) *ormo.InOutOrder {
+		od := &ormo.InOutOrder{
+			IOrder: &ormo.IOrder{ID: id, Symbol: pair, Status: ormo.InOutStatusFullEnter},
+			Enter:  &ormo.ExOrder{Amount: 2, Filled: 2, Average: 100},
+			Info:   map[string]interface{}{},
+		}
+		od.SetStopLoss(&ormo.ExitTrigger{Activate: 105, Callback: 0.02})
+		return od
+	}
+	// emulated: the stop order is placed after activation and replaced when trailing
+	// 模拟：激活后下止损单，跟踪时替换
+	core.ExgName = "okx"
+	od := newOd(1)
+	o.editTriggerOd(od, ormo.OdActionStopLoss)
+	if len(getOpens()) != 0 || o.trailStopLoss(od, 104) {
+		t.Fatal("trailing stop should not be placed before activation")
+	}
+	if !o.trailStopLoss(od, 108) {
+		t.Fatal("trailing stop should move after activation")
+	}
+	o.editTriggerOd(od, ormo.OdActionStopLoss)
+	if !o.trailStopLoss(od, 110) || o.trailStopLoss(od, 109) {
+		t.Fatal("trailing stop should only move toward profit")
+	}
+	o.editTriggerOd(od, ormo.OdActionStopLoss)
+	opens := getOpens()
+	if len(opens) != 1 || opens[0].Type != banexg.OdTypeStopMarket || opens[0].StopLossPrice != 110*0.98 {
+		t.Fatalf("expect a stop loss order moved to 107.8, got %v", opens)
+	}
+	_, _ = mock.CancelOrder(opens[0].ID, pair, map[string]interface{}{banexg.ParamAccount: "user1"})
+	// native: submitted once as a trailing stop order, with take profit as its oco pair
+	// 原生：提交一次跟踪止损单，和止盈单互为二选一
+	core.ExgName = "binance"
+	od = newOd(2)
+	od.SetTakeProfit(&ormo.ExitTrigger{Price: 120})
+	o.editTriggerOd(od, ormo.OdActionStopLoss)
+	o.editTriggerOd(od, ormo.OdActionTakeProfit)
+	if o.trailStopLoss(od, 112) {
+		t.Fatal("native trailing stop should not be moved locally")
+	}
+	opens = getOpens()
+	if len(opens) != 2 {
+		t.Fatalf("expect trailing stop and take profit orders, got %v", opens)
+	}
+	sl := od.GetStopLoss()
+	mock.OnBar(pair, "1m", &banexg.Kline{Time: 1700000060000, Open: 110, High: 115, Low: 110, Close: 114, Volume: 10})
+	mock.OnBar(pair, "1m", &banexg.Kline{Time: 1700000120000, Open: 114, High: 114, Low: 111, Close: 111, Volume: 10})
+	slOd, err := mock.FetchOrder(pair, sl.OrderId, map[string]interface{}{banexg.ParamAccount: "user1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if slOd.Type != banexg.OdTypeTrailingStopMarket || slOd.Status != banexg.OdStatusFilled ||
+		slOd.Average != 115*0.98 {
+		t.Fatalf("trailing stop should be filled at 112.7, got %v", slOd)
+	}
+	if !isFullTrigger(od, sl.OrderId) {
+		t.Fatal("stop loss without rate should close the whole position")
+	}
+	// a failed cancel keeps the take profit order id for retry 撤销失败时保留止盈单ID以便重试
+	tpId := od.GetTakeProfit().OrderId
+	mock.InjectErr("CancelOrder", errs.NewMsg(errs.CodeNetFail, "timeout"))
+	o.cancelOcoTrigger(od, true)
+	if od.GetTakeProfit().OrderId != tpId || len(getOpens()) != 1 {
+		t.Fatal("take profit should be kept when cancel fails")
+	}
+	err = o.updateByMyTrade(od, &banexg.MyTrade{Trade: banexg.Trade{Symbol: pair, Side: banexg.OdSideSell,
+		Type: slOd.Type, Amount: 1, Price: slOd.Average, Order: slOd.ID, Timestamp: slOd.Timestamp},
+		Filled: 1, Average: slOd.Average, State: banexg.OdStatusPartFilled})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opens = getOpens(); len(opens) != 0 || od.GetTakeProfit().OrderId != "" {
+		t.Fatalf("take profit should be canceled once stop loss filled, got %v", opens)
+	}
+	od.SetTakeProfit(&ormo.ExitTrigger{Price: 120, Rate: 0.995})
+	od.GetTakeProfit().OrderId = tpId
+	if isFullTrigger(od, tpId) {
+		t.Fatal("take profit with rate < 1 should not close the whole position")
+	}
+}
+
+func TestLiveSyncExgOrders(t *testing.T) {
+	mock := setupMockExg(t)
+	setupLiveTask(t)
+	pair, lostPair := "ETH/USDT:USDT", "BTC/USDT:USDT"
+	orm.CacheExSymbols(&orm.ExSymbol{ID: 1, Exchange: "binance", Market: banexg.MarketLinear, Symbol: pair},
+		&orm.ExSymbol{ID: 2, Exchange: "binance", Market: banexg.MarketLinear, Symbol: lostPair})
+	stamp := int64(1700000000000)
+	mock.OnBar(pair, "1m", &banexg.Kline{Time: stamp, Open: 100, High: 101, Low: 99, Close: 100, Volume: 10})
+	// entry submitted before restart, filled while the bot is down
+	od := newLiveOd(pair, 100, 1, stamp)
+	if err := od.Save(nil); err != nil {
+		t.Fatal(err)
+	}
+	res, err := mock.CreateOrder(pair, banexg.OdTypeLimit, banexg.OdSideBuy, 1, 100, liveOdParams(od))
+	if err != nil {
+		t.Fatal(err)
+	}
+	od.Enter.OrderID = res.ID
+	od.DirtyEnter = true
+	if err = od.Save(nil); err != nil {
+		t.Fatal(err)
+	}
+	mock.OnBar(pair, "1m", &banexg.Kline{Time: stamp + 60000, Open: 100, High: 100, Low: 98, Close: 99, Volume: 10})
+	// entered order whose position is closed on exchange
+	lost := newLiveOd(lostPair, 30000, 0.01, stamp)
+	lost.Status = ormo.InOutStatusFullEnter
+	lost.Enter.OrderID = "lost"
+	lost.Enter.Status = ormo.OdStatusClosed
+	lost.Enter.Filled = 0.01
+	lost.Enter.Average = 30000
+	if err = lost.Save(nil); err != nil {
+		t.Fatal(err)
+	}
+	o := newLiveOrderMgr(config.DefAcc, func(od *ormo.InOutOrder, isEnter bool) {})
+	oldList, newList, delList, err := o.SyncExgOrders()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(oldList) != 1 || oldList[0].ID != od.ID || len(newList) != 0 {
+		t.Fatalf("expect only the filled entry restored, got old %v new %v", oldList, newList)
+	}
+	restored := oldList[0]
+	if restored.Status != ormo.InOutStatusFullEnter || restored.Enter.Filled != 1 || restored.Enter.Average != 100 {
+		t.Fatalf("entry should be restored as filled, got status %v filled %v average %v", restored.Status,
+			restored.Enter.Filled, restored.Enter.Average)
+	}
+	if len(delList) != 1 || delList[0].ID != lost.ID || delList[0].ExitTag != core.ExitTagFatalErr {
+		t.Fatalf("order without position should be closed, got %v", delList)
+	}
+	openOds, lock := ormo.GetOpenODs(config.DefAcc)
+	lock.Lock()
+	_, hasLost := openOds[lost.ID]
+	lock.Unlock()
+	if hasLost {
+		t.Fatal("closed order should be removed from open orders")
+	}
+}
+
+func TestLiveTrialUnMatches(t *testing.T) {
+	mock := setupMockExg(t)
+	setupLiveTask(t)
+	pair := "ETH/USDT:USDT"
+	stamp := int64(1700000000000)
+	mock.OnBar(pair, "1m", &banexg.Kline{Time: stamp, Open: 100, High: 101, Low: 99, Close: 100, Volume: 10})
+	o := newLiveOrderMgr(config.DefAcc, func(od *ormo.InOutOrder, isEnter bool) {})
+	out, err := mock.WatchMyTrades(map[string]interface{}{banexg.ParamAccount: config.DefAcc})
+	if err != nil {
+		t.Fatal(err)
+	}
+	od := newLiveOd(pair, 100, 1, stamp)
+	if err = od.Save(nil); err != nil {
+		t.Fatal(err)
+	}
+	res, err := mock.CreateOrder(pair, banexg.OdTypeLimit, banexg.OdSideBuy, 1, 100, liveOdParams(od))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = o.updateOdByExgRes(od, true, res); err != nil {
+		t.Fatal(err)
+	}
+	// fill trade arrives before the order is tracked, kept as unmatched
+	mock.OnBar(pair, "1m", &banexg.Kline{Time: stamp + 60000, Open: 100, High: 100, Low: 98, Close: 99, Volume: 10})
+	trade := <-out
+	o.unMatchTrades[trade.Symbol+trade.ID] = trade
+	// recent trades wait for the next round
+	trade.Timestamp = btime.TimeMS()
+	o.trialUnMatches()
+	if od.Enter.Filled != 0 || len(o.unMatchTrades) != 1 {
+		t.Fatal("unmatched trade within 1s should not be applied")
+	}
+	trade.Timestamp = stamp + 120000
+	o.trialUnMatches()
+	if len(o.unMatchTrades) != 0 || od.Status != ormo.InOutStatusFullEnter || od.Enter.Filled != 1 {
+		t.Fatalf("unmatched trade should fill the entry, got status %v filled %v", od.Status, od.Enter.Filled)
+	}
+	// position closed by a third party on exchange
+	_, err = mock.CreateOrder(pair, banexg.OdTypeMarket, banexg.OdSideSell, 1, 0, map[string]interface{}{
+		banexg.ParamAccount:      config.DefAcc,
+		banexg.ParamPositionSide: "LONG",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	trade = <-out
+	o.unMatchTrades[trade.Symbol+trade.ID] = trade
+	o.trialUnMatches()
+	if od.Status != ormo.InOutStatusFullExit || od.Exit == nil || od.Exit.Filled != 1 {
+		t.Fatalf("third party close should exit the order, got status %v", od.Status)
+	}
+}
+
+func TestLiveVerifyTriggerOds(t *testing.T) {
+	setupMockExg(t)
+	oldReal, oldLimitSecs, oldMgrs := core.EnvReal, config.PutLimitSecs, accLiveOdMgrs
+	core.EnvReal, config.PutLimitSecs = true, 120
+	setupLiveTask(t)
+	pair := "ETH/USDT:USDT"
+	o := newLiveOrderMgr(config.DefAcc, func(od *ormo.InOutOrder, isEnter bool) {})
+	accLiveOdMgrs = map[string]*LiveOrderMgr{config.DefAcc: o}
+	// 1 per second traded, 10 bid for each price from 100 to 90
+	lockPairVolMap.Lock()
+	pairVolMap[pair+"_50"] = &PairValItem{AvgVol: 60, LastVol: 60, ExpireMS: btime.TimeMS() + 3600000}
+	lockPairVolMap.Unlock()
+	bids := make([][2]float64, 0, 11)
+	for p := 100; p >= 90; p-- {
+		bids = append(bids, [2]float64{float64(p), 10})
+	}
+	core.OdBooks[pair] = &banexg.OrderBook{Symbol: pair, TimeStamp: btime.TimeMS() + 3600000,
+		Bids: banexg.NewOdBookSide(true, 100, bids), Asks: banexg.NewOdBookSide(false, 100, nil)}
+	t.Cleanup(func() {
+		core.EnvReal, config.PutLimitSecs, accLiveOdMgrs = oldReal, oldLimitSecs, oldMgrs
+		lockPairVolMap.Lock()
+		delete(pairVolMap, pair+"_50")
+		lockPairVolMap.Unlock()
+		delete(core.OdBooks, pair)
+	})
+	stamp := btime.TimeMS()
+	addTrigger := func(price float64, stopAfter int64) *ormo.InOutOrder {
+		od := newLiveOd(pair, price, 1, stamp)
+		od.SetInfo(ormo.OdInfoStopAfter, stopAfter)
+		if err := od.Save(nil); err != nil {
+			t.Fatal(err)
+		}
+		ormo.AddTriggerOd(config.DefAcc, od)
+		return od
+	}
+	near := addTrigger(99.5, stamp+3600000)
+	far := addTrigger(50, stamp+3600000)
+	expired := addTrigger(50, stamp-1000)
+	VerifyTriggerOds()
+	if len(o.queue) != 1 {
+		t.Fatalf("expect 1 order submitted, got %v", len(o.queue))
+	}
+	if item := <-o.queue; item.Order != near || item.Action != ormo.OdActionEnter {
+		t.Fatalf("near limit should be submitted, got %v %s", item.Order.ID, item.Action)
+	}
+	triggers, lock := ormo.GetTriggerODs(config.DefAcc)
+	lock.Lock()
+	left := triggers[pair]
+	lock.Unlock()
+	if len(left) != 1 || left[far.ID] != far {
+		t.Fatalf("only the far limit should wait, got %v", left)
+	}
+	if expired.Status != ormo.InOutStatusFullExit || expired.ExitTag != core.ExitTagForceExit {
+		t.Fatalf("expired limit should be canceled, got status %v tag %s", expired.Status, expired.ExitTag)
+	}
+}
+
+func TestLiveOrderLegs(t *testing.T) {
+	mock := setupMockExg(t)
+	setupLiveTask(t)
+	oldReal, oldMgrs := core.EnvReal, accLiveOdMgrs
+	core.EnvReal = true
+	t.Cleanup(func() {
+		core.EnvReal, accLiveOdMgrs = oldReal, oldMgrs
+	})
+	pair := "ETH/USDT:USDT"
+	orm.CacheExSymbols(&orm.ExSymbol{ID: 1, Exchange: "binance", Market: banexg.MarketLinear, Symbol: pair})
+	stamp := int64(1700000000000)
+	mock.OnBar(pair, "1m", &banexg.Kline{Time: stamp, Open: 100, High: 101, Low: 99, Close: 100, Volume: 10})
+	o := newLiveOrderMgr(config.DefAcc, func(od *ormo.InOutOrder, isEnter bool) {})
+	accLiveOdMgrs = map[string]*LiveOrderMgr{config.DefAcc: o}
+	accParams := map[string]interface{}{banexg.ParamAccount: config.DefAcc}
+	getOpens := func() []*banexg.Order {
+		res, err := mock.FetchOpenOrders(pair, 0, 0, accParams)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+	// entered order with a stop loss on exchange
+	od := newLiveOd(pair, 100, 1, stamp)
+	res, err := mock.CreateOrder(pair, banexg.OdTypeMarket, banexg.OdSideBuy, 1, 0, liveOdParams(od))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = o.updateOdByExgRes(od, true, res); err != nil {
+		t.Fatal(err)
+	}
+	od.SetStopLoss(&ormo.ExitTrigger{Price: 95})
+	o.editTriggerOd(od, ormo.OdActionStopLoss)
+	if err = od.Save(nil); err != nil {
+		t.Fatal(err)
+	}
+	// market leg filled immediately, the stop loss is replaced with the new amount
+	od.AddLeg(banexg.OdTypeMarket, 0, 1)
+	if err = o.execOrderAdd(od); err != nil {
+		t.Fatal(err)
+	}
+	opens := getOpens()
+	if od.PendingLeg() != nil || od.Enter.Filled != 2 || len(opens) != 1 || opens[0].Amount != 2 ||
+		opens[0].StopLossPrice != 95 {
+		t.Fatalf("leg should be filled and stop loss replaced, filled %v, opens %v", od.Enter.Filled, opens)
+	}
+	// limit leg partially filled, the left is canceled after StopEnterBars
+	out, err := mock.WatchMyTrades(accParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock.FillRate = 0.1
+	leg := od.AddLeg(banexg.OdTypeLimit, 99, 2)
+	od.SetInfo(ormo.OdInfoStopAfter, btime.TimeMS()+60000)
+	if err = o.execOrderAdd(od); err != nil {
+		t.Fatal(err)
+	}
+	if od.PendingLeg() != leg || leg.OrderID == "" {
+		t.Fatal("limit leg should be open on exchange")
+	}
+	mock.OnBar(pair, "1m", &banexg.Kline{Time: stamp + 60000, Open: 100, High: 100, Low: 98, Close: 99, Volume: 10})
+	if err = o.updateByMyTrade(od, <-out); err != nil {
+		t.Fatal(err)
+	}
+	if leg.Filled != 1 || od.Enter.Filled != 3 || od.PendingLeg() != leg {
+		t.Fatalf("leg should be partially filled, got %v, enter %v", leg.Filled, od.Enter.Filled)
+	}
+	verifyAccountTriggerOds(config.DefAcc)
+	if od.PendingLeg() != leg {
+		t.Fatal("leg should not be canceled before StopEnterBars")
+	}
+	od.SetInfo(ormo.OdInfoStopAfter, btime.TimeMS()-1000)
+	verifyAccountTriggerOds(config.DefAcc)
+	opens = getOpens()
+	if od.PendingLeg() != nil || leg.Amount != 1 || leg.Status != ormo.OdStatusClosed || len(opens) != 1 ||
+		opens[0].Amount != 3 {
+		t.Fatalf("expired leg should be canceled and stop loss replaced, leg %v/%v, opens %v", leg.Filled,
+			leg.Amount, opens)
+	}
+	// pending leg filled while the bot is down, restored after restart
+	mock.FillRate = 0
+	od.AddLeg(banexg.OdTypeLimit, 99, 1)
+	if err = o.execOrderAdd(od); err != nil {
+		t.Fatal(err)
+	}
+	if err = od.Save(nil); err != nil {
+		t.Fatal(err)
+	}
+	mock.OnBar(pair, "1m", &banexg.Kline{Time: stamp + 120000, Open: 99, High: 99, Low: 98, Close: 98, Volume: 10})
+	// open orders in memory are lost after restart 重启后内存中的未平仓订单丢失
+	openOds, lock := ormo.GetOpenODs(config.DefAcc)
+	lock.Lock()
+	clear(openOds)
+	lock.Unlock()
+	o2 := newLiveOrderMgr(config.DefAcc, func(od *ormo.InOutOrder, isEnter bool) {})
+	oldList, newList, _, err := o2.SyncExgOrders()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(oldList) != 1 || oldList[0].ID != od.ID || len(newList) != 0 {
+		t.Fatalf("expect the order restored, got old %v new %v", oldList, newList)
+	}
+	restored := oldList[0]
+	legs := restored.GetLegs()
+	if restored.PendingLeg() != nil || restored.Enter.Filled != 4 || len(legs) != 3 || legs[2].Filled != 1 {
+		t.Fatalf("pending leg should be restored as filled, enter %v, legs %v", restored.Enter.Filled, len(legs))
+	}
+}
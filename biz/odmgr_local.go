@@ -14,13 +14,17 @@ import (
 	"github.com/banbox/banexg/utils"
 	"github.com/banbox/banta"
 	"go.uber.org/zap"
+	"math"
 	"strings"
 )
 
 type LocalOrderMgr struct {
 	OrderMgr
-	showLog  bool
-	zeroAmts map[string]int
+	showLog     bool
+	zeroAmts    map[string]int
+	fill        IFillModel
+	fundSettled map[string]int64 // last settled funding time of symbols 各品种上次结算资金费的时间
+	indexBars   map[string]*indexBars
 }
 
 type FnOdCb = func(od *ormo.InOutOrder, isEnter bool)
@@ -34,8 +38,11 @@ func InitLocalOrderMgr(callBack FnOdCb, showLog bool) {
 					callBack: callBack,
 					Account:  account,
 				},
-				showLog:  showLog,
-				zeroAmts: make(map[string]int),
+				showLog:     showLog,
+				zeroAmts:    make(map[string]int),
+				fill:        NewFillModel(config.FillModel),
+				fundSettled: make(map[string]int64),
+				indexBars:   make(map[string]*indexBars),
 			}
 			accOdMgrs[account] = mgr
 		}
@@ -62,13 +69,24 @@ func (o *LocalOrderMgr) UpdateByBar(allOpens []*ormo.InOutOrder, bar *orm.InfoKl
 	if len(curOrders) == 0 && !core.CheckWallets {
 		return nil
 	}
-	_, err := o.fillPendingOrders(curOrders, bar)
-	if err != nil {
-		return err
+	if core.IsContract {
+		// Settle funding fees for positions held before this bar
+		// 为此bar之前持有的仓位结算资金费
+		o.settleFunding(curOrders, bar)
+	}
+	if isTickFill() {
+		// Orders are filled by trades in tick-level backtest, only expire limit entries here
+		// tick级回测时订单由逐笔成交撮合，这里仅取消超时的限价入场单
+		o.expireLimitEnters(curOrders)
+	} else {
+		_, err := o.fillPendingOrders(curOrders, bar)
+		if err != nil {
+			return err
+		}
 	}
 	// Update all orders to profit at the end of the bar
 	// 更新所有订单在bar结束时利润
-	err = o.OrderMgr.UpdateByBar(curOrders, bar)
+	err := o.OrderMgr.UpdateByBar(curOrders, bar)
 	if err != nil {
 		return err
 	}
@@ -83,12 +101,176 @@ func (o *LocalOrderMgr) UpdateByBar(allOpens []*ormo.InOutOrder, bar *orm.InfoKl
 				orders = append(orders, od)
 			}
 		}
+		if config.Liquidation != nil && config.Liquidation.Enable && len(orders) > 0 {
+			// Liquidate positions by tiered maintenance margin at the mark price
+			// 按分层维持保证金在标记价格下强平仓位
+			err = o.checkLiquidation(orders, code, bar)
+			if err != nil {
+				return err
+			}
+			openOds := orders[:0]
+			for _, od := range orders {
+				if od.Status < ormo.InOutStatusFullExit {
+					openOds = append(openOds, od)
+				}
+			}
+			orders = openOds
+		}
 		wallets := GetWallets(o.Account)
 		err = wallets.UpdateOds(orders, code)
 	}
 	return err
 }
 
+/*
+UpdateByTrades
+Fill pending orders and triggers of the symbol at the trade prices in time order, used for tick-level backtest.
+prevPrice is the latest price before these trades.
+以逐笔成交价格按时间顺序撮合该品种的挂单和触发单，用于tick级回测。prevPrice是这些成交之前的最新价格
+*/
+func (o *LocalOrderMgr) UpdateByTrades(allOpens []*ormo.InOutOrder, symbol string, trades []*banexg.Trade,
+	prevPrice float64) *errs.Error {
+	if core.EnvReal {
+		return nil
+	}
+	var curOrders []*ormo.InOutOrder
+	for _, od := range allOpens {
+		if od.Symbol == symbol {
+			curOrders = append(curOrders, od)
+		}
+	}
+	if len(curOrders) == 0 {
+		return nil
+	}
+	for _, trade := range trades {
+		if prevPrice <= 0 {
+			prevPrice = trade.Price
+		}
+		for _, od := range curOrders {
+			if od.Status >= ormo.InOutStatusFullExit {
+				continue
+			}
+			err := o.fillByTrade(od, trade, prevPrice)
+			if err != nil {
+				return err
+			}
+		}
+		prevPrice = trade.Price
+	}
+	return nil
+}
+
+/*
+fillByTrade
+Fill the pending order at the trade price. A limit order which was not marketable at prevPrice is resting on the
+book, and filled at its own price when the trade reaches it.
+以成交价格撮合挂单。在prevPrice时不可立即成交的限价单视为挂在盘口，成交价到达时以其自身价格成交
+*/
+func (o *LocalOrderMgr) fillByTrade(od *ormo.InOutOrder, trade *banexg.Trade, prevPrice float64) *errs.Error {
+	var exOrder *ormo.ExOrder
+	if od.ExitTag != "" && od.Exit != nil && od.Exit.Status < ormo.OdStatusClosed {
+		exOrder = od.Exit
+	} else if od.Enter.Status < ormo.OdStatusClosed {
+		exOrder = od.Enter
+	} else if leg := od.PendingLeg(); leg != nil && od.ExitTag == "" {
+		exOrder = leg
+	} else {
+		if od.ExitTag == "" {
+			return o.tryTradeTriggers(od, trade)
+		}
+		return nil
+	}
+	// The order reaches the exchange after the network delay
+	// 订单在网络延迟后到达交易所
+	if trade.Timestamp < exOrder.CreateAt+int64(config.BTNetCost*1000) {
+		return nil
+	}
+	odType := config.OrderType
+	if exOrder.OrderType != "" {
+		odType = exOrder.OrderType
+	}
+	price := trade.Price
+	if odType == banexg.OdTypeLimit && exOrder.Price > 0 {
+		isBuy := exOrder.Side == banexg.OdSideBuy
+		if isBuy && price > exOrder.Price || !isBuy && price < exOrder.Price {
+			return nil
+		}
+		if isBuy && exOrder.Price < prevPrice || !isBuy && exOrder.Price > prevPrice {
+			price = exOrder.Price
+		}
+	}
+	if !exOrder.Enter {
+		return o.fillPendingExit(od, price, trade.Timestamp, 0)
+	}
+	var err *errs.Error
+	if exOrder == od.Enter {
+		err = o.fillPendingEnter(od, price, trade.Timestamp, 0)
+	} else {
+		err = o.fillPendingLeg(od, exOrder, price, trade.Timestamp, 0)
+	}
+	if err != nil || od.Status >= ormo.InOutStatusFullExit {
+		return err
+	}
+	// 入场后可能立刻触发止损/止盈
+	return o.tryTradeTriggers(od, trade)
+}
+
+/*
+tryTradeTriggers
+Check stop loss and take profit of the entered order with the trade price. A limit exit after triggering is filled
+only when the trade price reaches the limit.
+以成交价格检查已入场订单的止损止盈。触发后的限价离场仅在成交价达到限价时成交
+*/
+func (o *LocalOrderMgr) tryTradeTriggers(od *ormo.InOutOrder, trade *banexg.Trade) *errs.Error {
+	sl := od.GetStopLoss()
+	tp := od.GetTakeProfit()
+	if sl == nil && tp == nil {
+		return nil
+	}
+	price := trade.Price
+	hitNow := false
+	if sl != nil && !sl.Hit {
+		if sl.TrailTo(od.Short, price) {
+			od.DirtyInfo = true
+		}
+		sl.Hit = sl.Price > 0 && (od.Short && price >= sl.Price || !od.Short && price <= sl.Price)
+		hitNow = sl.Hit
+	}
+	if tp != nil && !tp.Hit {
+		tp.Hit = od.Short && price <= tp.Price || !od.Short && price >= tp.Price
+		hitNow = hitNow || tp.Hit
+	}
+	var state *ormo.TriggerState
+	isStopLoss := sl != nil && sl.Hit
+	if isStopLoss {
+		state = sl
+	} else if tp != nil && tp.Hit {
+		state = tp
+	} else {
+		return nil
+	}
+	od.DirtyInfo = true
+	fillPrice := price
+	odType := banexg.OdTypeMarket
+	if state.Limit > 0 {
+		if od.Short && price > state.Limit || !od.Short && price < state.Limit {
+			return nil
+		}
+		odType = banexg.OdTypeLimit
+		if !hitNow {
+			// The limit order was placed at an earlier trade, filled at its own price
+			// 限价单在更早的成交时已挂出，以其自身价格成交
+			fillPrice = state.Limit
+		}
+	}
+	exitTag := triggerExitTag(od, state, isStopLoss, fillPrice)
+	return o.exitByTrigger(od, isStopLoss, state.Rate, exitTag, fillPrice, odType, trade.Timestamp)
+}
+
+func isTickFill() bool {
+	return !core.LiveMode && config.TickSource != ""
+}
+
 /*
 fillPendingOrders
 Fills orders waiting for exchange response. Cannot be used for real trading; can be used for backtesting, simulated real trading, etc.
@@ -105,10 +287,12 @@ func (o *LocalOrderMgr) fillPendingOrders(orders []*ormo.InOutOrder, bar *orm.In
 			exOrder = od.Exit
 		} else if od.Enter.Status < ormo.OdStatusClosed {
 			exOrder = od.Enter
+		} else if leg := od.PendingLeg(); leg != nil && od.ExitTag == "" {
+			exOrder = leg
 		} else {
 			if od.ExitTag == "" {
 				// 已入场完成，尚未出现出场信号，检查是否触发止损The entry has been completed, but the exit signal has not yet appeared. Check whether the stop loss is triggered.
-				err := o.tryFillTriggers(od, &bar.Kline, 0)
+				err := o.tryFillTriggers(od, bar, 0)
 				if err != nil {
 					return 0, err
 				}
@@ -119,65 +303,87 @@ func (o *LocalOrderMgr) fillPendingOrders(orders []*ormo.InOutOrder, bar *orm.In
 		if exOrder.OrderType != "" {
 			odType = exOrder.OrderType
 		}
-		price := exOrder.Price
-		odTFSecs := utils.TFToSecs(od.Timeframe)
+		var price, maxAmt float64
+		odTFSecs := core.TFToSecs(od.Timeframe)
 		fillMS := btime.TimeMS() - int64((float64(odTFSecs)-config.BTNetCost)*1000)
 		fillBarRate := 0.0
 		if bar == nil {
 			price = core.GetPrice(od.Symbol)
 		} else if odType == banexg.OdTypeLimit && exOrder.Price > 0 {
-			if exOrder.Side == banexg.OdSideBuy {
-				if price < bar.Low {
-					continue
-				} else if price > bar.Open {
-					// 买价高于市价，以市价成交
-					// If the purchase price is higher than the market price, the transaction will be completed at the market price.
-					price = bar.Open
-				}
-			} else if exOrder.Side == banexg.OdSideSell {
-				if price > bar.High {
-					continue
-				} else if price < bar.Open {
-					// If the selling price is lower than the market price, the transaction will be done at the market price.
-					// 卖价低于市价，以市价成交
-					price = bar.Open
+			res := o.fill.Limit(od, exOrder, bar)
+			if res == nil {
+				if exOrder.Enter && od.Enter.Filled > 0 && od.ExitTag == "" {
+					// The filled part of a partially entered order may hit stop loss/take profit
+					// 部分入场订单的已成交部分可能触发止损/止盈
+					err := o.tryFillTriggers(od, bar, 0)
+					if err != nil {
+						return 0, err
+					}
 				}
+				continue
 			}
-			odIsBuy := exOrder.Side == banexg.OdSideBuy
-			fillBarRate = simMarketRate(&bar.Kline, exOrder.Price, odIsBuy, false, 0)
+			price, maxAmt = res.Price, res.Amount
+			fillBarRate = res.Rate
 			fillMS = bar.Time + int64(float64(odTFSecs)*fillBarRate)*1000
 		} else {
 			// 按网络延迟，模拟成交价格，和开盘价接近According to the network delay, the simulated transaction price is close to the opening price
 			rate := config.BTNetCost / float64(odTFSecs)
-			price = simMarketPrice(&bar.Kline, rate)
+			res := o.fill.Market(od, exOrder, bar, rate)
+			price, maxAmt = res.Price, res.Amount
 		}
 		var err *errs.Error
 		if exOrder.Enter {
-			err = o.fillPendingEnter(od, price, fillMS)
-			if err == nil {
+			if exOrder == od.Enter {
+				err = o.fillPendingEnter(od, price, fillMS, maxAmt)
+			} else {
+				err = o.fillPendingLeg(od, exOrder, price, fillMS, maxAmt)
+			}
+			if err == nil && od.Enter.Filled > 0 {
 				// 入场后可能立刻触发止损/止盈
-				err = o.tryFillTriggers(od, &bar.Kline, fillBarRate)
+				err = o.tryFillTriggers(od, bar, fillBarRate)
 			}
 		} else {
-			err = o.fillPendingExit(od, price, fillMS)
+			err = o.fillPendingExit(od, price, fillMS, maxAmt)
 		}
 		if err != nil {
 			return 0, err
 		}
 		affectNum += 1
 	}
-	// Forced liquidation of limit entry orders that have not been executed within a timeout period
-	// 强制平仓超时未成交的限价入场单
+	o.expireLimitEnters(orders)
+	return affectNum, nil
+}
+
+/*
+expireLimitEnters
+Forced liquidation of limit entry orders that have not been executed within a timeout period.
+The unfilled part of partially entered orders is canceled, and the filled part is kept.
+强制平仓超时未成交的限价入场单。部分入场的订单取消未成交部分，保留已成交部分
+*/
+func (o *LocalOrderMgr) expireLimitEnters(orders []*ormo.InOutOrder) {
 	curMS := btime.TimeMS()
 	for _, od := range orders {
-		if od.Status > ormo.InOutStatusInit || od.Enter.Price == 0 ||
+		if leg := od.PendingLeg(); leg != nil {
+			// Cancel the left of expired limit leg, the filled part is kept
+			// 取消超时限价加仓腿的剩余部分，保留已成交部分
+			stopAfter := od.GetInfoInt64(ormo.OdInfoStopAfter)
+			if leg.Price > 0 && stopAfter > 0 && stopAfter <= curMS && od.ExitTag == "" {
+				o.cancelEnterLeft(od)
+				strat.FireOdChange(o.Account, od, strat.OdChgEnterFill)
+			}
+			continue
+		}
+		if od.Status > ormo.InOutStatusPartEnter || od.Enter.Price == 0 ||
 			!strings.Contains(od.Enter.OrderType, banexg.OdTypeLimit) {
 			// Skip entered and non-limit orders
 			// 跳过已入场的以及非限价单
 			continue
 		}
 		stopAfter := od.GetInfoInt64(ormo.OdInfoStopAfter)
-		if stopAfter > 0 && stopAfter <= curMS {
+		if stopAfter > 0 && stopAfter <= curMS && od.Status == ormo.InOutStatusPartEnter {
+			o.cancelEnterLeft(od)
+			strat.FireOdChange(o.Account, od, strat.OdChgEnterFill)
+		} else if stopAfter > 0 && stopAfter <= curMS {
 			err := od.LocalExit(core.ExitTagEntExp, od.InitPrice, "reach StopEnterBars", "")
 			strat.FireOdChange(o.Account, od, strat.OdChgExitFill)
 			if err != nil {
@@ -185,31 +391,47 @@ func (o *LocalOrderMgr) fillPendingOrders(orders []*ormo.InOutOrder, bar *orm.In
 			}
 		}
 	}
-	return affectNum, nil
 }
 
-func (o *LocalOrderMgr) fillPendingEnter(od *ormo.InOutOrder, price float64, fillMS int64) *errs.Error {
-	wallets := GetWallets(o.Account)
-	_, err := wallets.EnterOd(od)
-	if err != nil {
-		if err.Code == core.ErrLowFunds {
-			err = od.LocalExit(core.ExitTagForceExit, od.InitPrice, err.Error(), "")
-			strat.FireOdChange(o.Account, od, strat.OdChgExitFill)
-			o.onLowFunds()
-			return err
-		}
-		return err
-	}
+/*
+fillPendingEnter
+Fill the entry order at price. At most maxAmt is filled when maxAmt > 0, the rest keeps pending and the order is
+partially entered.
+以price成交入场订单。maxAmt>0时最多成交maxAmt，剩余部分继续挂单，订单处于部分入场状态
+*/
+func (o *LocalOrderMgr) fillPendingEnter(od *ormo.InOutOrder, price float64, fillMS int64, maxAmt float64) *errs.Error {
 	exchange := exg.Default
 	market, err := exchange.GetMarket(od.Symbol)
 	if err != nil {
 		return err
 	}
+	if maxAmt > 0 {
+		maxAmt, err = exchange.PrecAmount(market, maxAmt)
+		if err != nil || maxAmt <= 0 {
+			// Less than the min amount, wait for next bar
+			// 少于最小数量，等待下一个bar
+			return nil
+		}
+	}
+	wallets := GetWallets(o.Account)
+	exOrder := od.Enter
+	prevFilled := exOrder.Filled
+	if prevFilled == 0 {
+		_, err = wallets.EnterOd(od)
+		if err != nil {
+			if err.Code == core.ErrLowFunds {
+				err = od.LocalExit(core.ExitTagForceExit, od.InitPrice, err.Error(), "")
+				strat.FireOdChange(o.Account, od, strat.OdChgExitFill)
+				o.onLowFunds()
+				return err
+			}
+			return err
+		}
+	}
 	entPrice, err := exchange.PrecPrice(market, price)
 	if err != nil {
 		return err
 	}
-	exOrder := od.Enter
 	if exOrder.Amount == 0 {
 		if od.Short && !core.IsContract {
 			// Spot short order, quantity must be given
@@ -243,7 +465,9 @@ func (o *LocalOrderMgr) fillPendingEnter(od *ormo.InOutOrder, price float64, fil
 	if exOrder.CreateAt == 0 {
 		exOrder.CreateAt = updateTime
 	}
-	if exOrder.OrderType == banexg.OdTypeLimit && updateTime-od.EnterAt < 60000 {
+	fillAmt := exOrder.Amount - prevFilled
+	isDone := maxAmt <= 0 || maxAmt >= fillAmt
+	if isDone && prevFilled == 0 && exOrder.OrderType == banexg.OdTypeLimit && updateTime-od.EnterAt < 60000 {
 		// 以限价单入场，但很快成交的话，认为是市价单成交If the limit order is filled quickly, it will be considered a market order.
 		exOrder.OrderType = banexg.OdTypeMarket
 	}
@@ -252,23 +476,170 @@ func (o *LocalOrderMgr) fillPendingEnter(od *ormo.InOutOrder, price float64, fil
 		// Update Enter.UpdateAt to the actual entry time
 		od.Enter.UpdateAt = updateTime
 	}
-	exOrder.Filled = exOrder.Amount
-	exOrder.Average = entPrice
-	exOrder.Status = ormo.OdStatusClosed
-	err = od.UpdateFee(entPrice, true, false)
+	oldFee := exOrder.Fee
+	if isDone {
+		exOrder.Filled = exOrder.Amount
+		exOrder.Status = ormo.OdStatusClosed
+	} else {
+		fillAmt = maxAmt
+		exOrder.Filled = prevFilled + fillAmt
+		exOrder.Status = ormo.OdStatusPartOK
+	}
+	if prevFilled == 0 {
+		exOrder.Average = entPrice
+	} else {
+		exOrder.Average = (exOrder.Average*prevFilled + entPrice*fillAmt) / exOrder.Filled
+	}
+	err = od.UpdateFee(exOrder.Average, true, false)
 	if err != nil {
 		return err
 	}
-	wallets.ConfirmOdEnter(od, entPrice)
-	od.Status = ormo.InOutStatusFullEnter
+	if prevFilled == 0 && isDone {
+		wallets.ConfirmOdEnter(od, entPrice)
+	} else {
+		wallets.ConfirmOdEnterPart(od, entPrice, fillAmt, exOrder.Fee-oldFee)
+		if isDone {
+			o.cancelPending(od)
+		}
+	}
+	if isDone {
+		od.Status = ormo.InOutStatusFullEnter
+	} else {
+		od.Status = ormo.InOutStatusPartEnter
+	}
 	od.DirtyEnter = true
 	od.DirtyMain = true
-	o.callBack(od, true)
+	if prevFilled == 0 {
+		o.callBack(od, true)
+	}
 	strat.FireOdChange(o.Account, od, strat.OdChgEnterFill)
 	return nil
 }
 
-func (o *LocalOrderMgr) fillPendingExit(od *ormo.InOutOrder, price float64, fillMS int64) *errs.Error {
+/*
+cancelEnterLeft
+Cancel the unfilled part of a partially entered order before exiting.
+退出前取消部分入场订单的未成交部分
+*/
+func (o *LocalOrderMgr) cancelEnterLeft(od *ormo.InOutOrder) {
+	if od.PendingLeg() != nil {
+		od.CancelLeg()
+		o.cancelPending(od)
+		return
+	}
+	exOrder := od.Enter
+	if exOrder.Status >= ormo.OdStatusClosed || exOrder.Filled == 0 {
+		return
+	}
+	if exOrder.Amount > 0 {
+		od.QuoteCost *= exOrder.Filled / exOrder.Amount
+	}
+	exOrder.Amount = exOrder.Filled
+	exOrder.Status = ormo.OdStatusClosed
+	if od.Status < ormo.InOutStatusFullEnter {
+		od.Status = ormo.InOutStatusFullEnter
+	}
+	od.DirtyMain = true
+	od.DirtyEnter = true
+	o.cancelPending(od)
+}
+
+/*
+fillPendingLeg
+Fill the entry leg of adding position at price, at most maxAmt is filled when maxAmt > 0. The filled part is merged
+into od.Enter with weighted average price.
+以price成交加仓入场腿，maxAmt>0时最多成交maxAmt。成交部分按加权均价合并到od.Enter
+*/
+func (o *LocalOrderMgr) fillPendingLeg(od *ormo.InOutOrder, leg *ormo.ExOrder, price float64, fillMS int64, maxAmt float64) *errs.Error {
+	exchange := exg.Default
+	market, err := exchange.GetMarket(od.Symbol)
+	if err != nil {
+		return err
+	}
+	fillAmt := leg.Amount - leg.Filled
+	if maxAmt > 0 && maxAmt < fillAmt {
+		fillAmt, err = exchange.PrecAmount(market, maxAmt)
+		if err != nil || fillAmt <= 0 {
+			return nil
+		}
+	}
+	entPrice, err := exchange.PrecPrice(market, price)
+	if err != nil {
+		return err
+	}
+	wallets := GetWallets(o.Account)
+	if leg.Filled == 0 {
+		err = wallets.EnterOdLeg(od, leg, entPrice)
+		if err != nil {
+			if err.Code == core.ErrLowFunds {
+				// Drop the leg when funds are insufficient, the entered position is kept
+				// 资金不足时放弃加仓，保留已入场仓位
+				if o.showLog {
+					log.Warn("add order leg fail", zap.String("key", od.Key()), zap.Error(err))
+				}
+				od.CancelLeg()
+				return nil
+			}
+			return err
+		}
+	}
+	fee, err := od.CalcFee(leg, fillAmt, entPrice, false)
+	if err != nil {
+		return err
+	}
+	filled := leg.Filled + fillAmt
+	average := (leg.Average*leg.Filled + entPrice*fillAmt) / filled
+	if leg.Price == 0 {
+		leg.Price = entPrice
+	}
+	leg.UpdateAt = fillMS
+	od.FillLeg(leg, filled, average, leg.Fee+fee.Cost)
+	wallets.ConfirmOdEnterPart(od, entPrice, fillAmt, fee.Cost)
+	if filled >= leg.Amount {
+		leg.Status = ormo.OdStatusClosed
+		o.cancelPending(od)
+	}
+	strat.FireOdChange(o.Account, od, strat.OdChgEnterFill)
+	return nil
+}
+
+/*
+cancelPending
+Release the pending funds left by the entry of the order
+释放订单入场剩余的pending资金
+*/
+func (o *LocalOrderMgr) cancelPending(od *ormo.InOutOrder) {
+	base, quote, _, _ := core.SplitSymbol(od.Symbol)
+	code := quote
+	if od.Short && !core.IsContract {
+		code = base
+	}
+	GetWallets(o.Account).Cancel(od.Key(), code, 0, true)
+}
+
+/*
+fillPendingExit
+Fill the exit order at price. When maxAmt > 0 and less than the exit amount, the filled part is cut into a new
+order, the rest keeps pending.
+以price成交出场订单。maxAmt>0且小于出场数量时，成交部分切分为新订单，剩余部分继续挂单
+*/
+func (o *LocalOrderMgr) fillPendingExit(od *ormo.InOutOrder, price float64, fillMS int64, maxAmt float64) *errs.Error {
+	o.cancelEnterLeft(od)
+	if maxAmt > 0 && maxAmt < od.Exit.Amount {
+		maxAmt = precAmount(od.Symbol, maxAmt)
+		if maxAmt <= 0 {
+			return nil
+		}
+		if maxAmt < od.Exit.Amount {
+			rate := maxAmt / od.Enter.Amount
+			part := o.CutOrder(od, rate, rate)
+			err := od.Save(nil)
+			if err != nil {
+				log.Error("save cutPart parent order fail", zap.String("key", od.Key()), zap.Error(err))
+			}
+			od = part
+		}
+	}
 	wallets := GetWallets(o.Account)
 	exOrder := od.Exit
 	wallets.ExitOd(od, exOrder.Amount)
@@ -295,18 +666,29 @@ func (o *LocalOrderMgr) fillPendingExit(od *ormo.InOutOrder, price float64, fill
 	return nil
 }
 
-func (o *LocalOrderMgr) tryFillTriggers(od *ormo.InOutOrder, bar *banexg.Kline, afterRate float64) *errs.Error {
+func (o *LocalOrderMgr) tryFillTriggers(od *ormo.InOutOrder, bar *orm.InfoKline, afterRate float64) *errs.Error {
 	sl := od.GetStopLoss()
 	tp := od.GetTakeProfit()
 	if sl == nil && tp == nil {
 		return nil
 	}
 	if sl != nil && !sl.Hit {
-		// 空单止损，最高价超过止损价触发
-		// Short order stop loss, triggered when the highest price exceeds the stop loss price
-		// 多单止损，最低价跌破止损价触发
-		// Stop loss for long orders, triggered when the lowest price falls below the stop loss price
-		sl.Hit = od.Short && bar.High >= sl.Price || !od.Short && bar.Low <= sl.Price
+		if sl.IsTrailing() {
+			// Trailing stop loss moves along the price path inside the bar, fill after the hit position
+			// 跟踪止损沿bar内价格路径移动，在触发位置之后成交
+			hitRate, hit, moved := trailStopInBar(sl, od.Short, &bar.Kline, afterRate)
+			od.DirtyInfo = od.DirtyInfo || moved
+			if hit {
+				sl.Hit = true
+				afterRate = max(afterRate, hitRate-1e-9)
+			}
+		} else {
+			// 空单止损，最高价超过止损价触发
+			// Short order stop loss, triggered when the highest price exceeds the stop loss price
+			// 多单止损，最低价跌破止损价触发
+			// Stop loss for long orders, triggered when the lowest price falls below the stop loss price
+			sl.Hit = od.Short && bar.High >= sl.Price || !od.Short && bar.Low <= sl.Price
+		}
 	}
 	if tp != nil && !tp.Hit {
 		// 空单止盈，最低价跌破止盈价触发
@@ -320,62 +702,60 @@ func (o *LocalOrderMgr) tryFillTriggers(od *ormo.InOutOrder, bar *banexg.Kline,
 		return nil
 	}
 	od.DirtyInfo = true
-	tfSecs := float64(utils.TFToSecs(od.Timeframe))
-	var fillPrice, trigPrice, amtRate float64
-	var exitTag string
-	if sl != nil && sl.Hit {
-		// Trigger stop loss and calculate execution price
-		// 触发止损，计算执行价格
-		trigPrice = sl.Price
-		amtRate = sl.Rate
-		fillPrice = getExcPrice(od, bar, sl.Price, sl.Limit, afterRate, tfSecs)
-		if sl.Tag != "" {
-			exitTag = sl.Tag
-		} else {
-			exitTag = core.ExitTagStopLoss
-			od.UpdateProfits(fillPrice)
-			if od.ProfitRate >= 0 {
-				exitTag = core.ExitTagSLTake
-			}
-		}
-	} else if tp != nil && tp.Hit {
-		// Trigger take profit and calculate execution price
-		// 触发止盈，计算执行价格
-		trigPrice = tp.Price
-		amtRate = tp.Rate
-		fillPrice = getExcPrice(od, bar, tp.Price, tp.Limit, afterRate, tfSecs)
-		if tp.Tag != "" {
-			exitTag = tp.Tag
-		} else {
-			exitTag = core.ExitTagTakeProfit
-		}
-	} else {
-		return nil
+	// Stop loss first when both are hit
+	// 同时触发时优先止损
+	isStopLoss := sl != nil && sl.Hit
+	state := tp
+	if isStopLoss {
+		state = sl
 	}
-	if fillPrice < 0 {
+	res := o.fill.Trigger(od, state, isStopLoss, bar, afterRate)
+	if res == nil {
 		return nil
 	}
-	curMS := btime.TimeMS()
-	// The time when the simulation is triggered
-	// 模拟触发时的时间
-	var rate = config.BTNetCost / tfSecs
 	odType := banexg.OdTypeMarket
-	if fillPrice > 0 {
+	if res.IsLimit {
 		odType = banexg.OdTypeLimit
-		rate += simMarketRate(bar, fillPrice, od.Short, true, afterRate)
-	} else {
-		// Trigger time + network delay
-		// 触发时间+网络延迟
-		rate += simMarketRate(bar, trigPrice, od.Short, true, afterRate)
-		// Stop loss at market price and sell immediately
-		// 市价止损，立刻卖出
-		fillPrice = simMarketPrice(bar, rate)
 	}
+	// Tag by the fill price; price 0 leaves the profit of the last bar and mistags losing stop losses as sl_take
+	// 按成交价打标签；价格为0会沿用上个bar的利润，把亏损止损误标为sl_take
+	exitTag := triggerExitTag(od, state, isStopLoss, res.Price)
+	tfSecs := float64(core.TFToSecs(od.Timeframe))
+	cutSecs := tfSecs * (1 - res.Rate)
+	exitMS := btime.TimeMS() - int64(cutSecs*1000)
+	if res.Amount > 0 {
+		// Only part can be filled in this bar, the rest keeps the hit trigger for next bar
+		// 本bar只能成交部分，剩余部分保留已触发状态到下一个bar
+		o.cancelEnterLeft(od)
+		amount := precAmount(od.Symbol, res.Amount)
+		if amount <= 0 {
+			return nil
+		}
+		if amount < od.Enter.Amount {
+			part := o.CutOrder(od, amount/od.Enter.Amount, 0)
+			err := od.Save(nil)
+			if err != nil {
+				log.Error("save cutPart parent order fail", zap.String("key", od.Key()), zap.Error(err))
+			}
+			od = part
+		}
+	}
+	return o.exitByTrigger(od, isStopLoss, state.Rate, exitTag, res.Price, odType, exitMS)
+}
+
+/*
+exitByTrigger
+Exit the order, or the amtRate part of it, hit by stop loss or take profit
+退出被止损或止盈触发的订单，或其amtRate部分
+*/
+func (o *LocalOrderMgr) exitByTrigger(od *ormo.InOutOrder, isStopLoss bool, amtRate float64, exitTag string,
+	fillPrice float64, odType string, exitMS int64) *errs.Error {
+	o.cancelEnterLeft(od)
 	if amtRate > 0 && amtRate <= 0.99 {
 		// Partial withdrawal
 		// 部分退出
 		part := o.CutOrder(od, amtRate, 0)
-		if sl != nil && sl.Hit {
+		if isStopLoss {
 			od.SetStopLoss(nil)
 		} else {
 			od.SetTakeProfit(nil)
@@ -387,8 +767,7 @@ func (o *LocalOrderMgr) tryFillTriggers(od *ormo.InOutOrder, bar *banexg.Kline,
 		od = part
 	}
 	err := od.LocalExit(exitTag, fillPrice, "", odType)
-	cutSecs := tfSecs * (1 - rate)
-	od.ExitAt = curMS - int64(cutSecs*1000)
+	od.ExitAt = exitMS
 	od.DirtyMain = true
 	if od.Exit != nil {
 		od.Exit.UpdateAt = od.ExitAt
@@ -405,6 +784,88 @@ func (o *LocalOrderMgr) tryFillTriggers(od *ormo.InOutOrder, bar *banexg.Kline,
 	return err
 }
 
+/*
+trailStopInBar
+Move the trailing stop loss along the price path of bar after afterRate (same as simMarketPrice), return the position
+where the price crosses the stop, whether it's hit, and whether the stop is moved.
+沿afterRate之后的bar价格路径(同simMarketPrice)移动跟踪止损，返回价格穿过止损的位置、是否触发、止损是否移动
+*/
+func trailStopInBar(sl *ormo.TriggerState, short bool, bar *banexg.Kline, afterRate float64) (float64, bool, bool) {
+	points := []float64{bar.Open, bar.Low, bar.High, bar.Close}
+	if bar.Open > bar.Close {
+		points[1], points[2] = bar.High, bar.Low
+	}
+	var totalLen float64
+	for i := 1; i < len(points); i++ {
+		totalLen += math.Abs(points[i] - points[i-1])
+	}
+	crossed := func(p float64) bool {
+		return sl.Price > 0 && (short && p >= sl.Price || !short && p <= sl.Price)
+	}
+	startRate := max(afterRate, 0)
+	var price, endRate float64
+	var started, moved bool
+	for i := 1; i < len(points); i++ {
+		beginRate := endRate
+		if totalLen > 0 {
+			endRate += math.Abs(points[i]-points[i-1]) / totalLen
+		} else {
+			endRate = 1
+		}
+		if endRate <= startRate {
+			continue
+		}
+		if !started {
+			started = true
+			price = points[i-1]
+			if startRate > beginRate {
+				price += (points[i] - points[i-1]) * (startRate - beginRate) / (endRate - beginRate)
+				beginRate = startRate
+			}
+			if crossed(price) {
+				return startRate, true, moved
+			}
+			moved = sl.TrailTo(short, price)
+		}
+		end := points[i]
+		if crossed(end) {
+			// The price moves against the position and crosses the stop in this segment
+			// 价格在此段逆向移动并穿过止损
+			return beginRate + (endRate-beginRate)*(price-sl.Price)/(price-end), true, moved
+		}
+		moved = sl.TrailTo(short, end) || moved
+		price = end
+	}
+	return 1, false, moved
+}
+
+func triggerExitTag(od *ormo.InOutOrder, state *ormo.TriggerState, isStopLoss bool, fillPrice float64) string {
+	if state.Tag != "" {
+		return state.Tag
+	}
+	if !isStopLoss {
+		return core.ExitTagTakeProfit
+	}
+	od.UpdateProfits(fillPrice)
+	if od.ProfitRate >= 0 {
+		return core.ExitTagSLTake
+	}
+	return core.ExitTagStopLoss
+}
+
+func precAmount(symbol string, amount float64) float64 {
+	exchange := exg.Default
+	market, err := exchange.GetMarket(symbol)
+	if err != nil {
+		return 0
+	}
+	res, err := exchange.PrecAmount(market, amount)
+	if err != nil {
+		return 0
+	}
+	return res
+}
+
 func (o *LocalOrderMgr) onLowFunds() {
 	// If the balance is insufficient and there are no orders entered, the backtest will be terminated early.
 	// 如果余额不足，且没有入场的订单，则提前终止回测
@@ -457,7 +918,7 @@ func (o *LocalOrderMgr) ExitAndFill(sess *ormo.Queries, orders []*ormo.InOutOrde
 	timeMS := btime.TimeMS()
 	for _, od := range orders {
 		price := core.GetPrice(od.Symbol)
-		err := o.fillPendingExit(od, price, timeMS)
+		err := o.fillPendingExit(od, price, timeMS, 0)
 		if err != nil {
 			return err
 		}
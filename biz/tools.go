@@ -567,6 +567,9 @@ func CalcCorrelation(args *config.CmdArgs) *errs.Error {
 		exsList = append(exsList, exs)
 	}
 	tf := args.TimeFrames[0]
+	if core.ParseAltBar(tf) != nil {
+		return errs.NewMsg(errs.CodeParamInvalid, "non-time timeframe is not supported: %s", tf)
+	}
 	tfMSecs := int64(utils2.TFToSecs(tf) * 1000)
 	gapTFMSecs := int64(utils2.TFToSecs(args.RunEveryTF) * 1000)
 	if int(gapTFMSecs/tfMSecs) < args.BatchSize {
@@ -3,6 +3,7 @@ package biz
 import (
 	"database/sql"
 	"fmt"
+	"slices"
 	"strings"
 
 	"github.com/banbox/banbot/btime"
@@ -15,7 +16,6 @@ import (
 	"github.com/banbox/banexg"
 	"github.com/banbox/banexg/errs"
 	"github.com/banbox/banexg/log"
-	utils2 "github.com/banbox/banexg/utils"
 	ta "github.com/banbox/banta"
 	"go.uber.org/zap"
 )
@@ -41,7 +41,7 @@ func (t *Trader) OnEnvJobs(bar *orm.InfoKline) (*ta.BarEnv, *errs.Error) {
 }
 
 func (t *Trader) FeedKline(bar *orm.InfoKline) *errs.Error {
-	tfSecs := utils2.TFToSecs(bar.TimeFrame)
+	tfSecs := core.TFToSecs(bar.TimeFrame)
 	core.SetBarPrice(bar.Symbol, bar.Close)
 	// If it exceeds 1 minute and half of the period, the bar is considered delayed and orders cannot be placed.
 	// 超过1分钟且周期的一半，认为bar延迟，不可下单
@@ -112,6 +112,7 @@ func (t *Trader) onAccountKline(account string, env *ta.BarEnv, bar *orm.InfoKli
 		job.InitBar(curOrders)
 		snap := job.SnapOrderStates()
 		job.Strat.OnBar(job)
+		strat.SnapJobState(job)
 		var isBatch = false
 		if !barExpired {
 			isBatch = job.Strat.BatchInOut && job.Strat.OnBatchJobs != nil
@@ -138,6 +139,7 @@ func (t *Trader) onAccountKline(account string, env *ta.BarEnv, bar *orm.InfoKli
 	for _, job := range infoJobs {
 		job.IsWarmUp = bar.IsWarmUp
 		job.Strat.OnInfoBar(job, env, bar.Symbol, bar.TimeFrame)
+		strat.SnapJobState(job)
 		if job.Strat.BatchInfo && job.Strat.OnBatchInfos != nil {
 			AddBatchJob(account, bar.TimeFrame, job, true)
 		}
@@ -149,6 +151,94 @@ func (t *Trader) onAccountKline(account string, env *ta.BarEnv, bar *orm.InfoKli
 	return t.ExecOrders(odMgr, jobs, env, enters, exits, edits)
 }
 
+/*
+FeedTrades
+Feed trades of a symbol in tick-level backtest: fill pending orders at the trade prices, then fire OnTrades of strategy jobs
+tick级回测时输入品种的逐笔成交：以成交价格撮合挂单，然后触发策略任务的OnTrades
+*/
+func (t *Trader) FeedTrades(symbol string, trades []*banexg.Trade) *errs.Error {
+	if len(trades) == 0 {
+		return nil
+	}
+	prevPrice := core.GetPriceSafe(symbol)
+	var err *errs.Error
+	for account := range config.Accounts {
+		odMgr, ok := GetOdMgr(account).(*LocalOrderMgr)
+		if !ok {
+			continue
+		}
+		openOds, lock := ormo.GetOpenODs(account)
+		lock.Lock()
+		allOrders := utils.ValsOfMap(openOds)
+		lock.Unlock()
+		err = odMgr.UpdateByTrades(allOrders, symbol, trades, prevPrice)
+		if err != nil {
+			return err
+		}
+	}
+	core.SetBarPrice(symbol, trades[len(trades)-1].Price)
+	for account := range config.Accounts {
+		curErr := t.onAccountTrades(account, symbol, trades)
+		if curErr != nil {
+			if err != nil {
+				log.Error("onAccountTrades fail", zap.String("account", account), zap.Error(curErr))
+			} else {
+				err = curErr
+			}
+		}
+	}
+	return err
+}
+
+func (t *Trader) onAccountTrades(account, symbol string, trades []*banexg.Trade) *errs.Error {
+	accJobs := strat.GetJobs(account)
+	prefix := symbol + "_"
+	envKeys := make([]string, 0, 2)
+	for envKey := range accJobs {
+		if strings.HasPrefix(envKey, prefix) {
+			envKeys = append(envKeys, envKey)
+		}
+	}
+	if len(envKeys) == 0 {
+		return nil
+	}
+	slices.Sort(envKeys)
+	openOds, lock := ormo.GetOpenODs(account)
+	lock.Lock()
+	allOrders := utils.ValsOfMap(openOds)
+	lock.Unlock()
+	var curOrders []*ormo.InOutOrder
+	for _, od := range allOrders {
+		if od.Status < ormo.InOutStatusFullExit && od.Symbol == symbol {
+			curOrders = append(curOrders, od)
+		}
+	}
+	odMgr := GetOdMgr(account)
+	for _, envKey := range envKeys {
+		jobs := accJobs[envKey]
+		var enters []*strat.EnterReq
+		var exits []*strat.ExitReq
+		for _, job := range jobs {
+			if job.Strat.OnTrades == nil {
+				continue
+			}
+			job.InitBar(curOrders)
+			job.Strat.OnTrades(job, trades)
+			enters = append(enters, job.Entrys...)
+			exits = append(exits, job.Exits...)
+		}
+		env, ok := strat.Envs[envKey]
+		if !ok {
+			continue
+		}
+		err := t.ExecOrders(odMgr, jobs, env, enters, exits, nil)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (t *Trader) ExecOrders(odMgr IOrderMgr, jobs map[string]*strat.StratJob, env *ta.BarEnv,
 	enters []*strat.EnterReq, exits []*strat.ExitReq, edits []*ormo.InOutEdit) *errs.Error {
 	if len(enters)+len(exits)+len(edits) == 0 {
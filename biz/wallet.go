@@ -385,6 +385,40 @@ func (w *BanWallets) ConfirmPending(odKey string, srcKey string, srcAmount float
 	return true
 }
 
+/*
+ConfirmPendingPart
+Confirm deduction of part of the pending from src and add to tgt's balance, the rest keeps pending
+从src中确认扣除部分pending，添加到tgt的余额中，剩余部分继续pending
+*/
+func (w *BanWallets) ConfirmPendingPart(odKey string, srcKey string, srcAmount float64, tgtKey string, tgtAmount float64, toFrozen bool) bool {
+	src, srcExists := w.Items[srcKey]
+	if !srcExists {
+		return false
+	}
+
+	tgt := w.Get(tgtKey)
+
+	src.lock.Lock()
+	pendingAmt, ok := src.Pendings[odKey]
+	if !ok {
+		src.lock.Unlock()
+		return false
+	}
+	src.Pendings[odKey] = pendingAmt - srcAmount
+	log.Debug("ConfirmPendingPart wallet", zap.String("key", odKey), zap.String("from", srcKey),
+		zap.Float64("cost", srcAmount), zap.Float64("leftPend", pendingAmt-srcAmount))
+	src.lock.Unlock()
+
+	tgt.lock.Lock()
+	if toFrozen {
+		tgt.Frozens[odKey] += tgtAmount
+	} else {
+		tgt.Available += tgtAmount
+	}
+	tgt.lock.Unlock()
+	return true
+}
+
 /*
 Cancel
 Unlock the quantity of currency (frozens/pendings) and add it to available again
@@ -495,6 +529,32 @@ func (w *BanWallets) EnterOd(od *ormo.InOutOrder) (float64, *errs.Error) {
 	return legalCost, nil
 }
 
+/*
+EnterOdLeg
+Lock the funds for the entry leg of adding position at price into pending of the order, the whole leg amount must be
+available. Call ConfirmOdEnterPart to confirm the filled part, and Cancel to release the left.
+以price锁定加仓入场腿所需资金到订单的pending，必须可用整个加仓数量。调用ConfirmOdEnterPart确认成交部分，Cancel释放剩余
+*/
+func (w *BanWallets) EnterOdLeg(od *ormo.InOutOrder, leg *ormo.ExOrder, price float64) *errs.Error {
+	if core.EnvReal {
+		return nil
+	}
+	exs := orm.GetSymbolByID(int32(od.Sid))
+	if exs == nil {
+		panic(fmt.Sprintf("EnterOdLeg invalid sid of order: %v", od.Sid))
+	}
+	baseCode, quoteCode, _, _ := core.SplitSymbol(exs.Symbol)
+	var err *errs.Error
+	if banexg.IsContract(exs.Market) {
+		_, err = w.CostAva(od.Key(), quoteCode, leg.Amount*price/od.Leverage, false, 1)
+	} else if od.Short {
+		_, err = w.CostAva(od.Key(), baseCode, leg.Amount, true, 1)
+	} else {
+		_, err = w.CostAva(od.Key(), quoteCode, leg.Amount*price, false, 1)
+	}
+	return err
+}
+
 func (w *BanWallets) ConfirmOdEnter(od *ormo.InOutOrder, enterPrice float64) {
 	if core.EnvReal {
 		return
@@ -526,6 +586,33 @@ func (w *BanWallets) ConfirmOdEnter(od *ormo.InOutOrder, enterPrice float64) {
 		w.ConfirmPending(od.Key(), quoteCode, quoteAmount, baseCode, baseAmt, false)
 	}
 }
+
+/*
+ConfirmOdEnterPart
+Confirm a partial fill of amount at enterPrice for the entry, fee is the fee of this fill. The unfilled part keeps
+pending, call Cancel to release it.
+确认入场订单以enterPrice部分成交amount，fee为本次成交的手续费。未成交部分继续pending，需调用Cancel释放
+*/
+func (w *BanWallets) ConfirmOdEnterPart(od *ormo.InOutOrder, enterPrice, amount, fee float64) {
+	if core.EnvReal {
+		return
+	}
+	exs := orm.GetSymbolByID(int32(od.Sid))
+	if exs == nil {
+		panic(fmt.Sprintf("EnterOd invalid sid of order: %v", od.Sid))
+	}
+	quoteAmount := enterPrice * amount
+	baseCode, quoteCode, _, _ := core.SplitSymbol(exs.Symbol)
+	if core.IsContract {
+		quoteAmount /= od.Leverage
+		w.ConfirmPendingPart(od.Key(), quoteCode, quoteAmount, quoteCode, quoteAmount-fee, true)
+	} else if od.Short {
+		w.ConfirmPendingPart(od.Key(), baseCode, amount, quoteCode, quoteAmount-fee, true)
+	} else {
+		w.ConfirmPendingPart(od.Key(), quoteCode, quoteAmount, baseCode, amount-fee, false)
+	}
+}
+
 func (w *BanWallets) ExitOd(od *ormo.InOutOrder, baseAmount float64) {
 	if core.EnvReal {
 		return
@@ -581,9 +668,11 @@ func (w *BanWallets) ConfirmOdExit(od *ormo.InOutOrder, exitPrice float64) {
 	if banexg.IsContract(exs.Market) {
 		//Futures contracts do not involve changes in base currency. When exiting the order, the locked pricing currency will be closed and released.
 		//Here profit deducts the entry and exit handling fees. The entry handling fee has been deducted previously, so the entry handling fee needs to be added here.
+		//The funding fees in profit have been settled to available, so they need to be deducted here.
 		//期货合约不涉及base币的变化。退出订单时，对锁定的定价币平仓释放
 		//这里profit扣除了入场和出场手续费，前面入场手续费已扣过了，所以这里需要加入场手续费
-		w.Cancel(odKey, quoteCode, od.Profit+od.Enter.Fee, false)
+		//profit中的资金费已结算到available，这里需要扣除
+		w.Cancel(odKey, quoteCode, od.Profit+od.Enter.Fee-od.GetInfoFloat64(ormo.OdInfoFunding), false)
 	} else if od.Short {
 		//For short orders, priority is given to buying from the frozen price of the quote. If it is not converted to base, it will be converted to the available price of the quote.
 		//空单，优先从quote的frozen买，不兑换为base，再换算为quote的avaiable
@@ -607,6 +696,24 @@ func (w *BanWallets) ConfirmOdExit(od *ormo.InOutOrder, exitPrice float64) {
 		w.ConfirmPending(odKey, baseCode, subOd.Amount, quoteCode, quoteAmount, false)
 	}
 }
+
+/*
+SettleFunding
+Settle a funding fee of a contract order to the available of the pricing coin, amount is negative when paying
+将合约订单的一笔资金费结算到定价币的available，支付时amount为负
+*/
+func (w *BanWallets) SettleFunding(od *ormo.InOutOrder, amount float64) {
+	if core.EnvReal || amount == 0 {
+		return
+	}
+	_, quoteCode, _, _ := core.SplitSymbol(od.Symbol)
+	wallet := w.Get(quoteCode)
+	wallet.lock.Lock()
+	wallet.Available += amount
+	wallet.lock.Unlock()
+	od.SetInfo(ormo.OdInfoFunding, od.GetInfoFloat64(ormo.OdInfoFunding)+amount)
+}
+
 func (w *BanWallets) CutPart(srcKey string, tgtKey string, symbol string, rate float64) {
 	item, exists := w.Items[symbol]
 	if !exists {
@@ -659,7 +766,9 @@ func (w *BanWallets) UpdateOds(odList []*ormo.InOutOrder, currency string) *errs
 	// 计算是否爆仓
 	var totProfit float64
 	for _, od := range odList {
-		totProfit += od.Profit
+		// funding fees in profit were settled to available already
+		// 利润中的资金费已结算到available
+		totProfit += od.Profit - od.GetInfoFloat64(ormo.OdInfoFunding)
 	}
 	wallet.lock.Lock()
 	wallet.UnrealizedPOL = totProfit
@@ -684,9 +793,9 @@ func (w *BanWallets) UpdateOds(odList []*ormo.InOutOrder, currency string) *errs
 		// Calculate nominal value
 		// 计算名义价值
 		quoteValue := od.Enter.Filled * curPrice
-		// Calculate current required margin
-		// 计算当前所需保证金
-		curMargin := quoteValue / od.Leverage
+		// Calculate current required margin, including margin added to isolated position
+		// 计算当前所需保证金，包含逐仓仓位追加的保证金
+		curMargin := quoteValue/od.Leverage + od.GetInfoFloat64(ormo.OdInfoMarginAdd)
 		// Determine whether the price trend and order opening direction are the same
 		// 判断价格走势和开单方向是否相同
 		odDirt := 1.0
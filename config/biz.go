@@ -1,10 +1,12 @@
 package config
 
 import (
+	"bytes"
 	"fmt"
 	"math"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -254,6 +256,21 @@ func ApplyConfig(args *CmdArgs, c *Config) *errs.Error {
 	if BTNetCost == 0 {
 		BTNetCost = 15
 	}
+	if c.FillModel == nil {
+		c.FillModel = &FillModelConfig{}
+	}
+	if c.FillModel.Name != "" && !core.FillModels[c.FillModel.Name] {
+		return errs.NewMsg(core.ErrBadConfig, "invalid fill_model.name: %s", c.FillModel.Name)
+	}
+	c.FillModel.Validate()
+	FillModel = c.FillModel
+	if c.Liquidation == nil {
+		c.Liquidation = &LiquidationConfig{}
+	}
+	if err := c.Liquidation.Validate(); err != nil {
+		return err
+	}
+	Liquidation = c.Liquidation
 	RelaySimUnFinish = c.RelaySimUnFinish
 	OrderBarMax = c.OrderBarMax
 	if OrderBarMax == 0 {
@@ -287,6 +304,8 @@ func ApplyConfig(args *CmdArgs, c *Config) *errs.Error {
 	FatalStopHours = c.FatalStopHours
 	TimeRange = c.TimeRange
 	RunTimeframes = c.RunTimeframes
+	KlineSource = c.KlineSource
+	TickSource = ParsePath(c.TickSource)
 	WatchJobs = c.WatchJobs
 	if c.StratPerf == nil {
 		c.StratPerf = &StratPerfConfig{
@@ -319,10 +338,14 @@ func ApplyConfig(args *CmdArgs, c *Config) *errs.Error {
 		return err
 	}
 	Database = c.Database
-	SpiderAddr = strings.ReplaceAll(c.SpiderAddr, "host.docker.internal", "127.0.0.1")
-	if SpiderAddr == "" {
-		SpiderAddr = "127.0.0.1:6789"
-	}
+	SpiderAddrs = parseSpiderAddrs(c.SpiderAddr)
+	SpiderAddr = SpiderAddrs[0]
+	SpiderRecord = c.SpiderRecord
+	if c.AInfer == nil {
+		c.AInfer = &AInferConfig{}
+	}
+	c.AInfer.Validate()
+	AInfer = c.AInfer
 	APIServer = c.APIServer
 	RPCChannels = c.RPCChannels
 	Webhook = c.Webhook
@@ -445,6 +468,96 @@ func (p *StratPerfConfig) Validate() {
 	}
 }
 
+func (p *FillModelConfig) Validate() {
+	if p.Name == "" {
+		p.Name = core.FillCandle
+	}
+	if p.VolRate <= 0 {
+		p.VolRate = 0.1
+	}
+	if p.SubTF == "" {
+		p.SubTF = "1m"
+	}
+}
+
+func (p *LiquidationConfig) Validate() *errs.Error {
+	if p.MarkPrice == "" {
+		p.MarkPrice = core.MarkPriceClose
+	} else if !core.MarkPriceModes[p.MarkPrice] {
+		return errs.NewMsg(core.ErrBadConfig, "invalid liquidation.mark_price: %s", p.MarkPrice)
+	}
+	if p.MarginMode == "" {
+		p.MarginMode = banexg.MarginCross
+	} else if p.MarginMode != banexg.MarginCross && p.MarginMode != banexg.MarginIsolated {
+		return errs.NewMsg(core.ErrBadConfig, "invalid liquidation.margin_mode: %s", p.MarginMode)
+	}
+	if p.FeeRate <= 0 {
+		p.FeeRate = 0.005
+	}
+	if len(p.Tiers) == 0 {
+		p.Tiers = []*MaintMarginTier{{Floor: 0, Rate: 0.005}}
+	}
+	return nil
+}
+
+/*
+parseSpiderAddrs
+spider_addr can be a string (comma separated is allowed) or a list of string, default 127.0.0.1:6789
+spider_addr可以是字符串(允许逗号分隔)或字符串列表，默认127.0.0.1:6789
+*/
+func parseSpiderAddrs(val interface{}) []string {
+	var items []string
+	switch v := val.(type) {
+	case string:
+		items = strings.Split(v, ",")
+	case []string:
+		items = v
+	case []interface{}:
+		for _, it := range v {
+			items = append(items, fmt.Sprintf("%v", it))
+		}
+	}
+	res := make([]string, 0, len(items))
+	for _, addr := range items {
+		addr = strings.TrimSpace(strings.ReplaceAll(addr, "host.docker.internal", "127.0.0.1"))
+		if addr != "" && !slices.Contains(res, addr) {
+			res = append(res, addr)
+		}
+	}
+	if len(res) == 0 {
+		res = append(res, "127.0.0.1:6789")
+	}
+	return res
+}
+
+func (p *AInferConfig) Validate() {
+	p.Addr = strings.ReplaceAll(p.Addr, "host.docker.internal", "127.0.0.1")
+	if p.TimeoutMS <= 0 {
+		p.TimeoutMS = 3000
+	}
+	if p.RetrySecs <= 0 {
+		p.RetrySecs = 30
+	}
+	if p.MaxMsgMB <= 0 {
+		p.MaxMsgMB = 100
+	}
+}
+
+/*
+GetMarginMode
+Return the margin mode of the account: cross/isolated
+返回账户的保证金模式：cross/isolated
+*/
+func GetMarginMode(account string) string {
+	if acc, ok := Accounts[account]; ok && acc.MarginMode != "" {
+		return acc.MarginMode
+	}
+	if Liquidation != nil && Liquidation.MarginMode != "" {
+		return Liquidation.MarginMode
+	}
+	return banexg.MarginCross
+}
+
 func ParsePath(path string) string {
 	if strings.HasPrefix(path, "$") {
 		path = strings.TrimLeft(path, "$\\/")
@@ -585,6 +698,8 @@ func (c *Config) Clone() *Config {
 		MinOpenRate:      c.MinOpenRate,
 		LowCostAction:    c.LowCostAction,
 		BTNetCost:        c.BTNetCost,
+		FillModel:        c.FillModel,
+		Liquidation:      c.Liquidation,
 		RelaySimUnFinish: c.RelaySimUnFinish,
 		OrderBarMax:      c.OrderBarMax,
 		MaxOpenOrders:    c.MaxOpenOrders,
@@ -600,6 +715,7 @@ func (c *Config) Clone() *Config {
 		TimeRange:        c.TimeRange,
 		RunTimeframes:    c.RunTimeframes,
 		KlineSource:      c.KlineSource,
+		TickSource:       c.TickSource,
 		WatchJobs:        c.WatchJobs,
 		RunPolicy:        c.RunPolicy,
 		StratPerf:        c.StratPerf,
@@ -607,6 +723,8 @@ func (c *Config) Clone() *Config {
 		PairMgr:          c.PairMgr,
 		PairFilters:      c.PairFilters,
 		SpiderAddr:       c.SpiderAddr,
+		SpiderRecord:     c.SpiderRecord,
+		AInfer:           c.AInfer,
 		Webhook:          c.Webhook,
 		Accounts:         c.Accounts,
 		Exchange:         c.Exchange,
@@ -821,9 +939,38 @@ func (c *RunPolicyConfig) ToYaml() string {
 	} else {
 		b.WriteString(fmt.Sprintf("    params: {%s}\n", argText))
 	}
+	if c.Rules != nil {
+		var rb bytes.Buffer
+		enc := yaml.NewEncoder(&rb)
+		enc.SetIndent(2)
+		if err_ := enc.Encode(c.Rules); err_ != nil {
+			log.Error("dump rules fail", zap.String("strat", c.Name), zap.Error(err_))
+		} else {
+			b.WriteString("    rules:\n")
+			for _, line := range strings.Split(strings.TrimRight(rb.String(), "\n"), "\n") {
+				b.WriteString("      " + line + "\n")
+			}
+		}
+	}
 	return b.String()
 }
 
+/*
+GetPolRules
+Find rules of the `expr` policy by name from loaded config, used when policies are rebuilt from opt logs
+从已加载配置中按名称查找`expr`策略的规则，用于从优化日志重建策略时
+*/
+func GetPolRules(name string) *StratRulesConfig {
+	for _, pols := range [][]*RunPolicyConfig{RunPolicy, Data.RunPolicy} {
+		for _, pol := range pols {
+			if pol.Name == name && pol.Rules != nil {
+				return pol.Rules
+			}
+		}
+	}
+	return nil
+}
+
 func (c *RunPolicyConfig) Clone() *RunPolicyConfig {
 	res := &RunPolicyConfig{
 		Name:          c.Name,
@@ -837,6 +984,7 @@ func (c *RunPolicyConfig) Clone() *RunPolicyConfig {
 		Pairs:         c.Pairs,
 		Params:        make(map[string]float64),
 		PairParams:    make(map[string]map[string]float64),
+		Rules:         c.Rules,
 		defs:          make(map[string]*core.Param),
 	}
 	if len(c.Params) > 0 {
@@ -20,3 +20,29 @@ func TestLoadConfig(t *testing.T) {
 	}
 	fmt.Println("result: \n", string(data))
 }
+
+func TestPolicyRulesYaml(t *testing.T) {
+	pol := &RunPolicyConfig{
+		Name:          "expr:ma",
+		RunTimeframes: []string{"15m"},
+		Params:        map[string]float64{"fast": 12},
+		Rules: &StratRulesConfig{
+			Hyper:      map[string]*RuleHyperConfig{"fast": {Default: 12, Min: 5, Max: 30, Int: true}},
+			Indicators: map[string]string{"ma": "SMA(close, fast)"},
+			LongEntry:  "close > ma && volume > 0",
+			StopLoss:   "close * 0.02",
+		},
+	}
+	text := "run_policy:\n" + pol.ToYaml()
+	var res struct {
+		RunPolicy []*RunPolicyConfig `yaml:"run_policy"`
+	}
+	if err := yaml.Unmarshal([]byte(text), &res); err != nil {
+		t.Fatalf("unmarshal fail: %v\n%s", err, text)
+	}
+	got := res.RunPolicy[0].Rules
+	if got == nil || got.LongEntry != pol.Rules.LongEntry || got.Indicators["ma"] != "SMA(close, fast)" ||
+		got.Hyper["fast"].Max != 30 || !got.Hyper["fast"].Int {
+		t.Fatalf("bad rules from yaml:\n%s", text)
+	}
+}
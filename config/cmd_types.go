@@ -49,9 +49,17 @@ type CmdArgs struct {
 	Picker        string  // Method for selecting targets from multiple hyperparameter optimization results 从多个超参数优化结果中挑选目标的方法
 	Alpha         float64 // the smoothing factor of calculate EMA 计算EMA的平滑因子
 	PairPicker    string  // pairs picker for hyper opt
+	Objectives    string  // objectives for multi-objective hyper opt, e.g. profit:2,drawdown 多目标超参数优化的目标
 	InType        string  // Input file data type 输入文件的数据类型
 	RunEveryTF    string  // run once every n timeframe
 	BatchSize     int
-	Separate      bool // Used for backtesting. When true, the strategy combination is tested separately. 用于回测，true时策略组合单独测试
+	Separate      bool    // Used for backtesting. When true, the strategy combination is tested separately. 用于回测，true时策略组合单独测试
+	Repair        bool    // Repair the offending ranges found by kline audit 修复K线审计发现的异常区间
+	VolSpike      float64 // Volume spike multiple of the recent median for kline audit K线审计的成交量突增倍数
+	AggTol        float64 // Max relative difference against the aggregate of sub bars for kline audit K线审计与子周期聚合的最大相对误差
+	MCRuns        int     // Monte Carlo simulations for each method, backtest runs it after finished when > 0 蒙特卡洛每种方法模拟次数，大于0时回测结束后执行
+	MCSkip        float64 // Rate of trades randomly skipped in Monte Carlo 蒙特卡洛随机跳过的交易比例
+	MCSlip        float64 // Max rate of fill price perturbation in Monte Carlo 蒙特卡洛成交价扰动的最大比例
+	ReplayPath    string  // Spider record file replayed by backtest instead of history klines 回测时替代历史K线回放的爬虫记录文件
 	Inited        bool
 }
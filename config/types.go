@@ -44,17 +44,23 @@ var (
 	TimeRange        *TimeTuple
 	RunTimeframes    []string
 	KlineSource      string
+	TickSource       string // Dir of trade files from `tick convert`, enable tick-level backtest when set 逐笔成交文件目录，设置时启用tick级回测
 	WatchJobs        map[string][]string
 	RunPolicy        []*RunPolicyConfig
 	StratPerf        *StratPerfConfig
 	Pairs            []string
 	PairMgr          *PairMgrConfig
+	FillModel        *FillModelConfig
+	Liquidation      *LiquidationConfig
 	PairFilters      []*CommonPairFilter
 	Exchange         *ExchangeConfig
 	DataDir          string
 	stratDir         string
 	Database         *DatabaseConfig
-	SpiderAddr       string
+	SpiderAddr       string   // The first spider address, also the listen address of spider 第一个爬虫地址，也是爬虫的监听地址
+	SpiderAddrs      []string // All spider addresses for failover and clustering 全部爬虫地址，用于故障转移和集群
+	SpiderRecord     bool
+	AInfer           *AInferConfig
 	APIServer        *APIServerConfig
 	RPCChannels      map[string]map[string]interface{}
 	Webhook          map[string]map[string]string
@@ -98,6 +104,8 @@ type Config struct {
 	MinOpenRate      float64                           `yaml:"min_open_rate,omitempty" mapstructure:"min_open_rate"`
 	LowCostAction    string                            `yaml:"low_cost_action,omitempty" mapstructure:"low_cost_action"`
 	BTNetCost        float64                           `yaml:"bt_net_cost,omitempty" mapstructure:"bt_net_cost"`
+	FillModel        *FillModelConfig                  `yaml:"fill_model,omitempty" mapstructure:"fill_model"`
+	Liquidation      *LiquidationConfig                `yaml:"liquidation,omitempty" mapstructure:"liquidation"`
 	RelaySimUnFinish bool                              `yaml:"relay_sim_unfinish,omitempty" mapstructure:"relay_sim_unfinish"`
 	OrderBarMax      int                               `yaml:"order_bar_max,omitempty" mapstructure:"order_bar_max"`
 	MaxOpenOrders    int                               `yaml:"max_open_orders,omitempty" mapstructure:"max_open_orders"`
@@ -113,6 +121,7 @@ type Config struct {
 	TimeRange        *TimeTuple                        `yaml:"-" json:"-" mapstructure:"-"`
 	RunTimeframes    []string                          `yaml:"run_timeframes,omitempty,flow" mapstructure:"run_timeframes"`
 	KlineSource      string                            `yaml:"kline_source,omitempty" mapstructure:"kline_source"`
+	TickSource       string                            `yaml:"tick_source,omitempty" mapstructure:"tick_source"`
 	WatchJobs        map[string][]string               `yaml:"watch_jobs,omitempty" mapstructure:"watch_jobs"`
 	RunPolicy        []*RunPolicyConfig                `yaml:"run_policy,omitempty" mapstructure:"run_policy"`
 	StratPerf        *StratPerfConfig                  `yaml:"strat_perf,omitempty" mapstructure:"strat_perf"`
@@ -122,7 +131,9 @@ type Config struct {
 	Accounts         map[string]*AccountConfig         `yaml:"accounts,omitempty" mapstructure:"accounts,omitempty"`
 	Exchange         *ExchangeConfig                   `yaml:"exchange,omitempty" mapstructure:"exchange"`
 	Database         *DatabaseConfig                   `yaml:"database,omitempty" mapstructure:"database"`
-	SpiderAddr       string                            `yaml:"spider_addr,omitempty" mapstructure:"spider_addr"`
+	SpiderAddr       interface{}                       `yaml:"spider_addr,omitempty" mapstructure:"spider_addr"` // string or list
+	SpiderRecord     bool                              `yaml:"spider_record,omitempty" mapstructure:"spider_record"`
+	AInfer           *AInferConfig                     `yaml:"ainfer,omitempty" mapstructure:"ainfer"`
 	APIServer        *APIServerConfig                  `yaml:"api_server,omitempty" mapstructure:"api_server"`
 	RPCChannels      map[string]map[string]interface{} `yaml:"rpc_channels,omitempty" mapstructure:"rpc_channels"`
 	Webhook          map[string]map[string]string      `yaml:"webhook,omitempty" mapstructure:"webhook"`
@@ -143,10 +154,42 @@ type RunPolicyConfig struct {
 	Pairs         []string                      `yaml:"pairs,omitempty,flow" mapstructure:"pairs"`
 	Params        map[string]float64            `yaml:"params,omitempty" mapstructure:"params"`
 	PairParams    map[string]map[string]float64 `yaml:"pair_params,omitempty" mapstructure:"pair_params"`
+	Rules         *StratRulesConfig             `yaml:"rules,omitempty" mapstructure:"rules"`
 	defs          map[string]*core.Param
 	Score         float64
 }
 
+/*
+StratRulesConfig
+Rules of the built-in `expr` strategy, compiled at load time. Expressions can use params, indicators and open/high/low/close/volume.
+内置`expr`策略的规则，加载时编译。表达式可使用参数、指标和open/high/low/close/volume。
+*/
+type StratRulesConfig struct {
+	Warmup     int                         `yaml:"warmup,omitempty" mapstructure:"warmup"`
+	Hyper      map[string]*RuleHyperConfig `yaml:"hyper,omitempty" mapstructure:"hyper"`
+	Indicators map[string]string           `yaml:"indicators,omitempty" mapstructure:"indicators"`
+	LongEntry  string                      `yaml:"long_entry,omitempty" mapstructure:"long_entry"`
+	LongExit   string                      `yaml:"long_exit,omitempty" mapstructure:"long_exit"`
+	ShortEntry string                      `yaml:"short_entry,omitempty" mapstructure:"short_entry"`
+	ShortExit  string                      `yaml:"short_exit,omitempty" mapstructure:"short_exit"`
+	// Distance from entry price to stop loss price 入场价格到止损价格的距离
+	StopLoss string `yaml:"stop_loss,omitempty" mapstructure:"stop_loss"`
+	// Distance from entry price to take profit price 入场价格到止盈价格的距离
+	TakeProfit string `yaml:"take_profit,omitempty" mapstructure:"take_profit"`
+	// Exit condition checked for each order, can use profit/max_profit/entry_price/hold_bars/is_short
+	// 对每个订单检查的退出条件，可使用profit/max_profit/entry_price/hold_bars/is_short
+	CheckExit string `yaml:"check_exit,omitempty" mapstructure:"check_exit"`
+}
+
+// RuleHyperConfig Numeric param of rules which can be optimized 规则中可优化的数值参数
+type RuleHyperConfig struct {
+	Default float64 `yaml:"default" mapstructure:"default"`
+	Min     float64 `yaml:"min" mapstructure:"min"`
+	Max     float64 `yaml:"max" mapstructure:"max"`
+	Int     bool    `yaml:"int,omitempty" mapstructure:"int"`
+	Norm    bool    `yaml:"norm,omitempty" mapstructure:"norm"` // normal distribution around default, uniform by default 以默认值为中心的正态分布，默认均匀分布
+}
+
 type StratPerfConfig struct {
 	Enable    bool    `yaml:"enable" mapstructure:"enable"`
 	MinOdNum  int     `yaml:"min_od_num,omitempty" mapstructure:"min_od_num"`
@@ -156,6 +199,49 @@ type StratPerfConfig struct {
 	BadWeight float64 `yaml:"bad_weight,omitempty" mapstructure:"bad_weight"`
 }
 
+// FillModelConfig Simulated fill of orders in backtesting 回测时订单的模拟成交
+type FillModelConfig struct {
+	// candle/worst/volume/queue/lowertf, default candle 默认candle
+	Name string `yaml:"name" mapstructure:"name"`
+	// Max fill rate of bar volume for volume/queue model, default 0.1 volume/queue模型单个bar最大成交量比例，默认0.1
+	VolRate float64 `yaml:"vol_rate,omitempty" mapstructure:"vol_rate"`
+	// Sub timeframe for lowertf model, default 1m lowertf模型的子周期，默认1m
+	SubTF string `yaml:"sub_tf,omitempty" mapstructure:"sub_tf"`
+}
+
+// LiquidationConfig Per position liquidation of contracts in backtesting 回测时合约按仓位强平
+type LiquidationConfig struct {
+	// Enable per position liquidation, otherwise only check the whole wallet 启用按仓位强平，否则仅检查整个钱包
+	Enable bool `yaml:"enable" mapstructure:"enable"`
+	// close/extreme/index, default close 默认close
+	MarkPrice string `yaml:"mark_price,omitempty" mapstructure:"mark_price"`
+	// Default margin mode of accounts: cross/isolated, default cross 账户默认保证金模式，默认cross
+	MarginMode string `yaml:"margin_mode,omitempty" mapstructure:"margin_mode"`
+	// Liquidation fee rate of position value, default 0.005 强平清算费率（仓位价值的比例），默认0.005
+	FeeRate float64 `yaml:"fee_rate,omitempty" mapstructure:"fee_rate"`
+	// Maintenance margin tiers used when exchange brackets are not available 交易所杠杆分层不可用时使用的维持保证金分层
+	Tiers []*MaintMarginTier `yaml:"tiers,omitempty" mapstructure:"tiers"`
+}
+
+// MaintMarginTier maintenance margin = notional * Rate - Cum, when notional >= Floor 名义价值>=Floor时，维持保证金=名义价值*Rate-Cum
+type MaintMarginTier struct {
+	Floor float64 `yaml:"floor" mapstructure:"floor"`
+	Rate  float64 `yaml:"rate" mapstructure:"rate"`
+	Cum   float64 `yaml:"cum,omitempty" mapstructure:"cum"`
+}
+
+// AInferConfig Model inference server used by strategies 策略使用的模型推理服务
+type AInferConfig struct {
+	// grpc address of the AInfer service, disabled when empty AInfer服务的grpc地址，为空时禁用
+	Addr string `yaml:"addr,omitempty" mapstructure:"addr"`
+	// Timeout of each call in milliseconds, default 3000 每次调用的超时毫秒数，默认3000
+	TimeoutMS int `yaml:"timeout_ms,omitempty" mapstructure:"timeout_ms"`
+	// Seconds to skip calls after the server is unavailable, default 30 服务不可用后跳过调用的秒数，默认30
+	RetrySecs int `yaml:"retry_secs,omitempty" mapstructure:"retry_secs"`
+	// Max size of request/response in MB, default 100 请求/响应的最大MB数，默认100
+	MaxMsgMB int `yaml:"max_msg_mb,omitempty" mapstructure:"max_msg_mb"`
+}
+
 type DatabaseConfig struct {
 	Url         string `yaml:"url,omitempty" mapstructure:"url"`
 	Retention   string `yaml:"retention,omitempty" mapstructure:"retention"`
@@ -198,6 +284,19 @@ type TelegramChannel struct {
 	MsgTypes []string `yaml:"msg_types,flow" mapstructure:"msg_types"`
 	Token    string   `yaml:"token" mapstructure:"token"`
 	Channel  string   `yaml:"channel" mapstructure:"channel"`
+	BaseUrl  string   `yaml:"base_url,omitempty" mapstructure:"base_url"`
+}
+
+type HttpChannel struct {
+	Enable     bool                   `yaml:"enable" mapstructure:"enable"`
+	Type       string                 `yaml:"type" mapstructure:"type"`
+	MsgTypes   []string               `yaml:"msg_types,flow" mapstructure:"msg_types"`
+	Url        string                 `yaml:"url" mapstructure:"url"`
+	Method     string                 `yaml:"method,omitempty" mapstructure:"method"`           // POST/PUT, default POST
+	Headers    map[string]string      `yaml:"headers,omitempty" mapstructure:"headers"`         // custom request headers 自定义请求头
+	Body       map[string]interface{} `yaml:"body,omitempty" mapstructure:"body"`               // json body template 请求体json模板
+	Secret     string                 `yaml:"secret,omitempty" mapstructure:"secret"`           // HMAC-SHA256 signing key 签名密钥
+	SignHeader string                 `yaml:"sign_header,omitempty" mapstructure:"sign_header"` // default X-Signature
 }
 
 /** ********************************** Symbol FILTER标的筛选器  ******************************** */
@@ -237,6 +336,7 @@ type AccountConfig struct {
 	Leverage      float64                   `yaml:"leverage,omitempty" mapstructure:"leverage"`
 	MaxPair       int                       `yaml:"max_pair,omitempty" mapstructure:"max_pair"`
 	MaxOpenOrders int                       `yaml:"max_open_orders,omitempty" mapstructure:"max_open_orders"`
+	MarginMode    string                    `yaml:"margin_mode,omitempty" mapstructure:"margin_mode"` // cross/isolated, for contracts backtest 合约回测的保证金模式
 	RPCChannels   []map[string]interface{}  `yaml:"rpc_channels,omitempty" mapstructure:"rpc_channels"`
 	APIServer     *AccPwdRole               `yaml:"api_server,omitempty" mapstructure:"api_server"`
 	Exchanges     map[string]*ExgApiSecrets `yaml:",inline" mapstructure:",remain"`
@@ -191,6 +191,17 @@ func (p *Param) ToRegular(x float64) (float64, bool) {
 	return x, true
 }
 
+/*
+ToOptSpace Inverse of ToRegular, map a hyperparameter value back to the optimize space 与ToRegular相反，将超参数值映射回优化空间
+*/
+func (p *Param) ToOptSpace(v float64) float64 {
+	if p.VType != VTypeNorm {
+		return v
+	}
+	scale := max(p.Mean-p.Min, p.Max-p.Mean)
+	return p.calcNormX((v-p.Mean)/scale*p.getEdgeY(), 1e-6, 1000)
+}
+
 func (p *Param) getEdgeY() float64 {
 	if p.edgeY == 0 {
 		p.edgeY = p.norm(0.5)
@@ -152,6 +152,34 @@ const (
 	LowCostKeepAll = "keepAll"
 )
 
+const (
+	FillCandle  = "candle"  // estimate the price path inside the candle 按蜡烛内价格路径估算成交
+	FillWorst   = "worst"   // fill at the worst price of the bar 以bar内最差价格成交
+	FillVolume  = "volume"  // cap fill amount by a fraction of bar volume 按bar成交量的比例限制成交数量
+	FillQueue   = "queue"   // resting limit orders fill by volume share at or through the price 挂单按穿过其价格的成交量份额逐步成交
+	FillLowerTF = "lowertf" // walk sub bars of lower timeframe 遍历更小周期的子K线撮合
+)
+
+const (
+	MarkPriceClose   = "close"   // bar close 使用bar收盘价
+	MarkPriceExtreme = "extreme" // the adverse extreme of bar: low for longs, high for shorts 使用bar内不利极值：多单用最低价，空单用最高价
+	MarkPriceIndex   = "index"   // close of the spot pair as index price 使用现货交易对收盘价作为指数价格
+)
+
+var MarkPriceModes = map[string]bool{
+	MarkPriceClose:   true,
+	MarkPriceExtreme: true,
+	MarkPriceIndex:   true,
+}
+
+var FillModels = map[string]bool{
+	FillCandle:  true,
+	FillWorst:   true,
+	FillVolume:  true,
+	FillQueue:   true,
+	FillLowerTF: true,
+}
+
 var LowCostVals = map[string]int{
 	LowCostIgnore:  0,
 	LowCostKeepBig: 1,
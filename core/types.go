@@ -57,3 +57,14 @@ type FloatText struct {
 	Text string
 	Val  float64
 }
+
+/*
+AltBarSpec
+Spec of a non-time bar, like vol:5000 usd:1e6 range:0.5% renko:20
+非时间驱动的K线规格，如 vol:5000 usd:1e6 range:0.5% renko:20
+*/
+type AltBarSpec struct {
+	Kind  string // vol/usd/range/renko
+	Size  float64
+	IsPct bool // Size is a ratio of the price 大小是价格的比例
+}
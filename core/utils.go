@@ -6,7 +6,9 @@ import (
 	"github.com/banbox/banexg/utils"
 	"regexp"
 	"slices"
+	"strconv"
 	"strings"
+	"sync"
 )
 
 /*
@@ -104,3 +106,62 @@ func SplitSymbol(pair string) (string, string, string, string) {
 	cache, _ := splitCache[pair]
 	return cache[0], cache[1], cache[2], cache[3]
 }
+
+const (
+	AltBarVol   = "vol"   // close when the volume reaches Size 成交量达到Size时完成
+	AltBarUsd   = "usd"   // close when the quote amount reaches Size 成交额达到Size时完成
+	AltBarRange = "range" // close when high-low reaches Size 最高最低价差达到Size时完成
+	AltBarRenko = "renko" // close when the price moves Size from the last brick 价格距上个砖块移动Size时完成
+	// AltBarBaseTF non-time bars are built from bars of this timeframe 非时间K线由此周期的K线构建
+	AltBarBaseTF = "1m"
+)
+
+var (
+	altBarSpecs = make(map[string]*AltBarSpec)
+	lockAltBar  sync.Mutex
+)
+
+/*
+ParseAltBar
+Parse the spec of a non-time bar, return nil for a time based timeframe
+解析非时间K线的规格，对于时间周期返回nil
+*/
+func ParseAltBar(timeFrame string) *AltBarSpec {
+	kind, val, ok := strings.Cut(timeFrame, ":")
+	if !ok {
+		return nil
+	}
+	lockAltBar.Lock()
+	defer lockAltBar.Unlock()
+	if spec, ok := altBarSpecs[timeFrame]; ok {
+		return spec
+	}
+	if kind != AltBarVol && kind != AltBarUsd && kind != AltBarRange && kind != AltBarRenko {
+		return nil
+	}
+	isPct := strings.HasSuffix(val, "%")
+	size, err := strconv.ParseFloat(strings.TrimSuffix(val, "%"), 64)
+	if err != nil || size <= 0 || isPct && (kind == AltBarVol || kind == AltBarUsd) {
+		return nil
+	}
+	if isPct {
+		size /= 100
+	}
+	spec := &AltBarSpec{Kind: kind, Size: size, IsPct: isPct}
+	altBarSpecs[timeFrame] = spec
+	return spec
+}
+
+/*
+TFToSecs
+Same as utils.TFToSecs, return the secs of AltBarBaseTF for non-time bars.
+A non-time bar uses the start time of its last source bar as Time, so Time+secs is always the finish time.
+同utils.TFToSecs，对非时间K线返回AltBarBaseTF的秒数。
+非时间K线使用其最后一个源K线的开始时间作为Time，因此Time+secs总是完成时间。
+*/
+func TFToSecs(timeFrame string) int {
+	if ParseAltBar(timeFrame) != nil {
+		return utils.TFToSecs(AltBarBaseTF)
+	}
+	return utils.TFToSecs(timeFrame)
+}
@@ -26,6 +26,11 @@ type FnPairKline = func(bar *orm.InfoKline)
 type FuncEnvEnd = func(bar *banexg.PairTFKline, adj *orm.AdjInfo)
 type FnGetInt64 = func() int64
 
+const (
+	altWarmRate = 60     // Number of source bars to fetch for each non-time bar when warming up 预热时每个非时间K线获取的源K线数量
+	altWarmMax  = 100000 // Max number of source bars for warming up a non-time bar 预热非时间K线的最大源K线数量
+)
+
 type PairTFCache struct {
 	TimeFrame  string
 	TFSecs     int
@@ -33,6 +38,17 @@ type PairTFCache struct {
 	WaitBar    *banexg.Kline // Record unfinished bars. Should be set to nil when completed 记录尚未完成的bar。已完成时应置为nil
 	Latest     *banexg.Kline // Record the latest bar data, which may not be completed or may be completed 记录最新bar数据，可能未完成，可能已完成
 	AlignOffMS int64
+	Hidden     bool // Only used as the source of non-time bars, no callback 仅用作非时间K线的源，不触发回调
+}
+
+/*
+AltBarState
+State of a non-time bar like vol:5000, built from the bars of core.AltBarBaseTF
+非时间K线的状态，如vol:5000，从core.AltBarBaseTF的K线构建
+*/
+type AltBarState struct {
+	TimeFrame string
+	*utils.AltBarBuilder
 }
 
 /*
@@ -55,6 +71,7 @@ LiveFeeder requires preheating for both new trading pairs and new cycles; HistFe
 type Feeder struct {
 	*orm.ExSymbol
 	States   []*PairTFCache
+	AltState []*AltBarState // Non-time bars, sorted by TimeFrame 非时间K线，按TimeFrame排序
 	WaitBar  *banexg.Kline
 	CallBack FnPairKline
 	OnEnvEnd FuncEnvEnd                 // If the futures main force switches or the stock is ex-rights, the position needs to be closed first 期货主力切换或股票除权，需先平仓
@@ -86,6 +103,12 @@ Add monitoring to States and return the newly added TimeFrames
 添加监听到States中，返回新增的TimeFrames
 */
 func (f *Feeder) SubTfs(timeFrames []string, delOther bool) []string {
+	timeFrames, altAdds := f.subAltTfs(timeFrames, delOther)
+	hideBase := false
+	if len(f.AltState) > 0 && !slices.Contains(timeFrames, core.AltBarBaseTF) {
+		timeFrames = append(timeFrames, core.AltBarBaseTF)
+		hideBase = true
+	}
 	var oldTfs = make(map[string]bool)
 	var stateMap = make(map[string]*PairTFCache)
 	var minTfSecs = 0 // 记录最小时间周期
@@ -139,6 +162,14 @@ func (f *Feeder) SubTfs(timeFrames []string, delOther bool) []string {
 			}
 		}
 	}
+	if sta, ok := stateMap[core.AltBarBaseTF]; ok {
+		sta.Hidden = hideBase
+		if hideBase {
+			adds = slices.DeleteFunc(adds, func(tf string) bool {
+				return tf == core.AltBarBaseTF
+			})
+		}
+	}
 	var newStates = utils.ValsOfMap(stateMap)
 	// Sort all periods from small to large. The first one must be the least common multiple of all subsequent states, so that all subsequent states can be updated from the first one.
 	// 对所有周期从小到大排序，第一个必须是后续所有states的最小公倍数，以便能从第一个更新后续所有
@@ -159,7 +190,43 @@ func (f *Feeder) SubTfs(timeFrames []string, delOther bool) []string {
 		}
 	}
 	f.States = newStates
-	return adds
+	return append(adds, altAdds...)
+}
+
+/*
+subAltTfs
+Update AltState with the non-time bars in timeFrames, return the time based timeframes and the added non-time bars
+用timeFrames中的非时间K线更新AltState，返回时间周期和新增的非时间K线
+*/
+func (f *Feeder) subAltTfs(timeFrames []string, delOther bool) ([]string, []string) {
+	var tfs, adds []string
+	var states []*AltBarState
+	olds := make(map[string]*AltBarState)
+	for _, sta := range f.AltState {
+		olds[sta.TimeFrame] = sta
+	}
+	for _, tf := range timeFrames {
+		spec := core.ParseAltBar(tf)
+		if spec == nil {
+			tfs = append(tfs, tf)
+			continue
+		}
+		if sta, ok := olds[tf]; ok {
+			states = append(states, sta)
+			delete(olds, tf)
+			continue
+		}
+		states = append(states, &AltBarState{TimeFrame: tf, AltBarBuilder: utils.NewAltBarBuilder(spec)})
+		adds = append(adds, tf)
+	}
+	if !delOther {
+		states = append(states, utils.ValsOfMap(olds)...)
+	}
+	slices.SortFunc(states, func(a, b *AltBarState) int {
+		return strings.Compare(a.TimeFrame, b.TimeFrame)
+	})
+	f.AltState = states
+	return tfs, adds
 }
 
 /*
@@ -190,13 +257,33 @@ func (f *Feeder) onStateOhlcvs(state *PairTFCache, bars []*banexg.Kline, lastOk
 	}
 	if len(finishBars) > 0 {
 		state.NextMS = finishBars[len(finishBars)-1].Time + tfMSecs
-		f.addTfKlines(state.TimeFrame, finishBars)
-		adjBars := f.adj.Apply(finishBars, core.AdjFront)
-		f.fireCallBacks(state.TimeFrame, tfMSecs, adjBars, f.adj)
+		if !state.Hidden {
+			f.addTfKlines(state.TimeFrame, finishBars)
+			adjBars := f.adj.Apply(finishBars, core.AdjFront)
+			f.fireCallBacks(state.TimeFrame, tfMSecs, adjBars, f.adj)
+		}
+		if state.TimeFrame == core.AltBarBaseTF {
+			f.onAltBars(tfMSecs, finishBars)
+		}
 	}
 	return finishBars
 }
 
+/*
+onAltBars
+Update non-time bars with the finished bars of core.AltBarBaseTF and trigger callbacks
+用core.AltBarBaseTF的已完成K线更新非时间K线并触发回调
+*/
+func (f *Feeder) onAltBars(tfMSecs int64, bars []*banexg.Kline) {
+	for _, sta := range f.AltState {
+		doneBars := sta.Update(bars)
+		if len(doneBars) > 0 {
+			adjBars := f.adj.Apply(doneBars, core.AdjFront)
+			f.fireCallBacks(sta.TimeFrame, tfMSecs, adjBars, f.adj)
+		}
+	}
+}
+
 func (f *Feeder) getTfKlines(tf string, endMS int64, limit int, pBar *utils.PrgBar) ([]*banexg.Kline, *errs.Error) {
 	bars, _ := f.tfBars[tf]
 	tfMSecs := int64(utils2.TFToSecs(tf) * 1000)
@@ -221,6 +308,34 @@ func (f *Feeder) getTfKlines(tf string, endMS int64, limit int, pBar *utils.PrgB
 	return bars, nil
 }
 
+/*
+getAltKlines
+Build non-time bars for warming up from bars of core.AltBarBaseTF before endMS. The builder is reset and keeps the unfinished bar afterwards.
+从endMS前core.AltBarBaseTF的K线构建用于预热的非时间K线。构建器会被重置，之后保留未完成的bar。
+*/
+func (f *Feeder) getAltKlines(tf string, endMS int64, limit int, pBar *utils.PrgBar) ([]*banexg.Kline, *errs.Error) {
+	idx := slices.IndexFunc(f.AltState, func(s *AltBarState) bool {
+		return s.TimeFrame == tf
+	})
+	if idx < 0 {
+		if pBar != nil {
+			pBar.Add(core.StepTotal)
+		}
+		return nil, nil
+	}
+	bars, err := f.getTfKlines(core.AltBarBaseTF, endMS, min(limit*altWarmRate, altWarmMax), pBar)
+	if err != nil {
+		return nil, err
+	}
+	sta := f.AltState[idx]
+	sta.AltBarBuilder = utils.NewAltBarBuilder(sta.Spec)
+	res := sta.Update(bars)
+	if len(res) > limit {
+		res = res[len(res)-limit:]
+	}
+	return res, nil
+}
+
 func (f *Feeder) addTfKlines(tf string, bars []*banexg.Kline) {
 	olds, _ := f.tfBars[tf]
 	if len(olds) > core.NumTaCache*2 {
@@ -340,12 +455,19 @@ func (f *KlineFeeder) WarmTfs(curMS int64, tfNums map[string]int, pBar *utils.Pr
 	maxEndMs := int64(0)
 	skips := make(map[string][2]int)
 	for tf, warmNum := range tfNums {
-		tfMSecs := int64(utils2.TFToSecs(tf) * 1000)
+		tfMSecs := int64(core.TFToSecs(tf) * 1000)
 		if tfMSecs < int64(60000) || warmNum <= 0 {
 			continue
 		}
 		endMS := utils2.AlignTfMSecs(curMS, tfMSecs)
-		bars, err := f.getTfKlines(tf, endMS, warmNum, pBar)
+		var bars []*banexg.Kline
+		var err *errs.Error
+		isAlt := core.ParseAltBar(tf) != nil
+		if isAlt {
+			bars, err = f.getAltKlines(tf, endMS, warmNum, pBar)
+		} else {
+			bars, err = f.getTfKlines(tf, endMS, warmNum, pBar)
+		}
 		if err != nil {
 			return 0, nil, err
 		}
@@ -357,6 +479,16 @@ func (f *KlineFeeder) WarmTfs(curMS int64, tfNums map[string]int, pBar *utils.Pr
 			skips[fmt.Sprintf("%s_%s", f.Symbol, tf)] = [2]int{warmNum, len(bars)}
 		}
 		curEnd := f.warmTf(tf, bars)
+		if isAlt {
+			// The source bars until endMS are consumed, the unfinished part is kept in the builder
+			// 截止endMS的源K线已被消费，未完成的部分保留在构建器中
+			curEnd = endMS
+			for _, sta := range f.States {
+				if sta.TimeFrame == core.AltBarBaseTF {
+					sta.NextMS = max(sta.NextMS, endMS)
+				}
+			}
+		}
 		maxEndMs = max(maxEndMs, curEnd)
 	}
 	return maxEndMs, skips, nil
@@ -384,7 +516,7 @@ func (f *KlineFeeder) warmTf(tf string, bars []*banexg.Kline) int64 {
 		return 0
 	}
 	f.isWarmUp = true
-	tfMSecs := int64(utils2.TFToSecs(tf) * 1000)
+	tfMSecs := int64(core.TFToSecs(tf) * 1000)
 	lastMS := bars[len(bars)-1].Time + tfMSecs
 	envKey := strings.Join([]string{f.Symbol, tf}, "_")
 	if env, ok := strat.Envs[envKey]; ok {
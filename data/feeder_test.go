@@ -0,0 +1,59 @@
+package data
+
+import (
+	"testing"
+
+	"github.com/banbox/banbot/config"
+	"github.com/banbox/banbot/core"
+	"github.com/banbox/banbot/orm"
+	"github.com/banbox/banexg"
+)
+
+func TestFeederAltBars(t *testing.T) {
+	if config.Exchange == nil {
+		config.Exchange = &config.ExchangeConfig{Name: "binance", Items: map[string]map[string]interface{}{}}
+		defer func() {
+			config.Exchange = nil
+		}()
+	}
+	var fired []*orm.InfoKline
+	f := &KlineFeeder{Feeder: Feeder{
+		ExSymbol: &orm.ExSymbol{Exchange: "binance", Market: banexg.MarketLinear, Symbol: "BTC/USDT:USDT"},
+		CallBack: func(bar *orm.InfoKline) {
+			fired = append(fired, bar)
+		},
+		tfBars: make(map[string][]*banexg.Kline),
+	}}
+	adds := f.SubTfs([]string{"vol:25", "5m"}, false)
+	if len(adds) != 2 || len(f.States) != 2 || f.States[0].TimeFrame != core.AltBarBaseTF || !f.States[0].Hidden {
+		t.Fatalf("expect a hidden 1m state, got %v %v", adds, f.States)
+	}
+	startMS := int64(1700000100000)
+	var bars []*banexg.Kline
+	for i := 0; i < 6; i++ {
+		bars = append(bars, &banexg.Kline{Time: startMS + int64(i)*60000, Open: 100, High: 101, Low: 99,
+			Close: 100, Volume: 10})
+	}
+	if _, err := f.onNewBars(60000, bars); err != nil {
+		t.Fatal(err)
+	}
+	var alts, bigs int
+	for _, bar := range fired {
+		switch bar.TimeFrame {
+		case "vol:25":
+			alts += 1
+		case "5m":
+			bigs += 1
+		default:
+			t.Fatalf("hidden timeframe should not fire: %v", bar.TimeFrame)
+		}
+	}
+	if alts != 2 || bigs != 1 {
+		t.Fatalf("expect 2 vol bars and one 5m bar, got %v %v", alts, bigs)
+	}
+	// unsubscribing the non-time bar removes the hidden base state
+	f.SubTfs([]string{"5m"}, true)
+	if len(f.AltState) != 0 || len(f.States) != 1 {
+		t.Fatalf("expect only 5m left, got %v %v", f.AltState, f.States)
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"math"
 	"sort"
+	"strings"
 	"sync"
 
 	"github.com/banbox/banbot/btime"
@@ -162,36 +163,54 @@ type HistProvider struct {
 	getEnd    FnGetInt64
 	maxTfSecs int
 	pBar      *utils.StagedPrg
+	OnTrades  FnPairTrades // receive trades in tick-level backtest tick级回测时接收逐笔成交
 }
 
 func NewHistProvider(callBack FnPairKline, envEnd FuncEnvEnd, getEnd FnGetInt64, showLog bool, pBar *utils.StagedPrg) *HistProvider {
-	return &HistProvider{
-		Provider: Provider[IHistKlineFeeder]{
-			holders: make(map[string]IHistKlineFeeder),
-			newFeeder: func(pair string, tfs []string) (IHistKlineFeeder, *errs.Error) {
-				exs, err := orm.GetExSymbolCur(pair)
-				if err != nil {
-					return nil, err
-				}
-				feeder, err := NewDBKlineFeeder(exs, callBack, showLog)
+	res := &HistProvider{
+		getEnd: getEnd,
+		pBar:   pBar,
+	}
+	res.Provider = Provider[IHistKlineFeeder]{
+		holders: make(map[string]IHistKlineFeeder),
+		newFeeder: func(pair string, tfs []string) (IHistKlineFeeder, *errs.Error) {
+			exs, err := orm.GetExSymbolCur(pair)
+			if err != nil {
+				return nil, err
+			}
+			if config.TickSource != "" {
+				feeder, err := NewTickKlineFeeder(exs, callBack, showLog)
 				if err != nil {
 					return nil, err
 				}
 				feeder.OnEnvEnd = envEnd
+				feeder.OnTrades = res.fireTrades
 				feeder.SubTfs(tfs, false)
 				return feeder, nil
-			},
-			dirtyVers: make(chan int, 5),
-			showLog:   showLog,
+			}
+			feeder, err := NewDBKlineFeeder(exs, callBack, showLog)
+			if err != nil {
+				return nil, err
+			}
+			feeder.OnEnvEnd = envEnd
+			feeder.SubTfs(tfs, false)
+			return feeder, nil
 		},
-		getEnd: getEnd,
-		pBar:   pBar,
+		dirtyVers: make(chan int, 5),
+		showLog:   showLog,
+	}
+	return res
+}
+
+func (p *HistProvider) fireTrades(pair string, trades []*banexg.Trade) {
+	if p.OnTrades != nil {
+		p.OnTrades(pair, trades)
 	}
 }
 
 func (p *HistProvider) downIfNeed() *errs.Error {
 	exchange := exg.Default
-	if !exchange.HasApi(banexg.ApiFetchOHLCV, core.Market) {
+	if orm.IsFileSource() || !exchange.HasApi(banexg.ApiFetchOHLCV, core.Market) {
 		return nil
 	}
 	var err *errs.Error
@@ -414,31 +433,21 @@ type LiveProvider struct {
 }
 
 func NewLiveProvider(callBack FnPairKline, envEnd FuncEnvEnd) (*LiveProvider, *errs.Error) {
-	watcher, err := NewKlineWatcher(config.SpiderAddr)
+	watcher, err := NewKlineWatcher(config.SpiderAddrs...)
 	if err != nil {
 		return nil, err
 	}
 	provider := &LiveProvider{
-		Provider: Provider[IKlineFeeder]{
-			holders: make(map[string]IKlineFeeder),
-			newFeeder: func(pair string, tfs []string) (IKlineFeeder, *errs.Error) {
-				exs, err := orm.GetExSymbol(exg.Default, pair)
-				if err != nil {
-					return nil, err
-				}
-				feeder, err := NewKlineFeeder(exs, callBack, true)
-				if err != nil {
-					return nil, err
-				}
-				feeder.SubTfs(tfs, false)
-				feeder.OnEnvEnd = envEnd
-				return feeder, nil
-			},
-			dirtyVers: make(chan int, 5),
-		},
+		Provider:     newLiveBaseProvider(callBack, envEnd),
 		KLineWatcher: watcher,
 	}
-	watcher.OnKLineMsg = makeOnKlineMsg(provider)
+	watcher.OnKLineMsg = makeOnKlineMsg(&provider.Provider)
+	if config.SpiderRecord {
+		err = watcher.StartRecord(GetSpiderRecPath())
+		if err != nil {
+			return nil, err
+		}
+	}
 	// 立刻订阅实时价格
 	err = watcher.SendMsg("subscribe", []string{
 		fmt.Sprintf("price_%s_%s", core.ExgName, core.Market),
@@ -449,33 +458,67 @@ func NewLiveProvider(callBack FnPairKline, envEnd FuncEnvEnd) (*LiveProvider, *e
 	return provider, nil
 }
 
+func newLiveBaseProvider(callBack FnPairKline, envEnd FuncEnvEnd) Provider[IKlineFeeder] {
+	return Provider[IKlineFeeder]{
+		holders: make(map[string]IKlineFeeder),
+		newFeeder: func(pair string, tfs []string) (IKlineFeeder, *errs.Error) {
+			exs, err := orm.GetExSymbol(exg.Default, pair)
+			if err != nil {
+				return nil, err
+			}
+			feeder, err := NewKlineFeeder(exs, callBack, true)
+			if err != nil {
+				return nil, err
+			}
+			feeder.SubTfs(tfs, false)
+			feeder.OnEnvEnd = envEnd
+			return feeder, nil
+		},
+		dirtyVers: make(chan int, 5),
+	}
+}
+
+/*
+makeWatchJobs
+Build spider jobs for new subscribed holders, return jobType: jobs
+为新订阅的holders构建爬虫任务，返回jobType: jobs
+*/
+func makeWatchJobs(newHolds []IKlineFeeder, sinceMap map[string]int64) map[string][]WatchJob {
+	res := make(map[string][]WatchJob)
+	if len(newHolds) == 0 {
+		return res
+	}
+	var jobs []WatchJob
+	for _, h := range newHolds {
+		symbol, timeFrame := h.getSymbol(), h.getStates()[0].TimeFrame
+		if since, ok := sinceMap[symbol]; ok {
+			jobs = append(jobs, WatchJob{
+				Symbol:    symbol,
+				TimeFrame: timeFrame,
+				Since:     since,
+			})
+		}
+	}
+	res["ohlcv"] = jobs
+	if len(core.BookPairs) > 0 {
+		jobs = make([]WatchJob, 0, len(core.BookPairs))
+		for pair := range core.BookPairs {
+			jobs = append(jobs, WatchJob{Symbol: pair, TimeFrame: "1m"})
+		}
+		res["book"] = jobs
+	}
+	return res
+}
+
 func (p *LiveProvider) SubWarmPairs(items map[string]map[string]int, delOther bool) *errs.Error {
 	newHolds, sinceMap, delPairs, err := p.Provider.SubWarmPairs(items, delOther, nil)
 	if err != nil {
 		return err
 	}
-	if len(newHolds) > 0 {
-		var jobs []WatchJob
-		for _, h := range newHolds {
-			symbol, timeFrame := h.getSymbol(), h.getStates()[0].TimeFrame
-			if since, ok := sinceMap[symbol]; ok {
-				jobs = append(jobs, WatchJob{
-					Symbol:    symbol,
-					TimeFrame: timeFrame,
-					Since:     since,
-				})
-			}
-		}
-		err = p.WatchJobs(core.ExgName, core.Market, "ohlcv", jobs...)
-		if err != nil {
-			return err
-		}
-		if len(core.BookPairs) > 0 {
-			jobs = make([]WatchJob, 0, len(core.BookPairs))
-			for pair := range core.BookPairs {
-				jobs = append(jobs, WatchJob{Symbol: pair, TimeFrame: "1m"})
-			}
-			err = p.WatchJobs(core.ExgName, core.Market, "book", jobs...)
+	watchJobs := makeWatchJobs(newHolds, sinceMap)
+	for _, jobType := range []string{"ohlcv", "book"} {
+		if jobs, ok := watchJobs[jobType]; ok {
+			err = p.WatchJobs(core.ExgName, core.Market, jobType, jobs...)
 			if err != nil {
 				return err
 			}
@@ -502,7 +545,7 @@ func (p *LiveProvider) LoopMain() *errs.Error {
 	return p.RunForever()
 }
 
-func makeOnKlineMsg(p *LiveProvider) func(msg *KLineMsg) {
+func makeOnKlineMsg(p *Provider[IKlineFeeder]) func(msg *KLineMsg) {
 	return func(msg *KLineMsg) {
 		if msg.ExgName != core.ExgName || msg.Market != core.Market {
 			return
@@ -558,3 +601,100 @@ func makeOnKlineMsg(p *LiveProvider) func(msg *KLineMsg) {
 		}
 	}
 }
+
+/*
+ReplayProvider
+Feed spider messages recorded by LiveProvider (config spider_record) back through the same callbacks,
+btime.CurTimeMS is set to the receive time of each message. Should be run in backtest mode.
+将LiveProvider记录的爬虫消息(配置spider_record)通过相同的回调重新推送，
+btime.CurTimeMS被设为每条消息的接收时间。应在回测模式下运行。
+*/
+type ReplayProvider struct {
+	Provider[IKlineFeeder]
+	*KLineWatcher
+	reader   *SpiderReader
+	next     *SpiderRecord
+	handlers map[string]utils.ConnCB
+}
+
+func NewReplayProvider(path string, callBack FnPairKline, envEnd FuncEnvEnd) (*ReplayProvider, *errs.Error) {
+	reader, err := NewSpiderReader(path)
+	if err != nil {
+		return nil, err
+	}
+	next, err := reader.Next()
+	if err == nil && next == nil {
+		err = errs.NewMsg(core.ErrIOReadFail, "no spider records in %s", path)
+	}
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+	// warm up pairs before the first message
+	// 在第一条消息之前预热品种
+	btime.CurTimeMS = next.TimeMS
+	watcher := &KLineWatcher{jobs: make(map[string]*PairTFCache)}
+	provider := &ReplayProvider{
+		Provider:     newLiveBaseProvider(callBack, envEnd),
+		KLineWatcher: watcher,
+		reader:       reader,
+		next:         next,
+		handlers:     watcher.dataHandlers(),
+	}
+	watcher.OnKLineMsg = makeOnKlineMsg(&provider.Provider)
+	return provider, nil
+}
+
+func (p *ReplayProvider) SubWarmPairs(items map[string]map[string]int, delOther bool) *errs.Error {
+	newHolds, sinceMap, delPairs, err := p.Provider.SubWarmPairs(items, delOther, nil)
+	if err != nil {
+		return err
+	}
+	for jobType, jobs := range makeWatchJobs(newHolds, sinceMap) {
+		_, _, _, err = p.addJobs(core.ExgName, core.Market, jobType, jobs)
+		if err != nil {
+			return err
+		}
+	}
+	p.delJobs("ohlcv", delPairs)
+	return nil
+}
+
+func (p *ReplayProvider) UnSubPairs(pairs ...string) *errs.Error {
+	removed := p.Provider.UnSubPairs(pairs...)
+	p.delJobs("ohlcv", removed)
+	return nil
+}
+
+func (p *ReplayProvider) LoopMain() *errs.Error {
+	defer p.reader.Close()
+	var err *errs.Error
+	for p.next != nil {
+		select {
+		case ver := <-p.dirtyVers:
+			if ver < 0 {
+				return nil
+			}
+		default:
+		}
+		rec := p.next
+		if rec.TimeMS > btime.CurTimeMS {
+			btime.CurTimeMS = rec.TimeMS
+		}
+		msgType, _, _ := strings.Cut(rec.Key, "_")
+		if handle, ok := p.handlers[msgType]; ok {
+			handle(rec.Key, rec.Data)
+		} else {
+			log.Debug("replay msg ignored", zap.String("key", rec.Key))
+		}
+		p.next, err = p.reader.Next()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *ReplayProvider) Terminate() {
+	p.dirtyVers <- -1
+}
@@ -0,0 +1,222 @@
+package data
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/banbox/banbot/btime"
+	"github.com/banbox/banbot/config"
+	"github.com/banbox/banbot/core"
+	"github.com/banbox/banbot/utils"
+	"github.com/banbox/banexg/errs"
+	"github.com/banbox/banexg/log"
+	"go.uber.org/zap"
+)
+
+const spiderRecMagic = "banrec1\n"
+
+/*
+SpiderRecord
+A raw message pushed by the spider, with the local receive time
+爬虫推送的一条原始消息，带本地接收时间
+*/
+type SpiderRecord struct {
+	TimeMS int64
+	Key    string // msgType_exg_market[_pair]
+	Data   []byte
+}
+
+/*
+SpiderRecorder
+Append spider messages to a gzip compressed binary log. Each record is encoded as:
+varint(timeMS delta), uvarint(key len), key, uvarint(data len), data
+将爬虫消息追加到gzip压缩的二进制日志。每条记录编码为：
+varint(时间戳增量), uvarint(key长度), key, uvarint(data长度), data
+*/
+type SpiderRecorder struct {
+	file    *os.File
+	gz      *gzip.Writer
+	buf     []byte
+	lastMS  int64
+	flushMS int64
+	lock    sync.Mutex
+}
+
+/*
+GetSpiderRecPath
+Get the record file path for the current session: @spider_rec/{exg}_{market}_{time}.bin
+获取当前会话的记录文件路径：@spider_rec/{exg}_{market}_{time}.bin
+*/
+func GetSpiderRecPath() string {
+	name := fmt.Sprintf("%s_%s_%s.bin", core.ExgName, core.Market, time.Now().Format("20060102_150405"))
+	return filepath.Join(config.GetDataDir(), "spider_rec", name)
+}
+
+func NewSpiderRecorder(path string) (*SpiderRecorder, *errs.Error) {
+	err_ := utils.EnsureDir(filepath.Dir(path), 0755)
+	if err_ != nil {
+		return nil, errs.New(core.ErrIOWriteFail, err_)
+	}
+	file, err_ := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err_ != nil {
+		return nil, errs.New(core.ErrIOWriteFail, err_)
+	}
+	gz := gzip.NewWriter(file)
+	if _, err_ = gz.Write([]byte(spiderRecMagic)); err_ != nil {
+		_ = file.Close()
+		return nil, errs.New(core.ErrIOWriteFail, err_)
+	}
+	return &SpiderRecorder{file: file, gz: gz}, nil
+}
+
+/*
+Write
+Append a message. The compressed stream is flushed to disk at most once per second.
+追加一条消息。压缩流每秒最多刷新到磁盘一次。
+*/
+func (r *SpiderRecorder) Write(timeMS int64, key string, data []byte) *errs.Error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.gz == nil {
+		return errs.NewMsg(core.ErrIOWriteFail, "spider recorder closed")
+	}
+	buf := binary.AppendVarint(r.buf[:0], timeMS-r.lastMS)
+	buf = binary.AppendUvarint(buf, uint64(len(key)))
+	buf = append(buf, key...)
+	buf = binary.AppendUvarint(buf, uint64(len(data)))
+	r.buf = buf
+	if _, err_ := r.gz.Write(buf); err_ != nil {
+		return errs.New(core.ErrIOWriteFail, err_)
+	}
+	if _, err_ := r.gz.Write(data); err_ != nil {
+		return errs.New(core.ErrIOWriteFail, err_)
+	}
+	r.lastMS = timeMS
+	if timeMS-r.flushMS >= 1000 {
+		r.flushMS = timeMS
+		if err_ := r.gz.Flush(); err_ != nil {
+			return errs.New(core.ErrIOWriteFail, err_)
+		}
+	}
+	return nil
+}
+
+/*
+Wrap
+Return a handler which records the message before passing it to handle
+返回一个处理函数，先记录消息再传给handle
+*/
+func (r *SpiderRecorder) Wrap(handle utils.ConnCB) utils.ConnCB {
+	return func(key string, data []byte) {
+		err := r.Write(btime.UTCStamp(), key, data)
+		if err != nil {
+			log.Warn("record spider msg fail", zap.String("key", key), zap.Error(err))
+		}
+		handle(key, data)
+	}
+}
+
+func (r *SpiderRecorder) Close() *errs.Error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.gz == nil {
+		return nil
+	}
+	err_ := r.gz.Close()
+	r.gz = nil
+	err2 := r.file.Close()
+	if err_ == nil {
+		err_ = err2
+	}
+	if err_ != nil {
+		return errs.New(core.ErrIOWriteFail, err_)
+	}
+	return nil
+}
+
+/*
+SpiderReader
+Read records written by SpiderRecorder in order
+按顺序读取SpiderRecorder写入的记录
+*/
+type SpiderReader struct {
+	file   *os.File
+	gz     *gzip.Reader
+	rd     *bufio.Reader
+	lastMS int64
+}
+
+func NewSpiderReader(path string) (*SpiderReader, *errs.Error) {
+	file, err_ := os.Open(path)
+	if err_ != nil {
+		return nil, errs.New(core.ErrIOReadFail, err_)
+	}
+	gz, err_ := gzip.NewReader(file)
+	if err_ != nil {
+		_ = file.Close()
+		return nil, errs.New(core.ErrDeCompressFail, err_)
+	}
+	rd := bufio.NewReader(gz)
+	magic := make([]byte, len(spiderRecMagic))
+	if _, err_ = io.ReadFull(rd, magic); err_ != nil || string(magic) != spiderRecMagic {
+		_ = file.Close()
+		return nil, errs.NewMsg(core.ErrIOReadFail, "invalid spider record file: %s", path)
+	}
+	return &SpiderReader{file: file, gz: gz, rd: rd}, nil
+}
+
+/*
+Next
+Read the next record, return nil at the end of file. A file truncated by an unexpected exit is read up to the last complete record.
+读取下一条记录，文件结束时返回nil。意外退出导致截断的文件，读取到最后一条完整记录。
+*/
+func (r *SpiderReader) Next() (*SpiderRecord, *errs.Error) {
+	delta, err_ := binary.ReadVarint(r.rd)
+	if err_ == nil {
+		var rec = &SpiderRecord{TimeMS: r.lastMS + delta}
+		var key []byte
+		if key, err_ = r.readBytes(); err_ == nil {
+			rec.Key = string(key)
+			if rec.Data, err_ = r.readBytes(); err_ == nil {
+				r.lastMS = rec.TimeMS
+				return rec, nil
+			}
+		}
+	}
+	if errors.Is(err_, io.EOF) {
+		return nil, nil
+	}
+	if errors.Is(err_, io.ErrUnexpectedEOF) {
+		log.Warn("spider record file truncated", zap.String("path", r.file.Name()))
+		return nil, nil
+	}
+	return nil, errs.New(core.ErrIOReadFail, err_)
+}
+
+// readBytes read a length prefixed field, always inside a record 读取长度前缀的字段，总是位于记录中间
+func (r *SpiderReader) readBytes() ([]byte, error) {
+	size, err := binary.ReadUvarint(r.rd)
+	if err == nil {
+		res := make([]byte, size)
+		if _, err = io.ReadFull(r.rd, res); err == nil {
+			return res, nil
+		}
+	}
+	if errors.Is(err, io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	return nil, err
+}
+
+func (r *SpiderReader) Close() {
+	_ = r.gz.Close()
+	_ = r.file.Close()
+}
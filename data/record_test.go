@@ -0,0 +1,73 @@
+package data
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/banbox/banbot/btime"
+	"github.com/banbox/banbot/core"
+)
+
+func TestSpiderRecordReplay(t *testing.T) {
+	oldExg, oldMarket := core.ExgName, core.Market
+	core.ExgName, core.Market = "binance", "linear"
+	defer func() {
+		core.ExgName, core.Market = oldExg, oldMarket
+	}()
+	path := filepath.Join(t.TempDir(), "rec.bin")
+	rec, err := NewSpiderRecorder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	startMS := int64(1700000000000)
+	msgs := []*SpiderRecord{
+		{TimeMS: startMS, Key: "price_binance_linear", Data: []byte(`{"RPL/USDT:USDT":1.5}`)},
+		{TimeMS: startMS + 500, Key: "uohlcv_binance_linear_RPL/USDT:USDT", Data: []byte(`{}`)},
+		{TimeMS: startMS + 2000, Key: "price_binance_linear", Data: []byte(`{"RPL/USDT:USDT":1.8}`)},
+	}
+	for _, m := range msgs {
+		if err = rec.Write(m.TimeMS, m.Key, m.Data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// an unclosed file is readable up to the last flushed record
+	reader, err := NewSpiderReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var num int
+	for {
+		item, err := reader.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if item == nil {
+			break
+		}
+		num += 1
+	}
+	reader.Close()
+	if num != len(msgs) {
+		t.Fatalf("expect %d flushed records, got %d", len(msgs), num)
+	}
+	if err = rec.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	dp, err := NewReplayProvider(path, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if btime.CurTimeMS != startMS {
+		t.Fatalf("btime should start at first record, got %d", btime.CurTimeMS)
+	}
+	if err = dp.LoopMain(); err != nil {
+		t.Fatal(err)
+	}
+	if btime.CurTimeMS != startMS+2000 {
+		t.Fatalf("btime should end at last record, got %d", btime.CurTimeMS)
+	}
+	if price := core.GetPriceSafe("RPL/USDT:USDT"); price != 1.8 {
+		t.Fatalf("expect replayed price 1.8, got %v", price)
+	}
+}
@@ -12,6 +12,8 @@ import (
 	"github.com/banbox/banexg/log"
 	utils2 "github.com/banbox/banexg/utils"
 	"go.uber.org/zap"
+	"math/rand"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -279,7 +281,106 @@ type Miner struct {
 
 type LiveSpider struct {
 	*utils.ServerIO
-	miners map[string]*Miner
+	miners  map[string]*Miner
+	cluster *SpiderCluster // nil when only one spider address 只有一个爬虫地址时为nil
+	epoch   string         // Changes when the spider restarts, sequences are only valid in the same epoch 爬虫重启时变化，序号仅在同一epoch内有效
+	seqBufs map[string]*seqBuffer
+	lockSeq sync.Mutex
+}
+
+const (
+	spiderReplayNum = 300 // Max messages kept for replay in each topic 每个主题保留用于回放的最大消息数
+)
+
+/*
+seqBuffer
+Sequence and recent messages of a topic, used for replay when clients reconnect
+一个主题的序号和最近的消息，用于客户端重连时回放
+*/
+type seqBuffer struct {
+	seq  int64
+	msgs []*utils.IOMsg
+}
+
+/*
+Broadcast
+Set the sequence of the topic and keep it in the replay buffer, then queue it to subscribers in the same lock,
+so messages are queued in sequence order and never interleave with replays.
+设置主题序号并保存到回放缓冲区，然后在同一锁内加入订阅者的发送队列，使消息按序号顺序排队且不与回放交错。
+*/
+func (s *LiveSpider) Broadcast(msg *utils.IOMsg) *errs.Error {
+	s.lockSeq.Lock()
+	defer s.lockSeq.Unlock()
+	buf, ok := s.seqBufs[msg.Action]
+	if !ok {
+		buf = &seqBuffer{}
+		s.seqBufs[msg.Action] = buf
+	}
+	buf.seq += 1
+	msg.Seq = buf.seq
+	if len(buf.msgs) >= spiderReplayNum {
+		copy(buf.msgs, buf.msgs[1:])
+		buf.msgs[len(buf.msgs)-1] = msg
+	} else {
+		buf.msgs = append(buf.msgs, msg)
+	}
+	return s.ServerIO.Broadcast(msg)
+}
+
+/*
+getMissed
+Return buffered messages after the sequence of the topic, and whether some messages are already dropped from the buffer
+返回主题中该序号之后的缓存消息，以及是否有消息已从缓冲区中丢弃
+lockSeq should be held by the caller 调用方需持有lockSeq
+*/
+func (s *LiveSpider) getMissed(topic string, lastSeq int64) ([]*utils.IOMsg, bool) {
+	buf, ok := s.seqBufs[topic]
+	if !ok || len(buf.msgs) == 0 {
+		return nil, false
+	}
+	idx := sort.Search(len(buf.msgs), func(i int) bool {
+		return buf.msgs[i].Seq > lastSeq
+	})
+	res := make([]*utils.IOMsg, len(buf.msgs)-idx)
+	copy(res, buf.msgs[idx:])
+	return res, buf.msgs[0].Seq > lastSeq+1
+}
+
+/*
+resume
+Replay missed messages to a reconnected client, only when it's the same spider epoch.
+Otherwise the client falls back to filling lacks by exchange api.
+向重连的客户端回放错过的消息，仅在同一爬虫epoch时。否则客户端回退到通过交易所接口补全。
+*/
+func (s *LiveSpider) resume(c *utils.BanConn, args *utils.IOResume) {
+	if args.Epoch != s.epoch {
+		log.Info("skip resume from other spider epoch", zap.String("remote", c.Remote),
+			zap.String("epoch", args.Epoch))
+		return
+	}
+	total := 0
+	// Subscribe and take the snapshot in one section with Broadcast, so later msgs are queued after replays
+	// 与Broadcast在同一临界区内订阅并获取快照，使后续消息排在回放之后
+	s.lockSeq.Lock()
+	defer s.lockSeq.Unlock()
+	c.Subscribe(utils.KeysOfMap(args.Seqs)...)
+	for topic, lastSeq := range args.Seqs {
+		msgs, lost := s.getMissed(topic, lastSeq)
+		if lost {
+			log.Warn("replay buffer overflow, some msgs lost", zap.String("topic", topic),
+				zap.Int64("last", lastSeq))
+		}
+		for _, msg := range msgs {
+			err := c.WriteMsgAsync(msg)
+			if err != nil {
+				log.Warn("replay msg fail", zap.String("remote", c.Remote), zap.Error(err))
+				return
+			}
+		}
+		total += len(msgs)
+	}
+	log.Info("resume client", zap.String("remote", c.Remote), zap.Int("topics", len(args.Seqs)),
+		zap.Int("replayed", total))
 }
 
 func newMiner(spider *LiveSpider, exgName, market string) (*Miner, *errs.Error) {
@@ -349,20 +450,27 @@ func (m *Miner) SubPairs(jobType string, pairs ...string) *errs.Error {
 
 func (m *Miner) UnSubPairs(jobType string, pairs ...string) *errs.Error {
 	if jobType == "ws" || jobType == "book" {
+		for _, p := range pairs {
+			delete(m.BookPairs, p)
+		}
 		return m.exchange.UnWatchOrderBooks(pairs, nil)
 	} else if jobType == "ohlcv" || jobType == "uohlcv" {
-		jobs := make([][2]string, len(m.KlinePairs))
+		jobs := make([][2]string, 0, len(pairs))
 		timeFrame := "1s"
 		if banexg.IsContract(m.Market) {
 			timeFrame = "1m"
 		}
-		for p := range m.KlinePairs {
+		for _, p := range pairs {
+			delete(m.KlinePairs, p)
 			jobs = append(jobs, [2]string{p, timeFrame})
 		}
 		return m.exchange.UnWatchOHLCVs(jobs, nil)
 	} else if jobType == "price" {
 		return m.exchange.UnWatchMarkPrices(nil, nil)
 	} else if jobType == "trade" {
+		for _, p := range pairs {
+			delete(m.TradePairs, p)
+		}
 		return m.exchange.UnWatchTrades(pairs, nil)
 	} else {
 		log.Error("unknown unsub type", zap.String("val", jobType))
@@ -370,6 +478,27 @@ func (m *Miner) UnSubPairs(jobType string, pairs ...string) *errs.Error {
 	return nil
 }
 
+/*
+watchedPairs
+Return pairs watched by the miner for the job type
+返回miner中该任务类型正在监听的品种
+*/
+func (m *Miner) watchedPairs(jobType string, pairs []string) []string {
+	watched := m.KlinePairs
+	if jobType == "trade" {
+		watched = m.TradePairs
+	} else if jobType == "ws" || jobType == "book" {
+		watched = m.BookPairs
+	}
+	res := make([]string, 0, len(pairs))
+	for _, p := range pairs {
+		if watched[p] {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
 func (m *Miner) watchTrades(pairs []string) {
 	if len(pairs) == 0 {
 		return
@@ -676,25 +805,42 @@ func (m *Miner) watchKLines(pairs []string) {
 	}()
 }
 
-func RunSpider(addr string) *errs.Error {
+/*
+RunSpider
+Listen on the first available address. With multiple addresses, spiders form a cluster and shard subscriptions.
+监听第一个可用的地址。有多个地址时，爬虫组成集群并分片订阅。
+*/
+func RunSpider(addrs ...string) *errs.Error {
+	ln, addr, err := listenFirst(addrs)
+	if err != nil {
+		return err
+	}
 	server := utils.NewBanServer(addr, "spider")
 	Spider = &LiveSpider{
 		ServerIO: server,
 		miners:   map[string]*Miner{},
+		epoch:    fmt.Sprintf("%d_%d", btime.UTCStamp(), rand.Int31()),
+		seqBufs:  map[string]*seqBuffer{},
 	}
 	server.InitConn = makeInitConn(Spider)
+	if len(addrs) > 1 {
+		Spider.cluster = newSpiderCluster(Spider, addr, addrs)
+		Spider.cluster.start()
+	}
 	go consumeWriteQ(5)
 	sess, conn, err := orm.Conn(nil)
 	if err != nil {
+		_ = ln.Close()
 		return err
 	}
 	err = sess.PurgeKlineUn()
 	if err != nil {
 		conn.Release()
+		_ = ln.Close()
 		return err
 	}
 	conn.Release()
-	return Spider.RunForever()
+	return Spider.Serve(ln)
 }
 
 func (s *LiveSpider) getMiner(exgName, market string) *Miner {
@@ -717,33 +863,93 @@ func (s *LiveSpider) getMiner(exgName, market string) *Miner {
 	return miner
 }
 
+/*
+watchPairs
+Watch pairs in local miner. In cluster mode, pairs owned by other spiders are relayed from them.
+在本地miner中监听品种。集群模式下，其他爬虫拥有的品种从其转发。
+*/
+func (s *LiveSpider) watchPairs(exgName, market, jobType string, pairs []string) *errs.Error {
+	miner := s.getMiner(exgName, market)
+	if s.cluster == nil || len(pairs) == 0 || jobType == "price" {
+		return miner.SubPairs(jobType, pairs...)
+	}
+	owned, remotes := s.cluster.assign(exgName, market, pairs)
+	for owner, items := range remotes {
+		err := s.cluster.relay(owner, &relayJob{ExgName: exgName, Market: market, JobType: jobType, Pairs: items})
+		if err != nil {
+			log.Warn("relay from spider fail, watch locally", zap.String("owner", owner), zap.Error(err))
+			owned = append(owned, items...)
+		}
+	}
+	if len(owned) == 0 {
+		return nil
+	}
+	return miner.SubPairs(jobType, owned...)
+}
+
+/*
+unwatchPairs
+Stop watching pairs. In cluster mode, pairs relayed from other spiders are unwatched from their owners.
+停止监听品种。集群模式下，从其他爬虫转发的品种向其所属爬虫取消监听。
+*/
+func (s *LiveSpider) unwatchPairs(exgName, market, jobType string, pairs []string) *errs.Error {
+	if s.cluster != nil && jobType != "price" {
+		pairs = s.cluster.unrelay(exgName, market, jobType, pairs)
+		if len(pairs) == 0 {
+			return nil
+		}
+	}
+	miner := s.getMiner(exgName, market)
+	return miner.UnSubPairs(jobType, pairs...)
+}
+
 func makeInitConn(s *LiveSpider) func(*utils.BanConn) {
 	return func(c *utils.BanConn) {
-		handlePairs := func(data []byte, name string) (*Miner, []string) {
+		handlePairs := func(data []byte, name string) []string {
 			var arr = make([]string, 0, 8)
 			err := utils2.Unmarshal(data, &arr, utils2.JsonNumDefault)
 			if err != nil {
 				log.Warn("receive invalid pairs", zap.String("n", name),
 					zap.String("in", string(data)), zap.Error(err))
-				return nil, nil
+				return nil
 			}
 			if len(arr) < 4 {
 				log.Error(name+" receive invalid", zap.Strings("msg", arr))
-				return nil, nil
+				return nil
 			}
-			miner := s.getMiner(arr[0], arr[1])
-			return miner, arr[2:]
+			return arr
+		}
+		c.Listens["resume"] = func(_ string, data []byte) {
+			var args utils.IOResume
+			err := utils2.Unmarshal(data, &args, utils2.JsonNumDefault)
+			if err != nil {
+				log.Warn("receive invalid resume", zap.String("in", string(data)), zap.Error(err))
+				return
+			}
+			s.resume(c, &args)
+		}
+		// tell the client current epoch, so it knows whether sequences are still valid
+		// 告知客户端当前epoch，以便其判断序号是否仍有效
+		err := c.WriteMsg(&utils.IOMsg{Action: "spider_epoch", Data: s.epoch})
+		if err != nil {
+			log.Warn("send spider epoch fail", zap.String("remote", c.Remote), zap.Error(err))
 		}
 		c.Listens["watch_pairs"] = func(_ string, data []byte) {
-			miner, arr := handlePairs(data, "watch_pairs")
-			err := miner.SubPairs(arr[0], arr[1:]...)
+			arr := handlePairs(data, "watch_pairs")
+			if arr == nil {
+				return
+			}
+			err := s.watchPairs(arr[0], arr[1], arr[2], arr[3:])
 			if err != nil {
 				log.Error("spider.sub_pairs fail", zap.Error(err))
 			}
 		}
 		c.Listens["unwatch_pairs"] = func(_ string, data []byte) {
-			miner, arr := handlePairs(data, "unwatch_pairs")
-			err := miner.UnSubPairs(arr[0], arr[1:]...)
+			arr := handlePairs(data, "unwatch_pairs")
+			if arr == nil {
+				return
+			}
+			err := s.unwatchPairs(arr[0], arr[1], arr[2], arr[3:])
 			if err != nil {
 				log.Error("spider.unsub_pairs fail", zap.Error(err))
 			}
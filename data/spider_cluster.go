@@ -0,0 +1,474 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/banbox/banbot/core"
+	"github.com/banbox/banbot/utils"
+	"github.com/banbox/banexg/errs"
+	"github.com/banbox/banexg/log"
+	"go.uber.org/zap"
+)
+
+const (
+	ownerExpireSecs = 60 // The owner of a pair expires if not refreshed 品种的归属未刷新时过期
+	ownerAliveSecs  = 20 // Interval for refreshing owned pairs 刷新归属品种的间隔
+)
+
+/*
+SpiderCluster
+Multiple spiders (config spider_addr as a list) shard Miner subscriptions by exchange/market/pair.
+The first address is the coordinator, whose ServerIO key/value stores the owner of each pair, guarded by GetNetLock.
+When a bot subscribes pairs owned by other spiders, they are relayed from the owner, so bots can connect to any spider.
+多个爬虫(配置spider_addr为列表)按交易所/市场/品种分片Miner订阅。
+第一个地址是协调者，其ServerIO的键值存储每个品种的归属，通过GetNetLock保护。
+机器人订阅其他爬虫拥有的品种时，从所属爬虫转发，所以机器人可连接任意爬虫。
+*/
+type SpiderCluster struct {
+	spider *LiveSpider
+	Addr   string                     // listen address of this spider 此爬虫的监听地址
+	Peers  []string                   // all spider addresses, the first is the coordinator 全部爬虫地址，第一个是协调者
+	leader *utils.ClientIO            // connection to the coordinator 到协调者的连接
+	relays map[string]*spiderRelay    // owner address: relay 所属地址: 转发
+	owns   map[string]map[string]bool // exg_market: pairs owned by this spider 此爬虫拥有的品种
+	lock   sync.Mutex
+}
+
+type spiderRelay struct {
+	client *utils.ClientIO
+	jobs   []*relayJob
+}
+
+type relayJob struct {
+	ExgName string
+	Market  string
+	JobType string
+	Pairs   []string
+}
+
+/*
+listenFirst
+Listen on the first address which can be bound on this machine, so several spiders can share the same spider_addr list
+监听第一个可在本机绑定的地址，以便多个爬虫共用相同的spider_addr列表
+*/
+func listenFirst(addrs []string) (net.Listener, string, *errs.Error) {
+	var err_ error
+	for _, addr := range addrs {
+		var ln net.Listener
+		ln, err_ = net.Listen("tcp", addr)
+		if err_ == nil {
+			return ln, addr, nil
+		}
+		log.Info("spider addr unavailable, try next", zap.String("addr", addr), zap.Error(err_))
+	}
+	return nil, "", errs.New(core.ErrNetConnect, err_)
+}
+
+func newSpiderCluster(spider *LiveSpider, addr string, peers []string) *SpiderCluster {
+	return &SpiderCluster{
+		spider: spider,
+		Addr:   addr,
+		Peers:  peers,
+		relays: make(map[string]*spiderRelay),
+		owns:   make(map[string]map[string]bool),
+	}
+}
+
+func (c *SpiderCluster) isCoordinator() bool {
+	return c.Addr == c.Peers[0]
+}
+
+func (c *SpiderCluster) start() {
+	if !c.isCoordinator() {
+		go c.loopConnLeader()
+	}
+	go func() {
+		for core.Sleep(time.Second * ownerAliveSecs) {
+			c.keepAlive()
+		}
+	}()
+}
+
+func (c *SpiderCluster) loopConnLeader() {
+	addr := c.Peers[0]
+	for {
+		client, err := utils.NewClientIO(addr)
+		if err == nil {
+			c.lock.Lock()
+			c.leader = client
+			c.lock.Unlock()
+			log.Info("connected to spider coordinator", zap.String("addr", addr))
+			go client.LoopPing(10)
+			err = client.RunForever()
+			log.Warn("spider coordinator disconnected", zap.String("addr", addr), zap.Error(err))
+			return
+		}
+		log.Warn("connect spider coordinator fail, retry after 10s", zap.String("addr", addr), zap.Error(err))
+		if !core.Sleep(time.Second * 10) {
+			return
+		}
+	}
+}
+
+func (c *SpiderCluster) getLeader() (*utils.ClientIO, *errs.Error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.leader == nil {
+		return nil, errs.NewMsg(core.ErrNetConnect, "spider coordinator %s not connected", c.Peers[0])
+	}
+	return c.leader, nil
+}
+
+func (c *SpiderCluster) getVal(key string) (string, *errs.Error) {
+	if c.isCoordinator() {
+		return c.spider.GetVal(key), nil
+	}
+	leader, err := c.getLeader()
+	if err != nil {
+		return "", err
+	}
+	return leader.GetVal(key, 5)
+}
+
+func (c *SpiderCluster) setVal(args *utils.KeyValExpire) *errs.Error {
+	if c.isCoordinator() {
+		c.spider.SetVal(args)
+		return nil
+	}
+	leader, err := c.getLeader()
+	if err != nil {
+		return err
+	}
+	return leader.SetVal(args)
+}
+
+func (c *SpiderCluster) netLock(key string) (int32, *errs.Error) {
+	if c.isCoordinator() {
+		return utils.GetNetLock(key, 5)
+	}
+	leader, err := c.getLeader()
+	if err != nil {
+		return 0, err
+	}
+	return leader.GetNetLock(key, 5)
+}
+
+func (c *SpiderCluster) delLock(key string, lockVal int32) {
+	var err *errs.Error
+	if c.isCoordinator() {
+		err = utils.DelNetLock(key, lockVal)
+	} else {
+		var leader *utils.ClientIO
+		leader, err = c.getLeader()
+		if err == nil {
+			err = leader.DelNetLock(key, lockVal)
+		}
+	}
+	if err != nil {
+		log.Warn("release spider lock fail", zap.String("key", key), zap.Error(err))
+	}
+}
+
+func ownerKey(exgName, market, pair string) string {
+	return fmt.Sprintf("spider_owner_%s_%s_%s", exgName, market, pair)
+}
+
+/*
+assign
+Find the owner of each pair, unowned pairs are taken by this spider.
+When the coordinator is unavailable, all pairs are watched locally.
+查找每个品种的所属爬虫，无归属的品种由此爬虫接管。协调者不可用时，所有品种在本地监听。
+*/
+func (c *SpiderCluster) assign(exgName, market string, pairs []string) ([]string, map[string][]string) {
+	remotes := make(map[string][]string)
+	lockKey := fmt.Sprintf("spider_%s_%s", exgName, market)
+	lockVal, err := c.netLock(lockKey)
+	if err != nil {
+		log.Warn("spider coordinate fail, watch locally", zap.String("key", lockKey), zap.Error(err))
+		c.addOwns(exgName, market, pairs)
+		return pairs, remotes
+	}
+	defer c.delLock(lockKey, lockVal)
+	owned := make([]string, 0, len(pairs))
+	for _, pair := range pairs {
+		key := ownerKey(exgName, market, pair)
+		owner, err := c.getVal(key)
+		if err == nil && owner != "" && owner != c.Addr {
+			remotes[owner] = append(remotes[owner], pair)
+			continue
+		}
+		if err == nil {
+			err = c.setVal(&utils.KeyValExpire{Key: key, Val: c.Addr, ExpireSecs: ownerExpireSecs})
+		}
+		if err != nil {
+			log.Warn("set pair owner fail", zap.String("key", key), zap.Error(err))
+		}
+		owned = append(owned, pair)
+	}
+	c.addOwns(exgName, market, owned)
+	return owned, remotes
+}
+
+func (c *SpiderCluster) addOwns(exgName, market string, pairs []string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	key := exgName + "_" + market
+	owns, ok := c.owns[key]
+	if !ok {
+		owns = make(map[string]bool)
+		c.owns[key] = owns
+	}
+	for _, p := range pairs {
+		owns[p] = true
+	}
+}
+
+/*
+keepAlive
+Refresh the owner of pairs watched by this spider. Pairs taken by others after expiring are dropped,
+and relayed from the new owner.
+刷新此爬虫监听品种的归属。过期后被其他爬虫接管的品种将被移除，并从新的所属爬虫转发。
+*/
+func (c *SpiderCluster) keepAlive() {
+	c.lock.Lock()
+	items := make(map[string][]string)
+	for key, owns := range c.owns {
+		items[key] = utils.KeysOfMap(owns)
+	}
+	c.lock.Unlock()
+	// exg_market: owner: pairs taken by other spiders 被其他爬虫接管的品种
+	drops := make(map[string]map[string][]string)
+	defer func() {
+		for exgMarket, owners := range drops {
+			exgName, market, _ := strings.Cut(exgMarket, "_")
+			for owner, pairs := range owners {
+				c.handOver(exgName, market, owner, pairs)
+			}
+		}
+	}()
+	for exgMarket, pairs := range items {
+		for _, pair := range pairs {
+			key := "spider_owner_" + exgMarket + "_" + pair
+			owner, err := c.getVal(key)
+			if err != nil {
+				log.Warn("refresh spider owner fail", zap.String("key", key), zap.Error(err))
+				return
+			}
+			if owner != "" && owner != c.Addr {
+				log.Warn("pair owned by other spider", zap.String("key", key), zap.String("owner", owner))
+				c.lock.Lock()
+				delete(c.owns[exgMarket], pair)
+				c.lock.Unlock()
+				owners, ok := drops[exgMarket]
+				if !ok {
+					owners = make(map[string][]string)
+					drops[exgMarket] = owners
+				}
+				owners[owner] = append(owners[owner], pair)
+				continue
+			}
+			err = c.setVal(&utils.KeyValExpire{Key: key, Val: c.Addr, ExpireSecs: ownerExpireSecs})
+			if err != nil {
+				log.Warn("refresh spider owner fail", zap.String("key", key), zap.Error(err))
+				return
+			}
+		}
+	}
+}
+
+/*
+handOver
+Relay pairs from the new owner and stop watching them in the local miner. They are kept local if relay fails.
+从新的所属爬虫转发品种，并在本地miner中停止监听。转发失败时保持本地监听。
+*/
+func (c *SpiderCluster) handOver(exgName, market, owner string, pairs []string) {
+	miner, ok := c.spider.miners[exgName+":"+market]
+	if !ok {
+		return
+	}
+	for _, jobType := range []string{"ohlcv", "trade", "book"} {
+		items := miner.watchedPairs(jobType, pairs)
+		if len(items) == 0 {
+			continue
+		}
+		err := c.relay(owner, &relayJob{ExgName: exgName, Market: market, JobType: jobType, Pairs: items})
+		if err != nil {
+			log.Warn("relay from new owner fail, keep watching", zap.String("owner", owner), zap.Error(err))
+			continue
+		}
+		err = miner.UnSubPairs(jobType, items...)
+		if err != nil {
+			log.Warn("unwatch pairs taken by other spider fail", zap.Strings("pairs", items), zap.Error(err))
+		}
+	}
+}
+
+/*
+relayTags
+Tags to subscribe from the owner spider for the job
+从所属爬虫订阅任务所需的tags
+*/
+func relayTags(job *relayJob) []string {
+	var prefixs []string
+	switch job.JobType {
+	case "ohlcv", "uohlcv":
+		prefixs = []string{"ohlcv", "uohlcv"}
+	case "ws":
+		prefixs = []string{"trade", "book"}
+	default:
+		prefixs = []string{job.JobType}
+	}
+	tags := make([]string, 0, len(prefixs)*len(job.Pairs))
+	for _, prefix := range prefixs {
+		for _, pair := range job.Pairs {
+			tags = append(tags, fmt.Sprintf("%s_%s_%s_%s", prefix, job.ExgName, job.Market, pair))
+		}
+	}
+	return tags
+}
+
+/*
+relay
+Watch pairs from the owner spider and broadcast the received data to local subscribers
+从所属爬虫监听品种，并将收到的数据广播给本地订阅者
+*/
+func (c *SpiderCluster) relay(owner string, job *relayJob) *errs.Error {
+	c.lock.Lock()
+	r, ok := c.relays[owner]
+	if !ok {
+		client, err := utils.NewClientIO(owner)
+		if err != nil {
+			c.lock.Unlock()
+			return err
+		}
+		// report the failure instead of reconnecting, so the pairs can be taken over
+		// 报告失败而不是重连，以便接管品种
+		client.DoConnect = nil
+		for _, prefix := range []string{"uohlcv", "ohlcv", "trade", "book"} {
+			client.Listens[prefix] = c.forward
+		}
+		client.Listens["spider_epoch"] = func(string, []byte) {}
+		r = &spiderRelay{client: client}
+		c.relays[owner] = r
+		go client.LoopPing(10)
+		go c.runRelay(owner, r)
+	}
+	r.jobs = append(r.jobs, job)
+	c.lock.Unlock()
+	err := r.client.WriteMsg(&utils.IOMsg{Action: "subscribe", Data: relayTags(job)})
+	if err != nil {
+		return err
+	}
+	args := append([]string{job.ExgName, job.Market, job.JobType}, job.Pairs...)
+	return r.client.WriteMsg(&utils.IOMsg{Action: "watch_pairs", Data: args})
+}
+
+/*
+unrelay
+Remove pairs from relay jobs and unwatch them from the owner spiders, return pairs which are not relayed
+从转发任务中移除品种并向所属爬虫取消监听，返回未被转发的品种
+*/
+func (c *SpiderCluster) unrelay(exgName, market, jobType string, pairs []string) []string {
+	removes := make(map[string]bool, len(pairs))
+	for _, p := range pairs {
+		removes[p] = true
+	}
+	relayed := make(map[string]bool)
+	stops := make(map[*spiderRelay]*relayJob)
+	c.lock.Lock()
+	for _, r := range c.relays {
+		jobs := make([]*relayJob, 0, len(r.jobs))
+		for _, job := range r.jobs {
+			if job.ExgName != exgName || job.Market != market || job.JobType != jobType {
+				jobs = append(jobs, job)
+				continue
+			}
+			left := make([]string, 0, len(job.Pairs))
+			for _, p := range job.Pairs {
+				if !removes[p] {
+					left = append(left, p)
+					continue
+				}
+				stop, ok := stops[r]
+				if !ok {
+					stop = &relayJob{ExgName: exgName, Market: market, JobType: jobType}
+					stops[r] = stop
+				}
+				stop.Pairs = append(stop.Pairs, p)
+				relayed[p] = true
+			}
+			if len(left) > 0 {
+				jobs = append(jobs, &relayJob{ExgName: exgName, Market: market, JobType: jobType, Pairs: left})
+			}
+		}
+		r.jobs = jobs
+	}
+	c.lock.Unlock()
+	for r, job := range stops {
+		err := r.client.WriteMsg(&utils.IOMsg{Action: "unsubscribe", Data: relayTags(job)})
+		if err == nil {
+			args := append([]string{job.ExgName, job.Market, job.JobType}, job.Pairs...)
+			err = r.client.WriteMsg(&utils.IOMsg{Action: "unwatch_pairs", Data: args})
+		}
+		if err != nil {
+			log.Warn("unwatch relayed pairs fail", zap.Strings("pairs", job.Pairs), zap.Error(err))
+		}
+	}
+	res := make([]string, 0, len(pairs))
+	for _, p := range pairs {
+		if !relayed[p] {
+			res = append(res, p)
+		}
+	}
+	return res
+}
+
+func (c *SpiderCluster) forward(key string, data []byte) {
+	err := c.spider.Broadcast(&utils.IOMsg{Action: key, Data: json.RawMessage(data)})
+	if err != nil {
+		log.Warn("forward spider msg fail", zap.String("key", key), zap.Error(err))
+	}
+}
+
+/*
+runRelay
+Read from the owner spider until it fails, then take over its pairs
+从所属爬虫读取直到失败，然后接管其品种
+*/
+func (c *SpiderCluster) runRelay(owner string, r *spiderRelay) {
+	err := r.client.RunForever()
+	log.Warn("spider relay stopped, take over pairs", zap.String("owner", owner), zap.Error(err))
+	c.lock.Lock()
+	delete(c.relays, owner)
+	jobs := r.jobs
+	c.lock.Unlock()
+	for _, job := range jobs {
+		c.release(owner, job)
+		err = c.spider.watchPairs(job.ExgName, job.Market, job.JobType, job.Pairs)
+		if err != nil {
+			log.Error("take over spider pairs fail", zap.String("owner", owner), zap.Error(err))
+		}
+	}
+}
+
+// release clear the owner of pairs if it's still the failed spider 如果归属仍是失败的爬虫，清除品种的归属
+func (c *SpiderCluster) release(owner string, job *relayJob) {
+	lockKey := fmt.Sprintf("spider_%s_%s", job.ExgName, job.Market)
+	lockVal, err := c.netLock(lockKey)
+	if err != nil {
+		log.Warn("release spider owner fail", zap.String("owner", owner), zap.Error(err))
+		return
+	}
+	defer c.delLock(lockKey, lockVal)
+	for _, pair := range job.Pairs {
+		key := ownerKey(job.ExgName, job.Market, pair)
+		if val, err := c.getVal(key); err == nil && val == owner {
+			_ = c.setVal(&utils.KeyValExpire{Key: key})
+		}
+	}
+}
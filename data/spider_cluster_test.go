@@ -0,0 +1,94 @@
+package data
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/banbox/banbot/core"
+	"github.com/banbox/banbot/utils"
+)
+
+func TestSpiderClusterAssign(t *testing.T) {
+	addr := "127.0.0.1:6791"
+	spider := &LiveSpider{ServerIO: utils.NewBanServer(addr, "spider"), miners: map[string]*Miner{}}
+	cluster := newSpiderCluster(spider, addr, []string{addr, "127.0.0.1:6792"})
+	owned, remotes := cluster.assign("binance", "linear", []string{"BTC/USDT:USDT", "ETH/USDT:USDT"})
+	if len(owned) != 2 || len(remotes) != 0 {
+		t.Fatalf("unowned pairs should be taken, got %v %v", owned, remotes)
+	}
+	other := "127.0.0.1:6792"
+	spider.SetVal(&utils.KeyValExpire{Key: ownerKey("binance", "linear", "SOL/USDT:USDT"), Val: other})
+	owned, remotes = cluster.assign("binance", "linear", []string{"ETH/USDT:USDT", "SOL/USDT:USDT"})
+	if !slices.Equal(owned, []string{"ETH/USDT:USDT"}) || !slices.Equal(remotes[other], []string{"SOL/USDT:USDT"}) {
+		t.Fatalf("SOL should be relayed from %s, got %v %v", other, owned, remotes)
+	}
+	if spider.GetVal("lock_spider_binance_linear") != "" {
+		t.Fatal("lock should be released after assign")
+	}
+	// the owner failed, its pairs are released to be taken over
+	cluster.release(other, &relayJob{ExgName: "binance", Market: "linear", JobType: "ohlcv",
+		Pairs: []string{"SOL/USDT:USDT"}})
+	owned, _ = cluster.assign("binance", "linear", []string{"SOL/USDT:USDT"})
+	if len(owned) != 1 || spider.GetVal(ownerKey("binance", "linear", "SOL/USDT:USDT")) != addr {
+		t.Fatalf("SOL should be taken over, got %v", owned)
+	}
+	if tags := relayTags(&relayJob{ExgName: "binance", Market: "linear", JobType: "ohlcv",
+		Pairs: []string{"SOL/USDT:USDT"}}); len(tags) != 2 {
+		t.Fatalf("expect ohlcv and uohlcv tags, got %v", tags)
+	}
+}
+
+func TestSpiderClusterUnrelay(t *testing.T) {
+	core.SetRunMode(core.RunModeLive)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	core.Ctx = ctx
+	ln, err_ := net.Listen("tcp", "127.0.0.1:0")
+	if err_ != nil {
+		t.Fatal(err_)
+	}
+	owner := ln.Addr().String()
+	ownerSrv := utils.NewBanServer(owner, "spider")
+	unwatches := make(chan []string, 4)
+	ownerSrv.InitConn = func(c *utils.BanConn) {
+		c.Listens["watch_pairs"] = func(string, []byte) {}
+		c.Listens["unwatch_pairs"] = func(_ string, data []byte) {
+			var arr []string
+			_ = json.Unmarshal(data, &arr)
+			unwatches <- arr
+		}
+	}
+	go ownerSrv.Serve(ln)
+	addr := "127.0.0.1:6793"
+	spider := &LiveSpider{ServerIO: utils.NewBanServer(addr, "spider"), miners: map[string]*Miner{}}
+	cluster := newSpiderCluster(spider, addr, []string{addr, owner})
+	err := cluster.relay(owner, &relayJob{ExgName: "binance", Market: "linear", JobType: "ohlcv",
+		Pairs: []string{"SOL/USDT:USDT", "BNB/USDT:USDT"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	locals := cluster.unrelay("binance", "linear", "ohlcv", []string{"SOL/USDT:USDT", "XRP/USDT:USDT"})
+	if !slices.Equal(locals, []string{"XRP/USDT:USDT"}) {
+		t.Fatalf("only XRP should be unwatched locally, got %v", locals)
+	}
+	select {
+	case arr := <-unwatches:
+		if !slices.Equal(arr, []string{"binance", "linear", "ohlcv", "SOL/USDT:USDT"}) {
+			t.Fatalf("SOL should be unwatched from owner, got %v", arr)
+		}
+	case <-time.After(time.Second * 3):
+		t.Fatal("owner spider not receive unwatch_pairs")
+	}
+	cluster.lock.Lock()
+	jobs := cluster.relays[owner].jobs
+	cluster.lock.Unlock()
+	if len(jobs) != 1 || !slices.Equal(jobs[0].Pairs, []string{"BNB/USDT:USDT"}) {
+		t.Fatalf("BNB should still be relayed, got %v", jobs)
+	}
+	// no jobs left to take over after the owner stops
+	cluster.unrelay("binance", "linear", "ohlcv", []string{"BNB/USDT:USDT"})
+}
@@ -0,0 +1,118 @@
+package data
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/banbox/banbot/core"
+	"github.com/banbox/banbot/utils"
+)
+
+func TestSpiderResume(t *testing.T) {
+	core.SetRunMode(core.RunModeLive)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	core.Ctx = ctx
+	ln, err_ := net.Listen("tcp", "127.0.0.1:0")
+	if err_ != nil {
+		t.Fatal(err_)
+	}
+	server := utils.NewBanServer(ln.Addr().String(), "spider")
+	spider := &LiveSpider{ServerIO: server, miners: map[string]*Miner{}, epoch: "e1",
+		seqBufs: map[string]*seqBuffer{}}
+	// capture connections and handled actions on the server side, instead of reading spider.Conns
+	// 在服务端捕获连接和已处理的消息，而不是读取spider.Conns
+	conns := make(chan net.Conn, 4)
+	handled := make(chan string, 8)
+	initConn := makeInitConn(spider)
+	server.InitConn = func(c *utils.BanConn) {
+		initConn(c)
+		conns <- c.Conn
+		for _, action := range []string{"subscribe", "resume"} {
+			name, handle := action, c.Listens[action]
+			c.Listens[action] = func(key string, data []byte) {
+				handle(key, data)
+				handled <- name
+			}
+		}
+	}
+	go spider.Serve(ln)
+
+	watcher, err := NewKlineWatcher(server.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	topic := "price_binance_linear"
+	var got []string
+	var lock sync.Mutex
+	watcher.Listens["price"] = func(_ string, data []byte) {
+		lock.Lock()
+		got = append(got, string(data))
+		lock.Unlock()
+	}
+	if err = watcher.SendMsg("subscribe", []string{topic}); err != nil {
+		t.Fatal(err)
+	}
+	go watcher.RunForever()
+	waitFor := func(cond func() bool) bool {
+		for i := 0; i < 100; i++ {
+			if cond() {
+				return true
+			}
+			time.Sleep(time.Millisecond * 50)
+		}
+		return false
+	}
+	waitHandled := func(action string) {
+		select {
+		case name := <-handled:
+			if name != action {
+				t.Fatalf("expect %s, got %s", action, name)
+			}
+		case <-time.After(time.Second * 10):
+			t.Fatalf("wait %s timeout", action)
+		}
+	}
+	gotNum := func(n int) func() bool {
+		return func() bool {
+			lock.Lock()
+			defer lock.Unlock()
+			return len(got) >= n
+		}
+	}
+	waitHandled("subscribe")
+	for _, v := range []string{"1", "2"} {
+		_ = spider.Broadcast(&utils.IOMsg{Action: topic, Data: v})
+	}
+	if !waitFor(gotNum(2)) {
+		t.Fatalf("expect 2 msgs, got %v", got)
+	}
+	// disconnect, messages broadcast meanwhile are replayed after reconnecting
+	_ = (<-conns).Close()
+	for _, v := range []string{"3", "4"} {
+		_ = spider.Broadcast(&utils.IOMsg{Action: topic, Data: v})
+	}
+	waitHandled("resume")
+	waitHandled("subscribe")
+	if !waitFor(gotNum(4)) {
+		lock.Lock()
+		defer lock.Unlock()
+		t.Fatalf("missed msgs should be replayed, got %v", got)
+	}
+	_ = spider.Broadcast(&utils.IOMsg{Action: topic, Data: "5"})
+	waitFor(gotNum(5))
+	lock.Lock()
+	defer lock.Unlock()
+	want := []string{`"1"`, `"2"`, `"3"`, `"4"`, `"5"`}
+	if len(got) != len(want) {
+		t.Fatalf("expect %v, got %v", want, got)
+	}
+	for i, v := range want {
+		if got[i] != v {
+			t.Fatalf("expect %v, got %v", want, got)
+		}
+	}
+}
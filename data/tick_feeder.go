@@ -0,0 +1,427 @@
+package data
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/banbox/banbot/btime"
+	"github.com/banbox/banbot/config"
+	"github.com/banbox/banbot/core"
+	"github.com/banbox/banbot/orm"
+	"github.com/banbox/banbot/utils"
+	"github.com/banbox/banexg"
+	"github.com/banbox/banexg/errs"
+	"github.com/banbox/banexg/log"
+	utils2 "github.com/banbox/banexg/utils"
+	"go.uber.org/zap"
+)
+
+/*
+Tick-level backtest
+
+When `tick_source` is set, the backtest is driven by the trade files written by `tick convert` (a zip per trading
+day, one csv per contract inside) instead of the klines in database:
+  - trades of each symbol are rebuilt from the ticks by differencing the cumulative daily volume, like build1mWithTicks
+  - bars of the minimum timeframe are built from the trades on the fly, bigger ones are aggregated as usual
+  - before firing a bar, its trades are sent to OnTrades in time order, pending orders are filled at the trade prices
+
+Trades are in time order for every symbol; across symbols they are ordered by bar.
+
+tick级回测
+
+设置`tick_source`后，回测由`tick convert`输出的逐笔成交文件（每个交易日一个zip，内部每个合约一个csv）驱动，而非数据库中的K线：
+  - 各品种的逐笔成交由tick的累计日成交量差分得到，和build1mWithTicks一致
+  - 最小周期的K线由逐笔成交实时构建，更大周期照常聚合
+  - 触发每个bar前，其逐笔成交按时间顺序发给OnTrades，挂单以成交价格撮合
+
+单个品种内逐笔成交严格按时间顺序；不同品种之间按bar排序。
+*/
+
+type FnPairTrades = func(pair string, trades []*banexg.Trade)
+
+type tickFile struct {
+	path   string
+	dateMS int64 // trading day from the file name, 0 if unknown 文件名中的交易日，未知时为0
+}
+
+var (
+	tickFileLock sync.Mutex
+	tickFileMap  = make(map[string][]*tickFile) // cached day files of tick dirs 缓存的tick目录日文件列表
+	tickDayLock  sync.Mutex
+	tickDayPath  string               // the last loaded day file 最后加载的日文件
+	tickDayTicks map[string][]*tkInfo // ticks of the last loaded day, the key is from tickSymKey 最后加载日的tick，键来自tickSymKey
+)
+
+/*
+TickKlineFeeder
+Read trades from the tick files for backtesting, build bars of the minimum timeframe from them
+从tick文件读取逐笔成交用于回测，并由其构建最小周期的K线
+*/
+type TickKlineFeeder struct {
+	HistKLineFeeder
+	OnTrades  FnPairTrades
+	symKey    string
+	files     []*tickFile
+	fileIdx   int                       // index of the next day file to read 下一个要读取的日文件索引
+	offsetMS  int64                     // trades before this are skipped 此时间之前的成交被跳过
+	pending   []*banexg.Trade           // trades of the last unfinished bar 最后一个未完成bar的成交
+	barTrades map[int64][]*banexg.Trade // trades of cached bars, the key is bar time 缓存bar的成交，键是bar时间
+}
+
+func NewTickKlineFeeder(exs *orm.ExSymbol, callBack FnPairKline, showLog bool) (*TickKlineFeeder, *errs.Error) {
+	files, err := listTickFiles(config.TickSource)
+	if err != nil {
+		return nil, err
+	}
+	feeder, err := NewKlineFeeder(exs, callBack, showLog)
+	if err != nil {
+		return nil, err
+	}
+	res := &TickKlineFeeder{
+		HistKLineFeeder: HistKLineFeeder{
+			KlineFeeder: *feeder,
+			TimeRange:   config.TimeRange.Clone(),
+		},
+		symKey: tickSymKey(exs.Symbol),
+		files:  files,
+	}
+	res.setNext = res.loadNext
+	return res, nil
+}
+
+func (f *TickKlineFeeder) SetSeek(since int64) {
+	if since == 0 {
+		since = core.MSMinStamp
+	}
+	// night session of the previous evening is in the file of next trading day
+	// 前一晚的夜盘在下一交易日的文件中
+	dayMSecs := int64(utils2.TFToSecs("1d") * 1000)
+	f.fileIdx = sort.Search(len(f.files), func(i int) bool {
+		dateMS := f.files[i].dateMS
+		return dateMS == 0 || dateMS+dayMSecs > since
+	})
+	f.rowIdx = 0
+	f.nextMS = 0
+	f.offsetMS = since
+	f.caches = nil
+	f.pending = nil
+	f.barTrades = nil
+	f.setNext()
+}
+
+/*
+DownIfNeed
+Nothing to download, trades are read from tick files
+无需下载，逐笔成交从tick文件读取
+*/
+func (f *TickKlineFeeder) DownIfNeed(sess *orm.Queries, exchange banexg.BanExchange, pBar *utils.PrgBar) *errs.Error {
+	return nil
+}
+
+/*
+RunBar
+Send the trades of the bar to OnTrades in time order, then fire the bar
+按时间顺序将bar的成交发给OnTrades，然后触发bar
+*/
+func (f *TickKlineFeeder) RunBar(bar *banexg.Kline) *errs.Error {
+	trades := f.barTrades[bar.Time]
+	delete(f.barTrades, bar.Time)
+	if f.OnTrades != nil {
+		for start := 0; start < len(trades); {
+			end := start + 1
+			for end < len(trades) && trades[end].Timestamp == trades[start].Timestamp {
+				end += 1
+			}
+			btime.CurTimeMS = trades[start].Timestamp
+			f.OnTrades(f.Symbol, trades[start:end])
+			start = end
+		}
+	}
+	return f.HistKLineFeeder.RunBar(bar)
+}
+
+func (f *TickKlineFeeder) loadNext() {
+	if f.rowIdx+1 < len(f.caches) {
+		f.rowIdx += 1
+		f.nextMS = f.caches[f.rowIdx].Time + f.minGapMs
+		return
+	}
+	tfMSecs := int64(f.States[0].TFSecs * 1000)
+	bars, err := f.loadBars(tfMSecs)
+	if err != nil || len(bars) == 0 {
+		f.rowIdx = -1
+		f.nextMS = math.MaxInt64
+		if err != nil {
+			log.Error("load tick trades fail", zap.String("pair", f.Symbol), zap.Error(err))
+		}
+		return
+	}
+	f.caches = bars
+	f.rowIdx = 0
+	f.nextMS = bars[0].Time + tfMSecs
+	f.minGapMs = tfMSecs
+}
+
+/*
+loadBars
+Read the next day files until some bars are finished. Trades of the last bar are kept until the next file is read,
+since it may be continued there.
+读取下一个日文件，直到有bar完成。最后一个bar的成交保留到读取下个文件，因其可能在下个文件中继续。
+*/
+func (f *TickKlineFeeder) loadBars(tfMSecs int64) ([]*banexg.Kline, *errs.Error) {
+	endMS := f.TimeRange.EndMS
+	offMS := f.States[0].AlignOffMS
+	for {
+		isEnd := f.fileIdx >= len(f.files)
+		if !isEnd {
+			file := f.files[f.fileIdx]
+			f.fileIdx += 1
+			if endMS > 0 && file.dateMS > endMS {
+				f.fileIdx = len(f.files)
+				isEnd = true
+			} else {
+				ticks, err := loadTickDay(file.path, f.symKey)
+				if err != nil {
+					return nil, err
+				}
+				for _, t := range ticksToTrades(f.Symbol, ticks) {
+					if t.Timestamp >= f.offsetMS && (endMS <= 0 || t.Timestamp < endMS) {
+						f.pending = append(f.pending, t)
+					}
+				}
+			}
+		}
+		if len(f.pending) == 0 {
+			if isEnd {
+				return nil, nil
+			}
+			continue
+		}
+		cut := len(f.pending)
+		if !isEnd {
+			lastMS := alignTradeMS(f.pending[cut-1].Timestamp, tfMSecs, offMS)
+			cut = sort.Search(len(f.pending), func(i int) bool {
+				return f.pending[i].Timestamp >= lastMS
+			})
+			if cut == 0 {
+				continue
+			}
+		}
+		bars, barTrades := buildTradeBars(f.pending[:cut], tfMSecs, offMS)
+		f.pending = append([]*banexg.Trade{}, f.pending[cut:]...)
+		f.barTrades = barTrades
+		f.offsetMS = bars[len(bars)-1].Time + tfMSecs
+		return bars, nil
+	}
+}
+
+func alignTradeMS(timeMS, tfMSecs, offMS int64) int64 {
+	return utils2.AlignTfMSecs(timeMS-offMS, tfMSecs) + offMS
+}
+
+/*
+buildTradeBars
+Build bars from the trades in time order, return bars and their trades
+由按时间排序的逐笔成交构建K线，返回K线及各自的成交
+*/
+func buildTradeBars(trades []*banexg.Trade, tfMSecs, offMS int64) ([]*banexg.Kline, map[int64][]*banexg.Trade) {
+	bars := make([]*banexg.Kline, 0, 64)
+	barTrades := make(map[int64][]*banexg.Trade)
+	var bar *banexg.Kline
+	start := 0
+	for i, t := range trades {
+		barMS := alignTradeMS(t.Timestamp, tfMSecs, offMS)
+		if bar == nil || barMS != bar.Time {
+			if bar != nil {
+				barTrades[bar.Time] = trades[start:i]
+			}
+			bar = &banexg.Kline{Time: barMS, Open: t.Price, High: t.Price, Low: t.Price}
+			bars = append(bars, bar)
+			start = i
+		}
+		bar.High = max(bar.High, t.Price)
+		bar.Low = min(bar.Low, t.Price)
+		bar.Close = t.Price
+		bar.Volume += t.Amount
+		if openInt, ok := t.Info.(float64); ok {
+			bar.Info = openInt
+		}
+	}
+	if bar != nil {
+		barTrades[bar.Time] = trades[start:]
+	}
+	return bars, barTrades
+}
+
+/*
+ticksToTrades
+Convert the ticks of one symbol to trades by differencing the cumulative volume. Ticks without volume change are
+quotes and skipped. The first tick of a session is the baseline. Info of trade is the open interest.
+通过累计成交量差分将单个品种的tick转为逐笔成交。成交量不变的tick是报价，跳过。每个交易时段的首个tick作为基准。成交的Info是持仓量。
+*/
+func ticksToTrades(symbol string, ticks []*tkInfo) []*banexg.Trade {
+	if len(ticks) == 0 {
+		return nil
+	}
+	minGapMSecs := int64(300000) // 间隔超过5分钟，且成交量下降，是切换盘口
+	res := make([]*banexg.Trade, 0, len(ticks)/2)
+	sumVol := ticks[0].volume
+	lastMS := ticks[0].timeMS
+	for _, t := range ticks[1:] {
+		if t.volume < sumVol {
+			if t.timeMS-lastMS >= minGapMSecs {
+				// 日盘/夜盘切换，累计成交量归0
+				sumVol = t.volume
+				lastMS = t.timeMS
+			}
+			// otherwise the tick is invalid, skip it
+			// 否则是无效tick，跳过
+			continue
+		}
+		lastMS = t.timeMS
+		amount := t.volume - sumVol
+		if amount == 0 || t.price <= 0 {
+			continue
+		}
+		sumVol = t.volume
+		res = append(res, &banexg.Trade{
+			Symbol:    symbol,
+			Type:      banexg.OdTypeMarket,
+			Amount:    amount,
+			Price:     t.price,
+			Cost:      amount * t.price,
+			Timestamp: t.timeMS,
+			Info:      t.openInt,
+		})
+	}
+	return res
+}
+
+/*
+tickSymKey
+Normalize the symbol to match the contract ID in tick files
+标准化品种名称，用于匹配tick文件中的合约ID
+*/
+func tickSymKey(symbol string) string {
+	return strings.ToLower(strings.NewReplacer("/", "", ":", "", "_", "", "-", "", ".", "").Replace(symbol))
+}
+
+/*
+listTickFiles
+List the day zip files under the tick dir, sorted by trading day
+列出tick目录下的日zip文件，按交易日排序
+*/
+func listTickFiles(dir string) ([]*tickFile, *errs.Error) {
+	tickFileLock.Lock()
+	defer tickFileLock.Unlock()
+	if files, ok := tickFileMap[dir]; ok {
+		return files, nil
+	}
+	names, err := FindPathNames(dir, ".zip")
+	if err != nil {
+		return nil, err
+	}
+	if len(names) <= 1 {
+		return nil, errs.NewMsg(core.ErrBadConfig, "no tick files in %s", dir)
+	}
+	loc, err_ := time.LoadLocation("Asia/Shanghai")
+	if err_ != nil {
+		return nil, errs.New(errs.CodeRunTime, err_)
+	}
+	files := make([]*tickFile, 0, len(names)-1)
+	for _, name := range names[1:] {
+		file := &tickFile{path: filepath.Join(names[0], name)}
+		cleanName := strings.Split(filepath.Base(name), ".")[0]
+		if len(cleanName) >= 8 {
+			dateObj, err_ := time.ParseInLocation("20060102", cleanName[len(cleanName)-8:], loc)
+			if err_ == nil {
+				file.dateMS = dateObj.UnixMilli()
+			}
+		}
+		files = append(files, file)
+	}
+	sort.SliceStable(files, func(i, j int) bool {
+		return files[i].dateMS < files[j].dateMS
+	})
+	tickFileMap[dir] = files
+	return files, nil
+}
+
+/*
+loadTickDay
+Return the ticks of the symbol in a day file. The last file is cached for all symbols, since feeders read the same day
+together. When a contract is in several csv, the one with the highest volume is used.
+返回日文件中指定品种的tick。最后一个文件为所有品种缓存，因各feeder同时读取同一天。合约出现在多个csv中时，使用成交量最高的。
+*/
+func loadTickDay(path, symKey string) ([]*tkInfo, *errs.Error) {
+	tickDayLock.Lock()
+	defer tickDayLock.Unlock()
+	if tickDayPath == path {
+		return tickDayTicks[symKey], nil
+	}
+	loc, err_ := time.LoadLocation("Asia/Shanghai")
+	if err_ != nil {
+		return nil, errs.New(errs.CodeRunTime, err_)
+	}
+	tickBar := makeTickBar(loc)
+	r, err_ := zip.OpenReader(path)
+	if err_ != nil {
+		return nil, errs.New(errs.CodeIOReadFail, err_)
+	}
+	defer r.Close()
+	groups := make(map[string][][]*tkInfo)
+	for _, file := range r.File {
+		if file.FileInfo().IsDir() || !strings.HasSuffix(file.Name, ".csv") || !isRawContract(file.Name) {
+			continue
+		}
+		fReader, err_ := file.Open()
+		if err_ != nil {
+			return nil, errs.New(errs.CodeIOReadFail, err_)
+		}
+		rows, err_ := csv.NewReader(fReader).ReadAll()
+		_ = fReader.Close()
+		if err_ != nil {
+			return nil, errs.New(errs.CodeIOReadFail, err_)
+		}
+		fileTicks := make(map[string][]*tkInfo)
+		for _, row := range rows {
+			symbol, timeMS, arr := tickBar(path, row)
+			if timeMS == 0 {
+				continue
+			}
+			key := tickSymKey(symbol)
+			fileTicks[key] = append(fileTicks[key], &tkInfo{symbol: symbol, timeMS: timeMS, price: arr[0],
+				volume: arr[1], avgPrice: arr[2], turnOver: arr[3], openInt: arr[4]})
+		}
+		for key, items := range fileTicks {
+			groups[key] = append(groups[key], items)
+		}
+	}
+	dayTicks := make(map[string][]*tkInfo, len(groups))
+	for key, grps := range groups {
+		var best []*tkInfo
+		bestVol := -1.0
+		for _, items := range grps {
+			sort.SliceStable(items, func(i, j int) bool {
+				return items[i].timeMS < items[j].timeMS
+			})
+			vol := 0.0
+			for _, t := range items {
+				vol = max(vol, t.volume)
+			}
+			if vol > bestVol {
+				best, bestVol = items, vol
+			}
+		}
+		dayTicks[key] = best
+	}
+	tickDayPath = path
+	tickDayTicks = dayTicks
+	return dayTicks[symKey], nil
+}
@@ -0,0 +1,100 @@
+package data
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func writeTickZip(t *testing.T, path string, files map[string][][]string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	out, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer out.Close()
+	zw := zip.NewWriter(out)
+	for name, rows := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = csv.NewWriter(w).WriteAll(rows); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func tickRow(symbol string, timeMS int64, price, volume, openInt float64) []string {
+	fmtFlt := func(v float64) string {
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	return []string{symbol, strconv.FormatInt(timeMS, 10), fmtFlt(price), fmtFlt(volume), fmtFlt(price - 1),
+		"3", fmtFlt(price + 1), "2", fmtFlt(price), "0", fmtFlt(openInt)}
+}
+
+func TestTickTrades(t *testing.T) {
+	dir := t.TempDir()
+	// 2024-01-02 01:30 UTC, 09:30 in Shanghai
+	startMS := int64(1704159000000)
+	rows := [][]string{
+		tickRow("rb2405", startMS, 3900, 100, 5000),
+		tickRow("rb2405", startMS+500, 3901, 103, 5001),
+		// quote without volume change is not a trade
+		tickRow("rb2405", startMS+1000, 3905, 103, 5001),
+		tickRow("rb2405", startMS+30000, 3898, 110, 5002),
+		tickRow("rb2405", startMS+61000, 3903, 112, 5004),
+		tickRow("rb2405", startMS+62000, 3899, 120, 5003),
+	}
+	writeTickZip(t, filepath.Join(dir, "2024", "20240102.zip"), map[string][][]string{
+		"rb2405.csv": rows,
+		// a smaller copy in another file should be ignored
+		"rb2405_2.csv": rows[:2],
+		"hc2405.csv":   {tickRow("hc2405", startMS, 3600, 10, 100)},
+	})
+	writeTickZip(t, filepath.Join(dir, "2024", "20231229.zip"), map[string][][]string{
+		"rb2405.csv": {tickRow("rb2405", startMS-86400000*4, 3880, 10, 4000)},
+	})
+	files, err := listTickFiles(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || filepath.Base(files[0].path) != "20231229.zip" || files[1].dateMS == 0 {
+		t.Fatalf("bad tick files: %v", files)
+	}
+	ticks, err := loadTickDay(files[1].path, tickSymKey("RB2405"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ticks) != len(rows) {
+		t.Fatalf("expect %v ticks, got %v", len(rows), len(ticks))
+	}
+	trades := ticksToTrades("RB2405", ticks)
+	if len(trades) != 4 {
+		t.Fatalf("expect 4 trades, got %v", len(trades))
+	}
+	if trades[0].Amount != 3 || trades[0].Price != 3901 || trades[0].Timestamp != startMS+500 {
+		t.Fatalf("bad first trade: %+v", trades[0])
+	}
+	bars, barTrades := buildTradeBars(trades, 60000, 0)
+	if len(bars) != 2 {
+		t.Fatalf("expect 2 bars, got %v", len(bars))
+	}
+	b := bars[0]
+	if b.Time != startMS || b.Open != 3901 || b.High != 3901 || b.Low != 3898 || b.Close != 3898 ||
+		b.Volume != 10 || b.Info != 5002 {
+		t.Fatalf("bad first bar: %+v", b)
+	}
+	b = bars[1]
+	if b.Time != startMS+60000 || b.Open != 3903 || b.Low != 3899 || b.Volume != 10 || len(barTrades[b.Time]) != 2 {
+		t.Fatalf("bad second bar: %+v", b)
+	}
+}
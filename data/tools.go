@@ -291,64 +291,11 @@ func build1mWithTicks(args *config.CmdArgs) *errs.Error {
 	// Output: A ZIP archive in the root directory every year, and each zip stores 1M data of the contract in CSV with the contract name
 	// 输入：根目录下只有年份的文件夹，每个年份文件夹下每个交易日一个zip压缩包，每个zip内以合约名称存储当日tick数据
 	// 输出：根目录下每年一个zip压缩包，每个zip内以合约名称csv存储当年该合约的1m数据
-	layout := "20060102 15:04:05"
 	loc, err_ := time.LoadLocation("Asia/Shanghai")
 	if err_ != nil {
 		return errs.New(errs.CodeRunTime, err_)
 	}
-	dayMSecs := int64(utils2.TFToSecs("1d") * 1000)
-	nightMSecs := int64(3600 * 10 * 1000)  // utc时间，10小时后，即北京18:00后
-	nightZeroMs := int64(3600 * 16 * 1000) // utc时间，16小时前，即北京24:00前
-	tickBar := func(inPath string, row []string) (string, int64, [5]float64) {
-		var symbol string
-		var timeMS int64
-		var arr [5]float64
-		if len(row) >= 13 && len(row[0]) == 8 && len(row[2]) == 8 {
-			// TradingDay,InstrumentID,UpdateTime,UpdateMillisec,LastPrice,Volume,BidPrice1,BidVolume1,
-			// AskPrice1,AskVolume1,AveragePrice,Turnover,OpenInterest,[UpperLimitPrice,LowerLimitPrice]
-			bidPrice1, _ := strconv.ParseFloat(row[6], 64)
-			askPrice1, _ := strconv.ParseFloat(row[8], 64)
-			avgPrice, _ := strconv.ParseFloat(row[10], 64)
-			if bidPrice1 == 0 && askPrice1 == 0 && avgPrice == 0 {
-				return "", 0, [5]float64{0, 0, 0, 0, 0}
-			}
-			symbol = row[1]
-			dateStr := row[0] + " " + row[2]
-			timeObj, err_ := time.ParseInLocation(layout, dateStr, loc)
-			if err_ != nil {
-				log.Error("invalid time", zap.String("date", dateStr), zap.String("name", inPath))
-				return "", 0, [5]float64{0, 0, 0, 0, 0}
-			}
-			milliSecs, _ := strconv.ParseInt(row[3], 10, 64)
-			timeMS = timeObj.UnixMilli() + milliSecs
-			price, _ := strconv.ParseFloat(row[4], 64)
-			volume, _ := strconv.ParseFloat(row[5], 64)
-			turnOver, _ := strconv.ParseFloat(row[11], 64)
-			openInt, _ := strconv.ParseFloat(row[12], 64)
-			arr = [5]float64{price, volume, avgPrice, turnOver, openInt}
-		} else {
-			// InstrumentID,Time,LastPrice,Volume,BidPrice1,BidVolume1,
-			// AskPrice1,AskVolume1,AveragePrice,Turnover,OpenInterest,[UpperLimitPrice,LowerLimitPrice]
-			symbol = row[0]
-			timeMS, _ = strconv.ParseInt(row[1], 10, 64)
-			if timeMS == 0 {
-				return "", 0, [5]float64{0, 0, 0, 0, 0}
-			}
-			price, _ := strconv.ParseFloat(row[2], 64)
-			volume, _ := strconv.ParseFloat(row[3], 64)
-			avgPrice, _ := strconv.ParseFloat(row[8], 64)
-			turnOver, _ := strconv.ParseFloat(row[9], 64)
-			openInt, _ := strconv.ParseFloat(row[10], 64)
-			arr = [5]float64{price, volume, avgPrice, turnOver, openInt}
-		}
-		off := timeMS % dayMSecs
-		if off > nightMSecs && off < nightZeroMs {
-			// Night trading time, and before 24 o'clock, is the day before; It doesn't have to be a day, it could be Monday, it needs to be minus two days, 1 day for simplicity
-			// 夜盘时间，且24点前，是日前的；不一定是一天，可能是周一，需要减两天，简单起见1天
-			timeMS -= dayMSecs
-		}
-		return symbol, timeMS, arr
-	}
+	tickBar := makeTickBar(loc)
 	// 按年对文件名分组
 	nameGrps := make([][]string, 0)
 	var tmpNames []string
@@ -460,6 +407,68 @@ func build1mWithTicks(args *config.CmdArgs) *errs.Error {
 	return nil
 }
 
+/*
+makeTickBar
+Return the FuncTickBar parsing a tick row in raw or `tick convert` format, the time of night session is moved to its real date
+返回解析原始格式或`tick convert`格式tick行的FuncTickBar，夜盘时间会修正到实际日期
+*/
+func makeTickBar(loc *time.Location) FuncTickBar {
+	layout := "20060102 15:04:05"
+	dayMSecs := int64(utils2.TFToSecs("1d") * 1000)
+	nightMSecs := int64(3600 * 10 * 1000)  // utc时间，10小时后，即北京18:00后
+	nightZeroMs := int64(3600 * 16 * 1000) // utc时间，16小时前，即北京24:00前
+	return func(inPath string, row []string) (string, int64, [5]float64) {
+		var symbol string
+		var timeMS int64
+		var arr [5]float64
+		if len(row) >= 13 && len(row[0]) == 8 && len(row[2]) == 8 {
+			// TradingDay,InstrumentID,UpdateTime,UpdateMillisec,LastPrice,Volume,BidPrice1,BidVolume1,
+			// AskPrice1,AskVolume1,AveragePrice,Turnover,OpenInterest,[UpperLimitPrice,LowerLimitPrice]
+			bidPrice1, _ := strconv.ParseFloat(row[6], 64)
+			askPrice1, _ := strconv.ParseFloat(row[8], 64)
+			avgPrice, _ := strconv.ParseFloat(row[10], 64)
+			if bidPrice1 == 0 && askPrice1 == 0 && avgPrice == 0 {
+				return "", 0, [5]float64{0, 0, 0, 0, 0}
+			}
+			symbol = row[1]
+			dateStr := row[0] + " " + row[2]
+			timeObj, err_ := time.ParseInLocation(layout, dateStr, loc)
+			if err_ != nil {
+				log.Error("invalid time", zap.String("date", dateStr), zap.String("name", inPath))
+				return "", 0, [5]float64{0, 0, 0, 0, 0}
+			}
+			milliSecs, _ := strconv.ParseInt(row[3], 10, 64)
+			timeMS = timeObj.UnixMilli() + milliSecs
+			price, _ := strconv.ParseFloat(row[4], 64)
+			volume, _ := strconv.ParseFloat(row[5], 64)
+			turnOver, _ := strconv.ParseFloat(row[11], 64)
+			openInt, _ := strconv.ParseFloat(row[12], 64)
+			arr = [5]float64{price, volume, avgPrice, turnOver, openInt}
+		} else {
+			// InstrumentID,Time,LastPrice,Volume,BidPrice1,BidVolume1,
+			// AskPrice1,AskVolume1,AveragePrice,Turnover,OpenInterest,[UpperLimitPrice,LowerLimitPrice]
+			symbol = row[0]
+			timeMS, _ = strconv.ParseInt(row[1], 10, 64)
+			if timeMS == 0 {
+				return "", 0, [5]float64{0, 0, 0, 0, 0}
+			}
+			price, _ := strconv.ParseFloat(row[2], 64)
+			volume, _ := strconv.ParseFloat(row[3], 64)
+			avgPrice, _ := strconv.ParseFloat(row[8], 64)
+			turnOver, _ := strconv.ParseFloat(row[9], 64)
+			openInt, _ := strconv.ParseFloat(row[10], 64)
+			arr = [5]float64{price, volume, avgPrice, turnOver, openInt}
+		}
+		off := timeMS % dayMSecs
+		if off > nightMSecs && off < nightZeroMs {
+			// Night trading time, and before 24 o'clock, is the day before; It doesn't have to be a day, it could be Monday, it needs to be minus two days, 1 day for simplicity
+			// 夜盘时间，且24点前，是日前的；不一定是一天，可能是周一，需要减两天，简单起见1天
+			timeMS -= dayMSecs
+		}
+		return symbol, timeMS, arr
+	}
+}
+
 func saveYear1m(outDir, year string) {
 	if len(symKLines) == 0 || year == "" {
 		return
@@ -19,6 +19,8 @@ type KLineWatcher struct {
 	initMsgs   []*utils.IOMsg
 	OnKLineMsg func(msg *KLineMsg) // 收到爬虫K线消息
 	OnTrade    func(exgName, market string, trade *banexg.Trade)
+	recorder   *SpiderRecorder // record spider messages for replay 记录爬虫消息用于回放
+	epoch      string          // epoch of the connected spider 已连接爬虫的epoch
 }
 
 type WatchJob struct {
@@ -39,8 +41,13 @@ func (j *PairTFCache) getFinishes(ohlcvs []*banexg.Kline, lastFinish bool) []*ba
 	return ohlcvs
 }
 
-func NewKlineWatcher(addr string) (*KLineWatcher, *errs.Error) {
-	client, err := utils.NewClientIO(addr)
+/*
+NewKlineWatcher
+Connect to the spider, the next address will be used when the current one is unhealthy
+连接到爬虫，当前地址不健康时将使用下一个地址
+*/
+func NewKlineWatcher(addrs ...string) (*KLineWatcher, *errs.Error) {
+	client, err := utils.NewClientIO(addrs...)
 	if err != nil {
 		return nil, err
 	}
@@ -48,15 +55,37 @@ func NewKlineWatcher(addr string) (*KLineWatcher, *errs.Error) {
 		ClientIO: client,
 		jobs:     make(map[string]*PairTFCache),
 	}
-	res.Listens["uohlcv"] = res.onSpiderBar
-	res.Listens["ohlcv"] = res.onSpiderBar
-	res.Listens["price"] = res.onPriceUpdate
-	res.Listens["trade"] = res.onTrades
-	res.Listens["book"] = res.onBook
+	for prefix, handle := range res.dataHandlers() {
+		res.Listens[prefix] = handle
+	}
+	res.Listens["spider_epoch"] = func(_ string, data []byte) {
+		var epoch string
+		err_ := utils2.Unmarshal(data, &epoch, utils2.JsonNumDefault)
+		if err_ != nil {
+			log.Warn("receive invalid spider epoch", zap.String("raw", string(data)))
+			return
+		}
+		if res.epoch != "" && res.epoch != epoch {
+			// spider restarted or failed over, old sequences are invalid
+			// 爬虫已重启或故障转移，旧序号无效
+			log.Info("spider epoch changed", zap.String("old", res.epoch), zap.String("new", epoch))
+			res.ResetSeqs()
+		}
+		res.epoch = epoch
+	}
 	res.ReInitConn = func() {
 		if len(res.initMsgs) == 0 {
 			return
 		}
+		// Resume before subscribing, the spider subscribes resumed topics along with the replay, so missed
+		// messages arrive before new ones
+		// 在订阅前恢复，爬虫在回放的同时订阅恢复的主题，以便错过的消息先于新消息到达
+		if seqs := res.LastSeqs(); res.epoch != "" && len(seqs) > 0 {
+			err := res.WriteMsg(&utils.IOMsg{Action: "resume", Data: &utils.IOResume{Epoch: res.epoch, Seqs: seqs}})
+			if err != nil {
+				log.Warn("send resume fail", zap.Error(err))
+			}
+		}
 		for _, msg := range res.initMsgs {
 			err := res.WriteMsg(msg)
 			if err != nil {
@@ -70,6 +99,48 @@ func NewKlineWatcher(addr string) (*KLineWatcher, *errs.Error) {
 	return res, nil
 }
 
+/*
+dataHandlers
+Handlers for market data pushed by the spider, keyed by message type
+爬虫推送的行情数据处理函数，键为消息类型
+*/
+func (w *KLineWatcher) dataHandlers() map[string]utils.ConnCB {
+	return map[string]utils.ConnCB{
+		"uohlcv": w.onSpiderBar,
+		"ohlcv":  w.onSpiderBar,
+		"price":  w.onPriceUpdate,
+		"trade":  w.onTrades,
+		"book":   w.onBook,
+	}
+}
+
+/*
+StartRecord
+Record all market data messages from the spider to the given file, which can be replayed by ReplayProvider
+记录爬虫推送的所有行情消息到指定文件，可通过ReplayProvider回放
+*/
+func (w *KLineWatcher) StartRecord(path string) *errs.Error {
+	if w.recorder != nil {
+		return nil
+	}
+	rec, err := NewSpiderRecorder(path)
+	if err != nil {
+		return err
+	}
+	w.recorder = rec
+	for prefix, handle := range w.dataHandlers() {
+		w.Listens[prefix] = rec.Wrap(handle)
+	}
+	core.ExitCalls = append(core.ExitCalls, func() {
+		err := rec.Close()
+		if err != nil {
+			log.Error("close spider recorder fail", zap.Error(err))
+		}
+	})
+	log.Info("recording spider messages", zap.String("path", path))
+	return nil
+}
+
 func (w *KLineWatcher) getPrefixs(exgName, marketType, jobType string) []string {
 	exgMarket := fmt.Sprintf("%s_%s", exgName, marketType)
 	prefixs := make([]string, 0, 2)
@@ -87,13 +158,36 @@ Subscribe data from crawlers.
 从爬虫订阅数据。ohlcv/uohlcv/ws/trade/book
 */
 func (w *KLineWatcher) WatchJobs(exgName, marketType, jobType string, jobs ...WatchJob) *errs.Error {
+	tags, pairs, minTfSecs, err := w.addJobs(exgName, marketType, jobType, jobs)
+	if err != nil {
+		return err
+	}
+	err = w.SendMsg("subscribe", tags)
+	if err != nil {
+		return err
+	}
+	if minTfSecs < 60 && banexg.IsContract(marketType) && jobType == "ohlcv" {
+		//The contract market does not support OHLCV below 1M, and WS is used to listen to transaction aggregation
+		//合约市场不支持1m以下的ohlcv，使用ws监听交易归集
+		jobType = "trade"
+	}
+	args := append([]string{exgName, marketType, jobType}, pairs...)
+	return w.SendMsg("watch_pairs", args)
+}
+
+/*
+addJobs
+Register local jobs without subscribing, return the tags and pairs to subscribe, and the minimum timeframe secs
+注册本地任务但不订阅，返回需要订阅的tags和pairs，以及最小周期秒数
+*/
+func (w *KLineWatcher) addJobs(exgName, marketType, jobType string, jobs []WatchJob) ([]string, []string, int, *errs.Error) {
 	prefixs := w.getPrefixs(exgName, marketType, jobType)
 	tags := make([]string, 0, len(jobs))
 	pairs := make([]string, 0, len(jobs))
 	minTfSecs := 300
 	exchange, err := exg.GetWith(exgName, marketType, "")
 	if err != nil {
-		return err
+		return nil, nil, 0, err
 	}
 	exgID := exchange.Info().ID
 	for _, j := range jobs {
@@ -112,17 +206,7 @@ func (w *KLineWatcher) WatchJobs(exgName, marketType, jobType string, jobs ...Wa
 		// 尽早启动延迟监听
 		core.SetPairMs(j.Symbol, j.Since, int64(tfSecs*1000))
 	}
-	err = w.SendMsg("subscribe", tags)
-	if err != nil {
-		return err
-	}
-	if minTfSecs < 60 && banexg.IsContract(marketType) && jobType == "ohlcv" {
-		//The contract market does not support OHLCV below 1M, and WS is used to listen to transaction aggregation
-		//合约市场不支持1m以下的ohlcv，使用ws监听交易归集
-		jobType = "trade"
-	}
-	args := append([]string{exgName, marketType, jobType}, pairs...)
-	return w.SendMsg("watch_pairs", args)
+	return tags, pairs, minTfSecs, nil
 }
 
 func (w *KLineWatcher) SendMsg(action string, data interface{}) *errs.Error {
@@ -142,11 +226,17 @@ func (w *KLineWatcher) UnWatchJobs(exgName, marketType, jobType string, pairs []
 		for _, prefix := range prefixs {
 			tags = append(tags, fmt.Sprintf("%s_%s", prefix, pair))
 		}
+	}
+	w.delJobs(jobType, pairs)
+	return w.WriteMsg(&utils.IOMsg{Action: "unsubscribe", Data: tags})
+}
+
+func (w *KLineWatcher) delJobs(jobType string, pairs []string) {
+	for _, pair := range pairs {
 		jobKey := fmt.Sprintf("%s_%s", pair, jobType)
 		delete(w.jobs, jobKey)
 		delete(core.PairCopiedMs, pair)
 	}
-	return w.WriteMsg(&utils.IOMsg{Action: "unsubscribe", Data: tags})
 }
 
 func (w *KLineWatcher) onSpiderBar(key string, data []byte) {
@@ -71,10 +71,11 @@ func init() {
 		Help:    "live trade",
 	})
 	AddCmdJob(&CmdJob{
-		Name:    "backtest",
-		Run:     RunBackTest,
-		Options: []string{"out", "timerange", "timestart", "timeend", "stake_amount", "pairs", "prg", "separate"},
-		Help:    "backtest with strategies and data",
+		Name: "backtest",
+		Run:  RunBackTest,
+		Options: []string{"out", "timerange", "timestart", "timeend", "stake_amount", "pairs", "prg", "separate",
+			"mc_runs", "mc_skip", "mc_slip", "replay"},
+		Help: "backtest with strategies and data",
 	})
 	AddCmdJob(&CmdJob{
 		Name: "spider",
@@ -84,7 +85,7 @@ func init() {
 	AddCmdJob(&CmdJob{
 		Name:    "optimize",
 		Run:     opt.RunOptimize,
-		Options: []string{"out", "opt_rounds", "sampler", "picker", "each_pairs", "concur"},
+		Options: []string{"out", "opt_rounds", "sampler", "picker", "each_pairs", "concur", "objectives"},
 		Help:    "run hyper parameters optimization",
 	})
 	AddCmdJob(&CmdJob{
@@ -97,7 +98,7 @@ func init() {
 		Name: "bt_opt",
 		Run:  opt.RunBTOverOpt,
 		Options: []string{"review_period", "run_period", "opt_rounds", "sampler", "picker", "each_pairs",
-			"concur", "alpha", "pair_picker"},
+			"concur", "alpha", "pair_picker", "objectives"},
 		Help: "rolling backtest with hyperparameter optimization",
 	})
 	AddCmdJob(&CmdJob{
@@ -130,6 +131,13 @@ func init() {
 		Options: []string{"timerange", "timestart", "timeend", "pairs", "timeframes", "medium"},
 		Help:    "download kline data from exchange",
 	})
+	AddCmdJob(&CmdJob{
+		Name:    "funding",
+		Parent:  "kline",
+		Run:     RunDownFunding,
+		Options: []string{"timerange", "timestart", "timeend", "pairs"},
+		Help:    "download funding rates of contracts from exchange",
+	})
 	AddCmdJob(&CmdJob{
 		Name:    "load",
 		Parent:  "kline",
@@ -158,6 +166,13 @@ func init() {
 		Options: []string{"pairs"},
 		Help:    "sync klines between timeframes",
 	})
+	AddCmdJob(&CmdJob{
+		Name:    "audit",
+		Parent:  "kline",
+		Run:     RunKlineAudit,
+		Options: []string{"pairs", "timeframes", "timerange", "timestart", "timeend", "out", "repair", "force", "vol_spike", "agg_tol"},
+		Help:    "audit kline anomalies, write report and repair optionally",
+	})
 	AddCmdJob(&CmdJob{
 		Name:    "adj_calc",
 		Parent:  "kline",
@@ -194,7 +209,7 @@ func init() {
 		Name:    "collect_opt",
 		Parent:  "tool",
 		Run:     opt.CollectOptLog,
-		Options: []string{"in", "picker"},
+		Options: []string{"in", "picker", "objectives"},
 		Help:    "collect result of optimize, and print in order",
 	})
 	AddCmdJob(&CmdJob{
@@ -209,9 +224,16 @@ func init() {
 		Parent: "tool",
 		Run:    opt.RunRollBTPicker,
 		Options: []string{"review_period", "run_period", "opt_rounds", "sampler", "each_pairs", "concur",
-			"picker", "pair_picker"},
+			"picker", "pair_picker", "objectives"},
 		Help: "test pickers in roll backtest",
 	})
+	AddCmdJob(&CmdJob{
+		Name:    "monte_carlo",
+		Parent:  "tool",
+		Run:     opt.RunMonteCarlo,
+		Options: []string{"in", "out", "mc_runs", "mc_skip", "mc_slip"},
+		Help:    "monte carlo robustness analysis of backtest orders",
+	})
 	AddCmdJob(&CmdJob{
 		Name:    "load_cal",
 		Parent:  "tool",
@@ -14,6 +14,7 @@ import (
 	"github.com/banbox/banbot/live"
 	"github.com/banbox/banbot/opt"
 	"github.com/banbox/banbot/orm"
+	"github.com/banbox/banbot/orm/ormo"
 	"github.com/banbox/banbot/utils"
 	"github.com/banbox/banexg/errs"
 	"github.com/banbox/banexg/log"
@@ -40,19 +41,19 @@ func RunBackTest(args *config.CmdArgs) *errs.Error {
 		for i, item := range policyList {
 			log.Info("start backtest", zap.Int("id", i+1), zap.String("name", item.Name))
 			config.RunPolicy = []*config.RunPolicyConfig{item}
-			outDir := runBackTest(fmt.Sprintf("%s%d", args.OutPath, i+1), "")
+			outDir := runBackTest(args, fmt.Sprintf("%s%d", args.OutPath, i+1), "")
 			err_ := utils.CopyDir(outDir, fmt.Sprintf("%s_%d", outDir, i+1))
 			if err_ != nil {
 				return errs.New(errs.CodeIOWriteFail, err_)
 			}
 		}
 	} else {
-		runBackTest(args.OutPath, args.PrgOut)
+		runBackTest(args, args.OutPath, args.PrgOut)
 	}
 	return nil
 }
 
-func runBackTest(outDir string, prgOut string) string {
+func runBackTest(args *config.CmdArgs, outDir string, prgOut string) string {
 	core.BotRunning = true
 	biz.ResetVars()
 	b := opt.NewBackTest(false, outDir)
@@ -68,6 +69,13 @@ func runBackTest(outDir string, prgOut string) string {
 		})
 	}
 	b.Run()
+	if args.MCRuns > 0 {
+		mc := &opt.MCConfig{Runs: args.MCRuns, SkipPct: args.MCSkip, Slip: args.MCSlip}
+		err := opt.MonteCarloOrders(ormo.HistODs, b.TotalInvest, mc, b.OutDir)
+		if err != nil {
+			log.Error("monte carlo fail", zap.Error(err))
+		}
+	}
 	return b.OutDir
 }
 
@@ -115,6 +123,47 @@ func RunDownData(args *config.CmdArgs) *errs.Error {
 	return nil
 }
 
+/*
+RunDownFunding
+Download the funding rate history of contracts for futures backtest
+下载合约的历史资金费率，用于期货回测
+*/
+func RunDownFunding(args *config.CmdArgs) *errs.Error {
+	err := biz.SetupComsExg(args)
+	if err != nil {
+		return err
+	}
+	if !core.IsContract {
+		return errs.NewMsg(core.ErrBadConfig, "funding rates only available for contract markets")
+	}
+	pairs, err := goods.RefreshPairList(false)
+	if err != nil {
+		return err
+	}
+	if len(pairs) == 0 {
+		log.Warn("no pairs to download")
+		return nil
+	}
+	sess, conn, err := orm.Conn(nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+	log.Info("start down funding rates for pairs", zap.Int("num", len(pairs)))
+	startMs, endMs := config.TimeRange.StartMS, config.TimeRange.EndMS
+	for _, pair := range pairs {
+		exs, err := orm.GetExSymbolCur(pair)
+		if err != nil {
+			return err
+		}
+		_, err = sess.DownFundingRates(exg.Default, exs, startMs, endMs)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func runExportData(args *config.CmdArgs) *errs.Error {
 	err := biz.SetupComsExg(args)
 	if err != nil {
@@ -139,6 +188,14 @@ func RunKlineCorrect(args *config.CmdArgs) *errs.Error {
 	return orm.SyncKlineTFs(args, nil)
 }
 
+func RunKlineAudit(args *config.CmdArgs) *errs.Error {
+	err := biz.SetupComs(args)
+	if err != nil {
+		return err
+	}
+	return orm.AuditKlines(args)
+}
+
 func RunKlineAdjFactors(args *config.CmdArgs) *errs.Error {
 	err := biz.SetupComs(args)
 	if err != nil {
@@ -156,7 +213,7 @@ func RunSpider(args *config.CmdArgs) *errs.Error {
 	if err != nil {
 		return err
 	}
-	return data.RunSpider(config.SpiderAddr)
+	return data.RunSpider(config.SpiderAddrs...)
 }
 
 func LoadKLinesToDB(args *config.CmdArgs) *errs.Error {
@@ -248,6 +248,8 @@ func bindSubFlags(args *config.CmdArgs, cmd *flag.FlagSet, opts ...string) error
 			cmd.StringVar(&args.Sampler, "sampler", "bayes", "hyper optimize method, tpe/bayes/random/cmaes/ipop-cmaes/bipop-cmaes")
 		case "picker":
 			cmd.StringVar(&args.Picker, "picker", "good3", "Method for selecting targets from multiple hyperparameter optimization results")
+		case "objectives":
+			cmd.StringVar(&args.Objectives, "objectives", "", "multi-objective hyper optimize, e.g. profit:2,drawdown,odnum,sharpe")
 		case "alpha":
 			cmd.Float64Var(&args.Alpha, "alpha", 1, "ma alpha for calculating ema in hyperOpt")
 		case "pair_picker":
@@ -268,6 +270,20 @@ func bindSubFlags(args *config.CmdArgs, cmd *flag.FlagSet, opts ...string) error
 			cmd.StringVar(&args.OutType, "out-type", "", "output data type")
 		case "separate":
 			cmd.BoolVar(&args.Separate, "separate", false, "run policy separately for backtest")
+		case "repair":
+			cmd.BoolVar(&args.Repair, "repair", false, "repair the offending ranges")
+		case "vol_spike":
+			cmd.Float64Var(&args.VolSpike, "vol-spike", 0, "volume spike multiple of recent median, default 50")
+		case "agg_tol":
+			cmd.Float64Var(&args.AggTol, "agg-tol", 0, "max relative diff against aggregate of sub bars, default 0.001")
+		case "mc_runs":
+			cmd.IntVar(&args.MCRuns, "mc-runs", 0, "monte carlo simulations for each method")
+		case "mc_skip":
+			cmd.Float64Var(&args.MCSkip, "mc-skip", 0, "rate of trades randomly skipped in monte carlo")
+		case "mc_slip":
+			cmd.Float64Var(&args.MCSlip, "mc-slip", 0, "max rate of fill price perturbation in monte carlo")
+		case "replay":
+			cmd.StringVar(&args.ReplayPath, "replay", "", "replay the spider record file instead of history klines")
 		default:
 			return errors.New(fmt.Sprintf("unknown argument: %s", key))
 		}
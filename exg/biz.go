@@ -29,7 +29,12 @@ func Setup() *errs.Error {
 func create(name, market, contractType string) (banexg.BanExchange, *errs.Error) {
 	var exgOpts, _ = config.Exchange.Items[config.Exchange.Name]
 	var options = map[string]interface{}{}
+	var useMock bool
 	for key, val := range exgOpts {
+		if key == "mock" {
+			useMock, _ = val.(bool)
+			continue
+		}
 		key = utils.SnakeToCamel(key)
 		if key == banexg.OptFees {
 			var target = make(map[string]map[string]float64)
@@ -75,7 +80,19 @@ func create(name, market, contractType string) (banexg.BanExchange, *errs.Error)
 	if core.RunEnv == core.RunEnvTest {
 		options[banexg.OptEnv] = core.RunEnv
 	}
-	return bex.New(name, options)
+	exchange, err := bex.New(name, options)
+	if err != nil || !useMock {
+		return exchange, err
+	}
+	// match orders locally, start with the wallet amounts in config
+	// 在本地撮合订单，以配置中的钱包金额开始
+	mock := NewMockExchange(exchange)
+	for acc := range config.Accounts {
+		for code, amount := range config.WalletAmounts {
+			mock.SetBalance(acc, code, amount)
+		}
+	}
+	return mock, nil
 }
 
 func GetWith(name, market, contractType string) (banexg.BanExchange, *errs.Error) {
@@ -0,0 +1,814 @@
+package exg
+
+import (
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/banbox/banbot/core"
+	"github.com/banbox/banexg"
+	"github.com/banbox/banexg/errs"
+	"github.com/banbox/banexg/log"
+	"github.com/banbox/banexg/utils"
+	"go.uber.org/zap"
+)
+
+const (
+	// BizCode of binance when a trigger order would trigger immediately 币安触发单将立即触发时的BizCode
+	MockCodeImmediateTrigger = -2021
+	// BizCode of binance when the order does not exist 币安订单不存在时的BizCode
+	MockCodeUnknownOrder = -2011
+)
+
+/*
+MockExchange
+A local exchange for testing the live order manager. Markets, precision and everything else not about trading are
+delegated to the wrapped exchange. Orders are matched locally by klines fed to OnBar or replayed by ReplayTo,
+and fills are pushed to WatchMyTrades. Latency, rejections, partial fills and websocket disconnects can be injected.
+用于测试实盘订单管理的本地交易所。市场、精度等非交易相关的功能委托给包装的交易所。
+订单由OnBar传入或ReplayTo回放的K线在本地撮合，成交推送到WatchMyTrades。可注入延迟、拒单、部分成交和websocket断开。
+*/
+type MockExchange struct {
+	banexg.BanExchange
+	Latency  time.Duration // Delay of every trading api 每个交易接口的延迟
+	FillRate float64       // Max filled rate of bar volume per bar for limit orders, 0 for no limit 限价单每个bar最多成交bar成交量的比例，0不限制
+	FeeRate  float64       // Fee rate of the filled cost 成交额的手续费率
+	lock     sync.Mutex
+	accs     map[string]*mockAccount
+	orders   map[string]*mockOrder
+	klines   map[string][]*banexg.Kline // symbol_tf: klines for FetchOHLCV and ReplayTo
+	replayed map[string]int             // symbol_tf: number of replayed klines
+	matchTFs map[string]int64           // symbol: the smallest tf msecs used for matching
+	prices   map[string]float64
+	failNext map[string][]*errs.Error // method: errors returned by next calls
+	nowMS    int64
+	lastID   int64
+	lastTrd  int64
+}
+
+type mockAccount struct {
+	name      string
+	assets    map[string]*banexg.Asset
+	positions map[string]*banexg.Position // symbol_side
+	leverages map[string]float64
+	tradeOuts []chan *banexg.MyTrade
+	cfgOuts   []chan *banexg.AccountConfig
+}
+
+type mockOrder struct {
+	*banexg.Order
+	account   string
+	triggered bool
+	callback  float64 // callback ratio of trailing stop order 跟踪止损单的回调比例
+	activate  float64 // activation price of trailing stop order 跟踪止损单的激活价格
+	extreme   float64 // best price since trailing activated 跟踪激活后的最优价格
+}
+
+func NewMockExchange(inner banexg.BanExchange) *MockExchange {
+	return &MockExchange{
+		BanExchange: inner,
+		FeeRate:     0.0005,
+		accs:        make(map[string]*mockAccount),
+		orders:      make(map[string]*mockOrder),
+		klines:      make(map[string][]*banexg.Kline),
+		replayed:    make(map[string]int),
+		matchTFs:    make(map[string]int64),
+		prices:      make(map[string]float64),
+		failNext:    make(map[string][]*errs.Error),
+	}
+}
+
+/*
+SetMarkets
+Set markets of the wrapped exchange so that LoadMarkets needs no network
+设置包装交易所的市场，使LoadMarkets无需联网
+*/
+func (e *MockExchange) SetMarkets(markets banexg.MarketMap) {
+	exchange := e.GetExg()
+	exchange.Markets = markets
+	exchange.MarketsById = make(banexg.MarketArrMap)
+	for _, mar := range markets {
+		exchange.MarketsById[mar.ID] = append(exchange.MarketsById[mar.ID], mar)
+	}
+}
+
+func (e *MockExchange) SetBalance(account, code string, total float64) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	acc := e.getAcc(account)
+	acc.assets[code] = &banexg.Asset{Code: code, Free: total, Total: total}
+}
+
+/*
+InjectErr
+Return err on the next call of method, e.g. CreateOrder/EditOrder/CancelOrder
+在method的下次调用时返回err，如CreateOrder/EditOrder/CancelOrder
+*/
+func (e *MockExchange) InjectErr(method string, err *errs.Error) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.failNext[method] = append(e.failNext[method], err)
+}
+
+/*
+Disconnect
+Close all websocket channels as if the connection is lost, trades before watching again are not pushed.
+关闭所有websocket通道，模拟连接断开，重新监听之前的成交不会推送。
+*/
+func (e *MockExchange) Disconnect() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	for _, acc := range e.accs {
+		for _, out := range acc.tradeOuts {
+			close(out)
+		}
+		for _, out := range acc.cfgOuts {
+			close(out)
+		}
+		acc.tradeOuts = nil
+		acc.cfgOuts = nil
+	}
+}
+
+/*
+AddKlines
+Add klines for FetchOHLCV and ReplayTo, the smallest timeframe of a symbol is used for matching orders
+添加用于FetchOHLCV和ReplayTo的K线，品种的最小周期用于撮合订单
+*/
+func (e *MockExchange) AddKlines(symbol, timeFrame string, bars []*banexg.Kline) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	key := symbol + "_" + timeFrame
+	e.klines[key] = append(e.klines[key], bars...)
+	slices.SortFunc(e.klines[key], func(a, b *banexg.Kline) int {
+		return int(a.Time - b.Time)
+	})
+	tfMSecs := int64(utils.TFToSecs(timeFrame)) * 1000
+	if old, ok := e.matchTFs[symbol]; !ok || tfMSecs < old {
+		e.matchTFs[symbol] = tfMSecs
+	}
+}
+
+/*
+ReplayTo
+Match orders by all added klines which close before endMS in time order, return the number of replayed klines
+按时间顺序使用所有在endMS前结束的已添加K线撮合订单，返回回放的K线数量
+*/
+func (e *MockExchange) ReplayTo(endMS int64) int {
+	type item struct {
+		symbol string
+		tf     string
+		bar    *banexg.Kline
+		stopMS int64
+	}
+	e.lock.Lock()
+	var items []item
+	for key, bars := range e.klines {
+		symbol, tf := splitMockKey(key)
+		tfMSecs := int64(utils.TFToSecs(tf)) * 1000
+		start := e.replayed[key]
+		for _, b := range bars[start:] {
+			if b.Time+tfMSecs > endMS {
+				break
+			}
+			items = append(items, item{symbol: symbol, tf: tf, bar: b, stopMS: b.Time + tfMSecs})
+			e.replayed[key] += 1
+		}
+	}
+	e.lock.Unlock()
+	slices.SortStableFunc(items, func(a, b item) int {
+		return int(a.stopMS - b.stopMS)
+	})
+	for _, it := range items {
+		e.OnBar(it.symbol, it.tf, it.bar)
+	}
+	return len(items)
+}
+
+/*
+OnBar
+Update the price by a finished bar and match open orders of the symbol. Only the smallest timeframe of the symbol
+matches orders when multiple timeframes are fed.
+使用已完成的bar更新价格并撮合此品种的挂单。传入多个周期时，仅品种的最小周期撮合订单。
+*/
+func (e *MockExchange) OnBar(symbol, timeFrame string, bar *banexg.Kline) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	tfMSecs := int64(utils.TFToSecs(timeFrame)) * 1000
+	if old, ok := e.matchTFs[symbol]; ok && old < tfMSecs {
+		e.nowMS = max(e.nowMS, bar.Time+tfMSecs)
+		return
+	}
+	e.matchTFs[symbol] = tfMSecs
+	e.nowMS = max(e.nowMS, bar.Time+tfMSecs)
+	e.prices[symbol] = bar.Close
+	ods := make([]*mockOrder, 0, 4)
+	for _, od := range e.orders {
+		if od.Symbol == symbol && !banexg.IsOrderDone(od.Status) {
+			ods = append(ods, od)
+		}
+	}
+	slices.SortFunc(ods, func(a, b *mockOrder) int {
+		return int(a.Timestamp - b.Timestamp)
+	})
+	volLeft := bar.Volume
+	for _, od := range ods {
+		if od.callback > 0 && !od.triggered && (od.TriggerPrice == 0 || !triggerHit(od.Order, bar.Low, bar.High)) {
+			// trailing stop not hit in this bar, move the trigger price by the best price
+			// 跟踪止损本bar未触发，按最优价格移动触发价
+			trailOrder(od, bar)
+			continue
+		}
+		if od.TriggerPrice > 0 && !od.triggered {
+			if !triggerHit(od.Order, bar.Low, bar.High) {
+				continue
+			}
+			od.triggered = true
+			if od.Price == 0 {
+				// trigger market order, filled at the trigger price, or open if gapped
+				// 触发市价单，以触发价成交，跳空时以开盘价成交
+				price := od.TriggerPrice
+				if triggerHit(od.Order, bar.Open, bar.Open) {
+					price = bar.Open
+				}
+				e.fill(od, od.Amount-od.Filled, price)
+				continue
+			}
+		}
+		var price float64
+		if od.Side == banexg.OdSideBuy && bar.Low <= od.Price {
+			price = math.Min(od.Price, bar.Open)
+		} else if od.Side == banexg.OdSideSell && bar.High >= od.Price {
+			price = math.Max(od.Price, bar.Open)
+		} else {
+			continue
+		}
+		amount := od.Amount - od.Filled
+		if e.FillRate > 0 {
+			amount = math.Min(amount, math.Max(0, volLeft*e.FillRate))
+			volLeft -= amount / e.FillRate
+		}
+		if amount > 0 {
+			e.fill(od, amount, price)
+		}
+	}
+}
+
+func (e *MockExchange) CreateOrder(symbol, odType, side string, amount, price float64, params map[string]interface{}) (*banexg.Order, *errs.Error) {
+	if err := e.before("CreateOrder"); err != nil {
+		return nil, err
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if amount <= 0 {
+		return nil, errs.NewMsg(errs.CodeParamInvalid, "amount must > 0")
+	}
+	curPrice := e.prices[symbol]
+	e.lastID += 1
+	od := &mockOrder{
+		Order: &banexg.Order{
+			ID:            strconv.FormatInt(e.lastID, 10),
+			ClientOrderID: utils.GetMapVal(params, banexg.ParamClientOrderId, ""),
+			Timestamp:     e.now(),
+			Status:        banexg.OdStatusOpen,
+			Symbol:        symbol,
+			Type:          odType,
+			PositionSide:  strings.ToLower(utils.GetMapVal(params, banexg.ParamPositionSide, "")),
+			Side:          side,
+			Amount:        amount,
+			Remaining:     amount,
+		},
+		account: utils.GetMapVal(params, banexg.ParamAccount, ""),
+	}
+	od.LastUpdateTimestamp = od.Timestamp
+	if odType != banexg.OdTypeMarket {
+		od.Price = price
+	}
+	slPrice := utils.GetMapVal(params, banexg.ParamStopLossPrice, 0.0)
+	tpPrice := utils.GetMapVal(params, banexg.ParamTakeProfitPrice, 0.0)
+	trigPrice := utils.GetMapVal(params, banexg.ParamTriggerPrice, 0.0)
+	if slPrice > 0 {
+		od.StopLossPrice = slPrice
+		od.TriggerPrice = slPrice
+		od.Type = banexg.OdTypeStopMarket
+		if odType != banexg.OdTypeMarket {
+			od.Type = banexg.OdTypeStop
+		}
+	} else if tpPrice > 0 {
+		od.TakeProfitPrice = tpPrice
+		od.TriggerPrice = tpPrice
+		od.Type = banexg.OdTypeTakeProfitMarket
+		if odType != banexg.OdTypeMarket {
+			od.Type = banexg.OdTypeTakeProfit
+		}
+	} else if trigPrice > 0 {
+		od.TriggerPrice = trigPrice
+	} else if odType == banexg.OdTypeTrailingStopMarket {
+		od.callback = utils.GetMapVal(params, banexg.ParamCallbackRate, 0.0) / 100
+		if od.callback <= 0 {
+			return nil, errs.NewMsg(errs.CodeParamRequired, "createOrder require callbackRate for %s order", odType)
+		}
+		od.activate = utils.GetMapVal(params, "activationPrice", 0.0)
+		od.Price = 0
+		if od.activate == 0 && curPrice > 0 {
+			trailOrder(od, &banexg.Kline{High: curPrice, Low: curPrice})
+		}
+	}
+	if od.TriggerPrice > 0 {
+		od.StopPrice = od.TriggerPrice
+		if curPrice > 0 && triggerHit(od.Order, curPrice, curPrice) {
+			err := errs.NewMsg(errs.CodeRunTime, "Order would immediately trigger.")
+			err.BizCode = MockCodeImmediateTrigger
+			return nil, err
+		}
+	}
+	e.orders[od.ID] = od
+	if od.TriggerPrice == 0 && odType == banexg.OdTypeMarket {
+		if curPrice == 0 {
+			delete(e.orders, od.ID)
+			return nil, errs.NewMsg(errs.CodeRunTime, "no price for market order: %s", symbol)
+		}
+		e.fill(od, amount, curPrice)
+	}
+	return copyOrder(od.Order), nil
+}
+
+func (e *MockExchange) EditOrder(symbol, orderId, side string, amount, price float64, params map[string]interface{}) (*banexg.Order, *errs.Error) {
+	if err := e.before("EditOrder"); err != nil {
+		return nil, err
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	od, err := e.getOpenOrder(orderId, symbol)
+	if err != nil {
+		return nil, err
+	}
+	if od.Side != side {
+		return nil, errs.NewMsg(errs.CodeParamInvalid, "side of order %s is %s", orderId, od.Side)
+	}
+	if amount < od.Filled {
+		return nil, errs.NewMsg(errs.CodeParamInvalid, "amount %v less than filled %v", amount, od.Filled)
+	}
+	od.Amount = amount
+	od.Remaining = amount - od.Filled
+	if price > 0 {
+		od.Price = price
+	}
+	od.LastUpdateTimestamp = e.now()
+	return copyOrder(od.Order), nil
+}
+
+func (e *MockExchange) CancelOrder(id string, symbol string, params map[string]interface{}) (*banexg.Order, *errs.Error) {
+	if err := e.before("CancelOrder"); err != nil {
+		return nil, err
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	od, err := e.getOpenOrder(id, symbol)
+	if err != nil {
+		return nil, err
+	}
+	od.Status = banexg.OdStatusCanceled
+	od.LastUpdateTimestamp = e.now()
+	return copyOrder(od.Order), nil
+}
+
+func (e *MockExchange) FetchOrder(symbol, orderId string, params map[string]interface{}) (*banexg.Order, *errs.Error) {
+	if err := e.before("FetchOrder"); err != nil {
+		return nil, err
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	od, ok := e.orders[orderId]
+	if !ok || od.Symbol != symbol {
+		return nil, unknownOrder(orderId)
+	}
+	return copyOrder(od.Order), nil
+}
+
+func (e *MockExchange) FetchOrders(symbol string, since int64, limit int, params map[string]interface{}) ([]*banexg.Order, *errs.Error) {
+	if err := e.before("FetchOrders"); err != nil {
+		return nil, err
+	}
+	return e.listOrders(symbol, since, limit, params, false), nil
+}
+
+func (e *MockExchange) FetchOpenOrders(symbol string, since int64, limit int, params map[string]interface{}) ([]*banexg.Order, *errs.Error) {
+	if err := e.before("FetchOpenOrders"); err != nil {
+		return nil, err
+	}
+	return e.listOrders(symbol, since, limit, params, true), nil
+}
+
+func (e *MockExchange) FetchBalance(params map[string]interface{}) (*banexg.Balances, *errs.Error) {
+	if err := e.before("FetchBalance"); err != nil {
+		return nil, err
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	acc := e.getAcc(utils.GetMapVal(params, banexg.ParamAccount, ""))
+	res := &banexg.Balances{
+		TimeStamp: e.now(),
+		Free:      make(map[string]float64),
+		Used:      make(map[string]float64),
+		Total:     make(map[string]float64),
+		Assets:    make(map[string]*banexg.Asset),
+	}
+	for code, a := range acc.assets {
+		item := *a
+		res.Assets[code] = &item
+		res.Free[code] = a.Free
+		res.Used[code] = a.Used
+		res.Total[code] = a.Total
+	}
+	return res, nil
+}
+
+func (e *MockExchange) FetchPositions(symbols []string, params map[string]interface{}) ([]*banexg.Position, *errs.Error) {
+	if err := e.before("FetchPositions"); err != nil {
+		return nil, err
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	acc := e.getAcc(utils.GetMapVal(params, banexg.ParamAccount, ""))
+	res := make([]*banexg.Position, 0, len(acc.positions))
+	for _, p := range acc.positions {
+		if p.Contracts == 0 || len(symbols) > 0 && !slices.Contains(symbols, p.Symbol) {
+			continue
+		}
+		item := *p
+		item.MarkPrice = e.prices[p.Symbol]
+		item.Notional = item.Contracts * item.MarkPrice
+		item.UnrealizedPnl = item.Contracts * (item.MarkPrice - item.EntryPrice)
+		if item.Side == banexg.PosSideShort {
+			item.UnrealizedPnl = -item.UnrealizedPnl
+		}
+		res = append(res, &item)
+	}
+	slices.SortFunc(res, func(a, b *banexg.Position) int {
+		return strings.Compare(a.Symbol+a.Side, b.Symbol+b.Side)
+	})
+	return res, nil
+}
+
+func (e *MockExchange) FetchAccountPositions(symbols []string, params map[string]interface{}) ([]*banexg.Position, *errs.Error) {
+	return e.FetchPositions(symbols, params)
+}
+
+/*
+FetchOHLCV
+Return added klines closed before the current time, fetch from the wrapped exchange if none added
+返回当前时间前已结束的已添加K线，未添加时从包装的交易所获取
+*/
+func (e *MockExchange) FetchOHLCV(symbol, timeframe string, since int64, limit int, params map[string]interface{}) ([]*banexg.Kline, *errs.Error) {
+	e.lock.Lock()
+	bars, ok := e.klines[symbol+"_"+timeframe]
+	nowMS := e.nowMS
+	e.lock.Unlock()
+	if !ok {
+		return e.BanExchange.FetchOHLCV(symbol, timeframe, since, limit, params)
+	}
+	tfMSecs := int64(utils.TFToSecs(timeframe)) * 1000
+	res := make([]*banexg.Kline, 0, len(bars))
+	for _, b := range bars {
+		if b.Time < since {
+			continue
+		}
+		if nowMS > 0 && b.Time+tfMSecs > nowMS || limit > 0 && len(res) >= limit {
+			break
+		}
+		res = append(res, b)
+	}
+	return res, nil
+}
+
+func (e *MockExchange) WatchMyTrades(params map[string]interface{}) (chan *banexg.MyTrade, *errs.Error) {
+	if err := e.before("WatchMyTrades"); err != nil {
+		return nil, err
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	acc := e.getAcc(utils.GetMapVal(params, banexg.ParamAccount, ""))
+	out := make(chan *banexg.MyTrade, 1000)
+	acc.tradeOuts = append(acc.tradeOuts, out)
+	return out, nil
+}
+
+func (e *MockExchange) WatchAccountConfig(params map[string]interface{}) (chan *banexg.AccountConfig, *errs.Error) {
+	if err := e.before("WatchAccountConfig"); err != nil {
+		return nil, err
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	acc := e.getAcc(utils.GetMapVal(params, banexg.ParamAccount, ""))
+	out := make(chan *banexg.AccountConfig, 10)
+	acc.cfgOuts = append(acc.cfgOuts, out)
+	return out, nil
+}
+
+func (e *MockExchange) SetLeverage(leverage float64, symbol string, params map[string]interface{}) (map[string]interface{}, *errs.Error) {
+	if err := e.before("SetLeverage"); err != nil {
+		return nil, err
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	acc := e.getAcc(utils.GetMapVal(params, banexg.ParamAccount, ""))
+	acc.leverages[symbol] = leverage
+	for _, out := range acc.cfgOuts {
+		select {
+		case out <- &banexg.AccountConfig{Symbol: symbol, Leverage: int(leverage)}:
+		default:
+		}
+	}
+	return map[string]interface{}{"symbol": symbol, "leverage": leverage}, nil
+}
+
+func (e *MockExchange) GetLeverage(symbol string, notional float64, account string) (float64, float64) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	acc := e.getAcc(account)
+	leverage, ok := acc.leverages[symbol]
+	if !ok {
+		leverage = 1
+	}
+	return leverage, 125
+}
+
+func (e *MockExchange) CalculateFee(symbol, odType, side string, amount float64, price float64, isMaker bool,
+	params map[string]interface{}) (*banexg.Fee, *errs.Error) {
+	_, quote, _, _ := core.SplitSymbol(symbol)
+	return &banexg.Fee{IsMaker: isMaker, Currency: quote, Cost: amount * price * e.FeeRate, Rate: e.FeeRate}, nil
+}
+
+func (e *MockExchange) MilliSeconds() int64 {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return e.now()
+}
+
+/*
+before
+Apply the latency and return the injected error of method
+应用延迟并返回method的注入错误
+*/
+func (e *MockExchange) before(method string) *errs.Error {
+	if e.Latency > 0 {
+		time.Sleep(e.Latency)
+	}
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	fails := e.failNext[method]
+	if len(fails) == 0 {
+		return nil
+	}
+	e.failNext[method] = fails[1:]
+	return fails[0]
+}
+
+func (e *MockExchange) now() int64 {
+	if e.nowMS > 0 {
+		return e.nowMS
+	}
+	return e.BanExchange.MilliSeconds()
+}
+
+func (e *MockExchange) getAcc(name string) *mockAccount {
+	acc, ok := e.accs[name]
+	if !ok {
+		acc = &mockAccount{
+			name:      name,
+			assets:    make(map[string]*banexg.Asset),
+			positions: make(map[string]*banexg.Position),
+			leverages: make(map[string]float64),
+		}
+		e.accs[name] = acc
+	}
+	return acc
+}
+
+func (e *MockExchange) getOpenOrder(id, symbol string) (*mockOrder, *errs.Error) {
+	od, ok := e.orders[id]
+	if !ok || od.Symbol != symbol || banexg.IsOrderDone(od.Status) {
+		return nil, unknownOrder(id)
+	}
+	return od, nil
+}
+
+func (e *MockExchange) listOrders(symbol string, since int64, limit int, params map[string]interface{}, openOnly bool) []*banexg.Order {
+	account := utils.GetMapVal(params, banexg.ParamAccount, "")
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	res := make([]*banexg.Order, 0, 8)
+	for _, od := range e.orders {
+		if od.account != account || symbol != "" && od.Symbol != symbol || od.Timestamp < since {
+			continue
+		}
+		if openOnly && banexg.IsOrderDone(od.Status) {
+			continue
+		}
+		res = append(res, copyOrder(od.Order))
+	}
+	slices.SortFunc(res, func(a, b *banexg.Order) int {
+		if a.Timestamp != b.Timestamp {
+			return int(a.Timestamp - b.Timestamp)
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	if limit > 0 && len(res) > limit {
+		res = res[len(res)-limit:]
+	}
+	return res
+}
+
+/*
+fill
+Fill amount of the order at price, update balances and positions, and push the trade to watchers
+以price成交订单的amount数量，更新余额和仓位，并推送成交到监听者
+*/
+func (e *MockExchange) fill(od *mockOrder, amount, price float64) {
+	if amount <= 0 {
+		return
+	}
+	nowMS := e.now()
+	cost := amount * price
+	_, quote, _, _ := core.SplitSymbol(od.Symbol)
+	fee := &banexg.Fee{IsMaker: od.Type == banexg.OdTypeLimit, Currency: quote, Cost: cost * e.FeeRate, Rate: e.FeeRate}
+	od.Average = (od.Average*od.Filled + cost) / (od.Filled + amount)
+	od.Filled += amount
+	od.Remaining = math.Max(0, od.Amount-od.Filled)
+	od.Cost += cost
+	if od.Fee == nil {
+		od.Fee = &banexg.Fee{Currency: quote, Rate: e.FeeRate}
+	}
+	od.Fee.Cost += fee.Cost
+	od.Status = banexg.OdStatusPartFilled
+	if od.Remaining <= od.Amount*1e-9 {
+		od.Remaining = 0
+		od.Status = banexg.OdStatusFilled
+	}
+	od.LastTradeTimestamp = nowMS
+	od.LastUpdateTimestamp = nowMS
+	e.lastTrd += 1
+	trade := &banexg.Trade{
+		ID:        strconv.FormatInt(e.lastTrd, 10),
+		Symbol:    od.Symbol,
+		Side:      od.Side,
+		Type:      od.Type,
+		Amount:    amount,
+		Price:     price,
+		Cost:      cost,
+		Order:     od.ID,
+		Timestamp: nowMS,
+		Maker:     fee.IsMaker,
+		Fee:       fee,
+	}
+	od.Trades = append(od.Trades, trade)
+	acc := e.getAcc(od.account)
+	e.applyFill(acc, od.Order, trade)
+	myTrade := &banexg.MyTrade{
+		Trade:    *trade,
+		Filled:   od.Filled,
+		ClientID: od.ClientOrderID,
+		Average:  od.Average,
+		State:    od.Status,
+		PosSide:  od.PositionSide,
+	}
+	for _, out := range acc.tradeOuts {
+		select {
+		case out <- myTrade:
+		default:
+			log.Warn("mock exchange trade chan full, drop", zap.String("id", trade.ID))
+		}
+	}
+}
+
+func (e *MockExchange) applyFill(acc *mockAccount, od *banexg.Order, trade *banexg.Trade) {
+	base, quote, _, _ := core.SplitSymbol(od.Symbol)
+	quoteAsset := acc.asset(quote)
+	addAsset(quoteAsset, -trade.Fee.Cost)
+	if od.PositionSide == "" {
+		// spot: exchange base and quote directly 现货：直接交换base和quote
+		baseAsset := acc.asset(base)
+		if od.Side == banexg.OdSideBuy {
+			addAsset(baseAsset, trade.Amount)
+			addAsset(quoteAsset, -trade.Cost)
+		} else {
+			addAsset(baseAsset, -trade.Amount)
+			addAsset(quoteAsset, trade.Cost)
+		}
+		return
+	}
+	key := od.Symbol + "_" + od.PositionSide
+	pos, ok := acc.positions[key]
+	if !ok {
+		pos = &banexg.Position{
+			ID:         key,
+			Symbol:     od.Symbol,
+			Side:       od.PositionSide,
+			Hedged:     true,
+			MarginMode: banexg.MarginCross,
+		}
+		acc.positions[key] = pos
+	}
+	pos.TimeStamp = trade.Timestamp
+	pos.Leverage = int(max(acc.leverages[od.Symbol], 1))
+	isOpen := (od.Side == banexg.OdSideBuy) == (od.PositionSide == banexg.PosSideLong)
+	if isOpen {
+		pos.EntryPrice = (pos.EntryPrice*pos.Contracts + trade.Cost) / (pos.Contracts + trade.Amount)
+		pos.Contracts += trade.Amount
+		return
+	}
+	amount := math.Min(trade.Amount, pos.Contracts)
+	pnl := amount * (trade.Price - pos.EntryPrice)
+	if od.PositionSide == banexg.PosSideShort {
+		pnl = -pnl
+	}
+	addAsset(quoteAsset, pnl)
+	pos.Contracts -= amount
+	if pos.Contracts <= 0 {
+		pos.Contracts = 0
+		pos.EntryPrice = 0
+	}
+}
+
+func (a *mockAccount) asset(code string) *banexg.Asset {
+	res, ok := a.assets[code]
+	if !ok {
+		res = &banexg.Asset{Code: code}
+		a.assets[code] = res
+	}
+	return res
+}
+
+func addAsset(a *banexg.Asset, amount float64) {
+	a.Free += amount
+	a.Total = a.Free + a.Used
+}
+
+/*
+triggerHit
+Whether the trigger price of the order is reached in the price range [low, high]
+价格区间[low, high]是否达到订单的触发价格
+*/
+func triggerHit(od *banexg.Order, low, high float64) bool {
+	isStop := od.StopLossPrice > 0 || od.TakeProfitPrice == 0 && od.Type != banexg.OdTypeTakeProfit &&
+		od.Type != banexg.OdTypeTakeProfitMarket
+	if isStop == (od.Side == banexg.OdSideSell) {
+		// sell stop loss / buy take profit: triggered when price falls
+		// 卖出止损/买入止盈：价格下跌时触发
+		return low <= od.TriggerPrice
+	}
+	return high >= od.TriggerPrice
+}
+
+/*
+trailOrder
+Update the best price of the trailing stop order by bar, and move its trigger price
+按bar更新跟踪止损单的最优价格，并移动其触发价
+*/
+func trailOrder(od *mockOrder, bar *banexg.Kline) {
+	isSell := od.Side == banexg.OdSideSell
+	best := bar.Low
+	if isSell {
+		best = bar.High
+	}
+	if od.extreme == 0 {
+		if od.activate > 0 && (isSell && best < od.activate || !isSell && best > od.activate) {
+			return
+		}
+	} else if isSell && best <= od.extreme || !isSell && best >= od.extreme {
+		return
+	}
+	od.extreme = best
+	if isSell {
+		od.TriggerPrice = best * (1 - od.callback)
+	} else {
+		od.TriggerPrice = best * (1 + od.callback)
+	}
+	od.StopPrice = od.TriggerPrice
+	od.StopLossPrice = od.TriggerPrice
+}
+
+func copyOrder(od *banexg.Order) *banexg.Order {
+	res := *od
+	res.Trades = slices.Clone(od.Trades)
+	if od.Fee != nil {
+		fee := *od.Fee
+		res.Fee = &fee
+	}
+	return &res
+}
+
+func unknownOrder(id string) *errs.Error {
+	err := errs.NewMsg(errs.CodeRunTime, "Unknown order sent: %s", id)
+	err.BizCode = MockCodeUnknownOrder
+	return err
+}
+
+func splitMockKey(key string) (string, string) {
+	idx := strings.LastIndex(key, "_")
+	return key[:idx], key[idx+1:]
+}
@@ -0,0 +1,104 @@
+package exg
+
+import (
+	"math"
+	"testing"
+
+	"github.com/banbox/banexg"
+	"github.com/banbox/banexg/bex"
+	"github.com/banbox/banexg/errs"
+)
+
+func newTestMock(t *testing.T) *MockExchange {
+	inner, err := bex.New("binance", map[string]interface{}{
+		banexg.OptMarketType: banexg.MarketLinear,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mock := NewMockExchange(inner)
+	mock.FeeRate = 0
+	mock.SetBalance("", "USDT", 1000)
+	return mock
+}
+
+func TestMockExchange(t *testing.T) {
+	mock := newTestMock(t)
+	pair := "BTC/USDT:USDT"
+	startMS := int64(1700000000000)
+	var bars []*banexg.Kline
+	for i, p := range []float64{100, 102, 98, 95, 105} {
+		bars = append(bars, &banexg.Kline{Time: startMS + int64(i)*60000, Open: p, High: p + 1, Low: p - 1,
+			Close: p, Volume: 10})
+	}
+	mock.AddKlines(pair, "1m", bars)
+	if num := mock.ReplayTo(startMS + 60000); num != 1 {
+		t.Fatalf("expect 1 replayed bar, got %v", num)
+	}
+	if res, _ := mock.FetchOHLCV(pair, "1m", 0, 0, nil); len(res) != 1 {
+		t.Fatalf("FetchOHLCV should only return finished bars, got %v", len(res))
+	}
+	trades, _ := mock.WatchMyTrades(nil)
+	long := map[string]interface{}{banexg.ParamPositionSide: "LONG"}
+	od, err := mock.CreateOrder(pair, banexg.OdTypeMarket, banexg.OdSideBuy, 2, 0, long)
+	if err != nil || od.Status != banexg.OdStatusFilled || od.Average != 100 {
+		t.Fatalf("market order should fill at last close: %v %v", od, err)
+	}
+	if trade := <-trades; trade.Order != od.ID || trade.Filled != 2 {
+		t.Fatalf("bad my trade: %v", trade)
+	}
+	// stop loss below the price is accepted, above triggers immediately
+	sl := map[string]interface{}{banexg.ParamPositionSide: "LONG", banexg.ParamStopLossPrice: 96.0}
+	slOd, err := mock.CreateOrder(pair, banexg.OdTypeMarket, banexg.OdSideSell, 1, 0, sl)
+	if err != nil || slOd.Status != banexg.OdStatusOpen {
+		t.Fatalf("create stop loss fail: %v", err)
+	}
+	sl[banexg.ParamStopLossPrice] = 101.0
+	_, err = mock.CreateOrder(pair, banexg.OdTypeMarket, banexg.OdSideSell, 1, 0, sl)
+	if err == nil || err.BizCode != MockCodeImmediateTrigger {
+		t.Fatalf("expect immediate trigger error, got %v", err)
+	}
+	// limit order partially filled by volume share
+	mock.FillRate = 0.1
+	lmt, _ := mock.CreateOrder(pair, banexg.OdTypeLimit, banexg.OdSideSell, 1.5, 101, long)
+	mock.ReplayTo(startMS + 2*60000)
+	res, _ := mock.FetchOrder(pair, lmt.ID, nil)
+	if res.Status != banexg.OdStatusPartFilled || math.Abs(res.Filled-1) > 1e-9 || res.Average != 102 {
+		t.Fatalf("expect part filled 1 at open 102, got %v %v %v", res.Status, res.Filled, res.Average)
+	}
+	opens, _ := mock.FetchOpenOrders(pair, 0, 0, nil)
+	if len(opens) != 2 {
+		t.Fatalf("expect 2 open orders, got %v", len(opens))
+	}
+	_, err = mock.CancelOrder(lmt.ID, pair, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = mock.CancelOrder(lmt.ID, pair, nil)
+	if err == nil || err.BizCode != MockCodeUnknownOrder {
+		t.Fatalf("cancel twice should fail, got %v", err)
+	}
+	// websocket disconnected, the stop loss fill is only visible by FetchOrder
+	mock.Disconnect()
+	for range trades {
+	}
+	mock.ReplayTo(startMS + 5*60000)
+	res, _ = mock.FetchOrder(pair, slOd.ID, nil)
+	if res.Status != banexg.OdStatusFilled || res.Average != 95 {
+		t.Fatalf("stop loss should fill at gapped open 95, got %v %v", res.Status, res.Average)
+	}
+	poss, _ := mock.FetchPositions(nil, nil)
+	if len(poss) != 0 {
+		t.Fatalf("position should be closed, got %v", poss)
+	}
+	bal, _ := mock.FetchBalance(nil)
+	// long 2 at 100, sell 1 at 102, sell 1 at 95
+	if math.Abs(bal.Total["USDT"]-997) > 1e-9 {
+		t.Fatalf("bad balance: %v", bal.Total["USDT"])
+	}
+	mock.InjectErr("CreateOrder", errs.NewMsg(errs.CodeNetFail, "timeout"))
+	_, err = mock.CreateOrder(pair, banexg.OdTypeMarket, banexg.OdSideBuy, 1, 0, long)
+	if err == nil || err.Code != errs.CodeNetFail {
+		t.Fatalf("expect injected error, got %v", err)
+	}
+}
@@ -12,6 +12,7 @@ import (
 	"github.com/banbox/banbot/orm"
 	"github.com/banbox/banbot/orm/ormo"
 	"github.com/banbox/banbot/rpc"
+	"github.com/banbox/banbot/strat"
 	"github.com/banbox/banbot/utils"
 	"github.com/banbox/banexg"
 	"github.com/banbox/banexg/log"
@@ -139,6 +140,26 @@ func CronCheckTriggerOds() {
 	}
 }
 
+func CronTrailStopLosses() {
+	// Move emulated trailing stop losses every 10 seconds 每10秒移动模拟的跟踪止损
+	_, err_ := core.Cron.AddFunc("*/10 * * * * *", biz.TrailStopLosses)
+	if err_ != nil {
+		log.Error("add TrailStopLosses fail", zap.Error(err_))
+	}
+}
+
+func CronSaveStratStates() {
+	// Save changed strategy states every 5 minutes 每5分钟保存有变化的策略状态
+	_, err_ := core.Cron.AddFunc("45 */5 * * * *", func() {
+		if err := strat.SaveJobStates(); err != nil {
+			log.Error("save strat states fail", zap.Error(err))
+		}
+	})
+	if err_ != nil {
+		log.Error("add SaveStratStates fail", zap.Error(err_))
+	}
+}
+
 func LoopBalancePositions() {
 	ticker := time.NewTicker(time.Duration(config.AccountPullSecs) * time.Second)
 	core.ExitCalls = append(core.ExitCalls, ticker.Stop)
@@ -108,6 +108,9 @@ func (t *CryptoTrader) Run() *errs.Error {
 }
 
 func (t *CryptoTrader) FeedKLine(bar *orm.InfoKline) {
+	if mock, ok := exg.Default.(*exg.MockExchange); ok && !bar.IsWarmUp {
+		mock.OnBar(bar.Symbol, bar.TimeFrame, &bar.Kline)
+	}
 	err := t.Trader.FeedKline(bar)
 	if err != nil {
 		log.Error("handle bar fail", zap.String("pair", bar.Symbol), zap.Error(err))
@@ -153,6 +156,12 @@ func (t *CryptoTrader) startJobs() {
 	// Check if the limit order submission is triggered at 15th secs of every minute
 	// 每分钟第15s检查是否触发限价单提交
 	CronCheckTriggerOds()
+	// Move trailing stop losses on exchanges without native trailing orders every 10 seconds
+	// 每10秒为不支持原生跟踪单的交易所移动跟踪止损
+	CronTrailStopLosses()
+	// Save custom states of strategy jobs every 5 minutes
+	// 每5分钟保存策略任务的自定义状态
+	CronSaveStratStates()
 	// Regularly update balance and synchronize exchange positions with local orders
 	// 定期更新余额，同步交易所持仓到本地订单
 	LoopBalancePositions()
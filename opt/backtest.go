@@ -12,6 +12,7 @@ import (
 	"github.com/banbox/banbot/orm/ormo"
 	"github.com/banbox/banbot/strat"
 	"github.com/banbox/banbot/utils"
+	"github.com/banbox/banexg"
 	"github.com/banbox/banexg/errs"
 	"github.com/banbox/banexg/log"
 	"github.com/robfig/cron/v3"
@@ -29,10 +30,20 @@ const (
 type BackTestLite struct {
 	biz.Trader
 	*BTResult
-	dp    *data.HistProvider
+	dp    btProvider
 	isOpt bool // whether is hyper optimization
 }
 
+/*
+btProvider
+Data provider of backtest: HistProvider, or ReplayProvider for recorded spider messages
+回测的数据源：HistProvider，或回放爬虫记录的ReplayProvider
+*/
+type btProvider interface {
+	data.IProvider
+	Terminate()
+}
+
 type BackTest struct {
 	*BackTestLite
 	lastDumpMs  int64 // The last time the backtest status was saved 上一次保存回测状态的时间
@@ -58,7 +69,9 @@ func NewBackTestLite(isOpt bool, onBar data.FnPairKline, getEnd data.FnGetInt64,
 			b.FeedKLine(bar)
 		}
 	}
-	b.dp = data.NewHistProvider(onBar, b.OnEnvEnd, getEnd, !isOpt, pBar)
+	dp := data.NewHistProvider(onBar, b.OnEnvEnd, getEnd, !isOpt, pBar)
+	dp.OnTrades = b.FeedTrades
+	b.dp = dp
 	biz.InitLocalOrderMgr(b.orderCB, !isOpt)
 	return b
 }
@@ -98,6 +111,26 @@ func (b *BackTestLite) FeedKLine(bar *orm.InfoKline) bool {
 	return true
 }
 
+/*
+FeedTrades
+Receive trades in tick-level backtest
+tick级回测时接收逐笔成交
+*/
+func (b *BackTestLite) FeedTrades(pair string, trades []*banexg.Trade) {
+	err := b.Trader.FeedTrades(pair, trades)
+	if err != nil {
+		if err.Code == core.ErrLiquidation {
+			b.onLiquidation(pair)
+		} else {
+			log.Error("FeedTrades fail", zap.String("p", pair), zap.Error(err))
+		}
+		return
+	}
+	if !core.BotRunning {
+		b.dp.Terminate()
+	}
+}
+
 func (b *BackTestLite) onLiquidation(symbol string) {
 	date := btime.ToDateStr(btime.TimeMS(), "")
 	if config.ChargeOnBomb {
@@ -181,6 +214,14 @@ func (b *BackTest) Init() *errs.Error {
 		return err
 	}
 	b.PBar.SetProgress("listMs", 1)
+	if config.Args.ReplayPath != "" {
+		// Replay recorded spider messages instead of history klines 回放录制的爬虫消息，替代历史K线
+		b.dp, err = data.NewReplayProvider(config.ParsePath(config.Args.ReplayPath), b.FeedKLine, b.OnEnvEnd)
+		if err != nil {
+			return err
+		}
+		log.Info("replay spider record", zap.String("path", config.Args.ReplayPath))
+	}
 	// 交易对初始化
 	err = RefreshPairJobs(b.dp, !b.isOpt, true, b.PBar)
 	return err
@@ -31,6 +31,7 @@ var (
 		"goodAvg": optGoodMa,
 		"good1t4": optGood1t4,
 		"good4":   optGood4,
+		"pareto":  optPareto,
 		// below performance is poor
 		// 下面的效果不好
 		"good2":    optGood2,
@@ -122,6 +123,7 @@ func (o *OptInfo) ToPol(name, dirt, tfStr, pairStr string) *config.RunPolicyConf
 		Dirt:   o.Dirt,
 		Params: o.Params,
 		Score:  o.Score,
+		Rules:  config.GetPolRules(name),
 	}
 	if len(tfStr) > 0 {
 		res.RunTimeframes = strings.Split(tfStr, "|")
@@ -133,8 +135,12 @@ func (o *OptInfo) ToPol(name, dirt, tfStr, pairStr string) *config.RunPolicyConf
 }
 
 func newRollBtOpt(args *config.CmdArgs) (*rollBtOpt, *errs.Error) {
+	err := SetOptObjectives(args.Objectives)
+	if err != nil {
+		return nil, err
+	}
 	core.SetRunMode(core.RunModeBackTest)
-	err := biz.SetupComsExg(args)
+	err = biz.SetupComsExg(args)
 	if err != nil {
 		return nil, err
 	}
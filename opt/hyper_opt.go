@@ -50,6 +50,7 @@ func RunBTOverOpt(args *config.CmdArgs) *errs.Error {
 	lastPols := config.RunPolicy
 	pbar := utils.NewPrgBar(int((t.allEndMs-t.curMs)/1000), "BtOpt")
 	defer pbar.Close()
+	wf := newWalkForward(t.reviewMSecs)
 	backPols := config.RunPolicy
 	for t.curMs < t.allEndMs {
 		pbar.Add(int(t.runMSecs / 1000))
@@ -71,6 +72,7 @@ func RunBTOverOpt(args *config.CmdArgs) *errs.Error {
 		}
 		applyOptPolicies(lastPols, polList, args.Alpha)
 		lastPols = config.RunPolicy
+		isScores := parsePolScores(polStr)
 		wallets := biz.GetWallets(config.DefAcc)
 		core.BotRunning = true
 		t.dateRange.StartMS = t.curMs
@@ -86,10 +88,16 @@ func RunBTOverOpt(args *config.CmdArgs) *errs.Error {
 		ormo.HistODs = allHisOds
 		bt.Run()
 		lastRes = bt.BTResult
+		winOds := ormo.HistODs[min(len(allHisOds), len(ormo.HistODs)):]
+		wf.add(t.dateRange.StartMS, t.dateRange.EndMS, lastPols, isScores, winOds)
 		allHisOds = ormo.HistODs
 		lastWal = wallets.DumpAvas()
 		t.curMs += t.runMSecs
 	}
+	err = wf.dump(t.outDir)
+	if err != nil {
+		return err
+	}
 	err = t.dumpConfig()
 	if err != nil {
 		return err
@@ -215,6 +223,12 @@ func pickFromExists(path string, picker, pairPicker string) (string, *errs.Error
 	if len(paths) == 0 {
 		return "", nil
 	}
+	for _, p := range paths {
+		if !isOptLogFinished(p) {
+			// interrupted, runOptimize will resume from it 已中断，runOptimize将从其恢复
+			return "", nil
+		}
+	}
 	return collectOptLog(paths, 0, picker, pairPicker)
 }
 
@@ -259,8 +273,12 @@ func RunOptimize(args *config.CmdArgs) *errs.Error {
 		return nil
 	}
 	args.LogLevel = "warn"
+	err := SetOptObjectives(args.Objectives)
+	if err != nil {
+		return err
+	}
 	core.SetRunMode(core.RunModeBackTest)
-	err := biz.SetupComsExg(args)
+	err = biz.SetupComsExg(args)
 	if err != nil {
 		return err
 	}
@@ -288,21 +306,31 @@ func runOptimize(args *config.CmdArgs, minScore float64) (string, *errs.Error) {
 	groups := config.RunPolicy
 	if len(groups) <= 1 || args.Concur <= 1 {
 		logOuts = append(logOuts, args.OutPath)
-		file, err_ := os.Create(args.OutPath)
-		if err_ != nil {
-			return "", errs.New(errs.CodeIOWriteFail, err_)
-		}
-		for _, gp := range groups {
-			// Bayesian optimization is carried out separately for each strategy, long and short, to find the best parameters
-			// 针对每个策略、多空单独进行贝叶斯优化，寻找最佳参数
-			err = optAndPrint(gp.Clone(), args, allPairs, file)
-			if err != nil {
-				file.Close()
-				return "", err
+		// resume from the log of an interrupted run, the log is rewritten while replaying 从中断运行的日志恢复，重放时重写日志
+		finished, resume := loadOptResume(args.OutPath, optLogHead(args))
+		if finished {
+			log.Warn("opt log finished, skip optimize", zap.String("path", args.OutPath))
+		} else {
+			optResumes = resume
+			file, err_ := os.Create(args.OutPath)
+			if err_ != nil {
+				return "", errs.New(errs.CodeIOWriteFail, err_)
 			}
+			for _, gp := range groups {
+				// Bayesian optimization is carried out separately for each strategy, long and short, to find the best parameters
+				// 针对每个策略、多空单独进行贝叶斯优化，寻找最佳参数
+				err = optAndPrint(gp.Clone(), args, allPairs, file)
+				if err != nil {
+					file.Close()
+					optResumes = nil
+					return "", err
+				}
+			}
+			file.WriteString("\n" + optFinishedMark + "\n")
+			file.Close()
+			optResumes = nil
+			sortOptLogs(args.OutPath)
 		}
-		file.Close()
-		sortOptLogs(args.OutPath)
 	} else {
 		// Multi-process execution to improve speed.
 		// 多进程执行，提高速度。
@@ -318,6 +346,9 @@ func runOptimize(args *config.CmdArgs, minScore float64) (string, *errs.Error) {
 		if args.Picker != "" {
 			cmds = append(cmds, "-picker", args.Picker)
 		}
+		if args.Objectives != "" {
+			cmds = append(cmds, "-objectives", args.Objectives)
+		}
 		startStr := strconv.FormatInt(config.TimeRange.StartMS/1000, 10)
 		endStr := strconv.FormatInt(config.TimeRange.EndMS/1000, 10)
 		err = utils.ParallelRun(groups, args.Concur, func(i int, pol *config.RunPolicyConfig) *errs.Error {
@@ -415,10 +446,7 @@ optAndPrint optimize for raw policy group;
 write one or multiple optimize result to file.
 */
 func optAndPrint(pol *config.RunPolicyConfig, args *config.CmdArgs, allPairs []string, file *os.File) *errs.Error {
-	file.WriteString(fmt.Sprintf("# run hyper optimize: %v, rounds: %v\n", args.Sampler, args.OptRounds))
-	startDt := btime.ToDateStr(config.TimeRange.StartMS, "")
-	endDt := btime.ToDateStr(config.TimeRange.EndMS, "")
-	file.WriteString(fmt.Sprintf("# date range: %v - %v\n", startDt, endDt))
+	file.WriteString(optLogHead(args))
 	var res []*GroupScore
 	if args.EachPairs {
 		pairs := pol.Pairs
@@ -495,16 +523,25 @@ func optForGroup(pol *config.RunPolicyConfig, method, picker string, rounds int,
 		// 检查组合的是否优于long/short/both
 		flog.WriteString("\n========== union long/short ============\n")
 		config.RunPolicy = []*config.RunPolicyConfig{long, short}
-		bt, loss := runBTOnce()
-		line := fmt.Sprintf("loss: %5.2f \t%v\n", loss, bt.BriefLine())
-		flog.WriteString(line)
-		log.Warn(line)
+		var loss float64
+		var odNum int
+		if sec := optResumes.next("union", true); sec != nil && len(sec.Lines) > 0 {
+			flog.WriteString(sec.Lines[0] + "\n")
+			old := sec.Batches[0][0]
+			loss, odNum = -old.Score, old.OrderNum
+		} else {
+			bt, btLoss := runBTOnce()
+			line := fmt.Sprintf("loss: %5.2f \t%v\n", btLoss, bt.BriefLine())
+			flog.WriteString(line)
+			log.Warn(line)
+			loss, odNum = btLoss, bt.OrderNum
+		}
 		curScore := -loss
-		odNumRate := float64(bt.OrderNum) / float64(bestOdNum)
+		odNumRate := float64(odNum) / float64(bestOdNum)
 		scoreRate := curScore / bestScore
 		if scoreRate > 1.25 || scoreRate > 1.1 && odNumRate < 1.5 {
 			bestScore = curScore
-			bestOdNum = bt.OrderNum
+			bestOdNum = odNum
 			bestPols = []*config.RunPolicyConfig{long, short}
 		}
 	}
@@ -560,7 +597,9 @@ func optForPol(pol *config.RunPolicyConfig, method, picker string, rounds int, f
 		return
 	}
 	flog.WriteString(fmt.Sprintf("\n============== %s =============\n", title))
+	sec := optResumes.next(title, false)
 	var resList = make([]*OptInfo, 0, rounds)
+	var moWeights []float64
 	runOptJob := func(data map[string]float64) (float64, *errs.Error) {
 		jobId := utils.RandomStr(6)
 		ints := make(map[string]bool)
@@ -576,15 +615,66 @@ func optForPol(pol *config.RunPolicyConfig, method, picker string, rounds int, f
 		bt.dumpDetail(filepath.Join(detailDir, jobId+".json"))
 		o.BTResult.DelBigObjects()
 		resList = append(resList, o)
+		if moWeights != nil {
+			// the sampler minimizes the scalarized objectives, the log keeps the score 采样器最小化标量化的多目标，日志保留score
+			return chebyshevLoss(o.BTResult, moWeights), nil
+		}
 		return loss, nil
 	}
 	var err *errs.Error
-	if method == "bayes" {
-		err = runBayes(rounds, params, runOptJob)
+	batchIdx := 0
+	runRounds := func(num int) {
+		// reuse finished trials from the interrupted log 复用中断日志中已完成的试验
+		var olds []*OptInfo
+		if sec != nil && batchIdx < len(sec.Batches) {
+			olds = sec.Batches[batchIdx]
+		}
+		batchIdx += 1
+		losses := make([]float64, 0, len(olds))
+		for _, o := range olds {
+			flog.WriteString(o.ToLine() + "\n")
+			resList = append(resList, o)
+			if moWeights != nil {
+				losses = append(losses, chebyshevLoss(o.BTResult, moWeights))
+			} else {
+				losses = append(losses, -o.Score)
+			}
+		}
+		num -= len(olds)
+		if num <= 0 || sec != nil && sec.Done {
+			return
+		}
+		if method == "bayes" {
+			err = runBayes(num, params, runOptJob, olds, losses)
+		} else {
+			err = runGOptuna(method, num, params, runOptJob, olds, losses)
+		}
+	}
+	weightList := moWeightList()
+	if len(weightList) == 0 {
+		runRounds(rounds)
 	} else {
-		err = runGOptuna(method, rounds, params, runOptJob)
+		// split rounds across weight vectors to cover the pareto front 在权重向量间均分轮次以覆盖帕累托前沿
+		subRounds := max(1, rounds/len(weightList))
+		for _, moWeights = range weightList {
+			texts := make([]string, 0, len(optObjs))
+			for i, obj := range optObjs {
+				texts = append(texts, fmt.Sprintf("%s: %.2f", obj.Name, moWeights[i]))
+			}
+			flog.WriteString(fmt.Sprintf("%s %s\n", optWeightsMark, strings.Join(texts, ", ")))
+			runRounds(subRounds)
+			if err != nil {
+				break
+			}
+		}
+	}
+	var best *OptInfo
+	if sec != nil && sec.Best != nil && sec.Picker == picker {
+		best = sec.Best
+		flog.WriteString(fmt.Sprintf("[%s] %s\n", picker, best.ToLine()))
+	} else {
+		best = calcBestBy(resList, picker)
 	}
-	best := calcBestBy(resList, picker)
 	if best.BTResult == nil {
 		best.ID = utils.RandomStr(6)
 		best.runGetBtResult(pol)
@@ -595,6 +685,8 @@ func optForPol(pol *config.RunPolicyConfig, method, picker string, rounds int, f
 	}
 	if err != nil {
 		log.Error("optimize fail", zap.String("job", title), zap.Error(err))
+	} else {
+		flog.WriteString(optDoneMark + "\n")
 	}
 	pol.Params = best.Params
 	pol.Score = best.Score
@@ -610,10 +702,11 @@ func runBTOnce() (*BackTest, float64) {
 	return bt, loss
 }
 
-func runGOptuna(name string, rounds int, params []*core.Param, loop FuncOptTask) *errs.Error {
+func runGOptuna(name string, rounds int, params []*core.Param, loop FuncOptTask, olds []*OptInfo, losses []float64) *errs.Error {
 	var sampler goptuna.Sampler
 	var options []goptuna.StudyOption
-	var seed = int64(0)
+	// different seed when resumed, avoid sampling the same points again 恢复时使用不同种子，避免再次采样相同的点
+	var seed = int64(len(olds))
 	if name == "random" {
 		sampler = goptuna.NewRandomSampler(goptuna.RandomSamplerOptionSeed(seed))
 	} else if name == "cmaes" {
@@ -640,6 +733,10 @@ func runGOptuna(name string, rounds int, params []*core.Param, loop FuncOptTask)
 	if err_ != nil {
 		return errs.New(errs.CodeRunTime, err_)
 	}
+	err := warmGOptuna(study, params, olds, losses)
+	if err != nil {
+		return err
+	}
 	err_ = study.Optimize(func(trial goptuna.Trial) (float64, error) {
 		var data = make(map[string]float64)
 		for _, p := range params {
@@ -667,7 +764,7 @@ func runGOptuna(name string, rounds int, params []*core.Param, loop FuncOptTask)
 	return nil
 }
 
-func runBayes(rounds int, params []*core.Param, loop FuncOptTask) *errs.Error {
+func runBayes(rounds int, params []*core.Param, loop FuncOptTask, olds []*OptInfo, losses []float64) *errs.Error {
 	bysParams := make([]bayesopt.Param, 0, len(params))
 	for _, p := range params {
 		minVal, maxVal := p.OptSpace()
@@ -677,12 +774,21 @@ func runBayes(rounds int, params []*core.Param, loop FuncOptTask) *errs.Error {
 			Max:  maxVal,
 		})
 	}
+	// finished trials count in random rounds 已完成的试验计入随机轮次
 	options := []bayesopt.OptimizerOption{
 		bayesopt.WithParallel(1),
 		bayesopt.WithRounds(rounds),
-		bayesopt.WithRandomRounds(rounds / 2),
+		bayesopt.WithRandomRounds(max(0, (rounds+len(olds))/2-len(olds))),
 	}
 	opt := bayesopt.New(bysParams, options...)
+	for i, o := range olds {
+		var x = make(map[bayesopt.Param]float64)
+		for j, p := range params {
+			minVal, maxVal := p.OptSpace()
+			x[bysParams[j]] = min(maxVal, max(minVal, p.ToOptSpace(o.Params[p.Name])))
+		}
+		opt.Log(x, losses[i])
+	}
 	_, _, err_ := opt.Optimize(func(m map[bayesopt.Param]float64) float64 {
 		var data = make(map[string]float64)
 		for k, v := range m {
@@ -724,8 +830,12 @@ func CollectOptLog(args *config.CmdArgs) *errs.Error {
 		sortOptLogs(args.InPath)
 		return nil
 	}
+	err := SetOptObjectives(args.Objectives)
+	if err != nil {
+		return err
+	}
 	core.SetRunMode(core.RunModeBackTest)
-	err := biz.SetupComsExg(args)
+	err = biz.SetupComsExg(args)
 	if err != nil {
 		return err
 	}
@@ -736,6 +846,13 @@ func CollectOptLog(args *config.CmdArgs) *errs.Error {
 		}
 		return nil
 	})
+	if len(optObjs) > 0 || args.Picker == "pareto" {
+		fronts, err := printParetoFronts(paths)
+		if err != nil {
+			return err
+		}
+		fmt.Print(fronts)
+	}
 	res, err := collectOptLog(paths, 0, args.Picker, args.PairPicker)
 	if err != nil {
 		return err
@@ -1028,6 +1145,10 @@ func parseOptLine(line string) *OptInfo {
 	paraArr := strings.Split(strings.TrimSpace(line[paraStart:paraEnd]), ",")
 	for _, str := range paraArr {
 		arr := strings.Split(strings.TrimSpace(str), ":")
+		if len(arr) < 2 {
+			// no params for union of long/short 多空联合没有参数
+			continue
+		}
 		res.Params[arr[0]], _ = strconv.ParseFloat(strings.TrimSpace(arr[1]), 64)
 	}
 	prefStr := strings.TrimSpace(line[paraEnd:])
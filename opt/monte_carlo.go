@@ -0,0 +1,282 @@
+package opt
+
+import (
+	"bytes"
+	"cmp"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/banbox/banbot/config"
+	"github.com/banbox/banbot/core"
+	"github.com/banbox/banbot/orm/ormo"
+	"github.com/banbox/banbot/utils"
+	"github.com/banbox/banexg/errs"
+	"github.com/banbox/banexg/log"
+	"github.com/olekukonko/tablewriter"
+	"go.uber.org/zap"
+	"gonum.org/v1/gonum/stat"
+)
+
+const (
+	MCBootstrap = "bootstrap" // sample trades with replacement 有放回抽样交易
+	MCShuffle   = "shuffle"   // shuffle the order of trades 打乱交易顺序
+	mcDefRuns   = 1000
+)
+
+var mcPercents = []float64{0.05, 0.25, 0.5, 0.75, 0.95}
+
+/*
+MCConfig
+Options for Monte Carlo simulation of backtest orders
+回测订单蒙特卡洛模拟的参数
+*/
+type MCConfig struct {
+	Runs    int     // simulations for each method 每种方法模拟次数
+	SkipPct float64 // rate of trades randomly skipped 随机跳过的交易比例
+	Slip    float64 // max rate of random fill price perturbation 成交价随机扰动的最大比例
+	Seed    int64
+}
+
+// MCPath result of a simulated equity path 一条模拟权益曲线的结果
+type MCPath struct {
+	FinalEquity    float64
+	MaxDrawDownPct float64
+	RecoveryDays   float64 // longest time from a peak to a new high 从高点到创新高的最长时间
+}
+
+type mcTrade struct {
+	profit   float64
+	enterVal float64
+	exitVal  float64
+	dirt     float64
+}
+
+/*
+RunMonteCarlo
+Load orders.gob from a backtest report dir and run Monte Carlo simulations on it
+从回测报告目录加载orders.gob并进行蒙特卡洛模拟
+*/
+func RunMonteCarlo(args *config.CmdArgs) *errs.Error {
+	if args.InPath == "" {
+		log.Warn("-in is required")
+		return nil
+	}
+	inDir := args.InPath
+	if info, err_ := os.Stat(inDir); err_ == nil && !info.IsDir() {
+		inDir = filepath.Dir(inDir)
+	}
+	orders, _, _, _, err_ := readBackTestOrders(args.InPath)
+	if err_ != nil {
+		return errs.New(errs.CodeIOReadFail, err_)
+	}
+	var stake float64
+	detailPath := filepath.Join(inDir, "detail.json")
+	if utils.Exists(detailPath) {
+		res, err := parseBtResult(detailPath)
+		if err != nil {
+			return err
+		}
+		stake = res.TotalInvest
+	}
+	if stake <= 0 {
+		for key, val := range config.WalletAmounts {
+			stake += val * core.GetPriceSafe(key)
+		}
+	}
+	outDir := args.OutPath
+	if outDir == "" {
+		outDir = inDir
+	}
+	runs := args.MCRuns
+	if runs <= 0 {
+		runs = mcDefRuns
+	}
+	return MonteCarloOrders(orders, stake, &MCConfig{Runs: runs, SkipPct: args.MCSkip, Slip: args.MCSlip}, outDir)
+}
+
+/*
+MonteCarloOrders
+Resample closed orders by bootstrap and shuffle, randomly skip trades and perturb fill prices.
+Write percentiles of final equity, max drawdown and recovery time to monte_carlo.csv, and histograms to mc_*.html
+通过有放回抽样和打乱顺序重采样已平仓订单，随机跳过交易并扰动成交价。
+最终权益、最大回撤和恢复时间的分位数输出到monte_carlo.csv，直方图输出到mc_*.html
+*/
+func MonteCarloOrders(orders []*ormo.InOutOrder, stake float64, cfg *MCConfig, outDir string) *errs.Error {
+	trades, gapDays := mcPrepare(orders)
+	if len(trades) == 0 {
+		log.Warn("no closed orders for monte carlo")
+		return nil
+	}
+	if stake <= 0 {
+		return errs.NewMsg(errs.CodeParamInvalid, "initial stake is required for monte carlo")
+	}
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = rand.Int63()
+	}
+	rng := rand.New(rand.NewSource(seed))
+	idxs := make([]int, len(trades))
+	for i := range idxs {
+		idxs[i] = i
+	}
+	origin := mcSimPath(trades, idxs, stake, gapDays, &MCConfig{}, rng)
+	results := make(map[string][]*MCPath)
+	for _, method := range []string{MCBootstrap, MCShuffle} {
+		paths := make([]*MCPath, 0, cfg.Runs)
+		for i := 0; i < cfg.Runs; i++ {
+			if method == MCBootstrap {
+				for j := range idxs {
+					idxs[j] = rng.Intn(len(trades))
+				}
+			} else {
+				rng.Shuffle(len(idxs), func(a, b int) {
+					idxs[a], idxs[b] = idxs[b], idxs[a]
+				})
+			}
+			paths = append(paths, mcSimPath(trades, idxs, stake, gapDays, cfg, rng))
+		}
+		results[method] = paths
+	}
+	return dumpMonteCarlo(origin, results, outDir)
+}
+
+/*
+mcPrepare
+Convert closed orders to trades sorted by exit time, return with average days between exits
+将已平仓订单按平仓时间排序转为交易，并返回平仓之间的平均天数
+*/
+func mcPrepare(orders []*ormo.InOutOrder) ([]*mcTrade, float64) {
+	ods := make([]*ormo.InOutOrder, 0, len(orders))
+	for _, od := range orders {
+		if od.Enter == nil || od.Enter.Filled == 0 || od.Exit == nil {
+			continue
+		}
+		ods = append(ods, od)
+	}
+	slices.SortFunc(ods, func(a, b *ormo.InOutOrder) int {
+		return cmp.Compare(a.RealExitMS(), b.RealExitMS())
+	})
+	trades := make([]*mcTrade, 0, len(ods))
+	for _, od := range ods {
+		dirt := float64(1)
+		if od.Short {
+			dirt = -1
+		}
+		trades = append(trades, &mcTrade{
+			profit:   od.Profit,
+			enterVal: od.Enter.Average * od.Enter.Filled,
+			exitVal:  od.Exit.Average * od.Exit.Filled,
+			dirt:     dirt,
+		})
+	}
+	var gapDays float64
+	if len(ods) > 1 {
+		spanMS := ods[len(ods)-1].RealExitMS() - ods[0].RealExitMS()
+		gapDays = float64(spanMS) / float64(len(ods)-1) / 86400000
+	}
+	return trades, gapDays
+}
+
+func mcSimPath(trades []*mcTrade, idxs []int, stake, gapDays float64, cfg *MCConfig, rng *rand.Rand) *MCPath {
+	equity, peak := stake, stake
+	var drawDown float64
+	peakAt, maxGap := 0, 0
+	for i, idx := range idxs {
+		if cfg.SkipPct > 0 && rng.Float64() < cfg.SkipPct {
+			continue
+		}
+		t := trades[idx]
+		profit := t.profit
+		if cfg.Slip > 0 {
+			enterRate := (rng.Float64()*2 - 1) * cfg.Slip
+			exitRate := (rng.Float64()*2 - 1) * cfg.Slip
+			profit += t.dirt * (t.exitVal*exitRate - t.enterVal*enterRate)
+		}
+		equity += profit
+		if equity >= peak {
+			peak = equity
+			maxGap = max(maxGap, i-peakAt)
+			peakAt = i
+		} else if peak > 0 {
+			drawDown = max(drawDown, (peak-equity)/peak)
+		}
+	}
+	// not recovered at the end 结束时仍未恢复
+	if equity < peak {
+		maxGap = max(maxGap, len(idxs)-1-peakAt)
+	}
+	return &MCPath{
+		FinalEquity:    equity,
+		MaxDrawDownPct: drawDown * 100,
+		RecoveryDays:   float64(maxGap) * gapDays,
+	}
+}
+
+func mcQuantiles(vals []float64) []float64 {
+	slices.Sort(vals)
+	res := make([]float64, 0, len(mcPercents)+1)
+	res = append(res, stat.Mean(vals, nil))
+	for _, p := range mcPercents {
+		res = append(res, stat.Quantile(p, stat.LinInterp, vals, nil))
+	}
+	return res
+}
+
+func dumpMonteCarlo(origin *MCPath, results map[string][]*MCPath, outDir string) *errs.Error {
+	err_ := utils.EnsureDir(outDir, 0755)
+	if err_ != nil {
+		return errs.New(errs.CodeIOWriteFail, err_)
+	}
+	metrics := []struct {
+		Name string
+		Get  func(p *MCPath) float64
+	}{
+		{"final_equity", func(p *MCPath) float64 { return p.FinalEquity }},
+		{"max_drawdown_pct", func(p *MCPath) float64 { return p.MaxDrawDownPct }},
+		{"recovery_days", func(p *MCPath) float64 { return p.RecoveryDays }},
+	}
+	head := []string{"method", "metric", "origin", "mean"}
+	for _, p := range mcPercents {
+		head = append(head, fmt.Sprintf("p%v", p*100))
+	}
+	rows := [][]string{head}
+	var b bytes.Buffer
+	table := tablewriter.NewWriter(&b)
+	table.SetHeader(head)
+	table.SetBorders(tablewriter.Border{Left: true, Top: false, Right: true, Bottom: false})
+	table.SetCenterSeparator("|")
+	table.SetAlignment(tablewriter.ALIGN_RIGHT)
+	for _, method := range []string{MCBootstrap, MCShuffle} {
+		paths := results[method]
+		for _, m := range metrics {
+			vals := make([]float64, 0, len(paths))
+			for _, p := range paths {
+				vals = append(vals, m.Get(p))
+			}
+			name := fmt.Sprintf("mc_%s_%s.html", method, m.Name)
+			title := fmt.Sprintf("%s %s, runs: %v", method, m.Name, len(paths))
+			err := DumpBarStat(filepath.Join(outDir, name), title, 50, vals)
+			if err != nil {
+				return err
+			}
+			row := []string{method, m.Name, strconv.FormatFloat(m.Get(origin), 'f', 2, 64)}
+			for _, v := range mcQuantiles(vals) {
+				row = append(row, strconv.FormatFloat(v, 'f', 2, 64))
+			}
+			rows = append(rows, row)
+			table.Append(row)
+		}
+	}
+	err := utils.WriteCsvFile(filepath.Join(outDir, "monte_carlo.csv"), rows, false)
+	if err != nil {
+		return err
+	}
+	table.Render()
+	log.Info("Monte Carlo Reports:\n"+strings.TrimRight(b.String(), "\n"), zap.String("at", outDir))
+	return nil
+}
@@ -0,0 +1,52 @@
+package opt
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/banbox/banbot/orm/ormo"
+)
+
+func TestMonteCarlo(t *testing.T) {
+	// +100, -50, +100 with stake 1000: peak 1100 -> 1050 -> 1150
+	trades := []*mcTrade{{profit: 100}, {profit: -50}, {profit: 100}}
+	rng := rand.New(rand.NewSource(1))
+	p := mcSimPath(trades, []int{0, 1, 2}, 1000, 2, &MCConfig{}, rng)
+	if p.FinalEquity != 1150 || p.RecoveryDays != 4 {
+		t.Fatalf("bad path: %+v", p)
+	}
+	if dd := 50.0 / 1100 * 100; p.MaxDrawDownPct < dd-1e-9 || p.MaxDrawDownPct > dd+1e-9 {
+		t.Fatalf("bad drawdown: %v", p.MaxDrawDownPct)
+	}
+	// losing at the end is counted as not recovered
+	p = mcSimPath(trades, []int{0, 2, 1}, 1000, 1, &MCConfig{}, rng)
+	if p.FinalEquity != 1150 || p.RecoveryDays != 1 {
+		t.Fatalf("bad unrecovered path: %+v", p)
+	}
+	var orders []*ormo.InOutOrder
+	startMS := int64(1700006400000)
+	for i := 0; i < 20; i++ {
+		profit := float64(10)
+		if i%3 == 0 {
+			profit = -15
+		}
+		orders = append(orders, &ormo.InOutOrder{
+			IOrder: &ormo.IOrder{Symbol: "BTC/USDT", Profit: profit},
+			Enter:  &ormo.ExOrder{CreateAt: startMS, Average: 100, Filled: 1},
+			Exit:   &ormo.ExOrder{CreateAt: startMS + 3600000, Average: 100 + profit, Filled: 1},
+		})
+		startMS += 86400000
+	}
+	outDir := t.TempDir()
+	cfg := &MCConfig{Runs: 200, SkipPct: 0.1, Slip: 0.001, Seed: 1}
+	if err := MonteCarloOrders(orders, 1000, cfg, outDir); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"monte_carlo.csv", "mc_bootstrap_final_equity.html", "mc_shuffle_recovery_days.html"} {
+		if _, err_ := os.Stat(filepath.Join(outDir, name)); err_ != nil {
+			t.Fatal(err_)
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package opt
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/banbox/banbot/btime"
+	"github.com/banbox/banbot/config"
+	"github.com/banbox/banbot/core"
+	"github.com/banbox/banexg/errs"
+	"github.com/banbox/banexg/log"
+	"github.com/c-bata/goptuna"
+	"go.uber.org/zap"
+)
+
+const (
+	optDoneMark     = "# optimize done"
+	optFinishedMark = "# optimize finished"
+	optWeightsMark  = "# objective weights:"
+)
+
+/*
+optSection
+Trials of an optimized policy (or the union of long/short) parsed from an existing opt log
+从已有优化日志解析的一个策略(或多空联合)的试验结果
+*/
+type optSection struct {
+	Title   string
+	Union   bool
+	Batches [][]*OptInfo // trials split by objective weights 按目标权重划分的试验
+	Lines   []string     // raw trial lines of union 多空联合的原始试验行
+	Picker  string
+	Best    *OptInfo // result recalculated by picker 选择器重新计算的结果
+	Done    bool
+}
+
+/*
+optResume
+Replay state of an interrupted optimize. The same policy groups are optimized in the same order,
+each optForPol call consumes the next section: finished ones are reused without backtest,
+the unfinished one warm-starts the sampler with its trials and runs the remaining rounds.
+中断优化的重放状态。相同策略组按相同顺序优化，每次optForPol消费下一个部分：
+已完成的直接复用无需回测，未完成的用已有试验热启动采样器并运行剩余轮次。
+*/
+type optResume struct {
+	sections []*optSection
+	pos      int
+}
+
+var optResumes *optResume
+
+func (s *optSection) lastBatch() []*OptInfo {
+	if len(s.Batches) == 0 {
+		s.Batches = append(s.Batches, nil)
+	}
+	return s.Batches[len(s.Batches)-1]
+}
+
+// optLogHead the head lines of opt log, resume only when unchanged 优化日志的头部行，不变时才可恢复
+func optLogHead(args *config.CmdArgs) string {
+	startDt := btime.ToDateStr(config.TimeRange.StartMS, "")
+	endDt := btime.ToDateStr(config.TimeRange.EndMS, "")
+	return fmt.Sprintf("# run hyper optimize: %v, rounds: %v\n# date range: %v - %v\n", args.Sampler,
+		args.OptRounds, startDt, endDt)
+}
+
+/*
+loadOptResume
+Parse an opt log written by an interrupted optimize. Return whether it's finished, and the replay state if not
+解析中断的优化写入的日志。返回是否已完成，未完成时返回重放状态
+*/
+func loadOptResume(path, head string) (bool, *optResume) {
+	data, err_ := os.ReadFile(path)
+	if err_ != nil || !strings.HasPrefix(string(data), head) {
+		return false, nil
+	}
+	var res = &optResume{}
+	var cur *optSection
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "==============") && strings.HasSuffix(line, "=============") {
+			cur = &optSection{Title: strings.Split(line, " ")[1]}
+			res.sections = append(res.sections, cur)
+		} else if strings.HasPrefix(line, "========== union") {
+			cur = &optSection{Title: "union", Union: true}
+			res.sections = append(res.sections, cur)
+		} else if line == optFinishedMark {
+			return true, nil
+		} else if cur == nil {
+			continue
+		} else if line == optDoneMark {
+			cur.Done = true
+		} else if strings.HasPrefix(line, optWeightsMark) {
+			cur.Batches = append(cur.Batches, nil)
+		} else if strings.HasPrefix(line, "loss:") {
+			cur.lastBatch()
+			idx := len(cur.Batches) - 1
+			cur.Batches[idx] = append(cur.Batches[idx], parseOptLine(line))
+			cur.Lines = append(cur.Lines, line)
+		} else if strings.HasPrefix(line, "[") && strings.Contains(line, "loss:") {
+			cur.Picker = line[1:strings.IndexRune(line, ']')]
+			cur.Best = parseOptLine(line[strings.Index(line, "loss:"):])
+			cur.Done = true
+		}
+	}
+	if len(res.sections) == 0 {
+		return false, nil
+	}
+	// sections followed by others are finished 后面还有其他部分的已完成
+	for _, sec := range res.sections[:len(res.sections)-1] {
+		sec.Done = true
+	}
+	log.Warn("resume optimize from log", zap.String("path", path), zap.Int("sections", len(res.sections)))
+	return false, res
+}
+
+/*
+isOptLogFinished
+Whether the opt log is written completely. Logs written before resume is supported have none of
+the done, weights or finished marks, take them as finished
+优化日志是否已完整写入。支持恢复之前写入的日志没有完成、权重或结束标记，视为已完成
+*/
+func isOptLogFinished(path string) bool {
+	data, err_ := os.ReadFile(path)
+	if err_ != nil {
+		return false
+	}
+	text := string(data)
+	if strings.Contains(text, "\n"+optFinishedMark+"\n") {
+		return true
+	}
+	return !strings.Contains(text, optDoneMark) && !strings.Contains(text, optWeightsMark)
+}
+
+/*
+next
+Consume the next section if matches. Once a mismatch is found, the rest are discarded since the config changed
+如果匹配则消费下一个部分。一旦不匹配，说明配置已变化，丢弃剩余部分
+*/
+func (r *optResume) next(title string, union bool) *optSection {
+	if r == nil || r.pos >= len(r.sections) {
+		return nil
+	}
+	sec := r.sections[r.pos]
+	if sec.Title != title || sec.Union != union {
+		log.Warn("opt log mismatch, stop resume", zap.String("expect", title), zap.String("got", sec.Title))
+		r.sections = nil
+		return nil
+	}
+	r.pos += 1
+	return sec
+}
+
+/*
+warmGOptuna
+Add finished trials to the study, so the sampler continues from them
+将已完成的试验添加到study，使采样器从其继续
+*/
+func warmGOptuna(study *goptuna.Study, params []*core.Param, olds []*OptInfo, losses []float64) *errs.Error {
+	for i, o := range olds {
+		inParams := make(map[string]float64)
+		outParams := make(map[string]interface{})
+		dists := make(map[string]interface{})
+		for _, p := range params {
+			minVal, maxVal := p.OptSpace()
+			val, ok := o.Params[p.Name]
+			if !ok {
+				continue
+			}
+			// values in log are regularized, convert back to optimize space 日志中是规整后的值，转回优化空间
+			val = p.ToOptSpace(val)
+			val = min(maxVal, max(minVal, val))
+			inParams[p.Name] = val
+			outParams[p.Name] = val
+			dists[p.Name] = goptuna.UniformDistribution{Low: minVal, High: maxVal}
+		}
+		_, err_ := study.Storage.CloneTrial(study.ID, goptuna.FrozenTrial{
+			State:              goptuna.TrialStateComplete,
+			Value:              losses[i],
+			InternalParams:     inParams,
+			Params:             outParams,
+			Distributions:      dists,
+			IntermediateValues: map[int]float64{},
+			UserAttrs:          map[string]string{},
+			SystemAttrs:        map[string]string{},
+		})
+		if err_ != nil {
+			return errs.New(errs.CodeRunTime, err_)
+		}
+	}
+	return nil
+}
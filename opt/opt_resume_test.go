@@ -0,0 +1,107 @@
+package opt
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/banbox/banbot/config"
+	"github.com/banbox/banbot/core"
+	"github.com/c-bata/goptuna"
+	"github.com/c-bata/goptuna/tpe"
+)
+
+func TestOptResume(t *testing.T) {
+	config.TimeRange = &config.TimeTuple{StartMS: 1700000000000, EndMS: 1710000000000}
+	args := &config.CmdArgs{Sampler: "tpe", OptRounds: 3}
+	head := optLogHead(args)
+	text := head + "\n============== ma:l/5m/ =============\n" +
+		"loss:  -12.00 \tfast: 5.00, slow: 20.00 \todNum: 10, profit: 12.0%, drawDown: 3.0%, sharpe: 1.20, id: a1\n" +
+		"loss:   -8.00 \tfast: 7.00, slow: 30.00 \todNum: 9, profit: 8.0%, drawDown: 2.0%, sharpe: 1.00, id: a2\n" +
+		"[good3] loss:  -10.00 \tfast: 6.00, slow: 25.00 \todNum: 9, profit: 10.0%, drawDown: 2.0%, sharpe: 1.1, id: a3\n" +
+		optDoneMark + "\n\n========== union long/short ============\n" +
+		"loss: -20.00 \todNum: 19, profit: 20.0%, drawDown: 4.0%, sharpe: 1.50\n" +
+		"\n============== ma:s/5m/ =============\n" +
+		"loss:   -3.00 \tfast: 4.00, slow: 22.00 \todNum: 5, profit: 3.0%, drawDown: 1.0%, sharpe: 0.50, id: b1\n"
+	path := filepath.Join(t.TempDir(), "opt.log")
+	if err_ := os.WriteFile(path, []byte(text), 0644); err_ != nil {
+		t.Fatal(err_)
+	}
+	if finished, res := loadOptResume(path, optLogHead(&config.CmdArgs{Sampler: "bayes", OptRounds: 3})); finished || res != nil {
+		t.Fatal("changed head should not resume")
+	}
+	finished, res := loadOptResume(path, head)
+	if finished || res == nil || len(res.sections) != 3 {
+		t.Fatalf("bad resume: %v %v", finished, res)
+	}
+	long := res.next("ma:l/5m/", false)
+	if !long.Done || len(long.Batches[0]) != 2 || long.Picker != "good3" || long.Best.Params["fast"] != 6 {
+		t.Fatalf("bad long section: %+v", long)
+	}
+	union := res.next("union", true)
+	if union == nil || union.Batches[0][0].Score != 20 || union.Batches[0][0].OrderNum != 19 {
+		t.Fatalf("bad union section: %+v", union)
+	}
+	short := res.next("ma:s/5m/", false)
+	if short.Done || len(short.Batches[0]) != 1 || short.Batches[0][0].Params["slow"] != 22 {
+		t.Fatalf("last section should be unfinished: %+v", short)
+	}
+	if res.next("ma/5m/", false) != nil {
+		t.Fatal("no more sections")
+	}
+	if err_ := os.WriteFile(path, []byte(text+"\n"+optFinishedMark+"\n"), 0644); err_ != nil {
+		t.Fatal(err_)
+	}
+	if finished, _ = loadOptResume(path, head); !finished || !isOptLogFinished(path) {
+		t.Fatal("expect finished log")
+	}
+	if isOptLogFinished(filepath.Join(filepath.Dir(path), "none.log")) {
+		t.Fatal("missing log should not be finished")
+	}
+	// logs without marks are written before resume is supported, take them as finished
+	oldText := "# run hyper optimize: tpe, rounds: 3\n# date range: 2023-11-14 22:13:20 - 2024-03-09 16:00:00\n" +
+		"\n============== ma:l/5m/ =============\n" +
+		"loss:  -12.00 \tfast: 5.00, slow: 20.00 \todNum: 10, profit: 12.0%, drawDown: 3.0%, sharpe: 1.20, id: a1\n" +
+		"[good3] loss:  -10.00 \tfast: 6.00, slow: 25.00 \todNum: 9, profit: 10.0%, drawDown: 2.0%, sharpe: 1.1, id: a3\n"
+	if err_ := os.WriteFile(path, []byte(oldText), 0644); err_ != nil {
+		t.Fatal(err_)
+	}
+	if !isOptLogFinished(path) {
+		t.Fatal("log without marks should be finished")
+	}
+	if err_ := os.WriteFile(path, []byte(text), 0644); err_ != nil {
+		t.Fatal(err_)
+	}
+	if isOptLogFinished(path) {
+		t.Fatal("interrupted log should not be finished")
+	}
+	weightText := head + "\n============== ma:l/5m/ =============\n" + optWeightsMark + " sharpe: 1.00\n" +
+		"loss:  -12.00 \tfast: 5.00, slow: 20.00 \todNum: 10, profit: 12.0%, drawDown: 3.0%, sharpe: 1.20, id: a1\n"
+	if err_ := os.WriteFile(path, []byte(weightText), 0644); err_ != nil {
+		t.Fatal(err_)
+	}
+	if isOptLogFinished(path) {
+		t.Fatal("interrupted log with weights should not be finished")
+	}
+	// warm start the sampler with finished trials
+	params := []*core.Param{
+		{Name: "fast", VType: core.VTypeUniform, Min: 2, Max: 10},
+		{Name: "slow", VType: core.VTypeNorm, Min: 10, Max: 40, Mean: 20, Rate: 1},
+	}
+	norm := params[1]
+	if v, _ := norm.ToRegular(norm.ToOptSpace(30)); math.Abs(v-30) > 1e-3 {
+		t.Fatalf("ToOptSpace should be the inverse of ToRegular, got %v", v)
+	}
+	study, err_ := goptuna.CreateStudy("test", goptuna.StudyOptionSampler(tpe.NewSampler()))
+	if err_ != nil {
+		t.Fatal(err_)
+	}
+	if err := warmGOptuna(study, params, long.Batches[0], []float64{-12, -8}); err != nil {
+		t.Fatal(err)
+	}
+	best, err_ := study.GetBestValue()
+	if err_ != nil || best != -12 {
+		t.Fatalf("expect warm trials in study, best: %v, err: %v", best, err_)
+	}
+}
@@ -0,0 +1,321 @@
+package opt
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/banbox/banbot/utils"
+	"github.com/banbox/banexg/errs"
+	"github.com/olekukonko/tablewriter"
+)
+
+/*
+OptObjective
+An objective of multi-objective hyperparameter optimization, larger Get is better.
+Norm maps the value to a fixed scale where Ideal is an excellent result, used by Chebyshev scalarization during optimization.
+多目标超参数优化的一个目标，Get越大越好。
+Norm将值映射到固定尺度，Ideal表示优秀结果，用于优化时的切比雪夫标量化。
+*/
+type OptObjective struct {
+	Name  string
+	Get   func(r *BTResult) float64
+	Norm  func(v float64) float64
+	Ideal float64
+}
+
+var (
+	OptObjectives = map[string]*OptObjective{
+		"profit": {Name: "profit", Ideal: 1,
+			Get:  func(r *BTResult) float64 { return r.TotProfitPct },
+			Norm: func(v float64) float64 { return v / 100 }},
+		"drawdown": {Name: "drawdown", Ideal: 0,
+			Get:  func(r *BTResult) float64 { return -r.ShowDrawDownPct },
+			Norm: func(v float64) float64 { return v / 100 }},
+		"odnum": {Name: "odnum", Ideal: 1,
+			Get:  func(r *BTResult) float64 { return float64(r.OrderNum) },
+			Norm: func(v float64) float64 { return math.Log10(1+v) / 3 }},
+		"sharpe": {Name: "sharpe", Ideal: 1,
+			Get:  func(r *BTResult) float64 { return r.SharpeRatio },
+			Norm: func(v float64) float64 { return v / 3 }},
+	}
+	defObjectives = []string{"profit", "drawdown", "odnum", "sharpe"}
+	// objectives and weights set by `-objectives`, empty means single objective 由`-objectives`设置的目标和权重，为空表示单目标
+	optObjs    []*OptObjective
+	optWeights []float64
+	optFixWeit bool // weights are given explicitly 是否显式指定了权重
+)
+
+const chebyRho = 0.05
+
+/*
+SetOptObjectives
+Parse objectives like `profit:2,drawdown,sharpe`, the weight defaults to 1.
+Enables multi-objective optimization and the `pareto` picker.
+解析`profit:2,drawdown,sharpe`形式的目标，权重默认为1。启用多目标优化和`pareto`选择器。
+*/
+func SetOptObjectives(text string) *errs.Error {
+	optObjs, optWeights, optFixWeit = nil, nil, false
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil
+	}
+	var objs []*OptObjective
+	var weights []float64
+	var sumWeit float64
+	fixWeit := false
+	for _, item := range strings.Split(text, ",") {
+		arr := strings.Split(strings.TrimSpace(item), ":")
+		obj, ok := OptObjectives[arr[0]]
+		if !ok {
+			return errs.NewMsg(errs.CodeParamInvalid, "unknown objective: %v, valid: %v", arr[0],
+				strings.Join(defObjectives, ","))
+		}
+		weight := float64(1)
+		if len(arr) > 1 {
+			val, err_ := strconv.ParseFloat(arr[1], 64)
+			if err_ != nil || val <= 0 {
+				return errs.NewMsg(errs.CodeParamInvalid, "invalid objective weight: %v", item)
+			}
+			weight = val
+			fixWeit = true
+		}
+		objs = append(objs, obj)
+		weights = append(weights, weight)
+		sumWeit += weight
+	}
+	for i := range weights {
+		weights[i] /= sumWeit
+	}
+	optObjs, optWeights, optFixWeit = objs, weights, fixWeit
+	return nil
+}
+
+// getObjectives return objectives and weights for pareto, default all with equal weights 返回用于帕累托的目标和权重，默认全部等权
+func getObjectives() ([]*OptObjective, []float64) {
+	if len(optObjs) > 0 {
+		return optObjs, optWeights
+	}
+	objs := make([]*OptObjective, 0, len(defObjectives))
+	weights := make([]float64, 0, len(defObjectives))
+	for _, name := range defObjectives {
+		objs = append(objs, OptObjectives[name])
+		weights = append(weights, 1/float64(len(defObjectives)))
+	}
+	return objs, weights
+}
+
+/*
+moWeightList
+Weight vectors for multi-objective optimization. The rounds are split across them to cover different parts of the Pareto front.
+When weights are given explicitly, only use them.
+多目标优化的权重向量列表。优化轮次在其间均分，以覆盖帕累托前沿的不同部分。显式指定权重时仅使用该权重。
+*/
+func moWeightList() [][]float64 {
+	if len(optObjs) == 0 {
+		return nil
+	}
+	res := [][]float64{optWeights}
+	if optFixWeit || len(optObjs) == 1 {
+		return res
+	}
+	num := float64(len(optObjs))
+	for i := range optObjs {
+		weights := make([]float64, len(optObjs))
+		for j := range weights {
+			if i == j {
+				weights[j] = 0.55
+			} else {
+				weights[j] = 0.45 / (num - 1)
+			}
+		}
+		res = append(res, weights)
+	}
+	return res
+}
+
+/*
+chebyshevLoss
+Augmented weighted Chebyshev distance to the ideal point on fixed scales, smaller is better
+在固定尺度上到理想点的增强加权切比雪夫距离，越小越好
+*/
+func chebyshevLoss(r *BTResult, weights []float64) float64 {
+	var maxVal, sumVal float64
+	for i, obj := range optObjs {
+		dist := weights[i] * (obj.Ideal - obj.Norm(obj.Get(r)))
+		if i == 0 || dist > maxVal {
+			maxVal = dist
+		}
+		sumVal += dist
+	}
+	return maxVal + chebyRho*sumVal
+}
+
+func objVector(r *BTResult, objs []*OptObjective) []float64 {
+	res := make([]float64, len(objs))
+	for i, obj := range objs {
+		res[i] = obj.Get(r)
+	}
+	return res
+}
+
+// dominates whether a is not worse than b on all objectives and better on one a是否在所有目标上不差于b且至少一个更好
+func dominates(a, b []float64) bool {
+	better := false
+	for i, v := range a {
+		if v < b[i] {
+			return false
+		}
+		if v > b[i] {
+			better = true
+		}
+	}
+	return better
+}
+
+/*
+ParetoFront
+Return non-dominated items on the given objectives, keeping the input order
+返回在给定目标上非支配的项，保持输入顺序
+*/
+func ParetoFront(items []*OptInfo, objs []*OptObjective) []*OptInfo {
+	vecs := make([][]float64, 0, len(items))
+	for _, it := range items {
+		vecs = append(vecs, objVector(it.BTResult, objs))
+	}
+	var res []*OptInfo
+	for i, it := range items {
+		dominated := false
+		for j, vec := range vecs {
+			if i != j && dominates(vec, vecs[i]) {
+				dominated = true
+				break
+			}
+		}
+		if !dominated {
+			res = append(res, it)
+		}
+	}
+	return res
+}
+
+/*
+optPareto
+Pick from the Pareto front the item with the smallest weighted Chebyshev distance to the ideal point,
+objectives are min-max normalized over the front. Change the weights of `-objectives` to move along the front.
+从帕累托前沿中选择到理想点加权切比雪夫距离最小的项，目标在前沿内做最小最大归一化。修改`-objectives`的权重可沿前沿移动。
+*/
+func optPareto(items []*OptInfo) *OptInfo {
+	var valids = make([]*OptInfo, 0, len(items))
+	for _, it := range items {
+		if it.BTResult != nil {
+			valids = append(valids, it)
+		}
+	}
+	objs, weights := getObjectives()
+	front := ParetoFront(valids, objs)
+	if len(front) == 0 {
+		return nil
+	}
+	mins := make([]float64, len(objs))
+	maxs := make([]float64, len(objs))
+	vecs := make([][]float64, 0, len(front))
+	for i, it := range front {
+		vec := objVector(it.BTResult, objs)
+		for j, v := range vec {
+			if i == 0 || v < mins[j] {
+				mins[j] = v
+			}
+			if i == 0 || v > maxs[j] {
+				maxs[j] = v
+			}
+		}
+		vecs = append(vecs, vec)
+	}
+	var best *OptInfo
+	bestDist := math.Inf(1)
+	for i, vec := range vecs {
+		var maxVal, sumVal float64
+		for j, v := range vec {
+			span := maxs[j] - mins[j]
+			if span == 0 {
+				continue
+			}
+			dist := weights[j] * (maxs[j] - v) / span
+			maxVal = max(maxVal, dist)
+			sumVal += dist
+		}
+		dist := maxVal + chebyRho*sumVal
+		if dist < bestDist {
+			best, bestDist = front[i], dist
+		}
+	}
+	return best
+}
+
+/*
+printParetoFronts
+Print the Pareto-optimal set of each section in the opt logs
+输出优化日志中每个部分的帕累托最优集
+*/
+func printParetoFronts(paths []string) (string, *errs.Error) {
+	objs, _ := getObjectives()
+	var b strings.Builder
+	for _, path := range paths {
+		fdata, err_ := os.ReadFile(path)
+		if err_ != nil {
+			return "", errs.New(errs.CodeIOReadFail, err_)
+		}
+		var title string
+		var items []*OptInfo
+		flush := func() {
+			if len(items) > 0 {
+				b.WriteString(fmt.Sprintf("# pareto %s %s\n", path, title))
+				b.WriteString(textParetoFront(ParetoFront(items, objs), objs))
+			}
+			items = nil
+		}
+		for _, line := range strings.Split(string(fdata), "\n") {
+			if strings.HasPrefix(line, "loss:") && title != "" {
+				items = append(items, parseOptLine(line))
+			} else if strings.HasPrefix(line, "========== union") {
+				// union of long/short has no params, skip 多空联合没有参数，跳过
+				flush()
+				title = ""
+			} else if strings.HasPrefix(line, "==========") {
+				flush()
+				title = strings.Trim(line, "= ")
+			}
+		}
+		flush()
+	}
+	return b.String(), nil
+}
+
+func textParetoFront(front []*OptInfo, objs []*OptObjective) string {
+	var b bytes.Buffer
+	table := tablewriter.NewWriter(&b)
+	heads := []string{"ID", "Score"}
+	for _, obj := range objs {
+		heads = append(heads, obj.Name)
+	}
+	heads = append(heads, "Params")
+	table.SetHeader(heads)
+	table.SetBorders(tablewriter.Border{Left: true, Top: false, Right: true, Bottom: false})
+	table.SetCenterSeparator("|")
+	table.SetAlignment(tablewriter.ALIGN_RIGHT)
+	table.SetAutoWrapText(false)
+	for _, it := range front {
+		row := []string{it.ID, strconv.FormatFloat(it.Score, 'f', 2, 64)}
+		for _, v := range objVector(it.BTResult, objs) {
+			row = append(row, strconv.FormatFloat(v, 'f', 2, 64))
+		}
+		row = append(row, utils.MapToStr(it.Params, true, 2))
+		table.Append(row)
+	}
+	table.Render()
+	return b.String()
+}
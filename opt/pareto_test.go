@@ -0,0 +1,64 @@
+package opt
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParetoFront(t *testing.T) {
+	if err := SetOptObjectives("profit,drawdown"); err != nil {
+		t.Fatal(err)
+	}
+	defer SetOptObjectives("")
+	if len(moWeightList()) != 3 {
+		t.Fatalf("expect 3 weight vectors, got %v", moWeightList())
+	}
+	newItem := func(id string, profit, dd float64) *OptInfo {
+		return &OptInfo{ID: id, Score: profit, Params: map[string]float64{"a": profit},
+			BTResult: &BTResult{TotProfitPct: profit, ShowDrawDownPct: dd, OrderNum: 10}}
+	}
+	items := []*OptInfo{newItem("a", 50, 30), newItem("b", 30, 10), newItem("c", 20, 20), newItem("d", 45, 12)}
+	front := ParetoFront(items, optObjs)
+	ids := make([]string, 0, len(front))
+	for _, it := range front {
+		ids = append(ids, it.ID)
+	}
+	if strings.Join(ids, ",") != "a,b,d" {
+		t.Fatalf("bad pareto front: %v", ids)
+	}
+	if best := optPareto(items); best.ID != "d" {
+		t.Fatalf("expect knee d, got %v", best.ID)
+	}
+	// move along the front by weights
+	if err := SetOptObjectives("profit:10,drawdown:1"); err != nil {
+		t.Fatal(err)
+	}
+	if best := optPareto(items); best.ID != "a" {
+		t.Fatalf("expect a for profit weighted, got %v", best.ID)
+	}
+	if len(moWeightList()) != 1 {
+		t.Fatal("explicit weights should use a single vector")
+	}
+	if chebyshevLoss(items[3].BTResult, optWeights) >= chebyshevLoss(items[2].BTResult, optWeights) {
+		t.Fatal("dominating item should have smaller loss")
+	}
+	if err := SetOptObjectives("profit,bad"); err == nil {
+		t.Fatal("expect error for unknown objective")
+	}
+	text := "# run hyper optimize: tpe, rounds: 3\n# date range: a - b\n\n============== ma/5m/ =============\n" +
+		"loss:  -50.00 \ta: 50 \todNum: 10, profit: 50.0%, drawDown: 30.0%, sharpe: 1.00, id: a\n" +
+		"loss:  -20.00 \ta: 20 \todNum: 10, profit: 20.0%, drawDown: 20.0%, sharpe: 1.00, id: c\n"
+	path := filepath.Join(t.TempDir(), "opt.log")
+	if err_ := os.WriteFile(path, []byte(text), 0644); err_ != nil {
+		t.Fatal(err_)
+	}
+	out, err := printParetoFronts([]string{path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "ma/5m/") || !strings.Contains(out, "a: 50.00") || !strings.Contains(out, "a: 20.00") {
+		t.Fatalf("bad pareto output:\n%s", out)
+	}
+}
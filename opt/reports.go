@@ -60,6 +60,7 @@ type BTResult struct {
 	TotProfit       float64    `json:"totProfit"`
 	TotCost         float64    `json:"totCost"`
 	TotFee          float64    `json:"totFee"`
+	TotFunding      float64    `json:"totFunding"`
 	TotProfitPct    float64    `json:"totProfitPct"`
 	WinRatePct      float64    `json:"winRatePct"`
 	SharpeRatio     float64    `json:"sharpeRatio"`
@@ -176,6 +177,7 @@ func (r *BTResult) Collect() {
 	r.OrderNum = len(orders)
 	sumProfit := float64(0)
 	sumFee := float64(0)
+	sumFunding := float64(0)
 	sumCost := float64(0)
 	winCount := float64(0)
 	for _, od := range orders {
@@ -184,6 +186,7 @@ func (r *BTResult) Collect() {
 		if od.Exit != nil {
 			sumFee += od.Exit.Fee
 		}
+		sumFunding += od.GetInfoFloat64(ormo.OdInfoFunding)
 		sumCost += od.EnterCost() / od.Leverage
 		if od.Profit > 0 {
 			winCount += 1
@@ -192,6 +195,7 @@ func (r *BTResult) Collect() {
 	r.TotProfit = sumProfit
 	r.TotCost = utils.NanInfTo(sumCost, 0)
 	r.TotFee = sumFee
+	r.TotFunding = sumFunding
 	r.TotProfitPct = r.TotProfit * 100 / r.TotalInvest
 	if r.MinReal > r.MaxReal {
 		r.MinReal = r.MaxReal
@@ -237,6 +241,9 @@ func (r *BTResult) textMetrics(orders []*ormo.InOutOrder) string {
 	totProfitPct := strconv.FormatFloat(r.TotProfitPct, 'f', 1, 64)
 	table.Append([]string{"Total Profit %", totProfitPct + "%"})
 	table.Append([]string{"Total Fee", strconv.FormatFloat(r.TotFee, 'f', 2, 64)})
+	if core.IsContract {
+		table.Append([]string{"Total Funding", strconv.FormatFloat(r.TotFunding, 'f', 2, 64)})
+	}
 	avfProfit := strconv.FormatFloat(r.TotProfitPct*100/float64(len(orders)), 'f', 2, 64)
 	table.Append([]string{"Avg Profit %%", avfProfit + "%%"})
 	table.Append([]string{"Total Cost", strconv.FormatFloat(r.TotCost, 'f', 2, 64)})
@@ -642,7 +649,7 @@ func DumpOrdersCSV(orders []*ormo.InOutOrder, outPath string) error {
 	defer writer.Flush()
 	heads := []string{"sid", "symbol", "timeframe", "direction", "leverage", "entAt", "entTag", "entPrice",
 		"entAmount", "entCost", "entFee", "exitAt", "exitTag", "exitPrice", "exitAmount", "exitGot",
-		"exitFee", "maxPftRate", "maxDrawDown", "profitRate", "profit", "strategy"}
+		"exitFee", "funding", "maxPftRate", "maxDrawDown", "profitRate", "profit", "strategy", "legs"}
 	if err_ = writer.Write(heads); err_ != nil {
 		return err_
 	}
@@ -667,11 +674,20 @@ func DumpOrdersCSV(orders []*ormo.InOutOrder, outPath string) error {
 		if od.Exit != nil {
 			row[13], row[14], row[15], row[16] = calcExOrder(od.Exit)
 		}
-		row[17] = strconv.FormatFloat(od.MaxPftRate, 'f', 4, 64)
-		row[18] = strconv.FormatFloat(od.MaxDrawDown, 'f', 4, 64)
-		row[19] = strconv.FormatFloat(od.ProfitRate, 'f', 4, 64)
-		row[20] = strconv.FormatFloat(od.Profit, 'f', 8, 64)
-		row[21] = od.Strategy
+		row[17] = strconv.FormatFloat(od.GetInfoFloat64(ormo.OdInfoFunding), 'f', 8, 64)
+		row[18] = strconv.FormatFloat(od.MaxPftRate, 'f', 4, 64)
+		row[19] = strconv.FormatFloat(od.MaxDrawDown, 'f', 4, 64)
+		row[20] = strconv.FormatFloat(od.ProfitRate, 'f', 4, 64)
+		row[21] = strconv.FormatFloat(od.Profit, 'f', 8, 64)
+		row[22] = od.Strategy
+		// number of filled entries, including added legs 已成交的入场次数，包含加仓
+		legNum := 1
+		for _, leg := range od.GetLegs() {
+			if leg.Filled > 0 {
+				legNum += 1
+			}
+		}
+		row[23] = strconv.Itoa(legNum)
 		if err_ = writer.Write(row); err_ != nil {
 			return err_
 		}
@@ -118,7 +118,7 @@ func CompareExgBTOrders(args []string) error {
 		return err_
 	}
 	for _, iod := range btOrders {
-		tfMSecs := int64(utils2.TFToSecs(iod.Timeframe) * 1000)
+		tfMSecs := int64(core.TFToSecs(iod.Timeframe) * 1000)
 		tfMSecsFlt := float64(tfMSecs)
 		entFixMS := utils2.AlignTfMSecs(iod.RealEnterMS(), tfMSecs)
 		exgOds, _ := pairExgOds[iod.Symbol]
@@ -308,7 +308,7 @@ func readBackTestOrders(path string) ([]*ormo.InOutOrder, map[string]int, int64,
 	var maxTfSecs int
 	var pairNums = make(map[string]int)
 	for _, od := range orders {
-		tfSecs := utils2.TFToSecs(od.Timeframe)
+		tfSecs := core.TFToSecs(od.Timeframe)
 		if tfSecs > maxTfSecs {
 			maxTfSecs = tfSecs
 		}
@@ -0,0 +1,289 @@
+package opt
+
+import (
+	"math"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/banbox/banbot/btime"
+	"github.com/banbox/banbot/config"
+	"github.com/banbox/banbot/core"
+	"github.com/banbox/banbot/orm/ormo"
+	"github.com/banbox/banbot/utils"
+	"github.com/banbox/banexg/errs"
+	"github.com/banbox/banexg/log"
+	utils2 "github.com/banbox/banexg/utils"
+	"go.uber.org/zap"
+	"gonum.org/v1/gonum/floats"
+)
+
+/*
+wfWindow
+Result of one walk-forward window: params optimized on the review period (in-sample),
+then backtested on the following run period (out-of-sample)
+滚动优化的一个窗口结果：在回顾期(样本内)优化的参数，在之后运行期(样本外)的回测表现
+*/
+type wfWindow struct {
+	StartMS     int64 // out-of-sample start 样本外开始时间
+	EndMS       int64
+	Params      map[string]float64 // key: policy.param
+	ISScore     float64
+	OOSScore    float64
+	OrderNum    int
+	Profit      float64
+	ProfitPct   float64
+	DrawDownPct float64
+	Sharpe      float64
+}
+
+type walkForward struct {
+	reviewMSecs int64
+	stake       float64 // wallet value at start of current window 当前窗口开始时钱包价值
+	windows     []*wfWindow
+}
+
+func newWalkForward(reviewMSecs int64) *walkForward {
+	stake := float64(0)
+	for key, val := range config.WalletAmounts {
+		stake += val * core.GetPriceSafe(key)
+	}
+	return &walkForward{reviewMSecs: reviewMSecs, stake: stake}
+}
+
+/*
+parsePolScores
+Read the `# score: x` comment of each group from the output of collectOptLog, return in the order of policies
+从collectOptLog的输出中读取每组的`# score: x`注释，按策略顺序返回
+*/
+func parsePolScores(polStr string) []float64 {
+	var res []float64
+	var score float64
+	for _, line := range strings.Split(polStr, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "# score:") {
+			val, err_ := strconv.ParseFloat(strings.TrimSpace(line[len("# score:"):]), 64)
+			if err_ == nil {
+				score = val
+			}
+		} else if strings.HasPrefix(line, "- name:") {
+			res = append(res, score)
+		}
+	}
+	return res
+}
+
+/*
+add
+Record a window after backtest. pols are the applied policies, ods are orders closed in this window
+回测后记录一个窗口。pols是实际应用的策略，ods是此窗口平仓的订单
+*/
+func (w *walkForward) add(startMS, endMS int64, pols []*config.RunPolicyConfig, isScores []float64, ods []*ormo.InOutOrder) {
+	win := &wfWindow{StartMS: startMS, EndMS: endMS, Params: make(map[string]float64), OrderNum: len(ods)}
+	for _, pol := range pols {
+		name := strings.TrimRight(pol.Key(), "/")
+		for k, v := range pol.Params {
+			win.Params[name+"."+k] = v
+		}
+	}
+	if len(isScores) > 0 {
+		win.ISScore = utils.NanInfTo(floats.Sum(isScores)/float64(len(isScores)), 0)
+	}
+	win.Profit, win.DrawDownPct, win.Sharpe = calcWindowPerf(ods, startMS, endMS, w.stake)
+	if w.stake > 0 {
+		win.ProfitPct = win.Profit / w.stake * 100
+	}
+	// same as BTResult.Score 和BTResult.Score相同
+	win.OOSScore = win.ProfitPct
+	if win.ProfitPct > 0 {
+		win.OOSScore = win.ProfitPct * math.Pow(1-win.DrawDownPct/100, 1.5)
+	}
+	w.stake += win.Profit
+	w.windows = append(w.windows, win)
+}
+
+/*
+calcWindowPerf
+Calculate profit, max drawdown pct and sharpe of orders in a window, based on daily returns
+基于日收益计算窗口内订单的利润、最大回撤百分比和夏普
+*/
+func calcWindowPerf(ods []*ormo.InOutOrder, startMS, endMS int64, stake float64) (float64, float64, float64) {
+	var profit float64
+	for _, od := range ods {
+		profit += od.Profit
+	}
+	if len(ods) == 0 || stake <= 0 {
+		return profit, 0, 0
+	}
+	dayMSecs := int64(utils2.TFToSecs("1d") * 1000)
+	startMS = utils2.AlignTfMSecs(startMS, dayMSecs)
+	endMS = utils2.AlignTfMSecs(endMS-1, dayMSecs) + dayMSecs
+	returns, _, _ := ormo.CalcUnitReturns(ods, nil, startMS, endMS, dayMSecs)
+	equity, peak, drawDown := stake, stake, float64(0)
+	retRates := make([]float64, len(returns))
+	for i, ret := range returns {
+		retRates[i] = ret / equity
+		equity += ret
+		peak = max(peak, equity)
+		drawDown = max(drawDown, (peak-equity)/peak)
+	}
+	var sharpe float64
+	if len(retRates) > 2 {
+		var err *errs.Error
+		sharpe, _, err = calcMeasures(retRates, 365)
+		if err != nil {
+			log.Warn("calc window sharpe fail", zap.Error(err))
+		}
+	}
+	return profit, drawDown * 100, utils.NanInfTo(sharpe, 0)
+}
+
+/*
+efficiency
+Walk-forward efficiency: out-of-sample score per day divided by in-sample score per day.
+A value far below 0.5 usually means the strategy is overfit.
+滚动优化效率：样本外每日得分除以样本内每日得分。远低于0.5通常意味着策略过拟合。
+*/
+func (w *walkForward) efficiency(isScore, oosScore float64, runDays float64) float64 {
+	reviewDays := float64(w.reviewMSecs) / float64(utils2.SecsDay*1000)
+	if isScore <= 0 || runDays <= 0 || reviewDays <= 0 {
+		return math.NaN()
+	}
+	return (oosScore / runDays) / (isScore / reviewDays)
+}
+
+type paramStability struct {
+	Name string
+	Num  int
+	Mean float64
+	Std  float64
+	CV   float64 // coefficient of variation 变异系数
+}
+
+/*
+stability
+Calculate mean, std and coefficient of variation of each param across windows. Larger CV means less stable.
+计算每个参数在各窗口间的均值、标准差和变异系数。变异系数越大越不稳定。
+*/
+func (w *walkForward) stability() []*paramStability {
+	var vals = make(map[string][]float64)
+	for _, win := range w.windows {
+		for k, v := range win.Params {
+			vals[k] = append(vals[k], v)
+		}
+	}
+	res := make([]*paramStability, 0, len(vals))
+	for name, arr := range vals {
+		mean := floats.Sum(arr) / float64(len(arr))
+		var sumSq float64
+		for _, v := range arr {
+			sumSq += (v - mean) * (v - mean)
+		}
+		std := math.Sqrt(sumSq / float64(len(arr)))
+		cv := float64(0)
+		if mean != 0 {
+			cv = std / math.Abs(mean)
+		} else if std > 0 {
+			cv = math.Inf(1)
+		}
+		res = append(res, &paramStability{Name: name, Num: len(arr), Mean: mean, Std: std, CV: cv})
+	}
+	slices.SortFunc(res, func(a, b *paramStability) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return res
+}
+
+/*
+dump
+Write walk_forward.csv, walk_forward_params.csv and walk_forward.html to outDir
+输出walk_forward.csv, walk_forward_params.csv和walk_forward.html到outDir
+*/
+func (w *walkForward) dump(outDir string) *errs.Error {
+	if len(w.windows) == 0 {
+		return nil
+	}
+	fmtFlt := func(v float64, prec int) string {
+		if math.IsNaN(v) {
+			return ""
+		}
+		return strconv.FormatFloat(v, 'f', prec, 64)
+	}
+	stabs := w.stability()
+	head := []string{"start", "end", "is_score", "oos_score", "wfe", "orders", "profit", "profit_pct",
+		"drawdown_pct", "sharpe"}
+	for _, s := range stabs {
+		head = append(head, s.Name)
+	}
+	rows := [][]string{head}
+	num := len(w.windows)
+	labels := make([]string, 0, num)
+	isScores := make([]float64, 0, num)
+	oosScores := make([]float64, 0, num)
+	wfes := make([]float64, 0, num)
+	profits := make([]float64, 0, num)
+	drawDowns := make([]float64, 0, num)
+	var sumIS, sumOOS, sumDays, sumPft float64
+	var odNum int
+	for _, win := range w.windows {
+		runDays := float64(win.EndMS-win.StartMS) / float64(utils2.SecsDay*1000)
+		wfe := w.efficiency(win.ISScore, win.OOSScore, runDays)
+		startStr := btime.ToDateStr(win.StartMS, "2006-01-02 15:04")
+		row := []string{startStr, btime.ToDateStr(win.EndMS, "2006-01-02 15:04"),
+			fmtFlt(win.ISScore, 2), fmtFlt(win.OOSScore, 2), fmtFlt(wfe, 3), strconv.Itoa(win.OrderNum),
+			fmtFlt(win.Profit, 2), fmtFlt(win.ProfitPct, 2), fmtFlt(win.DrawDownPct, 2), fmtFlt(win.Sharpe, 2)}
+		for _, s := range stabs {
+			if v, ok := win.Params[s.Name]; ok {
+				row = append(row, fmtFlt(v, 4))
+			} else {
+				row = append(row, "")
+			}
+		}
+		rows = append(rows, row)
+		labels = append(labels, startStr)
+		isScores = append(isScores, win.ISScore)
+		oosScores = append(oosScores, win.OOSScore)
+		wfes = append(wfes, wfe)
+		profits = append(profits, win.ProfitPct)
+		drawDowns = append(drawDowns, win.DrawDownPct)
+		sumIS += win.ISScore
+		sumOOS += win.OOSScore
+		sumDays += runDays
+		sumPft += win.Profit
+		odNum += win.OrderNum
+	}
+	avgDays := sumDays / float64(num)
+	totWfe := w.efficiency(sumIS/float64(num), sumOOS/float64(num), avgDays)
+	rows = append(rows, []string{"total", "", fmtFlt(sumIS/float64(num), 2), fmtFlt(sumOOS/float64(num), 2),
+		fmtFlt(totWfe, 3), strconv.Itoa(odNum), fmtFlt(sumPft, 2)})
+	err := utils.WriteCsvFile(filepath.Join(outDir, "walk_forward.csv"), rows, false)
+	if err != nil {
+		return err
+	}
+	pmRows := [][]string{{"param", "windows", "mean", "std", "cv"}}
+	for _, s := range stabs {
+		cv := fmtFlt(s.CV, 4)
+		if math.IsInf(s.CV, 1) {
+			cv = "inf"
+		}
+		pmRows = append(pmRows, []string{s.Name, strconv.Itoa(s.Num), fmtFlt(s.Mean, 4), fmtFlt(s.Std, 4), cv})
+	}
+	err = utils.WriteCsvFile(filepath.Join(outDir, "walk_forward_params.csv"), pmRows, false)
+	if err != nil {
+		return err
+	}
+	title := "Walk Forward, WFE: " + fmtFlt(totWfe, 3)
+	err = DumpChart(filepath.Join(outDir, "walk_forward.html"), title, labels, 5, nil, []*ChartDs{
+		{Label: "IS Score", Data: isScores},
+		{Label: "OOS Score", Data: oosScores},
+		{Label: "OOS Profit%", Data: profits, Hidden: true},
+		{Label: "OOS DrawDown%", Data: drawDowns, Hidden: true},
+		{Label: "WFE", Data: wfes, YAxisID: "yRight"},
+	})
+	if err != nil {
+		return err
+	}
+	log.Info("walk forward report", zap.Int("windows", num), zap.Float64("wfe", utils.NanInfTo(totWfe, 0)))
+	return nil
+}
@@ -0,0 +1,58 @@
+package opt
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/banbox/banbot/config"
+	"github.com/banbox/banbot/orm/ormo"
+)
+
+func TestWalkForward(t *testing.T) {
+	polStr := "run_policy:\n\n  # score: 12.50\n  - name: ma\n    run_timeframes: [ 5m ]\n    params: {p: 3}\n" +
+		"\n  # score: 4.00\n  - name: rsi\n    run_timeframes: [ 5m ]\n    dirt: long\n    params: {p: 1}\n" +
+		"  - name: rsi\n    run_timeframes: [ 5m ]\n    dirt: short\n    params: {p: 2}\n"
+	scores := parsePolScores(polStr)
+	if len(scores) != 3 || scores[0] != 12.5 || scores[1] != 4 || scores[2] != 4 {
+		t.Fatalf("bad pol scores: %v", scores)
+	}
+	dayMS := int64(86400000)
+	w := &walkForward{reviewMSecs: 30 * dayMS, stake: 1000}
+	startMS := int64(1700006400000)
+	for i, p := range []float64{10, 20} {
+		pol := &config.RunPolicyConfig{Name: "ma", RunTimeframes: []string{"5m"}, Params: map[string]float64{"p": p}}
+		od := &ormo.InOutOrder{
+			IOrder: &ormo.IOrder{Symbol: "BTC/USDT", Profit: 50},
+			Enter:  &ormo.ExOrder{CreateAt: startMS + dayMS, Average: 100, Filled: 10, Amount: 10},
+			Exit:   &ormo.ExOrder{CreateAt: startMS + 3*dayMS, Average: 105, Filled: 10, Amount: 10},
+		}
+		w.add(startMS, startMS+10*dayMS, []*config.RunPolicyConfig{pol}, []float64{30}, []*ormo.InOutOrder{od})
+		if w.windows[i].ProfitPct <= 0 || w.windows[i].OOSScore <= 0 {
+			t.Fatalf("bad window %v: %+v", i, w.windows[i])
+		}
+		startMS += 10 * dayMS
+	}
+	if w.stake != 1100 || math.Abs(w.windows[0].ProfitPct-5) > 1e-9 {
+		t.Fatalf("bad stake %v or profit pct %v", w.stake, w.windows[0].ProfitPct)
+	}
+	// oos 5%/10days vs is 30/30days
+	wfe := w.efficiency(30, 5, 10)
+	if math.Abs(wfe-0.5) > 1e-9 {
+		t.Fatalf("bad wfe: %v", wfe)
+	}
+	stabs := w.stability()
+	if len(stabs) != 1 || stabs[0].Name != "ma/5m.p" || stabs[0].Mean != 15 || math.Abs(stabs[0].CV-1.0/3) > 1e-9 {
+		t.Fatalf("bad stability: %+v", stabs[0])
+	}
+	outDir := t.TempDir()
+	if err := w.dump(outDir); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"walk_forward.csv", "walk_forward_params.csv", "walk_forward.html"} {
+		if _, err_ := os.Stat(filepath.Join(outDir, name)); err_ != nil {
+			t.Fatal(err_)
+		}
+	}
+}
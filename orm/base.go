@@ -54,6 +54,53 @@ func Setup() *errs.Error {
 		pool.Close()
 		pool = nil
 	}
+	if liteDb != nil {
+		_ = liteDb.Close()
+		liteDb = nil
+	}
+	srcDir, err2 := ParseKlineSource(config.KlineSource)
+	if err2 != nil {
+		return err2
+	}
+	fileSrcDir = ""
+	dbCfg := config.Database
+	if srcDir != "" {
+		// replay klines from a data bundle, no database is required
+		// 从数据包回放K线，无需数据库
+		err2 = setupFileSource(srcDir)
+		if err2 != nil {
+			return err2
+		}
+		log.Info("load kline source ok", zap.String("dir", srcDir))
+	} else if dbCfg == nil {
+		return errs.NewMsg(core.ErrBadConfig, "database config is missing!")
+	} else if litePath, isLite := ParseLiteUrl(dbCfg.Url); isLite {
+		err2 = setupLite(litePath)
+		if err2 != nil {
+			return err2
+		}
+		log.Info("connect sqlite ok", zap.String("path", litePath))
+	} else {
+		err2 = setupPg()
+		if err2 != nil {
+			return err2
+		}
+		log.Info("connect db ok", zap.String("url", utils2.MaskDBUrl(dbCfg.Url)), zap.Int("pool", dbCfg.MaxPoolSize))
+	}
+	err2 = LoadAllExSymbols()
+	if err2 != nil {
+		return err2
+	}
+	ctx := context.Background()
+	sess, conn, err2 := Conn(ctx)
+	if err2 != nil {
+		return err2
+	}
+	defer conn.Release()
+	return sess.UpdatePendingIns()
+}
+
+func setupPg() *errs.Error {
 	var err2 *errs.Error
 	pool, err2 = pgConnPool()
 	if err2 != nil {
@@ -105,18 +152,16 @@ func Setup() *errs.Error {
 			}
 			log.Info("added no_data column to khole table")
 		}
+		// funding_rates was added later, create it for existing databases
+		// funding_rates表是后来添加的，为已有数据库创建
+		_, err = pool.Exec(ctx, `
+			CREATE TABLE IF NOT EXISTS public.funding_rates (sid int4 not null, time_ms int8 not null, rate float8 not null);
+			CREATE UNIQUE INDEX IF NOT EXISTS idx_funding_rates_sid_time ON public.funding_rates (sid, time_ms);`)
+		if err != nil {
+			return NewDbErr(core.ErrDbReadFail, err)
+		}
 	}
-	log.Info("connect db ok", zap.String("url", utils2.MaskDBUrl(dbCfg.Url)), zap.Int("pool", dbCfg.MaxPoolSize))
-	err2 = LoadAllExSymbols()
-	if err2 != nil {
-		return err2
-	}
-	sess, conn, err2 := Conn(ctx)
-	if err2 != nil {
-		return err2
-	}
-	defer conn.Release()
-	return sess.UpdatePendingIns()
+	return nil
 }
 
 func pgConnPool() (*pgxpool.Pool, *errs.Error) {
@@ -177,6 +222,14 @@ func Conn(ctx context.Context) (*Queries, *pgxpool.Conn, *errs.Error) {
 	if ctx == nil {
 		ctx = context.Background()
 	}
+	if liteDb != nil {
+		// sqlite has no pooled conn, return an empty one which is safe to Release
+		// sqlite没有连接池，返回一个可安全Release的空连接
+		return New(newLiteDBTX(liteDb)), &pgxpool.Conn{}, nil
+	}
+	if pool == nil {
+		return nil, nil, errs.NewMsg(core.ErrDbConnFail, "db not setup, call orm.Setup first")
+	}
 	conn, err := pool.Acquire(ctx)
 	if err != nil {
 		return nil, nil, errs.New(core.ErrDbConnFail, err)
@@ -229,6 +282,16 @@ func DbLite(src string, path string, write bool) (*sql.DB, *errs.Error) {
 			} else {
 				return nil, errs.NewMsg(core.ErrDbExecFail, "db is empty: %v", path)
 			}
+		} else if write && src == DbTrades {
+			// stratstate was added later, create it for existing databases
+			// stratstate表是后来添加的，为已有数据库创建
+			_, err_ = db.Exec(`
+				CREATE TABLE IF NOT EXISTS stratstate (id INTEGER PRIMARY KEY AUTOINCREMENT, task_id INTEGER NOT NULL,
+					strategy TEXT NOT NULL, symbol TEXT NOT NULL, timeframe TEXT NOT NULL, update_at INTEGER NOT NULL, data TEXT NOT NULL);
+				CREATE UNIQUE INDEX IF NOT EXISTS idx_ss_key ON stratstate (task_id, strategy, symbol, timeframe);`)
+			if err_ != nil {
+				return nil, errs.New(core.ErrDbExecFail, err_)
+			}
 		}
 		dbPathInit[path] = true
 	}
@@ -237,6 +300,7 @@ func DbLite(src string, path string, write bool) (*sql.DB, *errs.Error) {
 
 type Tx struct {
 	tx     pgx.Tx
+	lite   *sql.Tx
 	closed bool
 }
 
@@ -248,7 +312,13 @@ func (t *Tx) Close(ctx context.Context, commit bool) *errs.Error {
 		ctx = context.Background()
 	}
 	var err error
-	if commit {
+	if t.lite != nil {
+		if commit {
+			err = t.lite.Commit()
+		} else {
+			err = t.lite.Rollback()
+		}
+	} else if commit {
 		err = t.tx.Commit(ctx)
 	} else {
 		err = t.tx.Rollback(ctx)
@@ -264,6 +334,13 @@ func (q *Queries) NewTx(ctx context.Context) (*Tx, *Queries, *errs.Error) {
 	if ctx == nil {
 		ctx = context.Background()
 	}
+	if q.isLite() {
+		tx, err := liteDb.BeginTx(ctx, nil)
+		if err != nil {
+			return nil, nil, errs.New(core.ErrDbConnFail, err)
+		}
+		return &Tx{lite: tx}, New(newLiteDBTX(tx)), nil
+	}
 	tx, err := pool.Begin(ctx)
 	if err != nil {
 		return nil, nil, errs.New(core.ErrDbConnFail, err)
@@ -78,6 +78,40 @@ func (q *Queries) AddCalendars(ctx context.Context, arg []AddCalendarsParams) (i
 	return q.db.CopyFrom(ctx, []string{"calendars"}, []string{"name", "start_ms", "stop_ms"}, &iteratorForAddCalendars{rows: arg})
 }
 
+// iteratorForAddFundingRates implements pgx.CopyFromSource.
+type iteratorForAddFundingRates struct {
+	rows                 []AddFundingRatesParams
+	skippedFirstNextCall bool
+}
+
+func (r *iteratorForAddFundingRates) Next() bool {
+	if len(r.rows) == 0 {
+		return false
+	}
+	if !r.skippedFirstNextCall {
+		r.skippedFirstNextCall = true
+		return true
+	}
+	r.rows = r.rows[1:]
+	return len(r.rows) > 0
+}
+
+func (r iteratorForAddFundingRates) Values() ([]interface{}, error) {
+	return []interface{}{
+		r.rows[0].Sid,
+		r.rows[0].TimeMs,
+		r.rows[0].Rate,
+	}, nil
+}
+
+func (r iteratorForAddFundingRates) Err() error {
+	return nil
+}
+
+func (q *Queries) AddFundingRates(ctx context.Context, arg []AddFundingRatesParams) (int64, error) {
+	return q.db.CopyFrom(ctx, []string{"funding_rates"}, []string{"sid", "time_ms", "rate"}, &iteratorForAddFundingRates{rows: arg})
+}
+
 // iteratorForAddKHoles implements pgx.CopyFromSource.
 type iteratorForAddKHoles struct {
 	rows                 []AddKHolesParams
@@ -38,6 +38,21 @@ func (q *Queries) LoadExgSymbols(exgName string) *errs.Error {
 	return nil
 }
 
+/*
+CacheExSymbols
+Put symbols into the memory cache without database, for tests and offline tools
+将标的放入内存缓存而不写数据库，用于测试和离线工具
+*/
+func CacheExSymbols(items ...*ExSymbol) {
+	symbolLock.Lock()
+	defer symbolLock.Unlock()
+	for _, exs := range items {
+		key := fmt.Sprintf("%s:%s:%s", exs.Exchange, exs.Market, exs.Symbol)
+		keySymbolMap[key] = exs
+		idSymbolMap[exs.ID] = exs
+	}
+}
+
 func GetExSymbols(exgName, market string) map[int32]*ExSymbol {
 	var res = make(map[int32]*ExSymbol)
 	for _, exs := range keySymbolMap {
@@ -391,7 +406,7 @@ func EnsureListDates(sess *Queries, exchange banexg.BanExchange, exsMap map[int3
 	if len(emptys) == 0 {
 		return nil
 	}
-	hasFetch := exchange.HasApi(banexg.ApiFetchOHLCV, exInfo.MarketType)
+	hasFetch := !IsFileSource() && exchange.HasApi(banexg.ApiFetchOHLCV, exInfo.MarketType)
 	var prgBar *utils.PrgBar
 	cacheNum := len(emptys)
 	if cacheNum > 10 && hasFetch {
@@ -0,0 +1,59 @@
+package orm
+
+import (
+	"context"
+	"errors"
+
+	"github.com/banbox/banbot/core"
+	"github.com/banbox/banexg"
+	"github.com/banbox/banexg/errs"
+	"github.com/banbox/banexg/log"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+)
+
+/*
+DownFundingRates
+Download the funding rate history of a contract into funding_rates, continue from the last saved record.
+Return the number of saved records.
+下载合约的历史资金费率到funding_rates，从最后保存的记录继续。返回保存的记录数
+*/
+func (q *Queries) DownFundingRates(exchange banexg.BanExchange, exs *ExSymbol, startMS, endMS int64) (int, *errs.Error) {
+	ctx := context.Background()
+	lastMS, err_ := q.GetLastFundingMS(ctx, exs.ID)
+	if err_ != nil && !errors.Is(err_, pgx.ErrNoRows) {
+		return 0, NewDbErr(core.ErrDbReadFail, err_)
+	}
+	if lastMS >= startMS {
+		startMS = lastMS + 1
+	}
+	total := 0
+	for startMS < endMS {
+		rates, err := exchange.FetchFundingRateHistory(exs.Symbol, startMS, 0, map[string]interface{}{
+			banexg.ParamUntil: endMS,
+		})
+		if err != nil {
+			return total, err
+		}
+		adds := make([]AddFundingRatesParams, 0, len(rates))
+		for _, r := range rates {
+			if r.Timestamp < startMS || r.Timestamp >= endMS {
+				continue
+			}
+			adds = append(adds, AddFundingRatesParams{Sid: exs.ID, TimeMs: r.Timestamp, Rate: r.FundingRate})
+			startMS = r.Timestamp + 1
+		}
+		if len(adds) == 0 {
+			break
+		}
+		_, err_ = q.AddFundingRates(ctx, adds)
+		if err_ != nil {
+			return total, NewDbErr(core.ErrDbExecFail, err_)
+		}
+		total += len(adds)
+	}
+	if total > 0 {
+		log.Info("saved funding rates", zap.String("symbol", exs.Symbol), zap.Int("num", total))
+	}
+	return total, nil
+}
@@ -0,0 +1,452 @@
+package orm
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"html/template"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/banbox/banbot/btime"
+	"github.com/banbox/banbot/config"
+	"github.com/banbox/banbot/core"
+	"github.com/banbox/banbot/exg"
+	"github.com/banbox/banbot/utils"
+	"github.com/banbox/banexg"
+	"github.com/banbox/banexg/errs"
+	"github.com/banbox/banexg/log"
+	utils2 "github.com/banbox/banexg/utils"
+	"go.uber.org/zap"
+)
+
+const (
+	IssueBadHL     = "bad_hl"    // high/low not covering open/close 最高最低价未包含开盘收盘价
+	IssueBadPrice  = "bad_price" // zero or negative price/volume 价格或成交量为0或负数
+	IssueVolSpike  = "vol_spike" // volume far above the recent median 成交量远超近期中位数
+	IssueAggDiff   = "agg_diff"  // disagree with the aggregate of the smaller timeframe 与小周期聚合结果不一致
+	IssueMisalign  = "misalign"  // timestamp not aligned to the timeframe 时间戳未对齐到周期
+	auditBatchBars = 5000
+)
+
+/*
+AuditThres
+Thresholds for kline audit
+K线审计的阈值
+*/
+type AuditThres struct {
+	VolSpike  float64 // volume > VolSpike * median of previous VolWindow bars is a spike 成交量大于前VolWindow个中位数的倍数视为异常
+	VolWindow int
+	AggTol    float64 // max relative difference between a bar and the aggregate of its sub bars 与子周期聚合结果的最大相对误差
+}
+
+func DefaultAuditThres() *AuditThres {
+	return &AuditThres{VolSpike: 50, VolWindow: 50, AggTol: 0.001}
+}
+
+type KlineIssue struct {
+	Sid       int32
+	Symbol    string
+	TimeFrame string
+	Time      int64
+	Kind      string
+	Detail    string
+}
+
+/*
+barAuditor
+Check the bars of a single sid+timeframe batch by batch, keep the recent volumes across batches
+逐批检查单个sid+周期的K线，跨批次保留近期成交量
+*/
+type barAuditor struct {
+	tfMSecs int64
+	offMS   int64
+	thres   *AuditThres
+	vols    []float64
+}
+
+func (a *barAuditor) check(bars []*banexg.Kline) []*KlineIssue {
+	var res []*KlineIssue
+	add := func(b *banexg.Kline, kind, detail string) {
+		res = append(res, &KlineIssue{Time: b.Time, Kind: kind, Detail: detail})
+	}
+	for _, b := range bars {
+		if (b.Time-a.offMS)%a.tfMSecs != 0 {
+			add(b, IssueMisalign, fmt.Sprintf("offset %vms", (b.Time-a.offMS)%a.tfMSecs))
+		}
+		if b.Open <= 0 || b.High <= 0 || b.Low <= 0 || b.Close <= 0 || b.Volume < 0 {
+			add(b, IssueBadPrice, fmt.Sprintf("o:%v h:%v l:%v c:%v v:%v", b.Open, b.High, b.Low, b.Close, b.Volume))
+		} else if b.High < max(b.Open, b.Close) || b.Low > min(b.Open, b.Close) {
+			add(b, IssueBadHL, fmt.Sprintf("o:%v h:%v l:%v c:%v", b.Open, b.High, b.Low, b.Close))
+		}
+		if a.thres.VolSpike > 0 && len(a.vols) >= a.thres.VolWindow {
+			med := median(a.vols)
+			if med > 0 && b.Volume > med*a.thres.VolSpike {
+				add(b, IssueVolSpike, fmt.Sprintf("vol %v = %.1fx median %v", b.Volume, b.Volume/med, med))
+			}
+		}
+		if a.thres.VolWindow > 0 {
+			a.vols = append(a.vols, b.Volume)
+			if len(a.vols) > a.thres.VolWindow {
+				a.vols = a.vols[1:]
+			}
+		}
+	}
+	return res
+}
+
+func median(arr []float64) float64 {
+	vals := slices.Clone(arr)
+	slices.Sort(vals)
+	mid := len(vals) / 2
+	if len(vals)%2 == 1 {
+		return vals[mid]
+	}
+	return (vals[mid-1] + vals[mid]) / 2
+}
+
+/*
+auditAgg
+Compare bigs with the aggregate of smalls. Only periods fully covered by smalls are compared, holes are left to kline correct.
+将bigs与smalls的聚合结果比较。只比较被smalls完整覆盖的周期，空洞交给kline correct处理。
+*/
+func auditAgg(bigs, smalls []*banexg.Kline, bigMSecs, smallMSecs, offMS int64, tol float64) []*KlineIssue {
+	type aggBar struct {
+		banexg.Kline
+		num int64
+	}
+	aggs := make(map[int64]*aggBar)
+	for _, b := range smalls {
+		start := utils2.AlignTfMSecsOffset(b.Time, bigMSecs, offMS)
+		if ag, ok := aggs[start]; ok {
+			ag.High = max(ag.High, b.High)
+			ag.Low = min(ag.Low, b.Low)
+			ag.Close = b.Close
+			ag.Volume += b.Volume
+			ag.num += 1
+		} else {
+			aggs[start] = &aggBar{Kline: banexg.Kline{Time: start, Open: b.Open, High: b.High, Low: b.Low,
+				Close: b.Close, Volume: b.Volume}, num: 1}
+		}
+	}
+	fullNum := bigMSecs / smallMSecs
+	var res []*KlineIssue
+	for _, b := range bigs {
+		ag, ok := aggs[b.Time]
+		if !ok || ag.num != fullNum {
+			continue
+		}
+		var diffs []string
+		for _, f := range [][3]interface{}{{"open", b.Open, ag.Open}, {"high", b.High, ag.High},
+			{"low", b.Low, ag.Low}, {"close", b.Close, ag.Close}, {"volume", b.Volume, ag.Volume}} {
+			val, exp := f[1].(float64), f[2].(float64)
+			if math.Abs(val-exp) > math.Abs(exp)*tol+core.AmtDust {
+				diffs = append(diffs, fmt.Sprintf("%s %v vs %v", f[0], val, exp))
+			}
+		}
+		if len(diffs) > 0 {
+			res = append(res, &KlineIssue{Time: b.Time, Kind: IssueAggDiff, Detail: strings.Join(diffs, ", ")})
+		}
+	}
+	return res
+}
+
+/*
+getAuditSrcTF
+Get the smaller timeframe used to verify the aggregate. 1h is downloaded but still checked against 1m.
+获取用于校验聚合的小周期。1h虽然是下载的，仍使用1m校验。
+*/
+func getAuditSrcTF(timeFrame string) string {
+	item, ok := aggMap[timeFrame]
+	if !ok || item.AggFrom != "" || timeFrame == aggList[0].TimeFrame {
+		if ok {
+			return item.AggFrom
+		}
+		return ""
+	}
+	return aggList[0].TimeFrame
+}
+
+/*
+AuditSid
+Audit klines of one symbol and timeframe in [startMS, endMS), 0 means the whole stored range.
+审计单个标的单个周期在[startMS, endMS)的K线，0表示全部已存储区间
+*/
+func (q *Queries) AuditSid(exs *ExSymbol, timeFrame string, startMS, endMS, offMS int64, thres *AuditThres) ([]*KlineIssue, *errs.Error) {
+	oldStart, oldEnd := q.GetKlineRange(exs.ID, timeFrame)
+	if oldStart == 0 || oldEnd == 0 {
+		return nil, nil
+	}
+	startMS = max(startMS, oldStart)
+	if endMS == 0 || endMS > oldEnd {
+		endMS = oldEnd
+	}
+	tfMSecs := int64(utils2.TFToSecs(timeFrame) * 1000)
+	auditor := &barAuditor{tfMSecs: tfMSecs, offMS: offMS, thres: thres}
+	var res []*KlineIssue
+	srcTF := getAuditSrcTF(timeFrame)
+	srcMSecs := int64(0)
+	step := tfMSecs * auditBatchBars
+	if srcTF != "" {
+		srcMSecs = int64(utils2.TFToSecs(srcTF) * 1000)
+		// Keep the sub bars of one batch under auditBatchBars*10
+		// 保持一批的子周期K线数量在auditBatchBars*10以内
+		step = max(tfMSecs, srcMSecs*auditBatchBars*10/tfMSecs*tfMSecs)
+	}
+	for curMS := startMS; curMS < endMS; curMS += step {
+		stopMS := min(curMS+step, endMS)
+		bars, err := q.queryRawKlines(exs.ID, timeFrame, curMS, stopMS)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, auditor.check(bars)...)
+		if srcTF == "" || len(bars) == 0 {
+			continue
+		}
+		smalls, err := q.queryRawKlines(exs.ID, srcTF, curMS, stopMS)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, auditAgg(bars, smalls, tfMSecs, srcMSecs, offMS, thres.AggTol)...)
+	}
+	for _, it := range res {
+		it.Sid = exs.ID
+		it.Symbol = exs.Symbol
+		it.TimeFrame = timeFrame
+	}
+	return res, nil
+}
+
+/*
+queryRawKlines
+Read the stored bars as is, misaligned rows included. QueryOHLCV is not used since it may aggregate from other tables.
+按原样读取已存储的K线，包括未对齐的行。不使用QueryOHLCV，因其可能从其他表聚合
+*/
+func (q *Queries) queryRawKlines(sid int32, timeFrame string, startMS, endMS int64) ([]*banexg.Kline, *errs.Error) {
+	sql := fmt.Sprintf(`select time,open,high,low,close,volume,info from kline_%s
+where sid=%d and time >= %v and time < %v order by time`, timeFrame, sid, startMS, endMS)
+	rows, err_ := q.db.Query(context.Background(), sql)
+	klines, err_ := mapToKlines(rows, err_)
+	if err_ != nil {
+		return nil, NewDbErr(core.ErrDbReadFail, err_)
+	}
+	return klines, nil
+}
+
+/*
+RepairKlines
+Delete the offending ranges and rebuild them: aggregated timeframes by refreshAgg, downloaded timeframes by downloading again.
+删除异常区间并重建：聚合周期使用refreshAgg，下载周期重新下载
+*/
+func (q *Queries) RepairKlines(exchange banexg.BanExchange, exs *ExSymbol, timeFrame string, offMS int64, issues []*KlineIssue) (int, *errs.Error) {
+	item, ok := aggMap[timeFrame]
+	if !ok {
+		return 0, errs.NewMsg(core.ErrInvalidTF, "invalid tf: %s", timeFrame)
+	}
+	ranges := mergeIssueRanges(issues, item.MSecs, offMS)
+	for _, rg := range ranges {
+		err := q.DelKLines(exs.ID, timeFrame, rg[0], rg[1])
+		if err != nil {
+			return 0, err
+		}
+		if item.AggFrom != "" {
+			err = q.refreshAgg(item, exs.ID, rg[0], rg[1], "", false)
+		} else {
+			// kinfo still covers the range, pass zero range to force downloading
+			// kinfo仍覆盖此区间，传入空区间强制下载
+			_, err = downOHLCV2DBRange(q, exchange, exs, timeFrame, rg[0], rg[1], 0, 0, 0, nil)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(ranges), nil
+}
+
+/*
+mergeIssueRanges
+Align issue times to bar starts with the same offset as klines, and merge them into continuous ranges
+按与K线相同的偏移将异常时间对齐到K线起始，并合并为连续区间
+*/
+func mergeIssueRanges(issues []*KlineIssue, tfMSecs, offMS int64) [][2]int64 {
+	starts := make([]int64, 0, len(issues))
+	for _, it := range issues {
+		starts = append(starts, utils2.AlignTfMSecsOffset(it.Time, tfMSecs, offMS))
+	}
+	slices.Sort(starts)
+	var res [][2]int64
+	for _, start := range starts {
+		if len(res) > 0 && start <= res[len(res)-1][1] {
+			res[len(res)-1][1] = max(res[len(res)-1][1], start+tfMSecs)
+		} else {
+			res = append(res, [2]int64{start, start + tfMSecs})
+		}
+	}
+	return res
+}
+
+/*
+AuditKlines
+Scan sids and timeframes for anomalies, write kline_audit.csv/html to args.OutPath, and repair the offending ranges if args.Repair
+扫描各标的和周期的K线异常，写入kline_audit.csv/html到args.OutPath，args.Repair时修复异常区间
+*/
+func AuditKlines(args *config.CmdArgs) *errs.Error {
+	log.Info("run kline audit ...")
+	thres := DefaultAuditThres()
+	if args.VolSpike > 0 {
+		thres.VolSpike = args.VolSpike
+	}
+	if args.AggTol > 0 {
+		thres.AggTol = args.AggTol
+	}
+	tfList := args.TimeFrames
+	if len(tfList) == 0 {
+		for _, item := range aggList {
+			tfList = append(tfList, item.TimeFrame)
+		}
+	}
+	for _, tf := range tfList {
+		if _, ok := aggMap[tf]; !ok {
+			return errs.NewMsg(core.ErrInvalidTF, "only stored timeframes can be audited: %s", tf)
+		}
+	}
+	outDir := args.OutPath
+	if outDir == "" {
+		outDir = filepath.Join(config.GetDataDir(), "audit")
+	}
+	pairs := make(map[string]bool)
+	for _, p := range args.Pairs {
+		pairs[p] = true
+	}
+	if len(pairs) == 0 && args.Repair && !args.Force {
+		fmt.Println("Repair klines for all symbols would take a long time, input `y` to confirm (y/n):")
+		reader := bufio.NewReader(os.Stdin)
+		input, err_ := reader.ReadString('\n')
+		if err_ != nil {
+			return errs.New(errs.CodeRunTime, err_)
+		}
+		if strings.TrimSpace(strings.ToLower(input)) != "y" {
+			return nil
+		}
+	}
+	var startMS, endMS int64
+	if args.TimeRange != "" || args.TimeStart != "" || args.TimeEnd != "" {
+		startMS, endMS = config.TimeRange.StartMS, config.TimeRange.EndMS
+	}
+	sess, conn, err := Conn(nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+	var exsList []*ExSymbol
+	for _, exs := range GetAllExSymbols() {
+		if len(pairs) == 0 || pairs[exs.Symbol] {
+			exsList = append(exsList, exs)
+		}
+	}
+	sort.Slice(exsList, func(i, j int) bool {
+		return exsList[i].ID < exsList[j].ID
+	})
+	pBar := utils.NewPrgBar(len(exsList)*len(tfList), "Audit")
+	defer pBar.Close()
+	var issues []*KlineIssue
+	repairNum := 0
+	for _, exs := range exsList {
+		var exchange banexg.BanExchange
+		for _, tf := range tfList {
+			pBar.Add(1)
+			offMS := GetAlignOff(exs.ID, aggMap[tf].MSecs)
+			items, err := sess.AuditSid(exs, tf, startMS, endMS, offMS, thres)
+			if err != nil {
+				return err
+			}
+			issues = append(issues, items...)
+			if !args.Repair || len(items) == 0 || exs.Combined || IsFileSource() {
+				continue
+			}
+			if exchange == nil {
+				exchange, err = exg.GetWith(exs.Exchange, exs.Market, "")
+				if err == nil {
+					_, err = LoadMarkets(exchange, false)
+				}
+				if err != nil {
+					return err
+				}
+			}
+			num, err := sess.RepairKlines(exchange, exs, tf, offMS, items)
+			if err != nil {
+				log.Warn("repair klines fail", zap.String("pair", exs.Symbol), zap.String("tf", tf), zap.Error(err))
+				continue
+			}
+			repairNum += num
+		}
+	}
+	err = WriteAuditReport(issues, outDir)
+	if err != nil {
+		return err
+	}
+	log.Info("kline audit done", zap.Int("issues", len(issues)), zap.Int("repaired", repairNum),
+		zap.String("out", outDir))
+	return nil
+}
+
+const auditHtmlTpl = `<!DOCTYPE html>
+<html><head><meta charset="utf-8"><title>Kline Audit</title>
+<style>body{font-family:sans-serif}table{border-collapse:collapse;margin-bottom:20px}
+td,th{border:1px solid #ccc;padding:3px 8px}</style></head><body>
+<h2>Kline Audit</h2>
+<table><tr><th>Kind</th><th>Count</th></tr>
+{{range .Kinds}}<tr><td>{{index . 0}}</td><td>{{index . 1}}</td></tr>{{end}}
+</table>
+<table><tr><th>Symbol</th><th>TimeFrame</th><th>Time</th><th>Kind</th><th>Detail</th></tr>
+{{range .Rows}}<tr><td>{{index . 1}}</td><td>{{index . 2}}</td><td>{{index . 4}}</td><td>{{index . 5}}</td><td>{{index . 6}}</td></tr>
+{{end}}</table></body></html>`
+
+/*
+WriteAuditReport
+Write the issues to kline_audit.csv and kline_audit.html under outDir
+将异常写入outDir下的kline_audit.csv和kline_audit.html
+*/
+func WriteAuditReport(issues []*KlineIssue, outDir string) *errs.Error {
+	err_ := utils.EnsureDir(outDir, 0755)
+	if err_ != nil {
+		return errs.New(errs.CodeIOWriteFail, err_)
+	}
+	rows := [][]string{{"sid", "symbol", "timeframe", "time", "date", "kind", "detail"}}
+	kindNums := make(map[string]int)
+	for _, it := range issues {
+		kindNums[it.Kind] += 1
+		rows = append(rows, []string{strconv.Itoa(int(it.Sid)), it.Symbol, it.TimeFrame,
+			strconv.FormatInt(it.Time, 10), btime.ToDateStr(it.Time, core.DefaultDateFmt), it.Kind, it.Detail})
+	}
+	err := utils.WriteCsvFile(filepath.Join(outDir, "kline_audit.csv"), rows, false)
+	if err != nil {
+		return err
+	}
+	kinds := make([][2]string, 0, len(kindNums))
+	for k, n := range kindNums {
+		kinds = append(kinds, [2]string{k, strconv.Itoa(n)})
+	}
+	sort.Slice(kinds, func(i, j int) bool {
+		return kinds[i][0] < kinds[j][0]
+	})
+	tpl, err_ := template.New("audit").Parse(auditHtmlTpl)
+	if err_ != nil {
+		return errs.New(errs.CodeRunTime, err_)
+	}
+	file, err_ := os.Create(filepath.Join(outDir, "kline_audit.html"))
+	if err_ != nil {
+		return errs.New(errs.CodeIOWriteFail, err_)
+	}
+	defer file.Close()
+	err_ = tpl.Execute(file, map[string]interface{}{"Kinds": kinds, "Rows": rows[1:]})
+	if err_ != nil {
+		return errs.New(errs.CodeIOWriteFail, err_)
+	}
+	return nil
+}
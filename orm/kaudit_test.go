@@ -0,0 +1,113 @@
+package orm
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/banbox/banexg"
+)
+
+func TestAuditBars(t *testing.T) {
+	startMS := int64(1700000100000)
+	var bars []*banexg.Kline
+	for i := 0; i < 10; i++ {
+		bars = append(bars, &banexg.Kline{Time: startMS + int64(i)*60000, Open: 100, High: 101, Low: 99,
+			Close: 100, Volume: 10})
+	}
+	bars[3].High = 99.5
+	bars[5].Low = 0
+	bars[7].Time += 1000
+	bars[8].Volume = 1000
+	a := &barAuditor{tfMSecs: 60000, thres: &AuditThres{VolSpike: 50, VolWindow: 5}}
+	kinds := make(map[int64]string)
+	for _, it := range a.check(bars) {
+		kinds[it.Time] = it.Kind
+	}
+	exp := map[int64]string{bars[3].Time: IssueBadHL, bars[5].Time: IssueBadPrice, bars[7].Time: IssueMisalign,
+		bars[8].Time: IssueVolSpike}
+	if len(kinds) != len(exp) {
+		t.Fatalf("expect %v issues, got %v", exp, kinds)
+	}
+	for k, v := range exp {
+		if kinds[k] != v {
+			t.Fatalf("expect %v at %v, got %v", v, k, kinds[k])
+		}
+	}
+}
+
+func TestMergeIssueRanges(t *testing.T) {
+	// weekly bars start on Monday, 4 days after the epoch Thursday
+	weekMS, offMS := int64(7*86400000), int64(4*86400000)
+	monday := int64(1699833600000) // 2023-11-13 00:00 UTC
+	issues := []*KlineIssue{{Time: monday + weekMS + 1000}, {Time: monday + 3600000}, {Time: monday + 3*weekMS}}
+	res := mergeIssueRanges(issues, weekMS, offMS)
+	exp := [][2]int64{{monday, monday + 2*weekMS}, {monday + 3*weekMS, monday + 4*weekMS}}
+	if len(res) != len(exp) {
+		t.Fatalf("expect %v, got %v", exp, res)
+	}
+	for i, rg := range exp {
+		if res[i] != rg {
+			t.Fatalf("expect %v, got %v", exp, res)
+		}
+	}
+}
+
+func TestAuditRepairAgg(t *testing.T) {
+	err := setupLite(filepath.Join(t.TempDir(), "kline.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = liteDb.Close()
+		liteDb = nil
+	}()
+	sess, conn, err := Conn(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Release()
+	startMS := int64(1700000100000)
+	endMS := startMS + 10*60000
+	var bars []*banexg.Kline
+	for i := 0; i < 10; i++ {
+		price := float64(100 + i)
+		bars = append(bars, &banexg.Kline{Time: startMS + int64(i)*60000, Open: price, High: price + 2,
+			Low: price - 1, Close: price + 1, Volume: 10})
+	}
+	if _, err = sess.InsertKLines("1m", 1, bars); err != nil {
+		t.Fatal(err)
+	}
+	if err = sess.refreshAgg(aggMap["5m"], 1, startMS, endMS, "", false); err != nil {
+		t.Fatal(err)
+	}
+	// corrupt the second 5m bar
+	if err = sess.Exec("update kline_5m set high=200 where sid=1 and time=$1", startMS+300000); err != nil {
+		t.Fatal(err)
+	}
+	exs := &ExSymbol{ID: 1, Symbol: "BTC/USDT"}
+	issues, err := sess.AuditSid(exs, "5m", 0, 0, 0, DefaultAuditThres())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(issues) != 1 || issues[0].Kind != IssueAggDiff || issues[0].Time != startMS+300000 {
+		t.Fatalf("expect one agg_diff issue, got %v", issues)
+	}
+	outDir := t.TempDir()
+	if err = WriteAuditReport(issues, outDir); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"kline_audit.csv", "kline_audit.html"} {
+		if _, err_ := os.Stat(filepath.Join(outDir, name)); err_ != nil {
+			t.Fatal(err_)
+		}
+	}
+	num, err := sess.RepairKlines(nil, exs, "5m", 0, issues)
+	if err != nil || num != 1 {
+		t.Fatalf("repair fail: %v %v", num, err)
+	}
+	issues, err = sess.AuditSid(exs, "5m", 0, 0, 0, DefaultAuditThres())
+	if err != nil || len(issues) != 0 {
+		t.Fatalf("expect no issues after repair, got %v %v", issues, err)
+	}
+}
@@ -115,7 +115,9 @@ stepCB 用于更新进度，总值固定1000，避免内部下载区间大于传
 */
 func downOHLCV2DBRange(sess *Queries, exchange banexg.BanExchange, exs *ExSymbol, timeFrame string, startMS, endMS,
 	oldStart, oldEnd int64, retry int, pBar *utils.PrgBar) (int, *errs.Error) {
-	if oldStart <= startMS && endMS <= oldEnd || startMS <= exs.ListMs && endMS <= exs.ListMs ||
+	// Klines replayed from a data bundle are never downloaded
+	// 从数据包回放K线时，从不下载
+	if IsFileSource() || oldStart <= startMS && endMS <= oldEnd || startMS <= exs.ListMs && endMS <= exs.ListMs ||
 		exs.Combined || exs.DelistMs > 0 {
 		// If you are completely in the downloaded interval or the download interval is less than the time of availability, you don't need to download it
 		// 完全处于已下载的区间 或 下载区间小于上市时间，无需下载
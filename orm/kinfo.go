@@ -60,12 +60,13 @@ func (q *Queries) FindKHoles(args FindKHolesArgs) ([]*KHole, int64, *errs.Error)
 	if args.Limit > 0 {
 		limit = args.Limit
 	}
+	b.WriteString(fmt.Sprintf("limit $%v", len(sqlParams)+1))
+	sqlParams = append(sqlParams, limit)
 	if args.Offset > 0 {
-		b.WriteString(fmt.Sprintf("offset $%v ", len(sqlParams)+1))
+		// limit must precede offset in sqlite
+		b.WriteString(fmt.Sprintf(" offset $%v", len(sqlParams)+1))
 		sqlParams = append(sqlParams, args.Offset)
 	}
-	b.WriteString(fmt.Sprintf("limit $%v", len(sqlParams)+1))
-	sqlParams = append(sqlParams, limit)
 
 	rows, err := q.db.Query(ctx, b.String(), sqlParams...)
 	if err != nil {
@@ -49,6 +49,19 @@ const (
   last(close, time) AS close,
   sum(volume) AS volume,
   last(info, time) AS info`
+	// sqlite has no first/last aggregate functions, use window functions instead; `where true` avoids the upsert parsing ambiguity
+	// sqlite没有first/last聚合函数，改用窗口函数；`where true`避免upsert的解析歧义
+	liteAggSql = `
+select sid,atime,max(fo),max(high),min(low),max(lc),sum(volume),max(li) from (
+select sid,"time"/%d*%d as atime,high,low,volume,
+  first_value(open) over w as fo,
+  last_value(close) over w as lc,
+  last_value(info) over w as li
+from %s where sid=%d and time>=%v and time<%v
+window w as (partition by "time"/%d order by time rows between unbounded preceding and unbounded following)
+) where true
+GROUP BY sid, atime
+ORDER BY sid, atime`
 	klineInsConflict = `
 ON CONFLICT (sid, time)
 DO UPDATE SET 
@@ -987,11 +1000,16 @@ func (q *Queries) refreshAgg(item *KlineAgg, sid int32, orgStartMS, orgEndMS int
 		aggFrom = item.AggFrom
 	}
 	tblName := "kline_" + aggFrom
-	sql := fmt.Sprintf(`
+	var sql string
+	if q.isLite() {
+		sql = fmt.Sprintf(liteAggSql, tfMSecs, tfMSecs, tblName, sid, aggStart, endMS, tfMSecs)
+	} else {
+		sql = fmt.Sprintf(`
 select sid,"time"/%d*%d as atime,%s
 from %s where sid=%d and time>=%v and time<%v
 GROUP BY sid, 2 
 ORDER BY sid, 2`, tfMSecs, tfMSecs, aggFields, tblName, sid, aggStart, endMS)
+	}
 	finalSql := fmt.Sprintf(`
 insert into %s (sid, time, open, high, low, close, volume, info)
 %s %s`, item.Table, sql, klineInsConflict)
@@ -0,0 +1,363 @@
+package orm
+
+import (
+	"archive/zip"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/banbox/banbot/btime"
+	"github.com/banbox/banbot/config"
+	"github.com/banbox/banbot/core"
+	"github.com/banbox/banbot/exg"
+	"github.com/banbox/banbot/utils"
+	"github.com/banbox/banexg"
+	"github.com/banbox/banexg/errs"
+	"github.com/banbox/banexg/log"
+	utils2 "github.com/banbox/banexg/utils"
+	"go.uber.org/zap"
+)
+
+/*
+`kline_source` decides where klines are read from:
+  - db (default): the kline store of `database.url`
+  - file:<dir>: a self-contained data bundle, written by `data export` (exInfo1.dat + kline*.dat)
+    or by `kline export` ({symbol}_{tf}.zip/.csv)
+
+A file bundle is loaded once into an embedded sqlite store cached under `<data dir>/ksource`, keyed by the
+names, sizes and modify times of the bundle files; the adj factors, holes and calendars of the bundle are
+imported too. Then all kline queries run as usual, but nothing is downloaded from the exchange, so a backtest
+can be re-run without any database.
+
+`kline_source`决定K线的读取来源：
+  - db（默认）：`database.url`对应的K线存储
+  - file:<dir>：自包含的数据包，由`data export`（exInfo1.dat + kline*.dat）或`kline export`（{symbol}_{tf}.zip/.csv）写入
+
+文件数据包会被一次性加载到嵌入式sqlite存储，缓存在`<数据目录>/ksource`下，以数据包文件的名称、大小、修改时间作为键；
+数据包中的复权因子、空洞和交易日历也会一并导入。之后所有K线查询照常执行，但不会从交易所下载任何数据，故回测无需数据库即可重跑
+*/
+
+const fileSrcPrefix = "file:"
+
+var (
+	fileSrcDir string // the dir of data bundle when `kline_source` is file 当`kline_source`为文件时的数据包目录
+)
+
+/*
+ParseKlineSource
+Return the data bundle dir for `file:<dir>`, empty for db
+`file:<dir>`时返回数据包目录，db时返回空
+*/
+func ParseKlineSource(src string) (string, *errs.Error) {
+	src = strings.TrimSpace(src)
+	if src == "" || src == "db" {
+		return "", nil
+	}
+	if !strings.HasPrefix(src, fileSrcPrefix) {
+		return "", errs.NewMsg(core.ErrBadConfig, "invalid kline_source: %s, expect `db` or `file:<dir>`", src)
+	}
+	dir := strings.TrimSpace(strings.TrimPrefix(src, fileSrcPrefix))
+	if dir == "" {
+		return "", errs.NewMsg(core.ErrBadConfig, "kline_source: dir is required for `file:<dir>`")
+	}
+	dir = config.ParsePath(dir)
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(config.GetDataDir(), dir)
+	}
+	return dir, nil
+}
+
+/*
+IsFileSource
+Whether klines are replayed from a data bundle, no download is allowed then
+是否从数据包回放K线，此时不允许下载
+*/
+func IsFileSource() bool {
+	return fileSrcDir != ""
+}
+
+func setupFileSource(dir string) *errs.Error {
+	files, isProto, err := listBundleFiles(dir)
+	if err != nil {
+		return err
+	}
+	var b strings.Builder
+	for _, path := range files {
+		info, err_ := os.Stat(path)
+		if err_ != nil {
+			return errs.New(core.ErrIOReadFail, err_)
+		}
+		b.WriteString(fmt.Sprintf("%s:%d:%d\n", filepath.Base(path), info.Size(), info.ModTime().UnixMilli()))
+	}
+	cacheDir := filepath.Join(config.GetDataDir(), "ksource")
+	cachePath := filepath.Join(cacheDir, utils.MD5([]byte(b.String()))[:16]+".db")
+	fileSrcDir = dir
+	if _, err_ := os.Stat(cachePath); err_ == nil {
+		log.Info("use cached kline source", zap.String("dir", dir), zap.String("cache", cachePath))
+		return setupLite(cachePath)
+	}
+	// Import into a temporary file first, so an interrupted import is never used
+	// 先导入到临时文件，避免使用中断的导入
+	tmpPath := cachePath + ".tmp"
+	for _, suffix := range []string{"", "-wal", "-shm"} {
+		_ = os.Remove(tmpPath + suffix)
+	}
+	err = setupLite(tmpPath)
+	if err != nil {
+		return err
+	}
+	resetExSymbols()
+	log.Info("loading kline source", zap.String("dir", dir), zap.Int("files", len(files)))
+	if isProto {
+		err = ImportData(dir, 1, nil)
+	} else {
+		err = importKlineCsvs(files)
+	}
+	_ = liteDb.Close()
+	liteDb = nil
+	if err != nil {
+		return err
+	}
+	if err_ := os.Rename(tmpPath, cachePath); err_ != nil {
+		return errs.New(core.ErrIOWriteFail, err_)
+	}
+	resetExSymbols()
+	return setupLite(cachePath)
+}
+
+/*
+listBundleFiles
+Return the files of a data bundle, and whether it is written by `data export`
+返回数据包的文件，以及是否由`data export`写入
+*/
+func listBundleFiles(dir string) ([]string, bool, *errs.Error) {
+	if _, err_ := os.Stat(filepath.Join(dir, "exInfo1.dat")); err_ == nil {
+		files, err_ := filepath.Glob(filepath.Join(dir, "*.dat"))
+		if err_ != nil {
+			return nil, false, errs.New(core.ErrIOReadFail, err_)
+		}
+		slices.Sort(files)
+		return files, true, nil
+	}
+	entries, err_ := os.ReadDir(dir)
+	if err_ != nil {
+		return nil, false, errs.New(core.ErrIOReadFail, err_)
+	}
+	var files []string
+	for _, e := range entries {
+		ext := strings.ToLower(filepath.Ext(e.Name()))
+		if e.IsDir() || ext != ".zip" && ext != ".csv" {
+			continue
+		}
+		files = append(files, filepath.Join(dir, e.Name()))
+	}
+	if len(files) == 0 {
+		return nil, false, errs.NewMsg(core.ErrBadConfig, "no exInfo1.dat or kline csv found in %s", dir)
+	}
+	return files, false, nil
+}
+
+func resetExSymbols() {
+	symbolLock.Lock()
+	keySymbolMap = make(map[string]*ExSymbol)
+	idSymbolMap = make(map[int32]*ExSymbol)
+	symbolLock.Unlock()
+	amLock.Lock()
+	adjMap = make(map[int32][]*AdjInfo)
+	amLock.Unlock()
+}
+
+/*
+importKlineCsvs
+Import the files written by `kline export`, which are named `{symbol}_{tf}` with `/` and `:` in symbol replaced by `_`.
+Prices are already adjusted when exporting, so no adj factors are needed.
+导入`kline export`写入的文件，文件名为`{symbol}_{tf}`，symbol中的`/`和`:`替换为了`_`。导出时价格已复权，无需复权因子
+*/
+func importKlineCsvs(files []string) *errs.Error {
+	exchange := exg.Default
+	marMap, err := LoadMarkets(exchange, false)
+	if err != nil {
+		return err
+	}
+	cleanMap := make(map[string]string)
+	for symbol := range marMap {
+		cleanMap[strings.ReplaceAll(strings.ReplaceAll(symbol, "/", "_"), ":", "_")] = symbol
+	}
+	type csvJob struct {
+		path   string
+		symbol string
+		tf     string
+		msecs  int64
+	}
+	var jobs []*csvJob
+	var symbols []string
+	for _, path := range files {
+		name := filepath.Base(path)
+		name = strings.TrimSuffix(name, filepath.Ext(name))
+		idx := strings.LastIndex(name, "_")
+		if idx <= 0 {
+			log.Warn("skip kline file: bad name", zap.String("path", path))
+			continue
+		}
+		clean, tf := name[:idx], name[idx+1:]
+		symbol, ok := cleanMap[clean]
+		if !ok {
+			log.Warn("skip kline file: symbol not found", zap.String("path", path), zap.String("exg", core.ExgName),
+				zap.String("market", core.Market))
+			continue
+		}
+		if _, ok = aggMap[tf]; !ok {
+			log.Warn("skip kline file: unsupported timeframe", zap.String("path", path), zap.String("tf", tf))
+			continue
+		}
+		if !slices.Contains(symbols, symbol) {
+			symbols = append(symbols, symbol)
+		}
+		jobs = append(jobs, &csvJob{path: path, symbol: symbol, tf: tf, msecs: int64(utils2.TFToSecs(tf) * 1000)})
+	}
+	if len(jobs) == 0 {
+		return errs.NewMsg(core.ErrBadConfig, "no valid kline csv for %s.%s", core.ExgName, core.Market)
+	}
+	err = EnsureCurSymbols(symbols)
+	if err != nil {
+		return err
+	}
+	// Small timeframes first, the bigger ones are aggregated from them and skipped when already exist
+	// 小周期优先，更大周期从中聚合，已存在时跳过
+	slices.SortFunc(jobs, func(a, b *csvJob) int {
+		if a.symbol != b.symbol {
+			return strings.Compare(a.symbol, b.symbol)
+		}
+		return int((a.msecs - b.msecs) / 1000)
+	})
+	sess, conn, err := Conn(nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+	pBar := utils.NewPrgBar(len(jobs), "kSource")
+	defer pBar.Close()
+	for _, job := range jobs {
+		pBar.Add(1)
+		exs, err := GetExSymbolCur(job.symbol)
+		if err != nil {
+			return err
+		}
+		rows, err := readCsvRows(job.path)
+		if err != nil {
+			return err
+		}
+		klines, err := parseCsvKlines(rows)
+		if err != nil {
+			return errs.NewMsg(err.Code, "%s: %s", job.path, err.Message())
+		}
+		if len(klines) == 0 {
+			continue
+		}
+		endMS := klines[len(klines)-1].Time + job.msecs
+		if sess.GetKlineNum(exs.ID, job.tf, klines[0].Time, endMS) > 0 {
+			continue
+		}
+		_, err = sess.InsertKLinesAuto(job.tf, exs.ID, klines, true)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadKlineCsv read klines from a csv (or zip) file in the format of `kline export` 读取`kline export`格式的csv(或zip)文件中的K线
+func ReadKlineCsv(path string) ([]*banexg.Kline, *errs.Error) {
+	rows, err := readCsvRows(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseCsvKlines(rows)
+}
+
+func readCsvRows(path string) ([][]string, *errs.Error) {
+	if strings.ToLower(filepath.Ext(path)) != ".zip" {
+		return utils.ReadCSV(path)
+	}
+	r, err_ := zip.OpenReader(path)
+	if err_ != nil {
+		return nil, errs.New(core.ErrIOReadFail, err_)
+	}
+	defer r.Close()
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() || !strings.HasSuffix(f.Name, ".csv") {
+			continue
+		}
+		var fReader io.ReadCloser
+		fReader, err_ = f.Open()
+		if err_ != nil {
+			return nil, errs.New(core.ErrIOReadFail, err_)
+		}
+		rows, err_ := csv.NewReader(fReader).ReadAll()
+		_ = fReader.Close()
+		if err_ != nil {
+			return nil, errs.New(core.ErrIOReadFail, err_)
+		}
+		return rows, nil
+	}
+	return nil, nil
+}
+
+/*
+parseCsvKlines
+Parse rows of utils.KlineToStr: date,open,high,low,close,volume[,info]. Date is `core.DefaultDateFmt` in btime.LocShow,
+or 10/13 digits timestamp. A header row is skipped.
+解析utils.KlineToStr的行：date,open,high,low,close,volume[,info]。日期是btime.LocShow时区的`core.DefaultDateFmt`，
+或10/13位时间戳。表头行会被跳过
+*/
+func parseCsvKlines(rows [][]string) ([]*banexg.Kline, *errs.Error) {
+	loc := btime.LocShow
+	if loc == nil {
+		loc = time.UTC
+	}
+	klines := make([]*banexg.Kline, 0, len(rows))
+	for i, row := range rows {
+		if len(row) < 6 {
+			return nil, errs.NewMsg(core.ErrInvalidBars, "row %d: expect at least 6 columns, got %d", i+1, len(row))
+		}
+		var timeMS int64
+		dateStr := strings.TrimSpace(row[0])
+		if btime.CountDigit(dateStr) == len(dateStr) {
+			val, err_ := strconv.ParseInt(dateStr, 10, 64)
+			if err_ != nil {
+				return nil, errs.NewMsg(core.ErrInvalidBars, "row %d: bad time %s", i+1, dateStr)
+			}
+			if val < 1000000000000 {
+				val *= 1000
+			}
+			timeMS = val
+		} else {
+			t, err_ := time.ParseInLocation(core.DefaultDateFmt, dateStr, loc)
+			if err_ != nil {
+				if i == 0 {
+					// header
+					continue
+				}
+				return nil, errs.NewMsg(core.ErrInvalidBars, "row %d: bad time %s", i+1, dateStr)
+			}
+			timeMS = t.UnixMilli()
+		}
+		var vals [6]float64
+		for j := 1; j < len(row) && j <= 6; j++ {
+			val, err_ := strconv.ParseFloat(strings.TrimSpace(row[j]), 64)
+			if err_ != nil {
+				return nil, errs.NewMsg(core.ErrInvalidBars, "row %d: bad number %s", i+1, row[j])
+			}
+			vals[j-1] = val
+		}
+		klines = append(klines, &banexg.Kline{Time: timeMS, Open: vals[0], High: vals[1], Low: vals[2],
+			Close: vals[3], Volume: vals[4], Info: vals[5]})
+	}
+	return klines, nil
+}
@@ -0,0 +1,157 @@
+package orm
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/banbox/banbot/btime"
+	"github.com/banbox/banbot/config"
+	"github.com/banbox/banbot/utils"
+	"github.com/banbox/banexg"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestParseKlineSource(t *testing.T) {
+	for _, src := range []string{"", "db", " db "} {
+		dir, err := ParseKlineSource(src)
+		if err != nil || dir != "" {
+			t.Fatalf("%q should be db, got: %v %v", src, dir, err)
+		}
+	}
+	dir, err := ParseKlineSource("file:/tmp/bundle")
+	if err != nil || dir != "/tmp/bundle" {
+		t.Fatalf("bad file source: %v %v", dir, err)
+	}
+	for _, src := range []string{"file:", "mysql", "files:/tmp"} {
+		if _, err = ParseKlineSource(src); err == nil {
+			t.Fatalf("%q should be invalid", src)
+		}
+	}
+}
+
+func TestKlineCsvRoundTrip(t *testing.T) {
+	startMS := int64(1700000100000)
+	bars := make([]*banexg.Kline, 0, 5)
+	for i := 0; i < 5; i++ {
+		price := 100.5 + float64(i)
+		bars = append(bars, &banexg.Kline{Time: startMS + int64(i)*60000, Open: price, High: price + 2,
+			Low: price - 1, Close: price + 1, Volume: 12.25, Info: float64(i)})
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "BTC_USDT_USDT_1m.csv")
+	err := utils.WriteCsvFile(path, utils.KlineToStr(bars, btime.UTCLocale), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := readCsvRows(filepath.Join(dir, "BTC_USDT_USDT_1m.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows = append([][]string{{"date", "open", "high", "low", "close", "volume", "info"}}, rows...)
+	res, err := parseCsvKlines(rows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res) != len(bars) {
+		t.Fatalf("expect %v bars, got %v", len(bars), len(res))
+	}
+	for i, b := range res {
+		if *b != *bars[i] {
+			t.Fatalf("bar %v mismatch: %+v != %+v", i, b, bars[i])
+		}
+	}
+	secs, err := parseCsvKlines(utils.KlineToStr(bars, nil))
+	if err != nil || len(secs) != len(bars) || secs[2].Time != bars[2].Time {
+		t.Fatalf("parse timestamp rows fail: %v", err)
+	}
+}
+
+func TestFileSourceBundle(t *testing.T) {
+	oldDataDir := config.DataDir
+	config.DataDir = t.TempDir()
+	defer func() {
+		config.DataDir = oldDataDir
+		fileSrcDir = ""
+		if liteDb != nil {
+			_ = liteDb.Close()
+			liteDb = nil
+		}
+		resetExSymbols()
+	}()
+	dir := t.TempDir()
+	startMS := int64(1700000040000)
+	holeStart := startMS + 20*60000
+	info := &EXInfo{
+		Symbols: []*ExSymbolBlock{
+			{Id: 7, Exchange: "binance", Market: "linear", Symbol: "BTC/USDT:USDT", ListMs: 1600000000000},
+			{Id: 8, Exchange: "binance", Market: "linear", Symbol: "ETH/USDT:USDT"},
+		},
+		KHoles:     []*KHoleBlock{{Sid: 7, Timeframe: "1m", Holes: []int64{holeStart, holeStart + 120000}}},
+		AdjFactors: []*AdjFactorBlock{{Sid: 7, SubId: 8, StartMs: startMS, Factor: 1.5}},
+	}
+	block := newKlineBlock(7, "1m", 10)
+	for i := 0; i < 10; i++ {
+		block.Open = append(block.Open, float64(100+i))
+		block.High = append(block.High, float64(102+i))
+		block.Low = append(block.Low, float64(99+i))
+		block.Close = append(block.Close, float64(101+i))
+		block.Volume = append(block.Volume, 10)
+	}
+	block.Start, block.End = startMS, startMS+10*60000
+	for name, msg := range map[string]proto.Message{"exInfo1.dat": info, "kline1.dat": block} {
+		file, err_ := os.Create(filepath.Join(dir, name))
+		if err_ != nil {
+			t.Fatal(err_)
+		}
+		err := dumpProto(msg, file)
+		_ = file.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := setupFileSource(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsFileSource() {
+		t.Fatal("file source should be active")
+	}
+	if err = LoadAllExSymbols(); err != nil {
+		t.Fatal(err)
+	}
+	exs, err := ParseShort("binance", "BTC/USDT.P")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exs.ListMs != 1600000000000 {
+		t.Fatalf("list date not imported: %+v", exs)
+	}
+	sess, conn, err := Conn(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	klines, err := sess.QueryOHLCV(exs.ID, "1m", startMS, startMS+10*60000, 0, false)
+	if err != nil || len(klines) != 10 || klines[9].Close != 110 {
+		t.Fatalf("query bundle klines fail: %v %v", len(klines), err)
+	}
+	adjs, err := sess.GetAdjs(exs.ID)
+	if err != nil || len(adjs) != 1 || adjs[0].Factor != 1.5 {
+		t.Fatalf("adj factors not imported: %v %v", len(adjs), err)
+	}
+	holes, err_ := sess.ListKHoles(context.Background(), []int32{exs.ID})
+	if err_ != nil || len(holes) != 1 || holes[0].Start != holeStart || !holes[0].NoData {
+		t.Fatalf("holes not imported: %v %v", len(holes), err_)
+	}
+	conn.Release()
+	// the second setup reuses the cached store
+	// 第二次加载复用缓存的存储
+	if err = setupFileSource(dir); err != nil {
+		t.Fatal(err)
+	}
+	caches, _ := filepath.Glob(filepath.Join(config.DataDir, "ksource", "*.db"))
+	if len(caches) != 1 {
+		t.Fatalf("expect one cached store, got: %v", caches)
+	}
+}
@@ -0,0 +1,97 @@
+package orm
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/banbox/banbot/core"
+	"github.com/banbox/banexg"
+)
+
+func TestLiteStore(t *testing.T) {
+	err := setupLite(filepath.Join(t.TempDir(), "kline.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = liteDb.Close()
+		liteDb = nil
+	}()
+	sess, conn, err := Conn(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Release()
+	if !sess.isLite() {
+		t.Fatal("Conn should return sqlite queries")
+	}
+	startMS := int64(1700000100000)
+	bars := make([]*banexg.Kline, 0, 10)
+	for i := 0; i < 10; i++ {
+		price := float64(100 + i)
+		bars = append(bars, &banexg.Kline{Time: startMS + int64(i)*60000, Open: price, High: price + 2,
+			Low: price - 1, Close: price + 1, Volume: 10, Info: float64(i)})
+	}
+	num, err := sess.InsertKLines("1m", 1, bars)
+	if err != nil || num != 10 {
+		t.Fatalf("insert klines fail: %v %v", num, err)
+	}
+	_, err = sess.InsertKLines("1m", 1, bars[:1])
+	if err == nil || err.Code != core.ErrDbUniqueViolation {
+		t.Fatalf("expect unique violation, got: %v", err)
+	}
+	endMS := startMS + 10*60000
+	res, err := sess.QueryOHLCV(1, "1m", startMS, endMS, 0, false)
+	if err != nil || len(res) != 10 {
+		t.Fatalf("query klines fail: %v %v", len(res), err)
+	}
+	err = sess.refreshAgg(aggMap["5m"], 1, startMS, endMS, "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	big, err := sess.QueryOHLCV(1, "5m", startMS, endMS, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(big) != 2 {
+		t.Fatalf("expect 2 bars of 5m, got %v", len(big))
+	}
+	b := big[1]
+	if b.Open != 105 || b.Close != 110 || b.High != 111 || b.Low != 104 || b.Volume != 50 || b.Info != 9 {
+		t.Fatalf("bad 5m bar: %+v", b)
+	}
+	start, stop := sess.GetKlineRange(1, "5m")
+	if start != startMS || stop != endMS {
+		t.Fatalf("bad kinfo for 5m: %v %v", start, stop)
+	}
+	ctx := context.Background()
+	_, err_ := sess.AddKHoles(ctx, []AddKHolesParams{{Sid: 1, Timeframe: "1m", Start: 1, Stop: 2}})
+	if err_ != nil {
+		t.Fatal(err_)
+	}
+	holes, err_ := sess.ListKHoles(ctx, []int32{1, 2})
+	if err_ != nil || len(holes) != 1 {
+		t.Fatalf("list kholes fail: %v %v", len(holes), err_)
+	}
+	insId, err := sess.AddInsJob(AddInsKlineParams{Sid: 1, Timeframe: "1m", StartMs: 1, StopMs: 2})
+	if err != nil || insId == 0 {
+		t.Fatalf("add ins job fail: %v %v", insId, err)
+	}
+	if _, err_ = sess.GetLastFundingMS(ctx, 1); err_ == nil {
+		t.Fatal("expect no funding rates")
+	}
+	_, err_ = sess.AddFundingRates(ctx, []AddFundingRatesParams{{Sid: 1, TimeMs: 100, Rate: 0.0001},
+		{Sid: 1, TimeMs: 200, Rate: -0.0002}})
+	if err_ != nil {
+		t.Fatal(err_)
+	}
+	lastMS, err_ := sess.GetLastFundingMS(ctx, 1)
+	if err_ != nil || lastMS != 200 {
+		t.Fatalf("bad last funding ms: %v %v", lastMS, err_)
+	}
+	rates, err_ := sess.GetFundingRates(ctx, GetFundingRatesParams{Sid: 1, TimeMs: 0, TimeMs_2: 200})
+	if err_ != nil || len(rates) != 1 || rates[0].Rate != 0.0001 {
+		t.Fatalf("bad funding rates: %v %v", rates, err_)
+	}
+}
@@ -30,6 +30,12 @@ type ExSymbol struct {
 	DelistMs int64  `json:"delist_ms"`
 }
 
+type FundingRate struct {
+	Sid    int32   `json:"sid"`
+	TimeMs int64   `json:"time_ms"`
+	Rate   float64 `json:"rate"`
+}
+
 type InsKline struct {
 	ID        int32  `json:"id"`
 	Sid       int32  `json:"sid"`
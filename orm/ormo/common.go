@@ -46,12 +46,34 @@ func decodeIOrderInfo(text string) map[string]interface{} {
 						result[key] = state
 					}
 				}
+			} else if key == OdInfoLegs {
+				legs := decodeLegs(val)
+				if len(legs) == 0 {
+					delete(result, key)
+				} else {
+					result[key] = legs
+				}
 			}
 		}
 	}
 	return result
 }
 
+func decodeLegs(val interface{}) []*ExOrder {
+	text, err_ := utils2.MarshalString(val)
+	if err_ != nil {
+		log.Error("marshal order legs fail", zap.Error(err_))
+		return nil
+	}
+	var legs []*ExOrder
+	err_ = utils2.UnmarshalString(text, &legs, utils2.JsonNumDefault)
+	if err_ != nil {
+		log.Error("unmarshal order legs fail", zap.String("legs", text), zap.Error(err_))
+		return nil
+	}
+	return legs
+}
+
 func decodeTriggerState(data map[string]interface{}) *TriggerState {
 	if data == nil || len(data) == 0 {
 		return nil
@@ -68,6 +90,9 @@ func decodeTriggerState(data map[string]interface{}) *TriggerState {
 	if v, ok := data["order_id"].(string); ok {
 		ts.OrderId = v
 	}
+	if v, ok := data["extreme"].(float64); ok {
+		ts.Extreme = v
+	}
 
 	// 处理嵌套的Old字段
 	if oldData, ok := data["old"].(map[string]interface{}); ok {
@@ -91,5 +116,17 @@ func decodeTrigger(data map[string]interface{}) *ExitTrigger {
 	if v, ok := data["tag"].(string); ok {
 		ts.Tag = v
 	}
+	if v, ok := data["activate"].(float64); ok {
+		ts.Activate = v
+	}
+	if v, ok := data["callback"].(float64); ok {
+		ts.Callback = v
+	}
+	if v, ok := data["offset"].(float64); ok {
+		ts.Offset = v
+	}
+	if v, ok := data["step"].(float64); ok {
+		ts.Step = v
+	}
 	return ts
 }
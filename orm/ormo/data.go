@@ -39,6 +39,9 @@ const (
 	OdInfoStopAfter  = "StopAfter"
 	OdInfoStopLoss   = "StopLoss"
 	OdInfoTakeProfit = "TakeProfit"
+	OdInfoFunding    = "Funding"
+	OdInfoMarginAdd  = "MarginAdd"
+	OdInfoLegs       = "Legs" // Added entry legs 加仓的入场腿
 )
 
 const (
@@ -48,4 +51,5 @@ const (
 	OdActionLimitExit  = "LimitExit"
 	OdActionStopLoss   = "StopLoss"
 	OdActionTakeProfit = "TakeProfit"
+	OdActionAddEnter   = "AddEnter"
 )
@@ -57,3 +57,13 @@ type IOrder struct {
 	Profit      float64 `json:"profit"`
 	Info        string  `json:"info"`
 }
+
+type StratState struct {
+	ID        int64  `json:"id"`
+	TaskID    int64  `json:"task_id"`
+	Strategy  string `json:"strategy"`
+	Symbol    string `json:"symbol"`
+	Timeframe string `json:"timeframe"`
+	UpdateAt  int64  `json:"update_at"`
+	Data      string `json:"data"`
+}
@@ -148,7 +148,7 @@ func (i *InOutOrder) Key() string {
 KeyAlign 开单时间戳按时间周期对齐，方便回测和实盘订单对比
 */
 func (i *InOutOrder) KeyAlign() string {
-	tfMSecs := int64(utils2.TFToSecs(i.Timeframe) * 1000)
+	tfMSecs := int64(core.TFToSecs(i.Timeframe) * 1000)
 	timeMS := int64(math.Round(float64(i.EnterAt)/float64(tfMSecs))) * tfMSecs
 	return i.key(timeMS)
 }
@@ -206,7 +206,9 @@ func (i *InOutOrder) UpdateProfits(price float64) {
 	if i.Exit != nil && !math.IsNaN(i.Exit.Fee) && !math.IsInf(i.Exit.Fee, 0) {
 		exitFee = i.Exit.Fee
 	}
-	i.Profit = profitVal - enterFee - exitFee
+	// Settled funding fees of contracts are part of the profit
+	// 合约已结算的资金费计入利润
+	i.Profit = profitVal - enterFee - exitFee + i.GetInfoFloat64(OdInfoFunding)
 	entPrice := i.InitPrice
 	if i.Enter.Average > 0 {
 		entPrice = i.Enter.Average
@@ -237,21 +239,11 @@ Calculates commission for entry/exit orders. Must be called after Filled is assi
 为入场/出场订单计算手续费，必须在Filled赋值后调用，否则计算为空
 */
 func (i *InOutOrder) UpdateFee(price float64, forEnter bool, isHistory bool) *errs.Error {
-	exchange := exg.Default
 	exOrder := i.Enter
 	if !forEnter {
 		exOrder = i.Exit
 	}
-	var maker = false
-	if exOrder.OrderType != banexg.OdTypeMarket {
-		if isHistory {
-			// 历史已完成订单，不使用当前价格判断是否为maker，直接认为maker
-			maker = true
-		} else {
-			maker = core.IsMaker(i.Symbol, exOrder.Side, price)
-		}
-	}
-	fee, err := exchange.CalculateFee(i.Symbol, exOrder.OrderType, exOrder.Side, exOrder.Filled, price, maker, nil)
+	fee, err := i.CalcFee(exOrder, exOrder.Filled, price, isHistory)
 	if err != nil {
 		return err
 	}
@@ -265,6 +257,24 @@ func (i *InOutOrder) UpdateFee(price float64, forEnter bool, isHistory bool) *er
 	return nil
 }
 
+/*
+CalcFee
+Calculate the fee of exOrder filling amount at price, exOrder can be Enter, Exit or a leg of the order.
+计算exOrder以price成交amount的手续费，exOrder可以是Enter、Exit或订单的加仓腿
+*/
+func (i *InOutOrder) CalcFee(exOrder *ExOrder, amount, price float64, isHistory bool) (*banexg.Fee, *errs.Error) {
+	var maker = false
+	if exOrder.OrderType != banexg.OdTypeMarket {
+		if isHistory {
+			// 历史已完成订单，不使用当前价格判断是否为maker，直接认为maker
+			maker = true
+		} else {
+			maker = core.IsMaker(i.Symbol, exOrder.Side, price)
+		}
+	}
+	return exg.Default.CalculateFee(i.Symbol, exOrder.OrderType, exOrder.Side, amount, price, maker, nil)
+}
+
 func (i *InOutOrder) CanClose() bool {
 	if i.ExitTag != "" {
 		return false
@@ -272,7 +282,7 @@ func (i *InOutOrder) CanClose() bool {
 	if i.Timeframe == "ws" {
 		return true
 	}
-	tfMSecs := int64(utils2.TFToSecs(i.Timeframe) * 1000)
+	tfMSecs := int64(core.TFToSecs(i.Timeframe) * 1000)
 	return float64(btime.TimeMS()-i.RealEnterMS()) > float64(tfMSecs)*0.9
 }
 
@@ -420,6 +430,17 @@ func (i *InOutOrder) CutPart(enterAmt, exitAmt float64) *InOutOrder {
 	for key, val := range i.Info {
 		part.Info[key] = val
 	}
+	// Added legs are kept in the original order only
+	// 加仓腿只保留在原订单中
+	delete(part.Info, OdInfoLegs)
+	for _, key := range []string{OdInfoFunding, OdInfoMarginAdd} {
+		// Funding fees and added margin are split by entry amount
+		// 资金费和追加保证金按入场数量拆分
+		if val := i.GetInfoFloat64(key); val != 0 {
+			part.Info[key] = val * enterRate
+			i.SetInfo(key, val-val*enterRate)
+		}
+	}
 	// The enter.at of the original order needs to be+1 to prevent conflicts with sub orders that have been split.
 	// 原来订单的enter_at需要+1，防止和拆分的子订单冲突。
 	i.EnterAt += 1
@@ -597,7 +618,7 @@ func (i *InOutOrder) GetInfoText() (string, *errs.Error) {
 func (i *InOutOrder) SetExitTrigger(key string, args *ExitTrigger) {
 	var empty *TriggerState
 	tg := utils2.GetMapVal(i.Info, key, empty)
-	if args == nil || args.Price == 0 {
+	if args == nil || args.Price == 0 && !args.IsTrailing() {
 		if tg != nil && tg.OrderId != "" {
 			tg.ExitTrigger = &ExitTrigger{}
 			i.SetInfo(key, tg)
@@ -611,10 +632,13 @@ func (i *InOutOrder) SetExitTrigger(key string, args *ExitTrigger) {
 	var rangeVal float64
 	if args.Limit != 0 {
 		rangeVal = math.Abs(i.InitPrice - args.Limit)
-	} else {
+	} else if args.Price != 0 {
 		rangeVal = math.Abs(i.InitPrice - args.Price)
 	}
 	tg.Range = rangeVal
+	if !args.IsTrailing() {
+		tg.Extreme = 0
+	}
 	tg.ExitTrigger = args
 	i.SetInfo(key, tg)
 }
@@ -641,6 +665,135 @@ func (i *InOutOrder) GetTakeProfit() *TriggerState {
 	return i.GetExitTrigger(OdInfoTakeProfit)
 }
 
+/*
+GetLegs
+Return the added entry legs of the order, the first entry is always i.Enter and not included.
+返回订单加仓的入场腿，首次入场始终是i.Enter且不包含在内
+*/
+func (i *InOutOrder) GetLegs() []*ExOrder {
+	i.loadInfo()
+	var empty []*ExOrder
+	return utils2.GetMapVal(i.Info, OdInfoLegs, empty)
+}
+
+/*
+PendingLeg
+Return the last leg which is not closed, nil if none.
+返回最后一个未关闭的加仓腿，没有时返回nil
+*/
+func (i *InOutOrder) PendingLeg() *ExOrder {
+	legs := i.GetLegs()
+	if len(legs) > 0 && legs[len(legs)-1].Status < OdStatusClosed {
+		return legs[len(legs)-1]
+	}
+	return nil
+}
+
+func (i *InOutOrder) GetLeg(orderID string) *ExOrder {
+	if orderID == "" {
+		return nil
+	}
+	for _, leg := range i.GetLegs() {
+		if leg.OrderID == orderID {
+			return leg
+		}
+	}
+	return nil
+}
+
+/*
+AddLeg
+Add a new entry leg for adding position to the order, the filled part is merged into i.Enter by FillLeg.
+为订单添加一个加仓入场腿，成交部分通过FillLeg合并到i.Enter
+*/
+func (i *InOutOrder) AddLeg(odType string, price, amount float64) *ExOrder {
+	leg := &ExOrder{
+		TaskID:    i.TaskID,
+		InoutID:   i.ID,
+		Symbol:    i.Symbol,
+		Enter:     true,
+		OrderType: odType,
+		Side:      i.Enter.Side,
+		CreateAt:  btime.TimeMS(),
+		Price:     price,
+		Amount:    amount,
+		Status:    OdStatusInit,
+	}
+	// slice can't be compared in SetInfo, set directly 切片无法在SetInfo中比较，直接设置
+	i.Info[OdInfoLegs] = append(i.GetLegs(), leg)
+	i.DirtyInfo = true
+	return leg
+}
+
+/*
+FillLeg
+Update the leg with cumulative filled, average and fee, the new filled part is merged into i.Enter with weighted
+average price, QuoteCost is increased, and the range of stop loss and take profit are recomputed.
+以累计的成交量、均价、手续费更新加仓腿，新成交部分按加权均价合并到i.Enter，增加QuoteCost，并重新计算止损止盈区间
+*/
+func (i *InOutOrder) FillLeg(leg *ExOrder, filled, average, fee float64) {
+	delta := filled - leg.Filled
+	if delta > 0 {
+		price := (average*filled - leg.Average*leg.Filled) / delta
+		entFilled := i.Enter.Filled + delta
+		i.Enter.Average = (i.Enter.Average*i.Enter.Filled + price*delta) / entFilled
+		i.Enter.Filled = entFilled
+		i.Enter.Amount += delta
+		i.QuoteCost += price * delta
+		for _, key := range []string{OdInfoStopLoss, OdInfoTakeProfit} {
+			tg := i.GetExitTrigger(key)
+			if tg == nil || tg.ExitTrigger == nil || tg.Price == 0 {
+				continue
+			}
+			exitPrice := tg.Price
+			if tg.Limit != 0 {
+				exitPrice = tg.Limit
+			}
+			tg.Range = math.Abs(i.Enter.Average - exitPrice)
+			// position amount changed, trigger orders on exchange should be replaced
+			// 持仓数量变化，交易所的触发订单需要重新提交
+			tg.Old = nil
+		}
+		i.DirtyMain = true
+	}
+	i.Enter.Fee += fee - leg.Fee
+	leg.Filled = filled
+	leg.Average = average
+	leg.Fee = fee
+	leg.FeeType = i.Enter.FeeType
+	if leg.Filled > 0 && leg.Status == OdStatusInit {
+		leg.Status = OdStatusPartOK
+	}
+	i.DirtyEnter = true
+	i.DirtyInfo = true
+}
+
+/*
+CancelLeg
+Close the pending leg and keep only the filled part, the leg is removed if nothing filled.
+关闭未完成的加仓腿，只保留已成交部分，未成交时删除此腿
+*/
+func (i *InOutOrder) CancelLeg() {
+	leg := i.PendingLeg()
+	if leg == nil {
+		return
+	}
+	if leg.Filled == 0 {
+		legs := i.GetLegs()
+		if len(legs) == 1 {
+			i.SetInfo(OdInfoLegs, nil)
+		} else {
+			i.Info[OdInfoLegs] = legs[:len(legs)-1]
+			i.DirtyInfo = true
+		}
+	} else {
+		leg.Amount = leg.Filled
+		leg.Status = OdStatusClosed
+		i.DirtyInfo = true
+	}
+	i.SetInfo(OdInfoStopAfter, nil)
+}
+
 /*
 ClientId
 Generate the exchange's ClientOrderId
@@ -878,11 +1031,11 @@ func (i *ExOrder) CutPart(rate float64, fill bool) *ExOrder {
 		Price:     i.Price,
 		Average:   i.Average,
 		Amount:    i.Amount * rate,
-		Fee:       i.Fee,
 		FeeType:   i.FeeType,
 		UpdateAt:  i.UpdateAt,
 	}
 	i.Amount -= part.Amount
+	orgFilled := i.Filled
 	if fill && i.Filled > 0 {
 		if i.Filled <= part.Amount {
 			part.Filled = i.Filled
@@ -895,6 +1048,12 @@ func (i *ExOrder) CutPart(rate float64, fill bool) *ExOrder {
 		part.Filled = i.Filled - i.Amount
 		i.Filled = i.Amount
 	}
+	if part.Filled > 0 {
+		// The fee is split by filled amount
+		// 手续费按成交数量拆分
+		part.Fee = i.Fee * part.Filled / orgFilled
+		i.Fee -= part.Fee
+	}
 	if part.Filled >= part.Amount {
 		part.Status = OdStatusClosed
 	} else if part.Filled > 0 {
@@ -1200,6 +1359,10 @@ func (s *TriggerState) SaveOld() {
 		s.Old.Price = s.Price
 		s.Old.Limit = s.Limit
 		s.Old.Rate = s.Rate
+		s.Old.Activate = s.Activate
+		s.Old.Callback = s.Callback
+		s.Old.Offset = s.Offset
+		s.Old.Step = s.Step
 		if s.Tag != "" {
 			s.Old.Tag = s.Tag
 		}
@@ -1211,26 +1374,75 @@ func (s *TriggerState) Clone() *TriggerState {
 		return nil
 	}
 	return &TriggerState{
-		ExitTrigger: &ExitTrigger{
-			Price: s.Price,
-			Limit: s.Limit,
-			Rate:  s.Rate,
-			Tag:   s.Tag,
-		},
-		Range:   s.Rate,
-		Hit:     s.Hit,
-		OrderId: s.OrderId,
+		ExitTrigger: s.ExitTrigger.Clone(),
+		Range:       s.Range,
+		Hit:         s.Hit,
+		OrderId:     s.OrderId,
+		Extreme:     s.Extreme,
+	}
+}
+
+/*
+TrailTo
+Update the trailing stop by the latest price, return whether Price was moved.
+Trailing starts after price reaches Activate; then Price follows the best price by Callback (or Offset),
+and only moves toward profit by at least Step.
+使用最新价格更新跟踪止损，返回Price是否被移动。
+价格达到Activate后开始跟踪；之后Price按Callback(或Offset)跟随最优价格，且仅向盈利方向移动至少Step。
+*/
+func (s *TriggerState) TrailTo(short bool, price float64) bool {
+	if s == nil || !s.IsTrailing() || price <= 0 {
+		return false
+	}
+	if s.Extreme == 0 {
+		if s.Activate > 0 && (short && price > s.Activate || !short && price < s.Activate) {
+			return false
+		}
+		s.Extreme = price
+	} else if short && price < s.Extreme || !short && price > s.Extreme {
+		s.Extreme = price
+	}
+	var stop float64
+	if s.Callback > 0 {
+		if short {
+			stop = s.Extreme * (1 + s.Callback)
+		} else {
+			stop = s.Extreme * (1 - s.Callback)
+		}
+	} else if short {
+		stop = s.Extreme + s.Offset
+	} else {
+		stop = s.Extreme - s.Offset
+	}
+	if stop <= 0 {
+		return false
+	}
+	if s.Price > 0 {
+		move := stop - s.Price
+		if short {
+			move = -move
+		}
+		if move <= 0 || move < s.Step {
+			return false
+		}
 	}
+	s.Price = stop
+	return true
+}
+
+// IsTrailing whether this is a trailing trigger 是否为跟踪触发
+func (t *ExitTrigger) IsTrailing() bool {
+	return t != nil && (t.Callback > 0 || t.Offset > 0)
 }
 
 func (t *ExitTrigger) Equal(o *ExitTrigger) bool {
 	if t == nil || o == nil {
 		return (t != nil) == (o != nil)
 	}
-	if t.Price != o.Price || t.Limit != o.Limit || t.Rate != o.Limit {
+	if t.Price != o.Price || t.Limit != o.Limit || t.Rate != o.Rate {
 		return false
 	}
-	return true
+	return t.Activate == o.Activate && t.Callback == o.Callback && t.Offset == o.Offset && t.Step == o.Step
 }
 
 func (t *ExitTrigger) Clone() *ExitTrigger {
@@ -1238,10 +1450,14 @@ func (t *ExitTrigger) Clone() *ExitTrigger {
 		return nil
 	}
 	return &ExitTrigger{
-		Price: t.Price,
-		Limit: t.Limit,
-		Rate:  t.Rate,
-		Tag:   t.Tag,
+		Price:    t.Price,
+		Limit:    t.Limit,
+		Rate:     t.Rate,
+		Tag:      t.Tag,
+		Activate: t.Activate,
+		Callback: t.Callback,
+		Offset:   t.Offset,
+		Step:     t.Step,
 	}
 }
 
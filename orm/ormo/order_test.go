@@ -1,11 +1,15 @@
 package ormo
 
 import (
+	"math"
 	"path/filepath"
 	"testing"
 
+	"github.com/banbox/banbot/btime"
 	"github.com/banbox/banbot/config"
+	"github.com/banbox/banbot/core"
 	"github.com/banbox/banbot/orm"
+	"github.com/banbox/banexg"
 	"github.com/banbox/banexg/errs"
 )
 
@@ -27,3 +31,49 @@ func TestGetOrders(t *testing.T) {
 	defer conn.Close()
 	sess.GetOrders(GetOrdersArgs{})
 }
+
+func TestExOrderCutPartFee(t *testing.T) {
+	near := func(a, b float64) bool {
+		return math.Abs(a-b) < 1e-9
+	}
+	od := &ExOrder{Amount: 4, Filled: 4, Fee: 0.4, Status: OdStatusClosed}
+	part := od.CutPart(0.25, true)
+	if part.Filled != 1 || !near(part.Fee, 0.1) || od.Filled != 3 || !near(od.Fee, 0.3) {
+		t.Fatalf("fee should be split by filled amount, part: %v/%v, left: %v/%v", part.Filled, part.Fee,
+			od.Filled, od.Fee)
+	}
+	od = &ExOrder{Amount: 4, Filled: 1, Fee: 0.1, Status: OdStatusPartOK}
+	part = od.CutPart(0.5, false)
+	if part.Filled != 0 || part.Fee != 0 || od.Fee != 0.1 {
+		t.Fatalf("unfilled part should take no fee, part: %v, left: %v", part.Fee, od.Fee)
+	}
+}
+
+func TestAltBarOrderExit(t *testing.T) {
+	oldTime, oldMode := btime.CurTimeMS, core.BackTestMode
+	core.BackTestMode = true
+	defer func() {
+		btime.CurTimeMS = oldTime
+		core.BackTestMode = oldMode
+	}()
+	// orders of non-time bars use core.AltBarBaseTF for holding time
+	enterAt := int64(1704067230000)
+	od := &InOutOrder{IOrder: &IOrder{Symbol: "BTC/USDT", Timeframe: "vol:25", EnterAt: enterAt,
+		Status: InOutStatusFullEnter}, Enter: &ExOrder{Side: banexg.OdSideBuy, Amount: 1, Filled: 1,
+		Price: 100, Average: 100, Status: OdStatusClosed}}
+	if key := od.KeyAlign(); key != od.key(1704067260000) {
+		t.Fatalf("enter time should be aligned to %s, got %s", core.AltBarBaseTF, key)
+	}
+	btime.CurTimeMS = enterAt + 30000
+	if od.CanClose() {
+		t.Fatal("order should not be closed within the base timeframe")
+	}
+	btime.CurTimeMS = enterAt + 60000
+	if !od.CanClose() {
+		t.Fatal("order should be closable after the base timeframe")
+	}
+	od.SetExit(core.ExitTagUserExit, banexg.OdTypeMarket, 0)
+	if od.Exit == nil || od.ExitTag != core.ExitTagUserExit || od.ExitAt != btime.CurTimeMS {
+		t.Fatalf("exit not set: %v %v", od.ExitTag, od.ExitAt)
+	}
+}
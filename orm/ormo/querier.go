@@ -17,10 +17,12 @@ type Querier interface {
 	GetIOrder(ctx context.Context, id int64) (*IOrder, error)
 	GetTask(ctx context.Context, id int64) (*BotTask, error)
 	GetTaskPairs(ctx context.Context, arg GetTaskPairsParams) ([]string, error)
+	ListStratStates(ctx context.Context, taskID int64) ([]*StratState, error)
 	ListTaskPairs(ctx context.Context, arg ListTaskPairsParams) ([]string, error)
 	ListTasks(ctx context.Context) ([]*BotTask, error)
 	SetExOrder(ctx context.Context, arg SetExOrderParams) error
 	SetIOrder(ctx context.Context, arg SetIOrderParams) error
+	SetStratState(ctx context.Context, arg SetStratStateParams) error
 }
 
 var _ Querier = (*Queries)(nil)
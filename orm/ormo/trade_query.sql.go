@@ -318,6 +318,42 @@ func (q *Queries) GetTaskPairs(ctx context.Context, arg GetTaskPairsParams) ([]s
 	return items, nil
 }
 
+const listStratStates = `-- name: ListStratStates :many
+select id, task_id, strategy, symbol, timeframe, update_at, data from stratstate
+where task_id = ?
+`
+
+func (q *Queries) ListStratStates(ctx context.Context, taskID int64) ([]*StratState, error) {
+	rows, err := q.db.QueryContext(ctx, listStratStates, taskID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []*StratState
+	for rows.Next() {
+		var i StratState
+		if err := rows.Scan(
+			&i.ID,
+			&i.TaskID,
+			&i.Strategy,
+			&i.Symbol,
+			&i.Timeframe,
+			&i.UpdateAt,
+			&i.Data,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const listTaskPairs = `-- name: ListTaskPairs :many
 select symbol from iorder
 where task_id = ?
@@ -529,3 +565,31 @@ func (q *Queries) SetIOrder(ctx context.Context, arg SetIOrderParams) error {
 	)
 	return err
 }
+
+const setStratState = `-- name: SetStratState :exec
+insert into stratstate ("task_id", "strategy", "symbol", "timeframe", "update_at", "data")
+values (?, ?, ?, ?, ?, ?)
+on conflict ("task_id", "strategy", "symbol", "timeframe")
+do update set "update_at" = excluded."update_at", "data" = excluded."data"
+`
+
+type SetStratStateParams struct {
+	TaskID    int64  `json:"task_id"`
+	Strategy  string `json:"strategy"`
+	Symbol    string `json:"symbol"`
+	Timeframe string `json:"timeframe"`
+	UpdateAt  int64  `json:"update_at"`
+	Data      string `json:"data"`
+}
+
+func (q *Queries) SetStratState(ctx context.Context, arg SetStratStateParams) error {
+	_, err := q.db.ExecContext(ctx, setStratState,
+		arg.TaskID,
+		arg.Strategy,
+		arg.Symbol,
+		arg.Timeframe,
+		arg.UpdateAt,
+		arg.Data,
+	)
+	return err
+}
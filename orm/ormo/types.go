@@ -5,6 +5,12 @@ type ExitTrigger struct {
 	Limit float64 `json:"limit,omitempty"` // Submit limit order price after triggering, otherwise market order. 触发后提交限价单价格，否则市价单
 	Rate  float64 `json:"rate,omitempty"`  // Stop-profit and stop-loss ratio, (0,1], 0 means all. 止盈止损比例，(0,1]，0表示全部
 	Tag   string  `json:"tag,omitempty"`   // Reason, used for ExitTag. 原因，用于ExitTag
+	// Trailing parameters below, only used for stop loss. Price is moved to follow the best price after activation.
+	// 以下为跟踪参数，仅用于止损。激活后Price跟随最优价格移动。
+	Activate float64 `json:"activate,omitempty"` // Activation price, trailing starts after price reaches it, 0 means immediately. 激活价格，价格达到后开始跟踪，0表示立即
+	Callback float64 `json:"callback,omitempty"` // Callback rate from the best price, (0,1) 相对最优价格的回调比例，(0,1)
+	Offset   float64 `json:"offset,omitempty"`   // Callback price distance, used when Callback is 0. 回调价格距离，Callback为0时使用
+	Step     float64 `json:"step,omitempty"`     // Min price improvement to move the stop. 移动止损价格的最小变化
 }
 
 type TriggerState struct {
@@ -12,5 +18,6 @@ type TriggerState struct {
 	Range   float64      `json:"range,omitempty"` // The stop-profit and stop-loss range is the range from the entry price to the exit price. 止盈止损区间，入场价格到离场价格的区间
 	Hit     bool         `json:"hit,omitempty"`   // whether trigger price has been triggered? 是否已触发
 	OrderId string       `json:"order_id,omitempty"`
+	Extreme float64      `json:"extreme,omitempty"` // Best price since trailing activated, 0 means not activated. 跟踪激活后的最优价格，0表示未激活
 	Old     *ExitTrigger `json:"old,omitempty"`
 }
@@ -22,6 +22,12 @@ type AddCalendarsParams struct {
 	StopMs  int64  `json:"stop_ms"`
 }
 
+type AddFundingRatesParams struct {
+	Sid    int32   `json:"sid"`
+	TimeMs int64   `json:"time_ms"`
+	Rate   float64 `json:"rate"`
+}
+
 const addInsKline = `-- name: AddInsKline :one
 insert into ins_kline ("sid", "timeframe", "start_ms", "stop_ms")
 values ($1, $2, $3, $4) RETURNING id
@@ -226,6 +232,38 @@ func (q *Queries) GetAllInsKlines(ctx context.Context) ([]*InsKline, error) {
 	return items, nil
 }
 
+const getFundingRates = `-- name: GetFundingRates :many
+select sid, time_ms, rate from funding_rates
+where sid=$1 and time_ms >= $2 and time_ms < $3
+order by time_ms
+`
+
+type GetFundingRatesParams struct {
+	Sid      int32 `json:"sid"`
+	TimeMs   int64 `json:"time_ms"`
+	TimeMs_2 int64 `json:"time_ms_2"`
+}
+
+func (q *Queries) GetFundingRates(ctx context.Context, arg GetFundingRatesParams) ([]*FundingRate, error) {
+	rows, err := q.db.Query(ctx, getFundingRates, arg.Sid, arg.TimeMs, arg.TimeMs_2)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	items := []*FundingRate{}
+	for rows.Next() {
+		var i FundingRate
+		if err := rows.Scan(&i.Sid, &i.TimeMs, &i.Rate); err != nil {
+			return nil, err
+		}
+		items = append(items, &i)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const getInsKline = `-- name: GetInsKline :one
 select id, sid, timeframe, start_ms, stop_ms from ins_kline
 where sid=$1
@@ -288,6 +326,20 @@ func (q *Queries) GetKHoles(ctx context.Context, arg GetKHolesParams) ([]*KHole,
 	return items, nil
 }
 
+const getLastFundingMS = `-- name: GetLastFundingMS :one
+select time_ms from funding_rates
+where sid=$1
+order by time_ms desc
+limit 1
+`
+
+func (q *Queries) GetLastFundingMS(ctx context.Context, sid int32) (int64, error) {
+	row := q.db.QueryRow(ctx, getLastFundingMS, sid)
+	var time_ms int64
+	err := row.Scan(&time_ms)
+	return time_ms, err
+}
+
 const listExchanges = `-- name: ListExchanges :many
 select distinct exchange from exsymbol
 `
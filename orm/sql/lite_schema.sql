@@ -0,0 +1,181 @@
+-- Schema of the embedded sqlite kline store, same tables as pg_schema.sql + pg_schema2.sql without TimescaleDB
+-- 嵌入式sqlite K线存储的表结构，和pg_schema.sql + pg_schema2.sql一致，但不依赖TimescaleDB
+
+-- ----------------------------
+-- Table structure for khole
+-- ----------------------------
+CREATE TABLE IF NOT EXISTS khole
+(
+    id        INTEGER PRIMARY KEY AUTOINCREMENT,
+    sid       INTEGER NOT NULL,
+    timeframe TEXT    NOT NULL,
+    start     INTEGER NOT NULL,
+    stop      INTEGER NOT NULL,
+    no_data   BOOL    NOT NULL DEFAULT FALSE
+);
+CREATE INDEX IF NOT EXISTS idx_khole_sid_tf ON khole (sid, timeframe);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_khole_sid_tf_start ON khole (sid, timeframe, start);
+
+-- ----------------------------
+-- Table structure for kinfo
+-- ----------------------------
+CREATE TABLE IF NOT EXISTS kinfo
+(
+    sid       INTEGER NOT NULL,
+    timeframe TEXT    NOT NULL,
+    start     INTEGER NOT NULL,
+    stop      INTEGER NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_kinfo_sid_tf ON kinfo (sid, timeframe);
+
+-- ----------------------------
+-- Table structure for kline_un
+-- ----------------------------
+CREATE TABLE IF NOT EXISTS kline_un
+(
+    sid       INTEGER NOT NULL,
+    start_ms  INTEGER NOT NULL,
+    stop_ms   INTEGER NOT NULL,
+    timeframe TEXT    NOT NULL,
+    open      REAL    NOT NULL,
+    high      REAL    NOT NULL,
+    low       REAL    NOT NULL,
+    close     REAL    NOT NULL,
+    volume    REAL    NOT NULL,
+    info      REAL    NOT NULL
+);
+CREATE UNIQUE INDEX IF NOT EXISTS kline_un_sid_tf_idx ON kline_un (sid, timeframe);
+
+-- ----------------------------
+-- Table structure for exsymbol
+-- ----------------------------
+CREATE TABLE IF NOT EXISTS exsymbol
+(
+    id        INTEGER PRIMARY KEY AUTOINCREMENT,
+    exchange  TEXT    NOT NULL,
+    exg_real  TEXT    NOT NULL,
+    market    TEXT    NOT NULL,
+    symbol    TEXT    NOT NULL,
+    combined  BOOL    NOT NULL DEFAULT FALSE,
+    list_ms   INTEGER NOT NULL DEFAULT 0,
+    delist_ms INTEGER NOT NULL DEFAULT 0
+);
+CREATE UNIQUE INDEX IF NOT EXISTS ix_exsymbol_unique ON exsymbol (exchange, market, symbol);
+
+-- ----------------------------
+-- Table structure for calendars
+-- ----------------------------
+CREATE TABLE IF NOT EXISTS calendars
+(
+    id       INTEGER PRIMARY KEY AUTOINCREMENT,
+    name     TEXT    NOT NULL,
+    start_ms INTEGER NOT NULL,
+    stop_ms  INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_calendar_name ON calendars (name);
+
+-- ----------------------------
+-- Table structure for adj_factors
+-- ----------------------------
+CREATE TABLE IF NOT EXISTS adj_factors
+(
+    id       INTEGER PRIMARY KEY AUTOINCREMENT,
+    sid      INTEGER NOT NULL,
+    sub_id   INTEGER NOT NULL,
+    start_ms INTEGER NOT NULL,
+    factor   REAL    NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_adj_factors_sid ON adj_factors (sid);
+CREATE INDEX IF NOT EXISTS idx_adj_factors_start ON adj_factors (start_ms);
+
+-- ----------------------------
+-- Table structure for ins_kline
+-- ----------------------------
+CREATE TABLE IF NOT EXISTS ins_kline
+(
+    id        INTEGER PRIMARY KEY AUTOINCREMENT,
+    sid       INTEGER NOT NULL,
+    timeframe TEXT    NOT NULL,
+    start_ms  INTEGER NOT NULL,
+    stop_ms   INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS idx_ins_kline_sid ON ins_kline (sid);
+
+-- ----------------------------
+-- Table structure for funding_rates
+-- ----------------------------
+CREATE TABLE IF NOT EXISTS funding_rates
+(
+    sid     INTEGER NOT NULL,
+    time_ms INTEGER NOT NULL,
+    rate    REAL    NOT NULL,
+    PRIMARY KEY (sid, time_ms)
+) WITHOUT ROWID;
+
+-- ----------------------------
+-- Table structure for kline_1m/5m/15m/1h/1d
+-- ----------------------------
+CREATE TABLE IF NOT EXISTS kline_1m
+(
+    sid    INTEGER NOT NULL,
+    time   INTEGER NOT NULL,
+    open   REAL    NOT NULL,
+    high   REAL    NOT NULL,
+    low    REAL    NOT NULL,
+    close  REAL    NOT NULL,
+    volume REAL    NOT NULL,
+    info   REAL    NOT NULL,
+    PRIMARY KEY (sid, time)
+) WITHOUT ROWID;
+
+CREATE TABLE IF NOT EXISTS kline_5m
+(
+    sid    INTEGER NOT NULL,
+    time   INTEGER NOT NULL,
+    open   REAL    NOT NULL,
+    high   REAL    NOT NULL,
+    low    REAL    NOT NULL,
+    close  REAL    NOT NULL,
+    volume REAL    NOT NULL,
+    info   REAL    NOT NULL,
+    PRIMARY KEY (sid, time)
+) WITHOUT ROWID;
+
+CREATE TABLE IF NOT EXISTS kline_15m
+(
+    sid    INTEGER NOT NULL,
+    time   INTEGER NOT NULL,
+    open   REAL    NOT NULL,
+    high   REAL    NOT NULL,
+    low    REAL    NOT NULL,
+    close  REAL    NOT NULL,
+    volume REAL    NOT NULL,
+    info   REAL    NOT NULL,
+    PRIMARY KEY (sid, time)
+) WITHOUT ROWID;
+
+CREATE TABLE IF NOT EXISTS kline_1h
+(
+    sid    INTEGER NOT NULL,
+    time   INTEGER NOT NULL,
+    open   REAL    NOT NULL,
+    high   REAL    NOT NULL,
+    low    REAL    NOT NULL,
+    close  REAL    NOT NULL,
+    volume REAL    NOT NULL,
+    info   REAL    NOT NULL,
+    PRIMARY KEY (sid, time)
+) WITHOUT ROWID;
+
+CREATE TABLE IF NOT EXISTS kline_1d
+(
+    sid    INTEGER NOT NULL,
+    time   INTEGER NOT NULL,
+    open   REAL    NOT NULL,
+    high   REAL    NOT NULL,
+    low    REAL    NOT NULL,
+    close  REAL    NOT NULL,
+    volume REAL    NOT NULL,
+    info   REAL    NOT NULL,
+    PRIMARY KEY (sid, time)
+) WITHOUT ROWID;
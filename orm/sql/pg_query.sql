@@ -82,6 +82,22 @@ order by start_ms;
 delete from adj_factors
 where sid=$1;
 
+-- name: AddFundingRates :copyfrom
+insert into funding_rates
+(sid, time_ms, rate)
+values ($1, $2, $3);
+
+-- name: GetFundingRates :many
+select * from funding_rates
+where sid=$1 and time_ms >= $2 and time_ms < $3
+order by time_ms;
+
+-- name: GetLastFundingMS :one
+select time_ms from funding_rates
+where sid=$1
+order by time_ms desc
+limit 1;
+
 
 
 -- name: GetInsKline :one
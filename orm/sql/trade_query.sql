@@ -99,3 +99,13 @@ update exorder set
                    "update_at" = ?
 where id = ?;
 
+
+-- name: ListStratStates :many
+select * from stratstate
+where task_id = ?;
+
+-- name: SetStratState :exec
+insert into stratstate ("task_id", "strategy", "symbol", "timeframe", "update_at", "data")
+values (?, ?, ?, ?, ?, ?)
+on conflict ("task_id", "strategy", "symbol", "timeframe")
+do update set "update_at" = excluded."update_at", "data" = excluded."data";
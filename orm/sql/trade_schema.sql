@@ -73,3 +73,20 @@ CREATE TABLE iorder
 
 CREATE INDEX idx_io_status  ON iorder (status);
 CREATE INDEX idx_io_task_id ON iorder (task_id);
+
+-- ----------------------------
+-- Table structure for stratstate
+-- ----------------------------
+--DROP TABLE IF EXISTS stratstate;
+CREATE TABLE stratstate
+(
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    task_id     INTEGER NOT NULL,
+    strategy    TEXT    NOT NULL,
+    symbol      TEXT    NOT NULL,
+    timeframe   TEXT    NOT NULL,
+    update_at   INTEGER NOT NULL,
+    data        TEXT    NOT NULL
+);
+
+CREATE UNIQUE INDEX idx_ss_key ON stratstate (task_id, strategy, symbol, timeframe);
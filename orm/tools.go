@@ -650,6 +650,9 @@ func ImportData(dataDir string, numWorkers int, pb *utils2.StagedPrg) *errs.Erro
 	if err = importCalendars(sess, exInfo.Calendars); err != nil {
 		return err
 	}
+	if err = importKHoles(sess, idMap, exInfo.KHoles); err != nil {
+		return err
+	}
 
 	// Get all .dat files in the directory
 	files, err_ := filepath.Glob(filepath.Join(dataDir, "kline*.dat"))
@@ -950,11 +953,34 @@ func importSymbols(items []*ExSymbolBlock) (map[int32]int32, *errs.Error) {
 			return nil, err
 		}
 		log.Info("symbols import ok", zap.Int("num", len(addExs)))
+		sess, conn, err := Conn(nil)
+		if err != nil {
+			return nil, err
+		}
+		defer conn.Release()
 		for i, exs := range addExs {
 			if exs.ID == 0 {
 				return nil, errs.NewMsg(errs.CodeRunTime, "add ExSymbol fail: %v", exs.Symbol)
 			}
-			idMap[addItems[i].Id] = exs.ID
+			it := addItems[i]
+			idMap[it.Id] = exs.ID
+			if it.ListMs == 0 && it.DelistMs == 0 {
+				continue
+			}
+			// AddSymbols does not save list dates, set them here
+			// AddSymbols不保存上市日期，这里设置
+			err_ := sess.SetListMS(context.Background(), SetListMSParams{
+				ID:       exs.ID,
+				ListMs:   it.ListMs,
+				DelistMs: it.DelistMs,
+			})
+			if err_ != nil {
+				return nil, NewDbErr(core.ErrDbExecFail, err_)
+			}
+			if item := GetSymbolByID(exs.ID); item != nil {
+				item.ListMs = it.ListMs
+				item.DelistMs = it.DelistMs
+			}
 		}
 	}
 	return idMap, nil
@@ -983,14 +1009,16 @@ func importAdjFactors(sess *Queries, idMap map[int32]int32, items []*AdjFactorBl
 		})
 	}
 	addNum := 0
-	defer log.Info("adjFactors import ok", zap.Int("num", addNum))
+	defer func() {
+		log.Info("adjFactors import ok", zap.Int("num", addNum))
+	}()
 	for sid, arr := range idArr {
 		olds, err := sess.GetAdjs(sid)
 		if err != nil {
 			return err
 		}
 		var valids = make([]*AdjFactor, 0, len(arr))
-		if len(olds) == 0 {
+		if len(olds) > 0 {
 			start := olds[0].StartMS
 			end := olds[len(olds)-1].StopMS
 			for _, v := range arr {
@@ -1016,8 +1044,61 @@ func importAdjFactors(sess *Queries, idMap map[int32]int32, items []*AdjFactorBl
 				return errs.New(core.ErrDbExecFail, err_)
 			}
 			addNum += len(adds)
+			// drop the cache loaded by GetAdjs above
+			// 删除上面GetAdjs加载的缓存
+			amLock.Lock()
+			delete(adjMap, sid)
+			amLock.Unlock()
+		}
+	}
+	return nil
+}
+
+/*
+importKHoles
+Import the holes without data of the exported klines, so they are not regarded as missing data
+导入已导出K线中无数据的空洞，避免被视为缺失数据
+*/
+func importKHoles(sess *Queries, idMap map[int32]int32, items []*KHoleBlock) *errs.Error {
+	if len(items) == 0 {
+		return nil
+	}
+	ctx := context.Background()
+	addNum := 0
+	for _, it := range items {
+		sid, ok := idMap[it.Sid]
+		if !ok {
+			return errs.NewMsg(errs.CodeRunTime, "sid unknown: %v", it.Sid)
+		}
+		if len(it.Holes) < 2 {
+			continue
+		}
+		olds, err_ := sess.GetKHoles(ctx, GetKHolesParams{Sid: sid, Timeframe: it.Timeframe,
+			Start: it.Holes[0], Stop: it.Holes[len(it.Holes)-1]})
+		if err_ != nil {
+			return NewDbErr(core.ErrDbReadFail, err_)
+		}
+		oldMap := make(map[int64]bool)
+		for _, h := range olds {
+			oldMap[h.Start] = true
+		}
+		adds := make([]AddKHolesParams, 0, len(it.Holes)/2)
+		for i := 0; i+1 < len(it.Holes); i += 2 {
+			if _, ok = oldMap[it.Holes[i]]; ok {
+				continue
+			}
+			adds = append(adds, AddKHolesParams{Sid: sid, Timeframe: it.Timeframe, Start: it.Holes[i],
+				Stop: it.Holes[i+1], NoData: true})
+		}
+		if len(adds) > 0 {
+			_, err_ = sess.AddKHoles(ctx, adds)
+			if err_ != nil {
+				return NewDbErr(core.ErrDbExecFail, err_)
+			}
+			addNum += len(adds)
 		}
 	}
+	log.Info("kHoles import ok", zap.Int("num", addNum))
 	return nil
 }
 
@@ -0,0 +1,160 @@
+package rpc
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"strings"
+
+	"github.com/banbox/banbot/btime"
+	utils2 "github.com/banbox/banbot/utils"
+	"github.com/banbox/banexg/log"
+	"github.com/banbox/banexg/utils"
+	"github.com/go-viper/mapstructure/v2"
+	"go.uber.org/zap"
+)
+
+/*
+HttpHook
+Send the rendered webhook payload to any url as json, used to route messages into custom alerting systems.
+The body is rendered from the `body` template, whose string values can use placeholders of payload keys, `msg_type`
+and `account`. When `secret` is set, the hex HMAC-SHA256 of "{timestamp}.{body}" is put in `sign_header`,
+and the millisecond timestamp in X-Timestamp.
+将渲染后的webhook消息以json发送到任意url，用于将消息接入自定义告警系统。
+请求体由`body`模板渲染，其字符串值可使用payload的键、`msg_type`和`account`作为占位符。
+设置`secret`时，将"{timestamp}.{body}"的HMAC-SHA256十六进制签名放入`sign_header`，毫秒时间戳放入X-Timestamp
+*/
+type HttpHook struct {
+	*WebHook
+	httpHookItem
+}
+
+type httpHookItem struct {
+	Url        string                 `mapstructure:"url"`
+	Method     string                 `mapstructure:"method"`
+	Headers    map[string]string      `mapstructure:"headers"`
+	Body       map[string]interface{} `mapstructure:"body"`
+	Secret     string                 `mapstructure:"secret"`
+	SignHeader string                 `mapstructure:"sign_header"`
+}
+
+const (
+	headerTimestamp = "X-Timestamp"
+	keyMsgType      = "msg_type"
+	keyAccount      = "account"
+)
+
+func NewHttpHook(name string, item map[string]interface{}) *HttpHook {
+	hook := NewWebHook(name, item)
+	var cfg httpHookItem
+	err_ := mapstructure.Decode(item, &cfg)
+	if err_ != nil {
+		panic(fmt.Sprintf("rpc_channels.%v is invalid: %v", name, err_))
+	}
+	if cfg.Url == "" {
+		panic(name + ": `url` is required")
+	}
+	cfg.Method = strings.ToUpper(cfg.Method)
+	if cfg.Method == "" {
+		cfg.Method = "POST"
+	} else if cfg.Method != "POST" && cfg.Method != "PUT" {
+		panic(name + ": `method` should be POST or PUT")
+	}
+	if cfg.SignHeader == "" {
+		cfg.SignHeader = "X-Signature"
+	}
+	res := &HttpHook{
+		WebHook:      hook,
+		httpHookItem: cfg,
+	}
+	res.doSendMsgs = makeHttpSendMsg(res)
+	return res
+}
+
+func (h *HttpHook) SendMsg(msgType string, account string, payload map[string]string) bool {
+	// msg type and account are also available in the body template
+	// 消息类型和账户也可在body模板中使用
+	data := maps.Clone(payload)
+	data[keyMsgType] = msgType
+	data[keyAccount] = account
+	return h.WebHook.SendMsg(msgType, account, data)
+}
+
+/*
+renderBody
+Render the json body from the template, return the payload itself if no template
+从模板渲染json请求体，无模板时返回payload本身
+*/
+func (h *HttpHook) renderBody(msg map[string]string) (string, error) {
+	if len(h.Body) == 0 {
+		return utils.MarshalString(msg)
+	}
+	args := make(map[string]interface{}, len(msg))
+	for k, v := range msg {
+		args[k] = v
+	}
+	return utils.MarshalString(renderTpl(h.Body, args))
+}
+
+func renderTpl(val interface{}, args map[string]interface{}) interface{} {
+	switch v := val.(type) {
+	case string:
+		return utils2.FormatWithMap(v, args)
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for key, it := range v {
+			res[key] = renderTpl(it, args)
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for i, it := range v {
+			res[i] = renderTpl(it, args)
+		}
+		return res
+	default:
+		return val
+	}
+}
+
+func signBody(secret string, stamp int64, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(fmt.Sprintf("%d.%s", stamp, body)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func makeHttpSendMsg(h *HttpHook) func([]map[string]string) []map[string]string {
+	return func(msgList []map[string]string) []map[string]string {
+		fails := []map[string]string{}
+		for _, msg := range msgList {
+			body, err_ := h.renderBody(msg)
+			if err_ != nil {
+				log.Error("http hook render body fail", zap.String("name", h.name), zap.Error(err_))
+				continue
+			}
+			headers := maps.Clone(h.Headers)
+			if headers == nil {
+				headers = make(map[string]string)
+			}
+			if h.Secret != "" {
+				stamp := btime.UTCStamp()
+				headers[headerTimestamp] = fmt.Sprintf("%d", stamp)
+				headers[h.SignHeader] = signBody(h.Secret, stamp, body)
+			}
+			rsp := requestWith(h.Method, h.Url, body, headers)
+			if rsp.Status == 0 || rsp.Status == 429 || rsp.Status >= 500 {
+				log.Error("http hook send msg net fail", zap.String("name", h.name), zap.Int("status", rsp.Status),
+					zap.String("rsp", rsp.Content), zap.Error(rsp.Error))
+				fails = append(fails, msg)
+				continue
+			}
+			if rsp.Status >= 300 {
+				log.Warn("http hook send msg fail", zap.String("name", h.name), zap.Int("status", rsp.Status),
+					zap.String("rsp", rsp.Content))
+			}
+		}
+		return fails
+	}
+}
@@ -0,0 +1,62 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/banbox/banbot/core"
+)
+
+func TestHttpHook(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	core.Ctx = ctx
+	got := make(chan map[string]interface{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		stamp, _ := strconv.ParseInt(r.Header.Get(headerTimestamp), 10, 64)
+		if r.Method != "PUT" || r.Header.Get("Authorization") != "Bearer tk" ||
+			r.Header.Get("X-Sign") != signBody("key", stamp, string(data)) {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var body map[string]interface{}
+		_ = json.Unmarshal(data, &body)
+		got <- body
+	}))
+	defer server.Close()
+	hook := NewHttpHook("alert", map[string]interface{}{
+		"type":        "http",
+		"url":         server.URL,
+		"method":      "put",
+		"headers":     map[string]interface{}{"Authorization": "Bearer tk"},
+		"secret":      "key",
+		"sign_header": "X-Sign",
+		"body": map[string]interface{}{
+			"text":  "[{msg_type}] {content}",
+			"level": 2,
+			"tags":  []interface{}{"{account}", "bot"},
+		},
+	})
+	go hook.ConsumeForever()
+	if !hook.SendMsg(MsgTypeException, "acc1", map[string]string{"content": "say \"hi\""}) {
+		t.Fatal("msg should be sent")
+	}
+	var body map[string]interface{}
+	select {
+	case body = <-got:
+	case <-time.After(5 * time.Second):
+		t.Fatal("wait msg timeout")
+	}
+	hook.CleanUp()
+	tags, _ := body["tags"].([]interface{})
+	if body["text"] != "[exception] say \"hi\"" || body["level"] != float64(2) || len(tags) != 2 || tags[0] != "acc1" {
+		t.Fatalf("bad body: %v", body)
+	}
+}
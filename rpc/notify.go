@@ -29,7 +29,7 @@ func initWebHooks() *errs.Error {
 		for i, chl := range acc.RPCChannels {
 			chlName := utils.GetMapVal(chl, "name", "")
 			if chlName == "" {
-				return errs.NewMsg(core.ErrBadConfig, "`name` is required in accounts.%s.rpc_channels[%d]", acc, i)
+				return errs.NewMsg(core.ErrBadConfig, "`name` is required in accounts.%s.rpc_channels[%d]", accName, i)
 			}
 			chl["_acc"] = accName
 			if _, ok := chl["accounts"]; !ok {
@@ -56,6 +56,10 @@ func initWebHooks() *errs.Error {
 		switch chlType {
 		case "wework":
 			channel = NewWeWork(name, item)
+		case "telegram":
+			channel = NewTelegram(name, item)
+		case "http":
+			channel = NewHttpHook(name, item)
 		default:
 			return errs.NewMsg(core.ErrBadConfig, "RPCChannel not support: %v", chlType)
 		}
@@ -0,0 +1,97 @@
+package rpc
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/banbox/banexg/log"
+	"github.com/banbox/banexg/utils"
+	"go.uber.org/zap"
+)
+
+/**
+Telegram Bot API push message. Telegram机器人推送消息
+https://core.telegram.org/bots/api#sendmessage
+*/
+
+type Telegram struct {
+	*WebHook
+	token   string
+	chatId  string
+	baseUrl string
+}
+
+const (
+	tgUrlBase = "https://api.telegram.org"
+	// max length of a telegram message text 单条telegram消息文本最大长度
+	tgMaxText = 4096
+)
+
+func NewTelegram(name string, item map[string]interface{}) *Telegram {
+	hook := NewWebHook(name, item)
+	res := &Telegram{
+		WebHook: hook,
+		token:   utils.GetMapVal(item, "token", ""),
+		chatId:  utils.GetMapVal(item, "channel", ""),
+		baseUrl: strings.TrimRight(utils.GetMapVal(item, "base_url", tgUrlBase), "/"),
+	}
+	if res.token == "" || res.chatId == "" {
+		panic(name + ": `token`, `channel` is required")
+	}
+	res.doSendMsgs = makeTgSendMsg(res)
+	return res
+}
+
+type TelegramRes struct {
+	Ok          bool   `json:"ok"`
+	ErrorCode   int    `json:"error_code"`
+	Description string `json:"description"`
+}
+
+func makeTgSendMsg(h *Telegram) func([]map[string]string) []map[string]string {
+	return func(msgList []map[string]string) []map[string]string {
+		url := fmt.Sprintf("%s/bot%s/sendMessage", h.baseUrl, h.token)
+		fails := []map[string]string{}
+		for _, msg := range msgList {
+			content, _ := msg["content"]
+			if content == "" {
+				log.Error("telegram get empty msg, skip")
+				continue
+			}
+			text := content
+			if runes := []rune(text); len(runes) > tgMaxText {
+				text = string(runes[:tgMaxText])
+			}
+			bodyText, err_ := utils.MarshalString(map[string]interface{}{
+				"chat_id": h.chatId,
+				"text":    text,
+			})
+			if err_ != nil {
+				log.Error("telegram marshal req fail", zap.String("content", content), zap.Error(err_))
+				continue
+			}
+			// rsp.Error contains the url with token, only log the body
+			// rsp.Error包含带token的url，仅记录body
+			rsp := request("POST", url, bodyText)
+			if rsp.Status == 0 || rsp.Status == 429 || rsp.Status >= 500 {
+				log.Error("telegram send msg net fail", zap.String("content", content),
+					zap.Int("status", rsp.Status), zap.String("rsp", rsp.Content))
+				fails = append(fails, msg)
+				continue
+			}
+			var res TelegramRes
+			err_ = utils.UnmarshalString(rsp.Content, &res, utils.JsonNumDefault)
+			if err_ != nil {
+				log.Error("telegram decode rsp fail", zap.String("body", rsp.Content), zap.Error(err_))
+				continue
+			}
+			if !res.Ok {
+				// Bad token or chat will not succeed by retrying
+				// token或chat错误时重试也不会成功
+				log.Warn("telegram send msg fail", zap.String("content", content),
+					zap.String("body", rsp.Content))
+			}
+		}
+		return fails
+	}
+}
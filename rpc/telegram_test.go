@@ -0,0 +1,80 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/banbox/banbot/core"
+)
+
+func TestTelegram(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	core.Ctx = ctx
+	var lock sync.Mutex
+	var texts []string
+	calls := 0
+	done := make(chan bool, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		calls += 1
+		if r.URL.Path != "/botabc/sendMessage" || r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if calls == 1 {
+			// first request is rate limited and should be retried
+			w.WriteHeader(http.StatusTooManyRequests)
+			_, _ = w.Write([]byte(`{"ok":false,"error_code":429,"description":"Too Many Requests"}`))
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		var body map[string]string
+		_ = json.Unmarshal(data, &body)
+		if body["chat_id"] != "-100" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		texts = append(texts, body["text"])
+		_, _ = w.Write([]byte(`{"ok":true,"result":{}}`))
+		done <- true
+	}))
+	defer server.Close()
+	tg := NewTelegram("tg", map[string]interface{}{
+		"type":      "telegram",
+		"token":     "abc",
+		"channel":   "-100",
+		"base_url":  server.URL,
+		"msg_types": []string{MsgTypeExit},
+		"keywords":  []string{"BTC"},
+		"retry_num": 1,
+	})
+	go tg.ConsumeForever()
+	if tg.SendMsg(MsgTypeEntry, "", map[string]string{"content": "BTC entry"}) {
+		t.Fatal("msg type not allowed should be skipped")
+	}
+	if tg.SendMsg(MsgTypeExit, "", map[string]string{"content": "ETH exit"}) {
+		t.Fatal("msg without keywords should be skipped")
+	}
+	if !tg.SendMsg(MsgTypeExit, "", map[string]string{"content": "BTC exit"}) {
+		t.Fatal("msg should be sent")
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("wait msg timeout")
+	}
+	tg.CleanUp()
+	lock.Lock()
+	defer lock.Unlock()
+	if len(texts) != 1 || texts[0] != "BTC exit" || calls != 2 {
+		t.Fatalf("bad sent msgs: %v, calls: %v", texts, calls)
+	}
+}
@@ -188,6 +188,10 @@ func (h *WebHook) doSendRetry(msgList []map[string]string) {
 }
 
 func request(method, url, body string) *banexg.HttpRes {
+	return requestWith(method, url, body, nil)
+}
+
+func requestWith(method, url, body string, headers map[string]string) *banexg.HttpRes {
 	if client == nil {
 		client = &http.Client{}
 	}
@@ -199,5 +203,11 @@ func request(method, url, body string) *banexg.HttpRes {
 	if err_ != nil {
 		return &banexg.HttpRes{Error: errs.New(core.ErrRunTime, err_)}
 	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for key, val := range headers {
+		req.Header.Set(key, val)
+	}
 	return utils2.DoHttp(client, req)
 }
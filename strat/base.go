@@ -93,6 +93,30 @@ func (s *StratJob) CanOpen(short bool) bool {
 	return !disable
 }
 
+/*
+AddOrder
+Add position to a fully entered order, the new leg is merged into od.Enter with weighted average price after filled.
+StopLoss/TakeProfit in req are applied to the whole order when given.
+To partially reduce an order, use CloseOrders with ExitReq.OrderID and ExitRate/Amount.
+
+为已完全入场的订单加仓，新入场腿成交后按加权均价合并到od.Enter。req中指定的止损止盈会应用到整个订单。
+部分减仓请使用CloseOrders并指定ExitReq.OrderID和ExitRate/Amount
+*/
+func (s *StratJob) AddOrder(od *ormo.InOutOrder, req *EnterReq) *errs.Error {
+	if od == nil || od.Status != ormo.InOutStatusFullEnter || od.ExitTag != "" {
+		return errs.NewMsg(errs.CodeParamInvalid, "only fully entered order can be added")
+	}
+	if od.PendingLeg() != nil {
+		return errs.NewMsg(errs.CodeParamInvalid, "order %v has pending leg", od.Key())
+	}
+	req.AddTo = od.ID
+	req.Short = od.Short
+	if req.Tag == "" {
+		req.Tag = od.EnterTag
+	}
+	return s.OpenOrder(req)
+}
+
 func (s *StratJob) OpenOrder(req *EnterReq) *errs.Error {
 	if req.Tag == "" {
 		return errs.NewMsg(errs.CodeParamRequired, "tag is Required")
@@ -106,7 +130,7 @@ func (s *StratJob) OpenOrder(req *EnterReq) *errs.Error {
 	if req.Short {
 		dirType = core.OdDirtShort
 	}
-	if !s.CanOpen(req.Short) {
+	if req.AddTo == 0 && !s.CanOpen(req.Short) {
 		if isLiveMode {
 			log.Warn("open order disabled",
 				zap.String("strategy", s.Strat.Name),
@@ -119,6 +143,7 @@ func (s *StratJob) OpenOrder(req *EnterReq) *errs.Error {
 	}
 	if math.IsNaN(req.Limit+req.Amount+req.Leverage+req.CostRate+req.LegalCost) ||
 		math.IsNaN(req.StopLoss+req.StopLossVal+req.StopLossLimit+req.StopLossRate) ||
+		math.IsNaN(req.TrailActivate+req.TrailCallback+req.TrailOffset+req.TrailStep) ||
 		math.IsNaN(req.TakeProfit+req.TakeProfitVal+req.TakeProfitLimit+req.TakeProfitRate) {
 		AddAccFailOpen(s.Account, FailOpenNanNum)
 		return errs.NewMsg(errs.CodeParamInvalid, "nan in EnterReq")
@@ -155,7 +180,7 @@ func (s *StratJob) OpenOrder(req *EnterReq) *errs.Error {
 				req.LegalCost = minCost * 1.1
 			} else {
 				AddAccFailOpen(s.Account, FailOpenCostTooLess)
-				return errs.NewMsg(errs.CodeParamInvalid, "legal cost must >= %d", minCost)
+				return errs.NewMsg(errs.CodeParamInvalid, "legal cost must >= %v", minCost)
 			}
 		}
 	}
@@ -194,6 +219,15 @@ func (s *StratJob) OpenOrder(req *EnterReq) *errs.Error {
 				zap.String("pair", symbol))
 		}
 	}
+	// Check trailing stop loss 检查跟踪止损
+	if req.TrailCallback > 0 || req.TrailOffset > 0 {
+		if !s.ExgStopLoss {
+			req.TrailCallback, req.TrailOffset = 0, 0
+		} else if req.TrailCallback >= 1 {
+			AddAccFailOpen(s.Account, FailOpenBadStopLoss)
+			return errs.NewMsg(errs.CodeParamInvalid, "%s trail callback %f must < 1", symbol, req.TrailCallback)
+		}
+	}
 	// 检查止盈
 	curTPPrice := s.LongTPPrice
 	if req.Short {
@@ -237,7 +271,9 @@ func (s *StratJob) OpenOrder(req *EnterReq) *errs.Error {
 		}
 	}
 	s.Entrys = append(s.Entrys, req)
-	s.OrderNum += 1
+	if req.AddTo == 0 {
+		s.OrderNum += 1
+	}
 	return nil
 }
 
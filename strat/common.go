@@ -25,6 +25,9 @@ var (
 func New(pol *config.RunPolicyConfig) *TradeStrat {
 	polID := pol.ID()
 	makeFn, ok := StratMake[pol.Name]
+	if !ok && isExprStrat(pol.Name) {
+		makeFn, ok = makeExprStrat, true
+	}
 	var stgy *TradeStrat
 	if ok {
 		stgy = makeFn(pol)
@@ -263,12 +266,12 @@ func (s *StratJob) checkOrderExit(od *ormo.InOutOrder) (*ormo.InOutEdit, *ormo.I
 	// Check if the condition sheet needs to be modified
 	// 检查是否需要修改条件单
 	if sl != nil || newSL != nil {
-		if sl == nil || newSL == nil || sl.Price != newSL.Price || sl.Limit != newSL.Limit {
+		if sl == nil || newSL == nil || !sl.ExitTrigger.Equal(newSL.ExitTrigger) {
 			slEdit = &ormo.InOutEdit{Order: od, Action: ormo.OdActionStopLoss}
 		}
 	}
 	if tp != nil || newTP != nil {
-		if tp == nil || newTP == nil || tp.Price != newTP.Price || tp.Limit != newTP.Limit {
+		if tp == nil || newTP == nil || !tp.ExitTrigger.Equal(newTP.ExitTrigger) {
 			tpEdit = &ormo.InOutEdit{Order: od, Action: ormo.OdActionTakeProfit}
 		}
 	}
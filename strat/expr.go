@@ -0,0 +1,216 @@
+package strat
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"sync/atomic"
+
+	"github.com/banbox/banbot/config"
+	"github.com/banbox/banbot/core"
+	"github.com/banbox/banbot/orm/ormo"
+	"github.com/banbox/banbot/utils"
+	"github.com/banbox/banexg/errs"
+)
+
+/*
+ExprStratName
+Name of the built-in declarative strategy. Use `expr` or `expr:xxx` as the policy name, and define `rules` in the policy:
+
+	run_policy:
+	  - name: expr:ma_cross
+	    run_timeframes: [ 15m ]
+	    params: {fast: 12, slow: 30}
+	    rules:
+	      hyper:
+	        fast: {default: 12, min: 5, max: 30, int: true}
+	        slow: {default: 30, min: 20, max: 120, int: true}
+	        sl_atr: {default: 2, min: 1, max: 4}
+	      indicators:
+	        ma_fast: SMA(close, fast)
+	        ma_slow: SMA(close, slow)
+	        atr: ATR(high, low, close, 14)
+	      long_entry: crossOver(ma_fast, ma_slow) && RSI(close, 14) < 70
+	      long_exit: crossUnder(ma_fast, ma_slow)
+	      stop_loss: atr * sl_atr
+	      check_exit: hold_bars > 100 and profit < 0
+
+Params in `hyper` are registered as hyperparameters, so they can be tuned by `optimize`.
+
+内置声明式策略的名称。使用`expr`或`expr:xxx`作为策略名，并在策略中定义`rules`。`hyper`中的参数注册为超参数，可由`optimize`优化。
+*/
+const ExprStratName = "expr"
+
+var exprStratNum atomic.Int32
+
+// exprStrat compiled rules of an expr policy 一个expr策略编译后的规则
+type exprStrat struct {
+	key        string
+	inds       []*exprNode
+	longEntry  *exprNode
+	longExit   *exprNode
+	shortEntry *exprNode
+	shortExit  *exprNode
+	stopLoss   *exprNode
+	takeProfit *exprNode
+	checkExit  *exprNode
+}
+
+func isExprStrat(name string) bool {
+	return name == ExprStratName || strings.HasPrefix(name, ExprStratName+":")
+}
+
+func makeExprStrat(pol *config.RunPolicyConfig) *TradeStrat {
+	stgy, err := NewExprStrat(pol)
+	if err != nil {
+		panic(fmt.Sprintf("invalid rules for %s: %s", pol.Name, err.Short()))
+	}
+	return stgy
+}
+
+/*
+NewExprStrat
+Compile `rules` of the policy into a TradeStrat, numeric params in `rules.hyper` are registered by pol.Def
+将策略的`rules`编译为TradeStrat，`rules.hyper`中的数值参数通过pol.Def注册
+*/
+func NewExprStrat(pol *config.RunPolicyConfig) (*TradeStrat, *errs.Error) {
+	rules := pol.Rules
+	if rules == nil {
+		rules = config.GetPolRules(pol.Name)
+	}
+	if rules == nil {
+		return nil, errs.NewMsg(errs.CodeParamRequired, "`rules` is required for %s", pol.Name)
+	}
+	params := make(map[string]float64)
+	for k, v := range pol.Params {
+		params[k] = v
+	}
+	for _, name := range utils.KeysOfMap(rules.Hyper) {
+		h := rules.Hyper[name]
+		if h.Min >= h.Max {
+			return nil, errs.NewMsg(errs.CodeParamInvalid, "hyper %s: min should < max", name)
+		}
+		p := core.PUniform(h.Min, h.Max)
+		if h.Norm {
+			p = core.PNorm(h.Min, h.Max)
+		}
+		if h.Int {
+			params[name] = float64(pol.DefInt(name, int(math.Round(h.Default)), p))
+		} else {
+			params[name] = pol.Def(name, h.Default, p)
+		}
+	}
+	c, err := newExprCompiler(params, rules.Indicators)
+	if err != nil {
+		return nil, err
+	}
+	// all indicators are evaluated every bar even if not referenced 所有指标每bar求值，即使未被引用
+	indNames := utils.KeysOfMap(rules.Indicators)
+	slices.Sort(indNames)
+	for _, name := range indNames {
+		if _, err = c.compileIdent(&exprAst{op: "id", name: name}); err != nil {
+			return nil, err
+		}
+	}
+	es := &exprStrat{key: fmt.Sprintf("_expr%d", exprStratNum.Add(1))}
+	items := []struct {
+		name     string
+		text     string
+		perBar   bool
+		hasOrder bool
+		dst      **exprNode
+	}{
+		{"long_entry", rules.LongEntry, true, false, &es.longEntry},
+		{"long_exit", rules.LongExit, true, false, &es.longExit},
+		{"short_entry", rules.ShortEntry, true, false, &es.shortEntry},
+		{"short_exit", rules.ShortExit, true, false, &es.shortExit},
+		{"stop_loss", rules.StopLoss, false, false, &es.stopLoss},
+		{"take_profit", rules.TakeProfit, false, false, &es.takeProfit},
+		{"check_exit", rules.CheckExit, false, true, &es.checkExit},
+	}
+	for _, it := range items {
+		*it.dst, err = c.compileText(it.name, it.text, it.perBar, it.hasOrder)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if es.longEntry == nil && es.shortEntry == nil {
+		return nil, errs.NewMsg(errs.CodeParamRequired, "long_entry or short_entry is required")
+	}
+	es.inds = c.indNodes
+	warmup := rules.Warmup
+	if warmup <= 0 {
+		warmup = max(30, c.maxArg*3)
+	}
+	res := &TradeStrat{
+		WarmupNum:    warmup,
+		EachMaxLong:  1,
+		EachMaxShort: 1,
+		OnStartUp: func(s *StratJob) {
+			s.More = &exprCtx{env: s.Env, key: es.key, vals: make([]exprVal, len(es.inds))}
+		},
+		OnBar: es.onBar,
+	}
+	if es.checkExit != nil {
+		res.OnCheckExit = es.onCheckExit
+	}
+	return res, nil
+}
+
+func (e *exprStrat) check(c *exprCtx, node *exprNode) bool {
+	if node == nil {
+		return false
+	}
+	return isTrue(node.eval(c).at(0))
+}
+
+func (e *exprStrat) onBar(s *StratJob) {
+	c := s.More.(*exprCtx)
+	for i, node := range e.inds {
+		c.vals[i] = node.eval(c)
+	}
+	// evaluate all conditions every bar to keep their series updated 每bar计算所有条件以保持其序列更新
+	longEnter, longExit := e.check(c, e.longEntry), e.check(c, e.longExit)
+	shortEnter, shortExit := e.check(c, e.shortEntry), e.check(c, e.shortExit)
+	if s.IsWarmUp {
+		return
+	}
+	if longExit && len(s.LongOrders) > 0 {
+		_ = s.CloseOrders(&ExitReq{Tag: "long_exit", Dirt: core.OdDirtLong})
+	}
+	if shortExit && len(s.ShortOrders) > 0 {
+		_ = s.CloseOrders(&ExitReq{Tag: "short_exit", Dirt: core.OdDirtShort})
+	}
+	if longEnter && !longExit && s.CanOpen(false) {
+		e.openOrder(s, c, false)
+	}
+	if shortEnter && !shortExit && s.CanOpen(true) {
+		e.openOrder(s, c, true)
+	}
+}
+
+func (e *exprStrat) openOrder(s *StratJob, c *exprCtx, short bool) {
+	req := &EnterReq{Tag: "long", Short: short}
+	if short {
+		req.Tag = "short"
+	}
+	if e.stopLoss != nil {
+		req.StopLossVal = e.stopLoss.eval(c).at(0)
+	}
+	if e.takeProfit != nil {
+		req.TakeProfitVal = e.takeProfit.eval(c).at(0)
+	}
+	_ = s.OpenOrder(req)
+}
+
+func (e *exprStrat) onCheckExit(s *StratJob, od *ormo.InOutOrder) *ExitReq {
+	c := s.More.(*exprCtx)
+	c.od = od
+	hit := e.check(c, e.checkExit)
+	c.od = nil
+	if hit {
+		return &ExitReq{Tag: "check_exit"}
+	}
+	return nil
+}
@@ -0,0 +1,829 @@
+package strat
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/banbox/banbot/orm/ormo"
+	"github.com/banbox/banexg/errs"
+	ta "github.com/banbox/banta"
+)
+
+/*
+A tiny expression language for declarative strategies:
+  - numbers, true/false, params, indicators, open/high/low/close/volume
+  - arithmetic + - * / %, comparison < <= > >= == !=, logical && || ! (and/or/not)
+  - `x[n]` the value n bars ago
+  - functions: banta indicators like SMA(close, 20), crossOver(a, b), abs/min/max/sqrt/log
+Boolean values are 1/0, NaN is treated as false.
+声明式策略的微型表达式语言：支持数字、参数、指标、K线序列，四则运算、比较、逻辑运算，`x[n]`取n个bar前的值，
+banta指标函数如SMA(close, 20)、crossOver(a, b)、abs/min/max/sqrt/log等。布尔值为1/0，NaN视为false。
+*/
+
+const (
+	tkEOF = iota
+	tkNum
+	tkIdent
+	tkOp
+)
+
+type exprToken struct {
+	kind int
+	text string
+	num  float64
+	pos  int
+}
+
+// exprAst node of parsed expression, op: num/id/call/idx/neg/! or binary operator 解析后的表达式节点
+type exprAst struct {
+	op   string
+	name string
+	num  float64
+	args []*exprAst
+	pos  int
+}
+
+var exprWordOps = map[string]string{"and": "&&", "or": "||", "not": "!"}
+
+func lexExpr(text string) ([]*exprToken, *errs.Error) {
+	var res []*exprToken
+	runes := []rune(text)
+	for i := 0; i < len(runes); {
+		ch := runes[i]
+		if unicode.IsSpace(ch) {
+			i += 1
+			continue
+		}
+		start := i
+		if unicode.IsDigit(ch) || ch == '.' {
+			for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '.' || runes[i] == 'e' ||
+				(runes[i] == '-' || runes[i] == '+') && (runes[i-1] == 'e')) {
+				i += 1
+			}
+			val, err_ := strconv.ParseFloat(string(runes[start:i]), 64)
+			if err_ != nil {
+				return nil, errs.NewMsg(errs.CodeParamInvalid, "invalid number at %d: %s", start, string(runes[start:i]))
+			}
+			res = append(res, &exprToken{kind: tkNum, num: val, pos: start})
+			continue
+		}
+		if unicode.IsLetter(ch) || ch == '_' {
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i += 1
+			}
+			word := string(runes[start:i])
+			if op, ok := exprWordOps[strings.ToLower(word)]; ok {
+				res = append(res, &exprToken{kind: tkOp, text: op, pos: start})
+			} else if word == "true" || word == "false" {
+				res = append(res, &exprToken{kind: tkNum, num: boolNum(word == "true"), pos: start})
+			} else {
+				res = append(res, &exprToken{kind: tkIdent, text: word, pos: start})
+			}
+			continue
+		}
+		if i+1 < len(runes) {
+			two := string(runes[i : i+2])
+			if two == "<=" || two == ">=" || two == "==" || two == "!=" || two == "&&" || two == "||" {
+				res = append(res, &exprToken{kind: tkOp, text: two, pos: start})
+				i += 2
+				continue
+			}
+		}
+		if strings.ContainsRune("+-*/%<>!()[],", ch) {
+			res = append(res, &exprToken{kind: tkOp, text: string(ch), pos: start})
+			i += 1
+			continue
+		}
+		return nil, errs.NewMsg(errs.CodeParamInvalid, "unexpected char at %d: %c", start, ch)
+	}
+	res = append(res, &exprToken{kind: tkEOF, pos: len(runes)})
+	return res, nil
+}
+
+type exprParser struct {
+	toks []*exprToken
+	pos  int
+}
+
+/*
+parseExpr
+Parse an expression text into ast, precedence from low to high: ||, &&, comparison, + -, * / %, unary, postfix
+将表达式文本解析为语法树，优先级从低到高：||, &&, 比较, + -, * / %, 一元, 后缀
+*/
+func parseExpr(text string) (*exprAst, *errs.Error) {
+	toks, err := lexExpr(text)
+	if err != nil {
+		return nil, err
+	}
+	p := &exprParser{toks: toks}
+	res, err := p.parseBinary(0)
+	if err != nil {
+		return nil, err
+	}
+	if tk := p.peek(); tk.kind != tkEOF {
+		return nil, errs.NewMsg(errs.CodeParamInvalid, "unexpected token at %d: %s", tk.pos, tk.text)
+	}
+	return res, nil
+}
+
+var exprBinLevels = [][]string{
+	{"||"},
+	{"&&"},
+	{"<", "<=", ">", ">=", "==", "!="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *exprParser) peek() *exprToken {
+	return p.toks[p.pos]
+}
+
+func (p *exprParser) isOp(text string) bool {
+	tk := p.toks[p.pos]
+	return tk.kind == tkOp && tk.text == text
+}
+
+func (p *exprParser) expect(text string) *errs.Error {
+	if !p.isOp(text) {
+		tk := p.peek()
+		return errs.NewMsg(errs.CodeParamInvalid, "expect `%s` at %d", text, tk.pos)
+	}
+	p.pos += 1
+	return nil
+}
+
+func (p *exprParser) parseBinary(level int) (*exprAst, *errs.Error) {
+	if level >= len(exprBinLevels) {
+		return p.parseUnary()
+	}
+	left, err := p.parseBinary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tk := p.peek()
+		matched := false
+		if tk.kind == tkOp {
+			for _, op := range exprBinLevels[level] {
+				if tk.text == op {
+					matched = true
+					break
+				}
+			}
+		}
+		if !matched {
+			return left, nil
+		}
+		p.pos += 1
+		right, err := p.parseBinary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = &exprAst{op: tk.text, args: []*exprAst{left, right}, pos: tk.pos}
+	}
+}
+
+func (p *exprParser) parseUnary() (*exprAst, *errs.Error) {
+	tk := p.peek()
+	if tk.kind == tkOp && (tk.text == "-" || tk.text == "!" || tk.text == "+") {
+		p.pos += 1
+		arg, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if tk.text == "+" {
+			return arg, nil
+		}
+		op := tk.text
+		if op == "-" {
+			op = "neg"
+		}
+		return &exprAst{op: op, args: []*exprAst{arg}, pos: tk.pos}, nil
+	}
+	return p.parsePostfix()
+}
+
+func (p *exprParser) parsePostfix() (*exprAst, *errs.Error) {
+	res, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for p.isOp("[") {
+		pos := p.peek().pos
+		p.pos += 1
+		idx, err := p.parseBinary(0)
+		if err != nil {
+			return nil, err
+		}
+		if err = p.expect("]"); err != nil {
+			return nil, err
+		}
+		res = &exprAst{op: "idx", args: []*exprAst{res, idx}, pos: pos}
+	}
+	return res, nil
+}
+
+func (p *exprParser) parsePrimary() (*exprAst, *errs.Error) {
+	tk := p.peek()
+	switch tk.kind {
+	case tkNum:
+		p.pos += 1
+		return &exprAst{op: "num", num: tk.num, pos: tk.pos}, nil
+	case tkIdent:
+		p.pos += 1
+		if !p.isOp("(") {
+			return &exprAst{op: "id", name: tk.text, pos: tk.pos}, nil
+		}
+		p.pos += 1
+		res := &exprAst{op: "call", name: tk.text, pos: tk.pos}
+		for !p.isOp(")") {
+			if len(res.args) > 0 {
+				if err := p.expect(","); err != nil {
+					return nil, err
+				}
+			}
+			arg, err := p.parseBinary(0)
+			if err != nil {
+				return nil, err
+			}
+			res.args = append(res.args, arg)
+		}
+		p.pos += 1
+		return res, nil
+	case tkOp:
+		if tk.text == "(" {
+			p.pos += 1
+			res, err := p.parseBinary(0)
+			if err != nil {
+				return nil, err
+			}
+			if err = p.expect(")"); err != nil {
+				return nil, err
+			}
+			return res, nil
+		}
+	}
+	if tk.kind == tkEOF {
+		return nil, errs.NewMsg(errs.CodeParamInvalid, "unexpected end of expression")
+	}
+	return nil, errs.NewMsg(errs.CodeParamInvalid, "unexpected token at %d: %s", tk.pos, tk.text)
+}
+
+/*
+****************************  compile  ****************************************
+ */
+
+// exprVal value of a node at current bar, a series or a scalar 节点在当前bar的值，序列或标量
+type exprVal struct {
+	ser *ta.Series
+	num float64
+}
+
+func (v exprVal) at(i int) float64 {
+	if v.ser != nil {
+		return v.ser.Get(i)
+	}
+	return v.num
+}
+
+// exprCtx evaluating state of a job 一个任务的求值状态
+type exprCtx struct {
+	env  *ta.BarEnv
+	od   *ormo.InOutOrder
+	key  string    // key of derived series in env 在env中派生序列的键
+	vals []exprVal // indicator values of current bar 当前bar的指标值
+}
+
+func (c *exprCtx) slot(id int) *ta.Series {
+	return c.env.Close.To(c.key, id)
+}
+
+type exprNode struct {
+	eval  func(c *exprCtx) exprVal
+	konst bool // constant, value in num 常量，值为num
+	num   float64
+	ser   bool // evaluated to a series 求值结果为序列
+	order bool // depends on the order 依赖订单
+}
+
+func constNode(v float64) *exprNode {
+	return &exprNode{konst: true, num: v, eval: func(c *exprCtx) exprVal {
+		return exprVal{num: v}
+	}}
+}
+
+/*
+exprCompiler
+Compile asts to closures. Indicators are compiled on first reference and evaluated in dependency order every bar.
+Nodes that need history (indicator args, crossOver, x[n]) are compiled to series derived from close.
+将语法树编译为闭包。指标在首次引用时编译，并按依赖顺序每bar求值。需要历史的节点(指标参数、交叉、x[n])编译为从close派生的序列。
+*/
+type exprCompiler struct {
+	params   map[string]float64
+	indAsts  map[string]*exprAst
+	inds     map[string]*exprNode
+	visiting map[string]bool
+	indNodes []*exprNode // in evaluation order 按求值顺序
+	slotNum  int
+	perBar   bool // whether evaluated every bar, functions with history are allowed only then 是否每bar求值，仅此时允许带历史的函数
+	hasOrder bool // whether order variables are available 是否可使用订单变量
+	maxArg   int  // max integer argument of indicators, for warmup 指标最大整数参数，用于预热
+}
+
+func newExprCompiler(params map[string]float64, indicators map[string]string) (*exprCompiler, *errs.Error) {
+	c := &exprCompiler{
+		params:   params,
+		indAsts:  make(map[string]*exprAst),
+		inds:     make(map[string]*exprNode),
+		visiting: make(map[string]bool),
+	}
+	for name, text := range indicators {
+		if _, ok := params[name]; ok || exprSeriesVar(name) != nil || exprOrderVars[name] != nil {
+			return nil, errs.NewMsg(errs.CodeParamInvalid, "indicator name conflict: %s", name)
+		}
+		ast, err := parseExpr(text)
+		if err != nil {
+			return nil, errs.NewMsg(errs.CodeParamInvalid, "indicator %s: %s", name, err.Short())
+		}
+		c.indAsts[name] = ast
+	}
+	return c, nil
+}
+
+// compileText compile an expression, empty text returns nil 编译一个表达式，空文本返回nil
+func (c *exprCompiler) compileText(name, text string, perBar, hasOrder bool) (*exprNode, *errs.Error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return nil, nil
+	}
+	ast, err := parseExpr(text)
+	if err == nil {
+		c.perBar, c.hasOrder = perBar, hasOrder
+		var res *exprNode
+		res, err = c.compile(ast, false)
+		if err == nil {
+			return res, nil
+		}
+	}
+	return nil, errs.NewMsg(errs.CodeParamInvalid, "%s: %s", name, err.Short())
+}
+
+func exprSeriesVar(name string) func(e *ta.BarEnv) *ta.Series {
+	switch name {
+	case "open":
+		return func(e *ta.BarEnv) *ta.Series { return e.Open }
+	case "high":
+		return func(e *ta.BarEnv) *ta.Series { return e.High }
+	case "low":
+		return func(e *ta.BarEnv) *ta.Series { return e.Low }
+	case "close":
+		return func(e *ta.BarEnv) *ta.Series { return e.Close }
+	case "volume":
+		return func(e *ta.BarEnv) *ta.Series { return e.Volume }
+	}
+	return nil
+}
+
+// exprOrderVars variables of current order, available in check_exit only 当前订单的变量，仅check_exit可用
+var exprOrderVars = map[string]func(c *exprCtx) float64{
+	"profit":     func(c *exprCtx) float64 { return c.od.ProfitRate },
+	"max_profit": func(c *exprCtx) float64 { return c.od.MaxPftRate },
+	"entry_price": func(c *exprCtx) float64 {
+		if c.od.Enter != nil && c.od.Enter.Average > 0 {
+			return c.od.Enter.Average
+		}
+		return c.od.InitPrice
+	},
+	"hold_bars": func(c *exprCtx) float64 { return math.Floor(c.env.BarCount(c.od.RealEnterMS())) },
+	"is_short":  func(c *exprCtx) float64 { return boolNum(c.od.Short) },
+}
+
+func (c *exprCompiler) compile(a *exprAst, wantSer bool) (*exprNode, *errs.Error) {
+	switch a.op {
+	case "num":
+		return constNode(a.num), nil
+	case "id":
+		return c.compileIdent(a)
+	case "call":
+		return c.compileCall(a, wantSer)
+	case "idx":
+		return c.compileIndex(a, wantSer)
+	case "neg":
+		return c.compileMap(a, a.args, wantSer, func(v []float64) float64 { return -v[0] })
+	case "!":
+		return c.compileMap(a, a.args, wantSer, func(v []float64) float64 { return boolNum(!isTrue(v[0])) })
+	}
+	fn, ok := exprBinOps[a.op]
+	if !ok {
+		return nil, errs.NewMsg(errs.CodeParamInvalid, "unknown operator: %s", a.op)
+	}
+	return c.compileMap(a, a.args, wantSer, fn)
+}
+
+var exprBinOps = map[string]func(v []float64) float64{
+	"+":  func(v []float64) float64 { return v[0] + v[1] },
+	"-":  func(v []float64) float64 { return v[0] - v[1] },
+	"*":  func(v []float64) float64 { return v[0] * v[1] },
+	"/":  func(v []float64) float64 { return v[0] / v[1] },
+	"%":  func(v []float64) float64 { return math.Mod(v[0], v[1]) },
+	"<":  func(v []float64) float64 { return boolNum(v[0] < v[1]) },
+	"<=": func(v []float64) float64 { return boolNum(v[0] <= v[1]) },
+	">":  func(v []float64) float64 { return boolNum(v[0] > v[1]) },
+	">=": func(v []float64) float64 { return boolNum(v[0] >= v[1]) },
+	"==": func(v []float64) float64 { return boolNum(v[0] == v[1]) },
+	"!=": func(v []float64) float64 { return boolNum(v[0] != v[1]) },
+	"&&": func(v []float64) float64 { return boolNum(isTrue(v[0]) && isTrue(v[1])) },
+	"||": func(v []float64) float64 { return boolNum(isTrue(v[0]) || isTrue(v[1])) },
+}
+
+// exprMathFns element-wise math functions 逐元素数学函数
+var exprMathFns = map[string]struct {
+	argNum int // -1 for variadic 可变参数
+	fn     func(v []float64) float64
+}{
+	"ABS":  {1, func(v []float64) float64 { return math.Abs(v[0]) }},
+	"SQRT": {1, func(v []float64) float64 { return math.Sqrt(v[0]) }},
+	"LOG":  {1, func(v []float64) float64 { return math.Log(v[0]) }},
+	"POW":  {2, func(v []float64) float64 { return math.Pow(v[0], v[1]) }},
+	"MIN": {-1, func(v []float64) float64 {
+		res := v[0]
+		for _, x := range v[1:] {
+			res = math.Min(res, x)
+		}
+		return res
+	}},
+	"MAX": {-1, func(v []float64) float64 {
+		res := v[0]
+		for _, x := range v[1:] {
+			res = math.Max(res, x)
+		}
+		return res
+	}},
+	"IF": {3, func(v []float64) float64 {
+		if isTrue(v[0]) {
+			return v[1]
+		}
+		return v[2]
+	}},
+}
+
+func (c *exprCompiler) compileIdent(a *exprAst) (*exprNode, *errs.Error) {
+	if getSer := exprSeriesVar(a.name); getSer != nil {
+		return &exprNode{ser: true, eval: func(x *exprCtx) exprVal {
+			return exprVal{ser: getSer(x.env)}
+		}}, nil
+	}
+	if v, ok := c.params[a.name]; ok {
+		return constNode(v), nil
+	}
+	if getVal, ok := exprOrderVars[a.name]; ok {
+		if !c.hasOrder {
+			return nil, errs.NewMsg(errs.CodeParamInvalid, "`%s` is only available in check_exit", a.name)
+		}
+		return &exprNode{order: true, eval: func(x *exprCtx) exprVal {
+			return exprVal{num: getVal(x)}
+		}}, nil
+	}
+	if node, ok := c.inds[a.name]; ok {
+		return node, nil
+	}
+	ast, ok := c.indAsts[a.name]
+	if !ok {
+		return nil, errs.NewMsg(errs.CodeParamInvalid, "unknown identifier at %d: %s", a.pos, a.name)
+	}
+	if c.visiting[a.name] {
+		return nil, errs.NewMsg(errs.CodeParamInvalid, "circular reference of indicator: %s", a.name)
+	}
+	c.visiting[a.name] = true
+	// indicators are always evaluated every bar with history kept 指标总是每bar求值并保留历史
+	perBar, hasOrder := c.perBar, c.hasOrder
+	c.perBar, c.hasOrder = true, false
+	body, err := c.compile(ast, true)
+	c.perBar, c.hasOrder = perBar, hasOrder
+	delete(c.visiting, a.name)
+	if err != nil {
+		return nil, errs.NewMsg(errs.CodeParamInvalid, "indicator %s: %s", a.name, err.Short())
+	}
+	if body.konst {
+		c.inds[a.name] = body
+		return body, nil
+	}
+	idx := len(c.indNodes)
+	c.indNodes = append(c.indNodes, body)
+	node := &exprNode{ser: body.ser, eval: func(x *exprCtx) exprVal {
+		return x.vals[idx]
+	}}
+	c.inds[a.name] = node
+	return node, nil
+}
+
+/*
+compileMap
+Compile an element-wise function on args. Result is a derived series if any arg is a series and history is wanted.
+编译对参数的逐元素函数。若任一参数为序列且需要历史，结果为派生序列。
+*/
+func (c *exprCompiler) compileMap(a *exprAst, argAsts []*exprAst, wantSer bool, fn func(v []float64) float64) (*exprNode, *errs.Error) {
+	args := make([]*exprNode, 0, len(argAsts))
+	allConst, anySer, anyOrder := true, false, false
+	for _, arg := range argAsts {
+		node, err := c.compile(arg, wantSer)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, node)
+		allConst = allConst && node.konst
+		anySer = anySer || node.ser
+		anyOrder = anyOrder || node.order
+	}
+	if allConst {
+		vals := make([]float64, len(args))
+		for i, arg := range args {
+			vals[i] = arg.num
+		}
+		return constNode(fn(vals)), nil
+	}
+	res := &exprNode{order: anyOrder, eval: func(x *exprCtx) exprVal {
+		vals := make([]float64, len(args))
+		// no short circuit, so that series in args are updated every bar 不短路，使参数中的序列每bar更新
+		for i, arg := range args {
+			vals[i] = arg.eval(x).at(0)
+		}
+		return exprVal{num: fn(vals)}
+	}}
+	if wantSer && anySer {
+		return c.toSeries(a, res)
+	}
+	return res, nil
+}
+
+// toSeries save values of a scalar node to a derived series 将标量节点的值保存到派生序列
+func (c *exprCompiler) toSeries(a *exprAst, node *exprNode) (*exprNode, *errs.Error) {
+	if node.order {
+		return nil, errs.NewMsg(errs.CodeParamInvalid, "order variables can't be used as series at %d", a.pos)
+	}
+	if !c.perBar {
+		return nil, errs.NewMsg(errs.CodeParamInvalid, "series calculation at %d is not allowed here, "+
+			"please define it in indicators", a.pos)
+	}
+	id := c.slotNum
+	c.slotNum += 1
+	return &exprNode{ser: true, eval: func(x *exprCtx) exprVal {
+		val := node.eval(x)
+		res := x.slot(id)
+		if !res.Cached() {
+			res.Append(val.at(0))
+		}
+		return exprVal{ser: res}
+	}}, nil
+}
+
+func (c *exprCompiler) compileIndex(a *exprAst, wantSer bool) (*exprNode, *errs.Error) {
+	idx, err := c.compile(a.args[1], false)
+	if err != nil {
+		return nil, err
+	}
+	if !idx.konst || idx.num < 0 {
+		return nil, errs.NewMsg(errs.CodeParamInvalid, "index at %d must be a non-negative constant", a.pos)
+	}
+	num := int(math.Round(idx.num))
+	src, err := c.compile(a.args[0], true)
+	if err != nil || src.konst {
+		return src, err
+	}
+	if !src.ser {
+		return nil, errs.NewMsg(errs.CodeParamInvalid, "index at %d requires a series", a.pos)
+	}
+	if wantSer {
+		return &exprNode{ser: true, eval: func(x *exprCtx) exprVal {
+			return exprVal{ser: src.eval(x).ser.Back(num)}
+		}}, nil
+	}
+	return &exprNode{eval: func(x *exprCtx) exprVal {
+		return exprVal{num: src.eval(x).at(num)}
+	}}, nil
+}
+
+func (c *exprCompiler) compileCall(a *exprAst, wantSer bool) (*exprNode, *errs.Error) {
+	name := strings.ToUpper(strings.ReplaceAll(a.name, "_", ""))
+	if mfn, ok := exprMathFns[name]; ok {
+		if mfn.argNum >= 0 && len(a.args) != mfn.argNum || mfn.argNum < 0 && len(a.args) == 0 {
+			return nil, errs.NewMsg(errs.CodeParamInvalid, "bad arg num for %s at %d", a.name, a.pos)
+		}
+		return c.compileMap(a, a.args, wantSer, mfn.fn)
+	}
+	if name == "CROSS" || name == "CROSSOVER" || name == "CROSSUNDER" {
+		return c.compileCross(a, name, wantSer)
+	}
+	ind, ok := exprInds[name]
+	if !ok {
+		return nil, errs.NewMsg(errs.CodeParamInvalid, "unknown function at %d: %s", a.pos, a.name)
+	}
+	if len(a.args) != len(ind.args) {
+		return nil, errs.NewMsg(errs.CodeParamInvalid, "%s at %d requires %d args, got %d", a.name, a.pos,
+			len(ind.args), len(a.args))
+	}
+	if !c.perBar {
+		return nil, errs.NewMsg(errs.CodeParamInvalid, "%s at %d is not allowed here, please define it in indicators",
+			a.name, a.pos)
+	}
+	args := make([]*exprNode, 0, len(a.args))
+	for i, argAst := range a.args {
+		arg, err := c.compile(argAst, true)
+		if err != nil {
+			return nil, err
+		}
+		if ind.args[i] == 's' && !arg.ser {
+			return nil, errs.NewMsg(errs.CodeParamInvalid, "arg %d of %s at %d should be a series", i+1, a.name, a.pos)
+		} else if ind.args[i] == 'n' {
+			if !arg.konst {
+				return nil, errs.NewMsg(errs.CodeParamInvalid, "arg %d of %s at %d should be a constant",
+					i+1, a.name, a.pos)
+			}
+			c.maxArg = max(c.maxArg, int(math.Round(arg.num)))
+		}
+		args = append(args, arg)
+	}
+	return &exprNode{ser: true, eval: func(x *exprCtx) exprVal {
+		vals := make([]exprVal, len(args))
+		for i, arg := range args {
+			vals[i] = arg.eval(x)
+		}
+		return exprVal{ser: ind.call(x.env, vals)}
+	}}, nil
+}
+
+/*
+compileCross
+crossOver(a, b) is true when a crosses above b at current bar, crossUnder for below.
+cross(a, b) returns banta Cross: positive for above, negative for below, abs-1 is bars since the cross.
+crossOver(a, b)在当前bar a上穿b时为true，crossUnder为下穿。cross(a, b)返回banta的Cross结果。
+*/
+func (c *exprCompiler) compileCross(a *exprAst, name string, wantSer bool) (*exprNode, *errs.Error) {
+	if len(a.args) != 2 {
+		return nil, errs.NewMsg(errs.CodeParamInvalid, "%s at %d requires 2 args", a.name, a.pos)
+	}
+	if !c.perBar {
+		return nil, errs.NewMsg(errs.CodeParamInvalid, "%s at %d is not allowed here, please define it in indicators",
+			a.name, a.pos)
+	}
+	left, err := c.compile(a.args[0], true)
+	if err != nil {
+		return nil, err
+	}
+	right, err := c.compile(a.args[1], true)
+	if err != nil {
+		return nil, err
+	}
+	if !left.ser {
+		left, right = right, left
+		if name == "CROSSOVER" {
+			name = "CROSSUNDER"
+		} else if name == "CROSSUNDER" {
+			name = "CROSSOVER"
+		}
+	}
+	if !left.ser {
+		return nil, errs.NewMsg(errs.CodeParamInvalid, "%s at %d requires a series arg", a.name, a.pos)
+	}
+	sign := 1
+	if name == "CROSSUNDER" {
+		sign = -1
+	}
+	res := &exprNode{eval: func(x *exprCtx) exprVal {
+		lv := left.eval(x).ser
+		var rv interface{}
+		if right.ser {
+			rv = right.eval(x).ser
+		} else {
+			rv = right.num
+		}
+		val := ta.Cross(lv, rv)
+		if name == "CROSS" {
+			return exprVal{num: float64(val)}
+		}
+		return exprVal{num: boolNum(val == sign)}
+	}}
+	if wantSer {
+		return c.toSeries(a, res)
+	}
+	return res, nil
+}
+
+type exprInd struct {
+	args string // kind of each arg: s series, n constant number 每个参数类型：s序列，n常数
+	call func(e *ta.BarEnv, v []exprVal) *ta.Series
+}
+
+func argInt(v exprVal) int {
+	return int(math.Round(v.num))
+}
+
+// exprInds banta indicators available in expressions, names are case-insensitive without `_` 表达式可用的banta指标，名称不区分大小写且忽略`_`
+var exprInds = map[string]*exprInd{
+	"SMA":  {"sn", func(e *ta.BarEnv, v []exprVal) *ta.Series { return ta.SMA(v[0].ser, argInt(v[1])) }},
+	"EMA":  {"sn", func(e *ta.BarEnv, v []exprVal) *ta.Series { return ta.EMA(v[0].ser, argInt(v[1])) }},
+	"RMA":  {"sn", func(e *ta.BarEnv, v []exprVal) *ta.Series { return ta.RMA(v[0].ser, argInt(v[1])) }},
+	"WMA":  {"sn", func(e *ta.BarEnv, v []exprVal) *ta.Series { return ta.WMA(v[0].ser, argInt(v[1])) }},
+	"HMA":  {"sn", func(e *ta.BarEnv, v []exprVal) *ta.Series { return ta.HMA(v[0].ser, argInt(v[1])) }},
+	"KAMA": {"sn", func(e *ta.BarEnv, v []exprVal) *ta.Series { return ta.KAMA(v[0].ser, argInt(v[1])) }},
+	"VWMA": {"ssn", func(e *ta.BarEnv, v []exprVal) *ta.Series {
+		return ta.VWMA(v[0].ser, v[1].ser, argInt(v[2]))
+	}},
+	"SUM":         {"sn", func(e *ta.BarEnv, v []exprVal) *ta.Series { return ta.Sum(v[0].ser, argInt(v[1])) }},
+	"HIGHEST":     {"sn", func(e *ta.BarEnv, v []exprVal) *ta.Series { return ta.Highest(v[0].ser, argInt(v[1])) }},
+	"LOWEST":      {"sn", func(e *ta.BarEnv, v []exprVal) *ta.Series { return ta.Lowest(v[0].ser, argInt(v[1])) }},
+	"ROC":         {"sn", func(e *ta.BarEnv, v []exprVal) *ta.Series { return ta.ROC(v[0].ser, argInt(v[1])) }},
+	"RSI":         {"sn", func(e *ta.BarEnv, v []exprVal) *ta.Series { return ta.RSI(v[0].ser, argInt(v[1])) }},
+	"CCI":         {"sn", func(e *ta.BarEnv, v []exprVal) *ta.Series { return ta.CCI(v[0].ser, argInt(v[1])) }},
+	"CMO":         {"sn", func(e *ta.BarEnv, v []exprVal) *ta.Series { return ta.CMO(v[0].ser, argInt(v[1])) }},
+	"ER":          {"sn", func(e *ta.BarEnv, v []exprVal) *ta.Series { return ta.ER(v[0].ser, argInt(v[1])) }},
+	"CTI":         {"sn", func(e *ta.BarEnv, v []exprVal) *ta.Series { return ta.CTI(v[0].ser, argInt(v[1])) }},
+	"LINREG":      {"sn", func(e *ta.BarEnv, v []exprVal) *ta.Series { return ta.LinReg(v[0].ser, argInt(v[1])) }},
+	"PERCENTRANK": {"sn", func(e *ta.BarEnv, v []exprVal) *ta.Series { return ta.PercentRank(v[0].ser, argInt(v[1])) }},
+	"STDDEV": {"sn", func(e *ta.BarEnv, v []exprVal) *ta.Series {
+		res, _ := ta.StdDev(v[0].ser, argInt(v[1]))
+		return res
+	}},
+	"TR": {"sss", func(e *ta.BarEnv, v []exprVal) *ta.Series { return ta.TR(v[0].ser, v[1].ser, v[2].ser) }},
+	"ATR": {"sssn", func(e *ta.BarEnv, v []exprVal) *ta.Series {
+		return ta.ATR(v[0].ser, v[1].ser, v[2].ser, argInt(v[3]))
+	}},
+	"ADX": {"sssn", func(e *ta.BarEnv, v []exprVal) *ta.Series {
+		return ta.ADX(v[0].ser, v[1].ser, v[2].ser, argInt(v[3]))
+	}},
+	"STOCH": {"sssn", func(e *ta.BarEnv, v []exprVal) *ta.Series {
+		return ta.Stoch(v[0].ser, v[1].ser, v[2].ser, argInt(v[3]))
+	}},
+	"MACD": {"snnn", func(e *ta.BarEnv, v []exprVal) *ta.Series {
+		res, _ := ta.MACD(v[0].ser, argInt(v[1]), argInt(v[2]), argInt(v[3]))
+		return res
+	}},
+	"MACDSIGNAL": {"snnn", func(e *ta.BarEnv, v []exprVal) *ta.Series {
+		_, res := ta.MACD(v[0].ser, argInt(v[1]), argInt(v[2]), argInt(v[3]))
+		return res
+	}},
+	"BBUPPER": {"snnn", func(e *ta.BarEnv, v []exprVal) *ta.Series {
+		res, _, _ := ta.BBANDS(v[0].ser, argInt(v[1]), v[2].num, v[3].num)
+		return res
+	}},
+	"BBMID": {"snnn", func(e *ta.BarEnv, v []exprVal) *ta.Series {
+		_, res, _ := ta.BBANDS(v[0].ser, argInt(v[1]), v[2].num, v[3].num)
+		return res
+	}},
+	"BBLOWER": {"snnn", func(e *ta.BarEnv, v []exprVal) *ta.Series {
+		_, _, res := ta.BBANDS(v[0].ser, argInt(v[1]), v[2].num, v[3].num)
+		return res
+	}},
+	"KDJK": {"sssnnn", func(e *ta.BarEnv, v []exprVal) *ta.Series {
+		res, _, _ := ta.KDJ(v[0].ser, v[1].ser, v[2].ser, argInt(v[3]), argInt(v[4]), argInt(v[5]))
+		return res
+	}},
+	"KDJD": {"sssnnn", func(e *ta.BarEnv, v []exprVal) *ta.Series {
+		_, res, _ := ta.KDJ(v[0].ser, v[1].ser, v[2].ser, argInt(v[3]), argInt(v[4]), argInt(v[5]))
+		return res
+	}},
+	"KDJJ": {"sssnnn", func(e *ta.BarEnv, v []exprVal) *ta.Series {
+		_, _, res := ta.KDJ(v[0].ser, v[1].ser, v[2].ser, argInt(v[3]), argInt(v[4]), argInt(v[5]))
+		return res
+	}},
+	"MFI":   {"n", func(e *ta.BarEnv, v []exprVal) *ta.Series { return ta.MFI(e, argInt(v[0])) }},
+	"CMF":   {"n", func(e *ta.BarEnv, v []exprVal) *ta.Series { return ta.CMF(e, argInt(v[0])) }},
+	"WILLR": {"n", func(e *ta.BarEnv, v []exprVal) *ta.Series { return ta.WillR(e, argInt(v[0])) }},
+	"CHOP":  {"n", func(e *ta.BarEnv, v []exprVal) *ta.Series { return ta.CHOP(e, argInt(v[0])) }},
+}
+
+func boolNum(v bool) float64 {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+func isTrue(v float64) bool {
+	return v != 0 && !math.IsNaN(v)
+}
+
+func (a *exprAst) String() string {
+	switch a.op {
+	case "num":
+		return strconv.FormatFloat(a.num, 'f', -1, 64)
+	case "id":
+		return a.name
+	case "call":
+		args := make([]string, 0, len(a.args))
+		for _, arg := range a.args {
+			args = append(args, arg.String())
+		}
+		return fmt.Sprintf("%s(%s)", a.name, strings.Join(args, ", "))
+	case "idx":
+		return fmt.Sprintf("%v[%v]", a.args[0], a.args[1])
+	case "neg":
+		return fmt.Sprintf("(-%v)", a.args[0])
+	case "!":
+		return fmt.Sprintf("(!%v)", a.args[0])
+	}
+	return fmt.Sprintf("(%v %s %v)", a.args[0], a.op, a.args[1])
+}
@@ -0,0 +1,160 @@
+package strat
+
+import (
+	"math"
+	"testing"
+
+	testcom "github.com/banbox/banbot/_testcom"
+	"github.com/banbox/banbot/config"
+	ta "github.com/banbox/banta"
+)
+
+func TestParseExpr(t *testing.T) {
+	cases := map[string]string{
+		"a + b * c > 3 && !d or e":    "((((a + (b * c)) > 3) && (!d)) || e)",
+		"-close[1] / 2":               "((-close[1]) / 2)",
+		"crossOver(SMA(close, n), x)": "crossOver(SMA(close, n), x)",
+	}
+	for text, expect := range cases {
+		ast, err := parseExpr(text)
+		if err != nil {
+			t.Fatalf("parse %s fail: %v", text, err)
+		}
+		if ast.String() != expect {
+			t.Errorf("parse %s got %s, expect %s", text, ast.String(), expect)
+		}
+	}
+	for _, text := range []string{"a +", "(a", "SMA(close, 3", "a $ b"} {
+		if _, err := parseExpr(text); err == nil {
+			t.Errorf("parse %s should fail", text)
+		}
+	}
+}
+
+func TestExprCompileErr(t *testing.T) {
+	inds := map[string]string{"ma": "SMA(close, n)", "a": "b + 1", "b": "a * 2"}
+	cases := []struct {
+		text     string
+		perBar   bool
+		hasOrder bool
+	}{
+		{"unknown > 1", true, false},
+		{"profit > 0.1", true, false},
+		{"SMA(close, 5) * 2", false, false},
+		{"SMA(close, close)", true, false},
+		{"a > 0", true, false},
+		{"crossOver(ma, close) and profit > 0", false, true},
+		{"(close + open)[1] > profit", false, true},
+	}
+	for _, cs := range cases {
+		c, err := newExprCompiler(map[string]float64{"n": 5}, inds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = c.compileText("test", cs.text, cs.perBar, cs.hasOrder); err == nil {
+			t.Errorf("compile %s should fail", cs.text)
+		}
+	}
+}
+
+func TestExprEval(t *testing.T) {
+	e := &ta.BarEnv{TimeFrame: "1d", TFMSecs: 86400000, Exchange: "binance", MarketType: "future"}
+	c, err := newExprCompiler(map[string]float64{"fast": 3, "slow": 8}, map[string]string{
+		"ma_fast": "SMA(close, fast)",
+		"ma_slow": "SMA(close, slow)",
+		"body":    "SMA(close - open, 3)",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var nodes = make(map[string]*exprNode)
+	for _, name := range []string{"ma_fast", "ma_slow", "body"} {
+		if nodes[name], err = c.compileText(name, name, true, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	golden, err := c.compileText("golden", "crossOver(ma_fast, ma_slow)", true, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chg, err := c.compileText("chg", "(close - close[1]) / close[1] * 100", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := &exprCtx{env: e, key: "_expr_test", vals: make([]exprVal, len(c.indNodes))}
+	var closes, bodies []float64
+	var prevDiff = math.NaN()
+	crossNum := 0
+	testcom.RunFakeEnv(e, func(i int, bar ta.Kline) {
+		for j, node := range c.indNodes {
+			ctx.vals[j] = node.eval(ctx)
+		}
+		closes = append(closes, bar.Close)
+		bodies = append(bodies, bar.Close-bar.Open)
+		if i >= 8 {
+			fast := avgLast(closes, 3)
+			slow := avgLast(closes, 8)
+			if math.Abs(nodes["ma_fast"].eval(ctx).at(0)-fast) > 1e-6 || math.Abs(nodes["ma_slow"].eval(ctx).at(0)-slow) > 1e-6 {
+				t.Fatalf("bad ma at %d", i)
+			}
+			if math.Abs(nodes["body"].eval(ctx).at(0)-avgLast(bodies, 3)) > 1e-6 {
+				t.Fatalf("bad body at %d", i)
+			}
+			expect := !math.IsNaN(prevDiff) && prevDiff < 0 && fast-slow > 0
+			if expect {
+				crossNum += 1
+			}
+			if isTrue(golden.eval(ctx).at(0)) != expect {
+				t.Fatalf("bad crossOver at %d, expect %v", i, expect)
+			}
+			prevDiff = fast - slow
+		} else {
+			golden.eval(ctx)
+		}
+		if i > 0 {
+			expect := (bar.Close - closes[i-1]) / closes[i-1] * 100
+			if math.Abs(chg.eval(ctx).at(0)-expect) > 1e-6 {
+				t.Fatalf("bad chg at %d", i)
+			}
+		}
+	})
+	if crossNum == 0 {
+		t.Error("no crossOver found")
+	}
+}
+
+func TestNewExprStrat(t *testing.T) {
+	pol := &config.RunPolicyConfig{
+		Name:   "expr:ma",
+		Params: map[string]float64{"fast": 6},
+		Rules: &config.StratRulesConfig{
+			Hyper: map[string]*config.RuleHyperConfig{
+				"fast": {Default: 5, Min: 3, Max: 20, Int: true},
+				"sl":   {Default: 2, Min: 1, Max: 4},
+			},
+			Indicators: map[string]string{"ma": "SMA(close, fast)", "atr": "ATR(high, low, close, 14)"},
+			LongEntry:  "close > ma",
+			StopLoss:   "atr * sl",
+			CheckExit:  "hold_bars > 10",
+		},
+	}
+	stgy := New(pol)
+	if stgy.Name != "expr:ma" || stgy.OnBar == nil || stgy.OnCheckExit == nil || stgy.WarmupNum != 42 {
+		t.Fatalf("bad expr strat: %+v", stgy)
+	}
+	if len(pol.HyperParams()) != 2 || !pol.IsInt("fast") || pol.IsInt("sl") {
+		t.Fatalf("bad hyper params: %v", pol.HyperParams())
+	}
+	pol.Rules.LongEntry = ""
+	if _, err := NewExprStrat(pol); err == nil {
+		t.Error("entry is required")
+	}
+}
+
+func avgLast(arr []float64, n int) float64 {
+	var sum float64
+	for _, v := range arr[len(arr)-n:] {
+		sum += v
+	}
+	return sum / float64(n)
+}
@@ -10,7 +10,6 @@ import (
 	"github.com/banbox/banexg"
 	"github.com/banbox/banexg/errs"
 	"github.com/banbox/banexg/log"
-	utils2 "github.com/banbox/banexg/utils"
 	"go.uber.org/zap"
 	"gonum.org/v1/gonum/floats"
 	"math"
@@ -46,7 +45,7 @@ func RelayPolicyGroups() []*PolicyGroup {
 		if _, ok := polTFs[key]; ok {
 			continue
 		}
-		minTfSecs := utils2.TFToSecs(tf)
+		minTfSecs := core.TFToSecs(tf)
 		if stgy.OnPairInfos != nil {
 			job := &StratJob{
 				Strat:     stgy,
@@ -54,7 +53,7 @@ func RelayPolicyGroups() []*PolicyGroup {
 			}
 			infos := stgy.OnPairInfos(job)
 			for _, it := range infos {
-				curSecs := utils2.TFToSecs(it.TimeFrame)
+				curSecs := core.TFToSecs(it.TimeFrame)
 				if curSecs < minTfSecs {
 					minTfSecs = curSecs
 				}
@@ -101,7 +100,7 @@ func RelayPolicyGroups() []*PolicyGroup {
 		}
 		idx := gp[fmt.Sprintf("%s:%s", pol.ID(), tf)]
 		g := polGroups[idx]
-		tfSecs := int64(utils2.TFToSecs(tf) * 1000)
+		tfSecs := int64(core.TFToSecs(tf) * 1000)
 		g.StartMS = min(g.StartMS, curTime-tfSecs*int64(stgy.orderBarMax()))
 		g.Policies = append(g.Policies, pol)
 	}
@@ -118,7 +117,7 @@ func CalcPairTfScores(exchange banexg.BanExchange, pairs []string) (map[string]m
 	}
 	wsModeTf := ""
 	for _, v := range allowTfs {
-		tfSecs := utils2.TFToSecs(v)
+		tfSecs := core.TFToSecs(v)
 		if tfSecs < 60 {
 			wsModeTf = v
 			break
@@ -153,6 +152,14 @@ func CalcPairTfScores(exchange banexg.BanExchange, pairs []string) (map[string]m
 	}
 	backNum := 600
 	for _, tf := range allowTfs {
+		if core.ParseAltBar(tf) != nil {
+			// Non-time bars are not scored by klines
+			// 非时间K线不通过K线评分
+			for _, pair := range pairs {
+				handle(pair, tf, nil, nil)
+			}
+			continue
+		}
 		err := orm.FastBulkOHLCV(exchange, pairs, tf, 0, 0, backNum, handle)
 		if err != nil {
 			return pairTfScores, err
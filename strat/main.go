@@ -145,6 +145,9 @@ func LoadStratJobs(pairs []string, tfScores map[string]map[string]float64) (map[
 						if job.Strat.OnShutDown != nil {
 							job.Strat.OnShutDown(job)
 						}
+						if err := SaveJobStates(job); err != nil {
+							log.Error("save strat state fail", zap.String("strat", name), zap.Error(err))
+						}
 						exitJobs[job] = true
 						exitPairs[job.Symbol.Symbol] = true
 						if job.EnteredNum > 0 {
@@ -163,7 +166,7 @@ func LoadStratJobs(pairs []string, tfScores map[string]map[string]float64) (map[
 				arr := strings.Split(envKey, "_")
 				pair, tf := arr[0], arr[1]
 				if _, ok := core.TFSecs[tf]; !ok {
-					core.TFSecs[tf] = utils2.TFToSecs(tf)
+					core.TFSecs[tf] = core.TFToSecs(tf)
 				}
 				for _, j := range resJobs {
 					subMap, ok := core.StgPairTfs[j.Strat.Name]
@@ -216,7 +219,7 @@ func LoadStratJobs(pairs []string, tfScores map[string]map[string]float64) (map[
 				arr := strings.Split(pairTf, "_")
 				pair, tf := arr[0], arr[1]
 				if _, ok := core.TFSecs[tf]; !ok {
-					core.TFSecs[tf] = utils2.TFToSecs(tf)
+					core.TFSecs[tf] = core.TFToSecs(tf)
 				}
 				// 确保添加到pairTfWarms中
 				pairTfs.Update(pair, tf, 0)
@@ -274,9 +277,13 @@ func ExitStratJobs() {
 				if job.Strat.OnShutDown != nil {
 					job.Strat.OnShutDown(job)
 				}
+				SnapJobState(job)
 			}
 		}
 	}
+	if err := SaveJobStates(); err != nil {
+		log.Error("save strat states fail", zap.Error(err))
+	}
 }
 
 func printFailTfScores(stratName string, pairTfScores map[string]map[string]float64) {
@@ -302,7 +309,7 @@ func initBarEnv(exs *orm.ExSymbol, tf string) *ta.BarEnv {
 	envKey := strings.Join([]string{exs.Symbol, tf}, "_")
 	env, ok := Envs[envKey]
 	if !ok {
-		tfMSecs := int64(utils2.TFToSecs(tf) * 1000)
+		tfMSecs := int64(core.TFToSecs(tf) * 1000)
 		env = &ta.BarEnv{
 			Exchange:   core.ExgName,
 			MarketType: core.Market,
@@ -371,6 +378,7 @@ func ensureStratJob(stgy *TradeStrat, tf string, exs *orm.ExSymbol, env *ta.BarE
 			if stgy.OnStartUp != nil {
 				stgy.OnStartUp(job)
 			}
+			restoreJobState(job)
 			envJobs[stgy.Name] = job
 		}
 		if allowOpen {
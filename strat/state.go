@@ -0,0 +1,176 @@
+package strat
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"sync"
+
+	"github.com/banbox/banbot/btime"
+	"github.com/banbox/banbot/core"
+	"github.com/banbox/banbot/orm"
+	"github.com/banbox/banbot/orm/ormo"
+	"github.com/banbox/banexg/errs"
+	"github.com/banbox/banexg/log"
+	utils2 "github.com/banbox/banexg/utils"
+	"go.uber.org/zap"
+)
+
+var (
+	accStates   = make(map[string]map[string]string) // account: strategy|pair|tf: state json loaded from db 从数据库加载的状态
+	savedStates = make(map[string]string)            // account|strategy|pair|tf: last saved json 上次保存的状态
+	pendStates  = make(map[string]*jobState)         // account|strategy|pair|tf: snapshots not saved yet 尚未保存的状态快照
+	lockStates  sync.Mutex
+)
+
+// jobState snapshot of a job state waiting to be saved 等待保存的任务状态快照
+type jobState struct {
+	Account   string
+	Strategy  string
+	Symbol    string
+	Timeframe string
+	Data      string
+}
+
+func stateKey(job *StratJob) string {
+	return strings.Join([]string{job.Strat.Name, job.Symbol.Symbol, job.TimeFrame}, "|")
+}
+
+/*
+loadAccStates
+Load all saved strategy states of the account's task from trades db, cached after the first call. Should be called with lockStates held.
+从交易数据库加载账户任务的所有策略状态，首次调用后缓存。调用时应持有lockStates
+*/
+func loadAccStates(account string) (map[string]string, *errs.Error) {
+	if states, ok := accStates[account]; ok {
+		return states, nil
+	}
+	states := make(map[string]string)
+	accStates[account] = states
+	taskId := ormo.GetTaskID(account)
+	if taskId == 0 {
+		return states, nil
+	}
+	sess, conn, err := ormo.Conn(orm.DbTrades, true)
+	if err != nil {
+		return states, err
+	}
+	defer conn.Close()
+	rows, err_ := sess.ListStratStates(context.Background(), taskId)
+	if err_ != nil {
+		return states, errs.New(core.ErrDbReadFail, err_)
+	}
+	for _, row := range rows {
+		key := strings.Join([]string{row.Strategy, row.Symbol, row.Timeframe}, "|")
+		states[key] = row.Data
+		savedStates[account+"|"+key] = row.Data
+	}
+	return states, nil
+}
+
+/*
+restoreJobState
+Pass the saved state to TradeStrat.OnLoadState in live mode, called after OnStartUp and before the first OnBar.
+实盘模式下将已保存的状态传给TradeStrat.OnLoadState，在OnStartUp之后、首次OnBar之前调用
+*/
+func restoreJobState(job *StratJob) {
+	if job.Strat.OnLoadState == nil || !core.LiveMode {
+		return
+	}
+	lockStates.Lock()
+	states, err := loadAccStates(job.Account)
+	data, ok := states[stateKey(job)]
+	lockStates.Unlock()
+	if err != nil {
+		log.Error("load strat states fail", zap.String("acc", job.Account), zap.Error(err))
+	}
+	if ok {
+		job.Strat.OnLoadState(job, data)
+	}
+}
+
+/*
+SnapJobState
+Take the state returned by TradeStrat.OnSaveState in live mode, on the goroutine running OnBar after it's called,
+so OnSaveState never runs concurrently with OnBar. Unchanged states are skipped, others are written by SaveJobStates.
+实盘模式下获取TradeStrat.OnSaveState返回的状态，在运行OnBar的协程中于其之后调用，使OnSaveState不会与OnBar并发执行。
+跳过未变化的状态，其他的由SaveJobStates写入。
+*/
+func SnapJobState(job *StratJob) {
+	if job.Strat.OnSaveState == nil || !core.LiveMode {
+		return
+	}
+	val := job.Strat.OnSaveState(job)
+	if val == nil {
+		return
+	}
+	text, err_ := utils2.MarshalString(val)
+	if err_ != nil {
+		log.Error("marshal strat state fail", zap.String("strat", job.Strat.Name),
+			zap.String("pair", job.Symbol.Symbol), zap.Error(err_))
+		return
+	}
+	cacheKey := job.Account + "|" + stateKey(job)
+	lockStates.Lock()
+	defer lockStates.Unlock()
+	if old, ok := savedStates[cacheKey]; ok && old == text {
+		delete(pendStates, cacheKey)
+		return
+	}
+	pendStates[cacheKey] = &jobState{Account: job.Account, Strategy: job.Strat.Name, Symbol: job.Symbol.Symbol,
+		Timeframe: job.TimeFrame, Data: text}
+}
+
+/*
+SaveJobStates
+Write state snapshots taken by SnapJobState to trades db in live mode. The given `jobs` are snapshotted first,
+should only be passed on the goroutine running them. Called periodically and when jobs are shut down.
+
+实盘模式下将SnapJobState获取的状态快照写入交易数据库。传入的jobs会先获取快照，仅应在运行它们的协程中传入。
+定期调用，并在任务停止时调用。
+*/
+func SaveJobStates(jobs ...*StratJob) *errs.Error {
+	if !core.LiveMode {
+		return nil
+	}
+	for _, job := range jobs {
+		SnapJobState(job)
+	}
+	lockStates.Lock()
+	defer lockStates.Unlock()
+	var sess *ormo.Queries
+	ctx := context.Background()
+	for cacheKey, sta := range pendStates {
+		taskId := ormo.GetTaskID(sta.Account)
+		if taskId == 0 {
+			delete(pendStates, cacheKey)
+			continue
+		}
+		if sess == nil {
+			var conn *sql.DB
+			var err *errs.Error
+			sess, conn, err = ormo.Conn(orm.DbTrades, true)
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+		}
+		err_ := sess.SetStratState(ctx, ormo.SetStratStateParams{
+			TaskID:    taskId,
+			Strategy:  sta.Strategy,
+			Symbol:    sta.Symbol,
+			Timeframe: sta.Timeframe,
+			UpdateAt:  btime.UTCStamp(),
+			Data:      sta.Data,
+		})
+		if err_ != nil {
+			return errs.New(core.ErrDbExecFail, err_)
+		}
+		delete(pendStates, cacheKey)
+		savedStates[cacheKey] = sta.Data
+		if states, ok := accStates[sta.Account]; ok {
+			states[strings.Join([]string{sta.Strategy, sta.Symbol, sta.Timeframe}, "|")] = sta.Data
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package strat
+
+import (
+	"testing"
+
+	"github.com/banbox/banbot/config"
+	"github.com/banbox/banbot/core"
+	"github.com/banbox/banbot/orm"
+	"github.com/banbox/banbot/orm/ormo"
+	utils2 "github.com/banbox/banexg/utils"
+)
+
+type gridState struct {
+	Level   int     `json:"level"`
+	LastBar int64   `json:"last_bar"`
+	Prices  []int64 `json:"prices"`
+}
+
+func TestJobStates(t *testing.T) {
+	oldLive, oldAccs, oldName := core.LiveMode, config.Accounts, config.Name
+	core.LiveMode = true
+	config.Accounts = map[string]*config.AccountConfig{config.DefAcc: {}}
+	config.Name = "state_test"
+	defer func() {
+		core.LiveMode, config.Accounts, config.Name = oldLive, oldAccs, oldName
+	}()
+	if err := ormo.InitTask(false, t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	stgy := &TradeStrat{
+		Name: "grid",
+		OnStartUp: func(s *StratJob) {
+			s.More = &gridState{}
+		},
+		OnSaveState: func(s *StratJob) interface{} {
+			return s.More
+		},
+		OnLoadState: func(s *StratJob, data string) {
+			if err := utils2.UnmarshalString(data, s.More, utils2.JsonNumDefault); err != nil {
+				t.Fatal(err)
+			}
+		},
+	}
+	newJob := func() *StratJob {
+		job := &StratJob{Strat: stgy, Symbol: &orm.ExSymbol{Symbol: "BTC/USDT:USDT"}, TimeFrame: "1h",
+			Account: config.DefAcc}
+		stgy.OnStartUp(job)
+		restoreJobState(job)
+		return job
+	}
+	job := newJob()
+	if job.More.(*gridState).Level != 0 {
+		t.Fatalf("state should be empty: %+v", job.More)
+	}
+	job.More = &gridState{Level: 3, LastBar: 1700000000000, Prices: []int64{95, 100, 105}}
+	if err := SaveJobStates(job); err != nil {
+		t.Fatal(err)
+	}
+	// simulate restart 模拟重启
+	accStates = make(map[string]map[string]string)
+	savedStates = make(map[string]string)
+	job = newJob()
+	st := job.More.(*gridState)
+	if st.Level != 3 || st.LastBar != 1700000000000 || len(st.Prices) != 3 {
+		t.Fatalf("bad restored state: %+v", st)
+	}
+	st.Level = 4
+	if err := SaveJobStates(job); err != nil {
+		t.Fatal(err)
+	}
+	accStates = make(map[string]map[string]string)
+	if job = newJob(); job.More.(*gridState).Level != 4 {
+		t.Fatalf("state should be updated: %+v", job.More)
+	}
+	// the periodic save only writes snapshots taken after OnBar, not the state changed later
+	// 定期保存只写入OnBar之后获取的快照，而不是之后变化的状态
+	job.More.(*gridState).Level = 5
+	SnapJobState(job)
+	job.More.(*gridState).Level = 6
+	if err := SaveJobStates(); err != nil {
+		t.Fatal(err)
+	}
+	if len(pendStates) != 0 {
+		t.Fatalf("snapshots should be saved: %v", pendStates)
+	}
+	accStates = make(map[string]map[string]string)
+	if job = newJob(); job.More.(*gridState).Level != 5 {
+		t.Fatalf("snapshot state should be saved: %+v", job.More)
+	}
+}
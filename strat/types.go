@@ -45,6 +45,12 @@ type TradeStrat struct {
 	GetDrawDownExitRate CalcDDExitRate                                      // Calculate the ratio of tracking profit taking, drawdown, and exit 计算跟踪止盈回撤退出的比率
 	PickTimeFrame       PickTimeFrameFunc                                   // Choose a suitable trading cycle for the specified currency 为指定币选择适合的交易周期
 	OnShutDown          func(s *StratJob)                                   // Callback when the robot stops 机器人停止时回调
+	// Return custom state to persist in live mode, saved as json periodically and on shut down, nil to skip. Called on the goroutine of OnBar after it returns
+	// 返回实盘时要持久化的自定义状态，定期及停止时保存为json，返回nil跳过。在OnBar所在协程中于其返回后调用
+	OnSaveState func(s *StratJob) interface{}
+	// Restore json saved by OnSaveState after live restarts, called after OnStartUp and before warm-up OnBar, check IsWarmUp in OnBar to avoid repeated updates
+	// 实盘重启后恢复OnSaveState保存的json，在OnStartUp之后、预热OnBar之前调用，OnBar中需检查IsWarmUp以免重复更新
+	OnLoadState func(s *StratJob, data string)
 }
 
 const (
@@ -129,12 +135,17 @@ type EnterReq struct {
 	StopLossLimit   float64 // Stop loss limit price, does not provide the use of StopLoss 止损限制价格，不提供使用StopLoss
 	StopLossRate    float64 // Stop loss exit ratio, 0 means all exits, needs to be between (0,1) 止损退出比例，0表示全部退出，需介于(0,1]之间
 	StopLossTag     string  // Reason for Stop Loss 止损原因
+	TrailActivate   float64 // Activation price of trailing stop loss, 0 means trailing from entry 跟踪止损激活价格，0表示入场即跟踪
+	TrailCallback   float64 // Callback rate of trailing stop loss from the best price, (0,1) 跟踪止损相对最优价格的回调比例，(0,1)
+	TrailOffset     float64 // Callback price distance of trailing stop loss, used when TrailCallback is 0 跟踪止损回调价格距离，TrailCallback为0时使用
+	TrailStep       float64 // Min price improvement to move the trailing stop loss 移动跟踪止损的最小价格变化
 	TakeProfitVal   float64 // The distance from the entry price to the take profit price is used to calculate TakeProfit 入场价格到止盈价格的距离，用于计算TakeProfit
 	TakeProfit      float64 // When the take profit trigger price is not empty, submit a take profit order on the exchange. 止盈触发价格，不为空时在交易所提交一个止盈单。
 	TakeProfitLimit float64 // Profit taking limit price, TakeProfit is not available for use 止盈限制价格，不提供使用TakeProfit
 	TakeProfitRate  float64 // Take profit exit ratio, 0 indicates full exit, needs to be between (0,1) 止盈退出比率，0表示全部退出，需介于(0,1]之间
 	TakeProfitTag   string  // Reason for profit taking 止盈原因
 	StopBars        int     // If the entry limit order exceeds how many bars and is not executed, it will be cancelled 入场限价单超过多少个bar未成交则取消
+	AddTo           int64   // ID of the entered order to add position, set by AddOrder 要加仓的已入场订单ID，由AddOrder设置
 }
 
 /*
@@ -0,0 +1,417 @@
+/*
+Package strattest runs a TradeStrat on synthetic klines without database, exchange network or config.yml.
+Klines are fed through the same Trader and LocalOrderMgr as backtest, so EnterReq/ExitReq, filled orders,
+exit tags and wallet balance can be asserted in unit tests.
+Harness modifies package globals, so tests using it should not run in parallel.
+
+Package strattest 在合成K线上运行TradeStrat，无需数据库、交易所网络或config.yml。
+K线经过与回测相同的Trader和LocalOrderMgr，可在单元测试中断言EnterReq/ExitReq、成交订单、退出标签和钱包余额。
+Harness会修改包全局变量，使用它的测试不应并行运行。
+*/
+package strattest
+
+import (
+	"math"
+	"slices"
+	"strings"
+
+	"github.com/banbox/banbot/biz"
+	"github.com/banbox/banbot/btime"
+	"github.com/banbox/banbot/config"
+	"github.com/banbox/banbot/core"
+	"github.com/banbox/banbot/exg"
+	"github.com/banbox/banbot/orm"
+	"github.com/banbox/banbot/orm/ormo"
+	"github.com/banbox/banbot/strat"
+	"github.com/banbox/banexg"
+	"github.com/banbox/banexg/bex"
+	"github.com/banbox/banexg/binance"
+	"github.com/banbox/banexg/errs"
+	utils2 "github.com/banbox/banexg/utils"
+	ta "github.com/banbox/banta"
+)
+
+/*
+Options
+Settings of a Harness, zero values use defaults
+Harness的设置，零值使用默认值
+*/
+type Options struct {
+	Pair        string                 // default BTC/USDT:USDT, spot market for pairs without `:` 默认BTC/USDT:USDT，不含`:`时为现货市场
+	TimeFrame   string                 // default 1h 默认1h
+	Wallets     map[string]float64     // initial wallets, default 10000 of quote 初始钱包，默认10000计价币
+	StakeAmount float64                // default 100 默认100
+	Leverage    float64                // default 1 默认1
+	FeeRate     float64                // fee rate of fills, default 0 成交手续费率，默认0
+	PriceStep   float64                // price tick size, default 0.01 价格最小变动，默认0.01
+	AmountStep  float64                // amount step, default 0.0001 数量步长，默认0.0001
+	Config      func(c *config.Config) // edit the config before applied 应用前修改配置
+}
+
+/*
+Step
+Result of one fed bar: the requests made by the strategy on this bar
+一个输入bar的结果：策略在此bar上发出的请求
+*/
+type Step struct {
+	Bar    *banexg.Kline
+	WarmUp bool
+	Entrys []*strat.EnterReq
+	Exits  []*strat.ExitReq
+}
+
+type Harness struct {
+	Exg                            *exg.MockExchange
+	Env                            *ta.BarEnv
+	Job                            *strat.StratJob
+	Symbol                         *orm.ExSymbol
+	Steps                          []*Step
+	trader                         *biz.Trader
+	last                           *banexg.Kline
+	oldExg                         banexg.BanExchange
+	oldMode, oldExgName, oldMarket string
+	oldContract                    bool
+}
+
+/*
+New
+Setup globals for an in-memory backtest of stgy on a single pair, call Close when done.
+Strategies made by strat.New keep their policy, e.g. `expr` strategies with `rules`.
+为stgy在单个品种上的内存回测设置全局变量，完成后调用Close。strat.New创建的策略保留其policy，如带`rules`的`expr`策略。
+*/
+func New(stgy *strat.TradeStrat, opt *Options) (*Harness, *errs.Error) {
+	if opt == nil {
+		opt = &Options{}
+	}
+	if opt.Pair == "" {
+		opt.Pair = "BTC/USDT:USDT"
+	}
+	if opt.TimeFrame == "" {
+		opt.TimeFrame = "1h"
+	}
+	if opt.StakeAmount == 0 {
+		opt.StakeAmount = 100
+	}
+	if opt.Leverage == 0 {
+		opt.Leverage = 1
+	}
+	if opt.PriceStep == 0 {
+		opt.PriceStep = 0.01
+	}
+	if opt.AmountStep == 0 {
+		opt.AmountStep = 0.0001
+	}
+	tfSecs, err := utils2.ParseTimeFrame(opt.TimeFrame)
+	if err != nil {
+		return nil, errs.NewMsg(errs.CodeParamInvalid, "invalid timeframe: %s", opt.TimeFrame)
+	}
+	tfMSecs := int64(tfSecs * 1000)
+	base, quote, settle, _ := core.SplitSymbol(opt.Pair)
+	if base == "" || quote == "" {
+		return nil, errs.NewMsg(errs.CodeParamInvalid, "invalid pair: %s", opt.Pair)
+	}
+	if opt.Wallets == nil {
+		opt.Wallets = map[string]float64{quote: 10000}
+	}
+	marketType := banexg.MarketSpot
+	if settle != "" {
+		marketType = banexg.MarketLinear
+	}
+	inner, err := bex.New("binance", map[string]interface{}{
+		banexg.OptMarketType: marketType,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if bnb, ok := inner.(*binance.Binance); ok && settle != "" {
+		// single bracket for maintenance margin, like the lowest tier of binance 单层维持保证金，类似币安最低档
+		bnb.LeverageBrackets = map[string]*binance.SymbolLvgBrackets{opt.Pair: {
+			Symbol: opt.Pair,
+			Brackets: []*binance.LvgBracket{{
+				BaseLvgBracket: binance.BaseLvgBracket{Bracket: 1, InitialLeverage: 125, MaintMarginRatio: 0.004},
+				Capacity:       math.MaxFloat64,
+			}},
+		}}
+	}
+	mock := exg.NewMockExchange(inner)
+	mock.FeeRate = opt.FeeRate
+	mock.SetMarkets(banexg.MarketMap{opt.Pair: newMarket(opt, base, quote, settle, marketType)})
+	h := &Harness{
+		Exg:         mock,
+		trader:      &biz.Trader{},
+		oldExg:      exg.Default,
+		oldMode:     core.RunMode,
+		oldExgName:  core.ExgName,
+		oldMarket:   core.Market,
+		oldContract: core.IsContract,
+	}
+	core.SetRunMode(core.RunModeBackTest)
+	exg.Default = mock
+	cfg := &config.Config{
+		Name:          "strattest",
+		Env:           core.RunEnvTest,
+		MarketType:    marketType,
+		Exchange:      &config.ExchangeConfig{Name: "binance", Items: map[string]map[string]interface{}{}},
+		Accounts:      map[string]*config.AccountConfig{config.DefAcc: {}},
+		StakeCurrency: []string{quote},
+		WalletAmounts: opt.Wallets,
+		StakeAmount:   opt.StakeAmount,
+		Leverage:      opt.Leverage,
+		OrderType:     banexg.OdTypeMarket,
+		TimeRange:     &config.TimeTuple{StartMS: 0, EndMS: math.MaxInt64},
+	}
+	if opt.Config != nil {
+		opt.Config(cfg)
+	}
+	err = config.ApplyConfig(&config.CmdArgs{}, cfg)
+	if err != nil {
+		h.Close()
+		return nil, err
+	}
+	core.IsContract = banexg.IsContract(core.Market)
+	core.BotRunning = true
+	biz.ResetVars()
+	h.Symbol = &orm.ExSymbol{ID: 1, Exchange: core.ExgName, ExgReal: core.ExgName, Market: core.Market,
+		Symbol: opt.Pair}
+	orm.CacheExSymbols(h.Symbol)
+	h.Env = &ta.BarEnv{
+		Exchange:   core.ExgName,
+		MarketType: core.Market,
+		Symbol:     opt.Pair,
+		TimeFrame:  opt.TimeFrame,
+		TFMSecs:    tfMSecs,
+		MaxCache:   core.NumTaCache,
+		Data:       map[string]interface{}{"sid": int64(h.Symbol.ID)},
+	}
+	envKey := strings.Join([]string{opt.Pair, opt.TimeFrame}, "_")
+	strat.Envs[envKey] = h.Env
+	if stgy.Name == "" {
+		stgy.Name = "strattest"
+	}
+	if stgy.Policy == nil {
+		stgy.Policy = &config.RunPolicyConfig{Name: stgy.Name}
+	}
+	strat.PairStrats[opt.Pair] = map[string]*strat.TradeStrat{stgy.Name: stgy}
+	h.Job = &strat.StratJob{
+		Strat:         stgy,
+		Env:           h.Env,
+		Symbol:        h.Symbol,
+		TimeFrame:     opt.TimeFrame,
+		Account:       config.DefAcc,
+		TPMaxs:        make(map[int64]float64),
+		CloseLong:     true,
+		CloseShort:    true,
+		ExgStopLoss:   true,
+		ExgTakeProfit: true,
+		MaxOpenLong:   stgy.EachMaxLong,
+		MaxOpenShort:  stgy.EachMaxShort,
+	}
+	if stgy.OnStartUp != nil {
+		stgy.OnStartUp(h.Job)
+	}
+	strat.GetJobs(config.DefAcc)[envKey] = map[string]*strat.StratJob{stgy.Name: h.Job}
+	biz.InitFakeWallets()
+	biz.InitLocalOrderMgr(h.orderCB, false)
+	return h, nil
+}
+
+func newMarket(opt *Options, base, quote, settle, marketType string) *banexg.Market {
+	isContract := banexg.IsContract(marketType)
+	return &banexg.Market{
+		ID:           strings.ReplaceAll(base+quote, "/", ""),
+		Symbol:       opt.Pair,
+		Base:         base,
+		Quote:        quote,
+		Settle:       settle,
+		Type:         marketType,
+		Spot:         !isContract,
+		Swap:         isContract,
+		Contract:     isContract,
+		Linear:       isContract,
+		Active:       true,
+		ContractSize: 1,
+		Precision: &banexg.Precision{
+			Amount:     opt.AmountStep,
+			Price:      opt.PriceStep,
+			ModeAmount: banexg.PrecModeTickSize,
+			ModePrice:  banexg.PrecModeTickSize,
+		},
+		Limits: &banexg.MarketLimits{
+			Leverage: &banexg.LimitRange{Min: 1, Max: 125},
+			Amount:   &banexg.LimitRange{Min: opt.AmountStep},
+			Price:    &banexg.LimitRange{Min: opt.PriceStep},
+			Cost:     &banexg.LimitRange{},
+			Market:   &banexg.LimitRange{},
+		},
+	}
+}
+
+func (h *Harness) orderCB(od *ormo.InOutOrder, isEnter bool) {
+	if !isEnter {
+		// update the stake amount by percent like backtest 像回测一样按百分比更新开单金额
+		biz.GetWallets(config.DefAcc).TryUpdateStakePctAmt()
+	}
+}
+
+// Feed run the strategy on bars in order 按顺序在bars上运行策略
+func (h *Harness) Feed(bars ...*banexg.Kline) *errs.Error {
+	for _, bar := range bars {
+		if err := h.feed(bar, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+Warm
+Feed bars as warm-up: indicators and OnBar are updated, orders are not processed
+以预热方式输入bars：更新指标和OnBar，不处理订单
+*/
+func (h *Harness) Warm(bars ...*banexg.Kline) *errs.Error {
+	for _, bar := range bars {
+		if err := h.feed(bar, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FeedCSV run the strategy on klines of a csv file in the format of `kline export` 在`kline export`格式的csv文件K线上运行策略
+func (h *Harness) FeedCSV(path string) *errs.Error {
+	bars, err := orm.ReadKlineCsv(path)
+	if err != nil {
+		return err
+	}
+	return h.Feed(bars...)
+}
+
+func (h *Harness) feed(bar *banexg.Kline, warmUp bool) *errs.Error {
+	if h.last != nil && bar.Time <= h.last.Time {
+		return errs.NewMsg(core.ErrInvalidBars, "bar time %v should > %v", bar.Time, h.last.Time)
+	}
+	h.last = bar
+	// the bar is finished when fed, same as backtest 输入时bar已完成，与回测一致
+	btime.CurTimeMS = bar.Time + h.Env.TFMSecs
+	curTime := btime.TimeMS()
+	if !warmUp {
+		if curTime > strat.LastBatchMS {
+			if waitNum := biz.TryFireBatches(curTime); waitNum > 0 {
+				return errs.NewMsg(core.ErrRunTime, "batch job exec fail, wait: %v", waitNum)
+			}
+			strat.LastBatchMS = curTime
+		}
+		core.CheckWallets = true
+	}
+	err := h.trader.FeedKline(&orm.InfoKline{
+		PairTFKline: &banexg.PairTFKline{Kline: *bar, Symbol: h.Env.Symbol, TimeFrame: h.Env.TimeFrame},
+		IsWarmUp:    warmUp,
+	})
+	h.Steps = append(h.Steps, &Step{Bar: bar, WarmUp: warmUp, Entrys: h.Job.Entrys, Exits: h.Job.Exits})
+	return err
+}
+
+/*
+End
+Exit all open orders at the last bar with tag `env_end`, same as the end of backtest
+在最后一个bar以`env_end`标签退出所有未平仓订单，与回测结束时一致
+*/
+func (h *Harness) End() {
+	if h.last == nil {
+		return
+	}
+	h.trader.OnEnvEnd(&banexg.PairTFKline{Kline: *h.last, Symbol: h.Env.Symbol, TimeFrame: h.Env.TimeFrame}, nil)
+}
+
+// Close restore the exchange and reset the global states 恢复交易所并重置全局状态
+func (h *Harness) Close() {
+	biz.ResetVars()
+	exg.Default = h.oldExg
+	core.SetRunMode(h.oldMode)
+	core.ExgName, core.Market, core.IsContract = h.oldExgName, h.oldMarket, h.oldContract
+}
+
+// Entrys entry requests of all non-warm-up bars 所有非预热bar的入场请求
+func (h *Harness) Entrys() []*strat.EnterReq {
+	var res []*strat.EnterReq
+	for _, s := range h.Steps {
+		if !s.WarmUp {
+			res = append(res, s.Entrys...)
+		}
+	}
+	return res
+}
+
+// Exits exit requests of all non-warm-up bars 所有非预热bar的退出请求
+func (h *Harness) Exits() []*strat.ExitReq {
+	var res []*strat.ExitReq
+	for _, s := range h.Steps {
+		if !s.WarmUp {
+			res = append(res, s.Exits...)
+		}
+	}
+	return res
+}
+
+// OpenOrders orders not fully exited, sorted by id 未完全退出的订单，按id排序
+func (h *Harness) OpenOrders() []*ormo.InOutOrder {
+	openOds, lock := ormo.GetOpenODs(config.DefAcc)
+	lock.Lock()
+	res := make([]*ormo.InOutOrder, 0, len(openOds))
+	for _, od := range openOds {
+		res = append(res, od)
+	}
+	lock.Unlock()
+	slices.SortFunc(res, func(a, b *ormo.InOutOrder) int {
+		return int(a.ID - b.ID)
+	})
+	return res
+}
+
+// ClosedOrders filled orders which are fully exited, in exit order 已完全退出的成交订单，按退出顺序
+func (h *Harness) ClosedOrders() []*ormo.InOutOrder {
+	return slices.Clone(ormo.HistODs)
+}
+
+// ExitTags exit tags of ClosedOrders ClosedOrders的退出标签
+func (h *Harness) ExitTags() []string {
+	res := make([]string, 0, len(ormo.HistODs))
+	for _, od := range ormo.HistODs {
+		res = append(res, od.ExitTag)
+	}
+	return res
+}
+
+func (h *Harness) Wallets() *biz.BanWallets {
+	return biz.GetWallets(config.DefAcc)
+}
+
+// Balance total legal value of wallets, without unrealized profits 钱包的总法币价值，不含未实现盈亏
+func (h *Harness) Balance() float64 {
+	return h.Wallets().TotalLegal(nil, false)
+}
+
+/*
+Bars
+Script klines from close prices: open is the previous close, high/low cover open and close, volume is 1000
+Return nil for an invalid timeFrame
+由收盘价生成K线：开盘价为上一个收盘价，最高最低价覆盖开盘和收盘价，成交量为1000
+timeFrame无效时返回nil
+*/
+func Bars(startMS int64, timeFrame string, closes ...float64) []*banexg.Kline {
+	tfSecs, err := utils2.ParseTimeFrame(timeFrame)
+	if err != nil {
+		return nil
+	}
+	tfMSecs := int64(tfSecs * 1000)
+	res := make([]*banexg.Kline, 0, len(closes))
+	for i, price := range closes {
+		open := price
+		if i > 0 {
+			open = closes[i-1]
+		}
+		res = append(res, &banexg.Kline{Time: startMS + int64(i)*tfMSecs, Open: open, High: max(open, price),
+			Low: min(open, price), Close: price, Volume: 1000})
+	}
+	return res
+}
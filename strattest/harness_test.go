@@ -0,0 +1,245 @@
+package strattest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/banbox/banbot/config"
+	"github.com/banbox/banbot/core"
+	"github.com/banbox/banbot/strat"
+)
+
+const startMS = int64(1700000000000)
+
+func newHarness(t *testing.T, stgy *strat.TradeStrat, opt *Options) *Harness {
+	h, err := New(stgy, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(h.Close)
+	return h
+}
+
+func TestInvalidTimeFrame(t *testing.T) {
+	if _, err := New(&strat.TradeStrat{Name: "bad"}, &Options{TimeFrame: "1x"}); err == nil {
+		t.Fatal("expect error for invalid timeframe")
+	}
+	if bars := Bars(startMS, "abc", 100, 101); bars != nil {
+		t.Fatalf("expect no bars for invalid timeframe, got %v", bars)
+	}
+}
+
+func TestStopLoss(t *testing.T) {
+	stgy := &strat.TradeStrat{
+		Name:        "sl",
+		EachMaxLong: 1,
+		OnBar: func(s *strat.StratJob) {
+			if s.Env.BarNum == 3 {
+				_ = s.OpenOrder(&strat.EnterReq{Tag: "long", StopLoss: 95})
+			}
+		},
+	}
+	h := newHarness(t, stgy, nil)
+	if err := h.Feed(Bars(startMS, "1h", 100, 100, 100, 101, 99, 90, 92)...); err != nil {
+		t.Fatal(err)
+	}
+	if ents := h.Entrys(); len(ents) != 1 || ents[0].Tag != "long" {
+		t.Fatalf("expect one entry, got %v", ents)
+	}
+	ods := h.ClosedOrders()
+	if len(ods) != 1 || len(h.OpenOrders()) != 0 {
+		t.Fatalf("expect one closed order, got %v, open: %v", ods, h.OpenOrders())
+	}
+	od := ods[0]
+	// filled at the open of next bar, stop loss with slippage 在下一个bar开盘成交，止损有滑点
+	// the tag is decided by the fill price, not the profitable close of previous bar 标签由成交价决定，而非上个bar盈利的收盘价
+	if od.ExitTag != core.ExitTagStopLoss || od.Enter.Average != 100 || od.Exit.Average > 95 || od.Profit >= 0 {
+		t.Fatalf("bad stop loss order: %s enter %v exit %v", od.ExitTag, od.Enter.Average, od.Exit.Average)
+	}
+	if bal := h.Balance(); bal >= 10000 || bal < 9990 {
+		t.Fatalf("bad balance after stop loss: %v", bal)
+	}
+}
+
+func TestExitTags(t *testing.T) {
+	stgy := &strat.TradeStrat{
+		Name:         "cross",
+		EachMaxLong:  1,
+		EachMaxShort: 1,
+		OnBar: func(s *strat.StratJob) {
+			e := s.Env
+			if e.Close.Get(0) > e.Close.Get(1) {
+				if len(s.ShortOrders) > 0 {
+					_ = s.CloseOrders(&strat.ExitReq{Tag: "up", Dirt: core.OdDirtShort})
+				}
+				if len(s.LongOrders) == 0 {
+					_ = s.OpenOrder(&strat.EnterReq{Tag: "long"})
+				}
+			} else if e.Close.Get(0) < e.Close.Get(1) {
+				if len(s.LongOrders) > 0 {
+					_ = s.CloseOrders(&strat.ExitReq{Tag: "down", Dirt: core.OdDirtLong})
+				}
+				if len(s.ShortOrders) == 0 {
+					_ = s.OpenOrder(&strat.EnterReq{Tag: "short", Short: true})
+				}
+			}
+		},
+	}
+	h := newHarness(t, stgy, &Options{FeeRate: 0.001})
+	bars := Bars(startMS, "1h", 100, 100, 101, 103, 102, 100, 104, 104)
+	if err := h.Warm(bars[:2]...); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Feed(bars[2:]...); err != nil {
+		t.Fatal(err)
+	}
+	if len(h.Steps) != 8 || len(h.Steps[1].Entrys) != 0 || len(h.Entrys()) != 3 || len(h.Exits()) != 2 {
+		t.Fatalf("bad requests, entrys: %v, exits: %v", h.Entrys(), h.Exits())
+	}
+	h.End()
+	tags := strings.Join(h.ExitTags(), ",")
+	if tags != "down,up,"+core.ExitTagEnvEnd {
+		t.Fatalf("bad exit tags: %s", tags)
+	}
+	var profit float64
+	for _, od := range h.ClosedOrders() {
+		if od.Enter.Fee <= 0 {
+			t.Fatalf("fee is required: %v", od.Key())
+		}
+		profit += od.Profit
+	}
+	if bal := h.Balance(); !nearly(bal, 10000+profit) {
+		t.Fatalf("balance %v should be %v", bal, 10000+profit)
+	}
+}
+
+func TestTrailingStop(t *testing.T) {
+	stgy := &strat.TradeStrat{
+		Name:        "trail",
+		EachMaxLong: 1,
+		OnBar: func(s *strat.StratJob) {
+			if s.Env.BarNum == 3 {
+				_ = s.OpenOrder(&strat.EnterReq{Tag: "long", TrailActivate: 105, TrailCallback: 0.03})
+			}
+		},
+	}
+	h := newHarness(t, stgy, nil)
+	// not activated at 98, then trails 110 to 106.7 and is hit in the bar falling to 104
+	// 98时未激活，之后跟踪110到106.7，并在跌到104的bar中触发
+	if err := h.Feed(Bars(startMS, "1h", 100, 100, 100, 100, 98, 104, 108, 110, 107)...); err != nil {
+		t.Fatal(err)
+	}
+	ods := h.OpenOrders()
+	if len(ods) != 1 || len(h.ClosedOrders()) != 0 {
+		t.Fatalf("expect one open order, got %v, closed: %v", ods, h.ClosedOrders())
+	}
+	if sl := ods[0].GetStopLoss(); sl == nil || sl.Extreme != 110 || !nearly(sl.Price, 106.7) {
+		t.Fatalf("bad trailing stop: %v", sl)
+	}
+	if err := h.Feed(Bars(startMS+9*3600000, "1h", 107, 104, 103)[1:]...); err != nil {
+		t.Fatal(err)
+	}
+	closed := h.ClosedOrders()
+	if len(closed) != 1 || len(h.OpenOrders()) != 0 {
+		t.Fatalf("expect one closed order, got %v, open: %v", closed, h.OpenOrders())
+	}
+	od := closed[0]
+	if od.ExitTag != core.ExitTagSLTake || od.Exit.Average > 106.7 || od.Exit.Average < 106 || od.Profit <= 0 {
+		t.Fatalf("bad trailing exit: %s exit %v profit %v", od.ExitTag, od.Exit.Average, od.Profit)
+	}
+}
+
+func TestAddOrder(t *testing.T) {
+	var opened, reduced bool
+	stgy := &strat.TradeStrat{
+		Name:        "pyramid",
+		EachMaxLong: 1,
+		OnBar: func(s *strat.StratJob) {
+			price := s.Env.Close.Get(0)
+			if !opened {
+				opened = true
+				_ = s.OpenOrder(&strat.EnterReq{Tag: "long", Amount: 1})
+			} else if len(s.LongOrders) == 1 {
+				od := s.LongOrders[0]
+				if price == 90 && len(od.GetLegs()) == 0 {
+					if err := s.AddOrder(od, &strat.EnterReq{Amount: 1}); err != nil {
+						t.Fatal(err)
+					}
+				} else if price == 100 && len(od.GetLegs()) > 0 && !reduced {
+					reduced = true
+					_ = s.CloseOrders(&strat.ExitReq{Tag: "reduce", OrderID: od.ID, ExitRate: 0.5})
+				}
+			}
+		},
+	}
+	h := newHarness(t, stgy, &Options{FeeRate: 0.001})
+	if err := h.Feed(Bars(startMS, "1h", 100, 100, 100, 90, 90, 100, 100)...); err != nil {
+		t.Fatal(err)
+	}
+	ods := h.OpenOrders()
+	if len(ods) != 1 || len(h.ClosedOrders()) != 1 {
+		t.Fatalf("expect one open and one closed order, got %v, closed: %v", ods, h.ClosedOrders())
+	}
+	od := ods[0]
+	legs := od.GetLegs()
+	if len(legs) != 1 || legs[0].Filled != 1 || legs[0].Average != 90 {
+		t.Fatalf("bad legs: %v", legs)
+	}
+	// half of the averaged position is kept 保留一半的均价仓位
+	if !nearly(od.Enter.Average, 95) || !nearly(od.Enter.Filled, 1) || !nearly(od.QuoteCost, 95) {
+		t.Fatalf("bad added order: avg %v filled %v cost %v", od.Enter.Average, od.Enter.Filled, od.QuoteCost)
+	}
+	part := h.ClosedOrders()[0]
+	if part.ExitTag != "reduce" || !nearly(part.Enter.Filled, 1) || !nearly(part.Enter.Average, 95) ||
+		len(part.GetLegs()) != 0 {
+		t.Fatalf("bad reduced part: %s filled %v avg %v", part.ExitTag, part.Enter.Filled, part.Enter.Average)
+	}
+	h.End()
+	var profit float64
+	for _, od := range h.ClosedOrders() {
+		profit += od.Profit
+	}
+	if profit <= 9 || profit >= 10 {
+		t.Fatalf("bad profit: %v", profit)
+	}
+	if bal := h.Balance(); !nearly(bal, 10000+profit) {
+		t.Fatalf("balance %v should be %v", bal, 10000+profit)
+	}
+}
+
+func TestExprStrat(t *testing.T) {
+	stgy := strat.New(&config.RunPolicyConfig{
+		Name: "expr:rise",
+		Rules: &config.StratRulesConfig{
+			Warmup:    2,
+			LongEntry: "close > close[1]",
+			CheckExit: "hold_bars >= 2",
+		},
+	})
+	h := newHarness(t, stgy, &Options{Pair: "ETH/USDT", TimeFrame: "15m"})
+	var rows = []string{"date,open,high,low,close,volume"}
+	for i, price := range []float64{10, 10, 11, 11, 11, 11, 11} {
+		rows = append(rows, fmt.Sprintf("%d,%v,%v,%v,%v,100", startMS+int64(i)*900000, price, price, price, price))
+	}
+	path := filepath.Join(t.TempDir(), "ETH_USDT_15m.csv")
+	if err_ := os.WriteFile(path, []byte(strings.Join(rows, "\n")), 0644); err_ != nil {
+		t.Fatal(err_)
+	}
+	if err := h.FeedCSV(path); err != nil {
+		t.Fatal(err)
+	}
+	ods := h.ClosedOrders()
+	if len(ods) != 1 || ods[0].ExitTag != "check_exit" || ods[0].Enter.Amount != 9.0909 {
+		t.Fatalf("expect one order exit by check_exit, got %v", h.ExitTags())
+	}
+	if h.Wallets().Get("ETH").Available != 0 || !nearly(h.Balance(), 10000+ods[0].Profit) {
+		t.Fatalf("bad wallets: %v", h.Wallets().DumpAvas())
+	}
+}
+
+func nearly(a, b float64) bool {
+	return a-b < 1e-6 && b-a < 1e-6
+}
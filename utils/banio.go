@@ -19,6 +19,7 @@ import (
 	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
@@ -28,6 +29,7 @@ type ConnCB = func(string, []byte)
 type IBanConn interface {
 	WriteMsg(msg *IOMsg) *errs.Error
 	Write(data []byte, locked bool) *errs.Error
+	WriteAsync(data []byte)
 	ReadMsg() (*IOMsgRaw, *errs.Error)
 	Subscribe(tags ...string)
 	UnSubscribe(tags ...string)
@@ -47,19 +49,42 @@ type BanConn struct {
 	IsReading   bool
 	lockConnect sync.Mutex
 	lockWrite   sync.Mutex
+	lockTags    sync.Mutex
+	closed      atomic.Bool         // Set when RunForever exits, safe to read from other goroutines 在RunForever退出时设置，可从其他协程读取
 	heartBeatMs int64               // Timestamp of the latest received ping/pong
 	DoConnect   func(conn *BanConn) // Reconnect function, no attempt to reconnect provided 重新连接函数，未提供不尝试重新连接
 	ReInitConn  func()              // Initialize callback function after successful reconnection 重新连接成功后初始化回调函数
+	lastSeqs    map[string]int64    // The last received sequence of each topic 每个主题最后收到的序号
+	lockSeqs    sync.Mutex
+	sendQ       chan []byte // Queue for async writing in order 按顺序异步写入的队列
+	lockSend    sync.Mutex
 }
 
+const (
+	sendQueueSize = 1000
+)
+
 type IOMsg struct {
 	Action string      `json:"action"`
 	Data   interface{} `json:"data"`
+	Seq    int64       `json:"seq,omitempty"` // sequence in topic(action), 0 for no sequence 主题(action)内的序号，0表示无序号
 }
 
 type IOMsgRaw struct {
 	Action string          `json:"action"`
 	Data   json.RawMessage `json:"data"`
+	Seq    int64           `json:"seq,omitempty"`
+}
+
+/*
+IOResume
+Sent by the client after reconnecting, to receive the missed messages after the last sequences of each topic.
+Sequences are only valid in the same server epoch.
+客户端重连后发送，用于接收每个主题最后序号之后错过的消息。序号仅在相同的服务端epoch内有效。
+*/
+type IOResume struct {
+	Epoch string           `json:"epoch"`
+	Seqs  map[string]int64 `json:"seqs"`
 }
 
 var (
@@ -71,10 +96,12 @@ func (c *BanConn) GetRemote() string {
 	return c.Remote
 }
 func (c *BanConn) IsClosed() bool {
-	return c.Conn == nil || !c.Ready
+	return c.closed.Load()
 }
 func (c *BanConn) HasTag(tag string) bool {
+	c.lockTags.Lock()
 	_, ok := c.Tags[tag]
+	c.lockTags.Unlock()
 	return ok
 }
 
@@ -93,6 +120,24 @@ func (c *BanConn) WriteMsg(msg *IOMsg) *errs.Error {
 	return c.Write(compressed, false)
 }
 
+/*
+WriteMsgAsync
+Queue the message to the same ordered queue used by WriteAsync
+将消息加入WriteAsync使用的同一个有序队列
+*/
+func (c *BanConn) WriteMsgAsync(msg *IOMsg) *errs.Error {
+	raw, err_ := utils.Marshal(*msg)
+	if err_ != nil {
+		return errs.New(core.ErrMarshalFail, err_)
+	}
+	compressed, err := compress(raw)
+	if err != nil {
+		return err
+	}
+	c.WriteAsync(compressed)
+	return nil
+}
+
 func (c *BanConn) Write(data []byte, locked bool) *errs.Error {
 	if c.Conn == nil {
 		return errs.NewMsg(errs.CodeIOWriteFail, "write fail as disconnected")
@@ -129,6 +174,43 @@ func (c *BanConn) Write(data []byte, locked bool) *errs.Error {
 	return errs.NewMsg(errs.CodeIOWriteFail, "write fail as disconnected")
 }
 
+/*
+WriteAsync
+Write compressed data in background, keeping the order of messages. Data is dropped when the queue is full.
+在后台写入压缩数据，保持消息顺序。队列已满时丢弃数据。
+*/
+func (c *BanConn) WriteAsync(data []byte) {
+	c.lockSend.Lock()
+	if c.sendQ == nil {
+		c.sendQ = make(chan []byte, sendQueueSize)
+		go c.loopSend(c.sendQ)
+	}
+	select {
+	case c.sendQ <- data:
+	default:
+		log.Warn("send queue full, drop msg", zap.String("remote", c.Remote))
+	}
+	c.lockSend.Unlock()
+}
+
+func (c *BanConn) loopSend(queue chan []byte) {
+	for data := range queue {
+		err := c.Write(data, false)
+		if err != nil {
+			log.Warn("async write fail", zap.String("remote", c.Remote), zap.Error(err))
+		}
+	}
+}
+
+func (c *BanConn) closeSendQ() {
+	c.lockSend.Lock()
+	if c.sendQ != nil {
+		close(c.sendQ)
+		c.sendQ = nil
+	}
+	c.lockSend.Unlock()
+}
+
 func (c *BanConn) ReadMsg() (*IOMsgRaw, *errs.Error) {
 	compressed, err := c.Read()
 	if err != nil {
@@ -168,14 +250,18 @@ func (c *BanConn) Read() ([]byte, *errs.Error) {
 }
 
 func (c *BanConn) Subscribe(tags ...string) {
+	c.lockTags.Lock()
 	for _, tag := range tags {
 		c.Tags[tag] = true
 	}
+	c.lockTags.Unlock()
 }
 func (c *BanConn) UnSubscribe(tags ...string) {
+	c.lockTags.Lock()
 	for _, tag := range tags {
 		delete(c.Tags, tag)
 	}
+	c.lockTags.Unlock()
 }
 
 /*
@@ -198,8 +284,10 @@ func (c *BanConn) RunForever() *errs.Error {
 		return errs.NewMsg(errs.CodeRunTime, "BanConn is unavailable in mode %s", core.RunMode)
 	}
 	defer func() {
+		c.closed.Store(true)
 		c.Ready = false
 		c.IsReading = false
+		c.closeSendQ()
 		if c.Conn != nil {
 			err_ := c.Conn.Close()
 			if err_ != nil {
@@ -219,6 +307,10 @@ func (c *BanConn) RunForever() *errs.Error {
 			}
 			return err
 		}
+		if msg.Seq > 0 && !c.checkSeq(msg.Action, msg.Seq) {
+			log.Debug("skip duplicate msg", zap.String("action", msg.Action), zap.Int64("seq", msg.Seq))
+			continue
+		}
 		isMatch := false
 		for prefix, handle := range c.Listens {
 			if strings.HasPrefix(msg.Action, prefix) {
@@ -233,6 +325,46 @@ func (c *BanConn) RunForever() *errs.Error {
 	}
 }
 
+/*
+checkSeq
+Record the sequence of a topic, return false if it was received already
+记录主题的序号，如果已收到过则返回false
+*/
+func (c *BanConn) checkSeq(topic string, seq int64) bool {
+	c.lockSeqs.Lock()
+	defer c.lockSeqs.Unlock()
+	if c.lastSeqs == nil {
+		c.lastSeqs = make(map[string]int64)
+	}
+	last := c.lastSeqs[topic]
+	if seq <= last {
+		return false
+	}
+	if last > 0 && seq > last+1 {
+		log.Debug("msg seq gap", zap.String("topic", topic), zap.Int64("last", last), zap.Int64("seq", seq))
+	}
+	c.lastSeqs[topic] = seq
+	return true
+}
+
+// LastSeqs return a copy of the last received sequences 返回最后收到序号的副本
+func (c *BanConn) LastSeqs() map[string]int64 {
+	c.lockSeqs.Lock()
+	defer c.lockSeqs.Unlock()
+	res := make(map[string]int64, len(c.lastSeqs))
+	for k, v := range c.lastSeqs {
+		res[k] = v
+	}
+	return res
+}
+
+// ResetSeqs clear received sequences when the server epoch changed 服务端epoch变化时清空已收到的序号
+func (c *BanConn) ResetSeqs() {
+	c.lockSeqs.Lock()
+	c.lastSeqs = nil
+	c.lockSeqs.Unlock()
+}
+
 /*
 connect
 A function used for reconnecting.
@@ -253,6 +385,7 @@ func (c *BanConn) connect() {
 	core.Sleep(time.Second * 3)
 	c.DoConnect(c)
 	c.RefreshMS = btime.TimeMS()
+	c.heartBeatMs = btime.UTCStamp()
 	if c.Conn != nil {
 		if c.ReInitConn != nil {
 			c.ReInitConn()
@@ -262,18 +395,33 @@ func (c *BanConn) connect() {
 	}
 }
 
+/*
+LoopPing
+Send ping periodically and close the connection when pong timeout.
+For connections which can reconnect, only the current socket is closed, the reader will reconnect (and fail over) and ping continues.
+定期发送ping，pong超时时关闭连接。
+对于可重连的连接，仅关闭当前socket，读取方会重新连接(并故障转移)，ping继续进行。
+*/
 func (c *BanConn) LoopPing(intvSecs int) {
 	id := 0
 	failNum := 0
-	addrField := zap.String("addr", c.Remote)
 	for {
 		time.Sleep(time.Duration(intvSecs) * time.Second)
 		if !c.IsReading {
+			if c.Conn == nil && c.DoConnect == nil {
+				// closed and can't reconnect 已关闭且无法重连
+				break
+			}
 			continue
 		}
+		addrField := zap.String("addr", c.Remote)
 		timeouts := float64(btime.UTCStamp()-c.heartBeatMs) / 1000 / float64(intvSecs)
 		if id > 1 && timeouts > 2.2 {
 			log.Error("close conn as ping timeout", addrField, zap.Int64("last", c.heartBeatMs))
+			if c.closeForRetry() {
+				id, failNum = 0, 0
+				continue
+			}
 			break
 		}
 		id += 1
@@ -283,6 +431,10 @@ func (c *BanConn) LoopPing(intvSecs int) {
 			if failNum >= 2 {
 				// 连续两次失败退出
 				log.Error("close conn as ping fail", addrField, zap.String("err", err.Short()))
+				if c.closeForRetry() {
+					id, failNum = 0, 0
+					continue
+				}
 				break
 			} else {
 				log.Warn("write ping fail", addrField, zap.Error(err))
@@ -294,12 +446,27 @@ func (c *BanConn) LoopPing(intvSecs int) {
 	if c.Conn != nil {
 		err_ := c.Conn.Close()
 		if err_ != nil {
-			log.Warn("close ban conn error", addrField, zap.Error(err_))
+			log.Warn("close ban conn error", zap.String("addr", c.Remote), zap.Error(err_))
 		}
 		c.Conn = nil
 	}
 }
 
+/*
+closeForRetry
+Close the current socket for connections which can reconnect, return false if it can't reconnect.
+关闭可重连连接的当前socket，不可重连时返回false
+*/
+func (c *BanConn) closeForRetry() bool {
+	if c.DoConnect == nil {
+		return false
+	}
+	if conn := c.Conn; conn != nil {
+		_ = conn.Close()
+	}
+	return true
+}
+
 func (c *BanConn) initListens() {
 	c.Listens["subscribe"] = makeArrStrHandle(func(arr []string) {
 		c.Subscribe(arr...)
@@ -424,6 +591,8 @@ type ServerIO struct {
 	Data     map[string]string // Cache data available for remote access 缓存的数据，可供远程端访问
 	DataExp  map[string]int64  // Cache data expiration timestamp, 13 bits 缓存数据的过期时间戳，13位
 	InitConn func(*BanConn)
+	lockData sync.Mutex
+	lockConn sync.Mutex
 }
 
 var (
@@ -435,6 +604,7 @@ func NewBanServer(addr, name string) *ServerIO {
 	server.Addr = addr
 	server.Name = name
 	server.Data = map[string]string{}
+	server.DataExp = map[string]int64{}
 	banServer = &server
 	return &server
 }
@@ -444,6 +614,15 @@ func (s *ServerIO) RunForever() *errs.Error {
 	if err_ != nil {
 		return errs.New(core.ErrNetConnect, err_)
 	}
+	return s.Serve(ln)
+}
+
+/*
+Serve
+Accept clients from a listener which is already bound
+从已绑定的监听器接收客户端
+*/
+func (s *ServerIO) Serve(ln net.Listener) *errs.Error {
 	defer ln.Close()
 	log.Info("banio started", zap.String("name", s.Name), zap.String("addr", s.Addr))
 	for {
@@ -453,7 +632,9 @@ func (s *ServerIO) RunForever() *errs.Error {
 		}
 		conn := s.WrapConn(conn_)
 		log.Info("receive client", zap.String("remote", conn.GetRemote()))
+		s.lockConn.Lock()
 		s.Conns = append(s.Conns, conn)
+		s.lockConn.Unlock()
 		go func() {
 			err := conn.RunForever()
 			if err != nil {
@@ -476,18 +657,25 @@ type IOKeyVal struct {
 }
 
 func (s *ServerIO) SetVal(args *KeyValExpire) {
+	s.lockData.Lock()
+	defer s.lockData.Unlock()
 	if args.Val == "" {
 		// 删除值
 		delete(s.Data, args.Key)
+		delete(s.DataExp, args.Key)
 		return
 	}
 	s.Data[args.Key] = args.Val
 	if args.ExpireSecs > 0 {
 		s.DataExp[args.Key] = btime.TimeMS() + int64(args.ExpireSecs*1000)
+	} else {
+		delete(s.DataExp, args.Key)
 	}
 }
 
 func (s *ServerIO) GetVal(key string) string {
+	s.lockData.Lock()
+	defer s.lockData.Unlock()
 	val, ok := s.Data[key]
 	if !ok {
 		return ""
@@ -503,6 +691,7 @@ func (s *ServerIO) GetVal(key string) string {
 }
 
 func (s *ServerIO) Broadcast(msg *IOMsg) *errs.Error {
+	s.lockConn.Lock()
 	allConns := make([]IBanConn, 0, len(s.Conns))
 	curConns := make([]IBanConn, 0)
 	for _, conn := range s.Conns {
@@ -515,6 +704,7 @@ func (s *ServerIO) Broadcast(msg *IOMsg) *errs.Error {
 		}
 	}
 	s.Conns = allConns
+	s.lockConn.Unlock()
 	if len(curConns) == 0 {
 		return nil
 	}
@@ -527,13 +717,7 @@ func (s *ServerIO) Broadcast(msg *IOMsg) *errs.Error {
 		return err
 	}
 	for _, conn := range curConns {
-		go func(c IBanConn) {
-			err = c.Write(compressed, false)
-			if err != nil {
-				log.Warn("broadcast fail", zap.String("remote", c.GetRemote()),
-					zap.String("tag", msg.Action), zap.Error(err))
-			}
-		}(conn)
+		conn.WriteAsync(compressed)
 	}
 	return nil
 }
@@ -581,17 +765,42 @@ func (s *ServerIO) WrapConn(conn net.Conn) *BanConn {
 
 type ClientIO struct {
 	BanConn
-	Addr  string
-	waits map[string]chan string
+	Addr      string   // The address currently connected 当前连接的地址
+	Addrs     []string // All candidate addresses for failover 用于故障转移的全部候选地址
+	addrIdx   int
+	waits     map[string]chan string
+	lockWaits sync.Mutex
 }
 
-func NewClientIO(addr string) (*ClientIO, *errs.Error) {
-	conn, err_ := net.Dial("tcp", addr)
+/*
+NewClientIO
+Connect to the first available address. When disconnected, the others will be tried in turn.
+连接到第一个可用的地址。断开后会依次尝试其他地址。
+*/
+func NewClientIO(addrs ...string) (*ClientIO, *errs.Error) {
+	if len(addrs) == 0 {
+		return nil, errs.NewMsg(core.ErrInvalidAddr, "no address for ClientIO")
+	}
+	var conn net.Conn
+	var err_ error
+	var addrIdx int
+	for i, addr := range addrs {
+		conn, err_ = net.DialTimeout("tcp", addr, time.Second*5)
+		if err_ == nil {
+			addrIdx = i
+			break
+		}
+		if len(addrs) > 1 {
+			log.Warn("connect fail, try next", zap.String("addr", addr), zap.Error(err_))
+		}
+	}
 	if err_ != nil {
 		return nil, errs.New(core.ErrNetConnect, err_)
 	}
 	res := &ClientIO{
-		Addr: addr,
+		Addr:    addrs[addrIdx],
+		Addrs:   addrs,
+		addrIdx: addrIdx,
 		BanConn: BanConn{
 			Conn:      conn,
 			Tags:      map[string]bool{},
@@ -608,7 +817,10 @@ func NewClientIO(addr string) (*ClientIO, *errs.Error) {
 		if err != nil {
 			log.Error("onGetValRes unmarshal fail", zap.String("raw", string(data)), zap.Error(err))
 		} else {
+			res.lockWaits.Lock()
 			out, ok := res.waits[val.Key]
+			delete(res.waits, val.Key)
+			res.lockWaits.Unlock()
 			if !ok {
 				return
 			}
@@ -618,23 +830,35 @@ func NewClientIO(addr string) (*ClientIO, *errs.Error) {
 	res.initListens()
 	// This is only responsible for connection, no initialization required, leave it to connect for initialization
 	// 这里只负责连接，无需初始化，交给connect初始化
+	// The current address failed, try the next ones first, and the current one last
+	// 当前地址已失败，先尝试后面的地址，最后尝试当前地址
 	res.DoConnect = func(c *BanConn) {
 		for {
-			cn, err_ := net.Dial("tcp", addr)
-			if err_ != nil {
-				curMS := btime.TimeMS()
-				tipRetryTimesLock.Lock()
-				nextMS, _ := tipRetryTimes[addr]
-				if curMS > nextMS {
-					tipRetryTimes[addr] = curMS + 10000
-					log.Error("connect fail, sleep 10s and retry..", zap.String("addr", addr))
+			for i := range res.Addrs {
+				idx := (res.addrIdx + 1 + i) % len(res.Addrs)
+				addr := res.Addrs[idx]
+				cn, err_ := net.DialTimeout("tcp", addr, time.Second*5)
+				if err_ != nil {
+					continue
 				}
-				tipRetryTimesLock.Unlock()
-				core.Sleep(time.Second * 10)
-				continue
+				if addr != res.Addr {
+					log.Warn("spider failover", zap.String("from", res.Addr), zap.String("to", addr))
+				}
+				res.addrIdx, res.Addr = idx, addr
+				c.Conn = cn
+				c.Remote = cn.RemoteAddr().String()
+				return
 			}
-			c.Conn = cn
-			return
+			addrKey := strings.Join(res.Addrs, ",")
+			curMS := btime.TimeMS()
+			tipRetryTimesLock.Lock()
+			nextMS, _ := tipRetryTimes[addrKey]
+			if curMS > nextMS {
+				tipRetryTimes[addrKey] = curMS + 10000
+				log.Error("connect fail, sleep 10s and retry..", zap.String("addr", addrKey))
+			}
+			tipRetryTimesLock.Unlock()
+			core.Sleep(time.Second * 10)
 		}
 	}
 	banClient = res
@@ -656,14 +880,17 @@ func (c *ClientIO) GetVal(key string, timeout int) (string, *errs.Error) {
 	if timeout == 0 {
 		timeout = readTimeout
 	}
-	out := make(chan string)
+	out := make(chan string, 1)
+	c.lockWaits.Lock()
 	c.waits[key] = out
+	c.lockWaits.Unlock()
 	var res string
 	select {
 	case res = <-out:
 	case <-time.After(time.Second * time.Duration(timeout)):
-		close(out)
+		c.lockWaits.Lock()
 		delete(c.waits, key)
+		c.lockWaits.Unlock()
 	}
 	return res, nil
 }
@@ -706,15 +933,43 @@ func SetServerData(args *KeyValExpire) *errs.Error {
 }
 
 func GetNetLock(key string, timeout int) (int32, *errs.Error) {
+	return getNetLock(GetServerData, SetServerData, key, timeout)
+}
+
+func DelNetLock(key string, lockVal int32) *errs.Error {
+	return delNetLock(GetServerData, SetServerData, key, lockVal)
+}
+
+/*
+GetNetLock
+Get a lock from the server this client connected, instead of the global server/client
+从此客户端连接的服务器获取锁，而非全局的服务器/客户端
+*/
+func (c *ClientIO) GetNetLock(key string, timeout int) (int32, *errs.Error) {
+	return getNetLock(c.getData, c.SetVal, key, timeout)
+}
+
+func (c *ClientIO) DelNetLock(key string, lockVal int32) *errs.Error {
+	return delNetLock(c.getData, c.SetVal, key, lockVal)
+}
+
+func (c *ClientIO) getData(key string) (string, *errs.Error) {
+	return c.GetVal(key, 5)
+}
+
+type fnGetData = func(key string) (string, *errs.Error)
+type fnSetData = func(args *KeyValExpire) *errs.Error
+
+func getNetLock(getData fnGetData, setData fnSetData, key string, timeout int) (int32, *errs.Error) {
 	lockKey := "lock_" + key
-	val, err := GetServerData(lockKey)
+	val, err := getData(lockKey)
 	if err != nil {
 		return 0, err
 	}
 	lockVal := rand.Int31()
 	lockStr := fmt.Sprintf("%v", lockVal)
 	if val == "" {
-		err = SetServerData(&KeyValExpire{Key: lockKey, Val: lockStr})
+		err = setData(&KeyValExpire{Key: lockKey, Val: lockStr})
 		return lockVal, err
 	}
 	if timeout == 0 {
@@ -723,27 +978,27 @@ func GetNetLock(key string, timeout int) (int32, *errs.Error) {
 	stopAt := btime.Time() + float64(timeout)
 	for btime.Time() < stopAt {
 		core.Sleep(time.Microsecond * 10)
-		val, err = GetServerData(lockKey)
+		val, err = getData(lockKey)
 		if err != nil {
 			return 0, err
 		}
 		if val == "" {
-			err = SetServerData(&KeyValExpire{Key: lockKey, Val: lockStr})
+			err = setData(&KeyValExpire{Key: lockKey, Val: lockStr})
 			return lockVal, err
 		}
 	}
 	return 0, errs.NewMsg(core.ErrTimeout, "GetNetLock for %s", key)
 }
 
-func DelNetLock(key string, lockVal int32) *errs.Error {
+func delNetLock(getData fnGetData, setData fnSetData, key string, lockVal int32) *errs.Error {
 	lockKey := "lock_" + key
-	val, err := GetServerData(lockKey)
+	val, err := getData(lockKey)
 	if err != nil {
 		return err
 	}
 	lockStr := fmt.Sprintf("%v", lockVal)
 	if val == lockStr {
-		return SetServerData(&KeyValExpire{Key: lockKey, Val: ""})
+		return setData(&KeyValExpire{Key: lockKey, Val: ""})
 	}
 	log.Info("del lock fail", zap.String("val", val), zap.Int32("exp", lockVal))
 	return nil
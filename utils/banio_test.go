@@ -2,6 +2,8 @@ package utils
 
 import (
 	"context"
+	"fmt"
+	"net"
 	"github.com/banbox/banbot/core"
 	"github.com/banbox/banexg/log"
 	"go.uber.org/zap"
@@ -77,3 +79,60 @@ func TestBanClient(t *testing.T) {
 	}
 	log.Info("lock val after del", zap.String("val", val))
 }
+
+func TestClientFailover(t *testing.T) {
+	core.SetRunMode(core.RunModeLive)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	core.Ctx = ctx
+	startServer := func(name string) (*ServerIO, net.Listener) {
+		ln, err_ := net.Listen("tcp", "127.0.0.1:0")
+		if err_ != nil {
+			t.Fatal(err_)
+		}
+		server := NewBanServer(ln.Addr().String(), name)
+		go server.Serve(ln)
+		return server, ln
+	}
+	// a closed port is skipped on start
+	deadLn, _ := net.Listen("tcp", "127.0.0.1:0")
+	deadAddr := deadLn.Addr().String()
+	_ = deadLn.Close()
+	srvA, lnA := startServer("a")
+	srvB, _ := startServer("b")
+	srvB.SetVal(&KeyValExpire{Key: "name", Val: "b"})
+	client, err := NewClientIO(deadAddr, srvA.Addr, srvB.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.Addr != srvA.Addr {
+		t.Fatalf("expect connect to %s, got %s", srvA.Addr, client.Addr)
+	}
+	go client.RunForever()
+	lockVal, err := client.GetNetLock("lk", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// messages are handled in order, so the lock is visible
+	if val, _ := client.GetVal("lock_lk", 3); val != fmt.Sprintf("%v", lockVal) || srvA.GetVal("lock_lk") != val {
+		t.Fatalf("lock should be stored in server a, got %s", val)
+	}
+	if err = client.DelNetLock("lk", lockVal); err != nil {
+		t.Fatal(err)
+	}
+	// server a down, the client fails over to b
+	_ = lnA.Close()
+	for _, conn := range srvA.Conns {
+		_ = conn.(*BanConn).Conn.Close()
+	}
+	var name string
+	for i := 0; i < 20 && name == ""; i++ {
+		time.Sleep(time.Millisecond * 500)
+		if client.Addr == srvB.Addr && !client.IsClosed() {
+			name, _ = client.GetVal("name", 1)
+		}
+	}
+	if name != "b" {
+		t.Fatalf("expect fail over to server b, got %s at %s", name, client.Addr)
+	}
+}
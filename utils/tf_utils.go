@@ -2,6 +2,7 @@ package utils
 
 import (
 	"fmt"
+	"github.com/banbox/banbot/core"
 	"github.com/banbox/banexg"
 	"github.com/banbox/banexg/utils"
 	"math"
@@ -102,6 +103,91 @@ func BuildOHLCV(arr []*banexg.Kline, toTFMSecs int64, preFire float64, resOHLCV
 	return resOHLCV, lastFinished
 }
 
+/*
+AltBarBuilder
+Build non-time bars (volume/amount/range/renko) from finished bars of core.AltBarBaseTF.
+The Time of a result bar is the start time of its last source bar, so bar times are strictly increasing.
+从core.AltBarBaseTF的已完成K线构建非时间K线(成交量/成交额/波幅/砖形图)。
+结果K线的Time是其最后一个源K线的开始时间，从而K线时间严格递增。
+*/
+type AltBarBuilder struct {
+	Spec  *core.AltBarSpec
+	Wait  *banexg.Kline // The unfinished bar 未完成的bar
+	sum   float64       // Accumulated volume or amount of Wait Wait的累计成交量或成交额
+	brick float64       // Close price of the last renko brick 上一个砖块的收盘价
+}
+
+func NewAltBarBuilder(spec *core.AltBarSpec) *AltBarBuilder {
+	return &AltBarBuilder{Spec: spec}
+}
+
+/*
+Update
+Feed finished source bars, return the finished non-time bars.
+A renko bar moving several bricks within one source bar is returned as one bar covering them.
+传入已完成的源K线，返回已完成的非时间K线。
+一个源K线内移动多个砖块时，返回一个覆盖它们的K线。
+*/
+func (b *AltBarBuilder) Update(bars []*banexg.Kline) []*banexg.Kline {
+	var res []*banexg.Kline
+	spec := b.Spec
+	for _, k := range bars {
+		w := b.Wait
+		if w == nil {
+			w = &banexg.Kline{Open: k.Open, High: k.High, Low: k.Low}
+			b.Wait = w
+			b.sum = 0
+		} else {
+			w.High = max(w.High, k.High)
+			w.Low = min(w.Low, k.Low)
+		}
+		w.Time = k.Time
+		w.Close = k.Close
+		w.Volume += k.Volume
+		w.Info = k.Info
+		done := false
+		switch spec.Kind {
+		case core.AltBarVol:
+			b.sum += k.Volume
+			done = b.sum >= spec.Size
+		case core.AltBarUsd:
+			b.sum += k.Volume * (k.High + k.Low + k.Close) / 3
+			done = b.sum >= spec.Size
+		case core.AltBarRange:
+			size := spec.Size
+			if spec.IsPct {
+				size *= w.Open
+			}
+			done = w.High-w.Low >= size
+		case core.AltBarRenko:
+			if b.brick == 0 {
+				b.brick = w.Open
+			}
+			size := spec.Size
+			if spec.IsPct {
+				size *= b.brick
+			}
+			num := math.Floor(math.Abs(k.Close-b.brick) / size)
+			if num >= 1 {
+				if k.Close < b.brick {
+					num = -num
+				}
+				w.Open = b.brick
+				w.Close = b.brick + num*size
+				w.High = max(w.High, w.Open, w.Close)
+				w.Low = min(w.Low, w.Open, w.Close)
+				b.brick = w.Close
+				done = true
+			}
+		}
+		if done {
+			res = append(res, w)
+			b.Wait = nil
+		}
+	}
+	return res
+}
+
 func RoundSecsTF(secs int) string {
 	if secs < 60 {
 		if secs >= 45 {
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/banbox/banbot/core"
+	"github.com/banbox/banexg"
 	"github.com/banbox/banexg/log"
 	"go.uber.org/zap"
 )
@@ -126,3 +128,44 @@ func TestRoundSecsTF(t *testing.T) {
 		})
 	}
 }
+
+func TestAltBarBuilder(t *testing.T) {
+	startMS := int64(1700000040000)
+	closes := []float64{100, 101, 103, 102, 99, 95}
+	bars := make([]*banexg.Kline, 0, len(closes))
+	for i, c := range closes {
+		bars = append(bars, &banexg.Kline{Time: startMS + int64(i)*60000, Open: c, High: c + 1, Low: c - 1,
+			Close: c, Volume: 10})
+	}
+	tests := []struct {
+		tf     string
+		times  []int
+		closes []float64
+	}{
+		{"vol:25", []int{2, 5}, []float64{103, 95}},
+		{"range:4", []int{2, 4}, []float64{103, 99}},
+		{"range:3%", []int{1, 4}, []float64{101, 99}},
+		{"renko:2", []int{2, 4, 5}, []float64{102, 100, 96}},
+	}
+	for _, tt := range tests {
+		spec := core.ParseAltBar(tt.tf)
+		if spec == nil {
+			t.Fatalf("parse %s fail", tt.tf)
+		}
+		res := NewAltBarBuilder(spec).Update(bars)
+		if len(res) != len(tt.times) {
+			t.Fatalf("%s: expect %v bars, got %v", tt.tf, len(tt.times), len(res))
+		}
+		for i, k := range res {
+			if k.Time != bars[tt.times[i]].Time || k.Close != tt.closes[i] {
+				t.Fatalf("%s: bad bar %d: %+v", tt.tf, i, k)
+			}
+			if k.High < max(k.Open, k.Close) || k.Low > min(k.Open, k.Close) {
+				t.Fatalf("%s: bad high/low %d: %+v", tt.tf, i, k)
+			}
+		}
+	}
+	if core.ParseAltBar("vol:5%") != nil || core.ParseAltBar("5m") != nil || core.TFToSecs("usd:1e6") != 60 {
+		t.Fatal("bad alt bar spec parsing")
+	}
+}
@@ -79,9 +79,9 @@ func SetKlineSub(client *WsClient, isSub, lock bool, keys ...string) {
 
 func RunReceiver() {
 	var err *errs.Error
-	receiver, err = data.NewKlineWatcher(config.SpiderAddr)
+	receiver, err = data.NewKlineWatcher(config.SpiderAddrs...)
 	if err != nil {
-		log.Warn("connect spider fail", zap.String("addr", config.SpiderAddr), zap.String("err", err.Short()))
+		log.Warn("connect spider fail", zap.Strings("addr", config.SpiderAddrs), zap.String("err", err.Short()))
 		return
 	}
 	receiver.OnKLineMsg = klineHandler